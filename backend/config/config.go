@@ -1,7 +1,13 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
+	"grandma/backend/models"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -14,11 +20,56 @@ type Config struct {
 	OpenAIBaseURL      string
 	AnthropicAPIKey    string
 	AnthropicBaseURL   string
+	GeminiAPIKey       string
+	GeminiBaseURL      string
+	GeminiModel        string // Gemini模型名称
+	OllamaBaseURL      string // Ollama服务地址（为空时不启用本地模型）
+	OllamaAPIKey       string // 可选，经过鉴权代理访问Ollama时使用
+	OllamaModel        string // Ollama模型名称
 	DatabasePath       string
 	EnableRAG          bool   // 是否启用RAG功能
 	EmbeddingModel     string // Embedding模型名称
 	EmbeddingBaseURL   string // Embedding API URL
-	EmbeddingAPIKey    string // Embedding API Key
+	EmbeddingAPIKey    string // Embedding API Key（为空时不启用RAG）
+
+	CredentialMasterKey string // 加密用户API Key的服务端主密钥
+	ProviderKeyFallback string // 用户未配置API Key时的服务端Key回退策略：all、admin、none
+
+	DailyTokenQuota   int64  // 默认每用户每日Token上限（0表示不限制）
+	MonthlyTokenQuota int64  // 默认每用户每月Token上限（0表示不限制）
+	UsagePricing      string // 模型单价，格式为 "模型=输入单价/输出单价,..."（美元/百万Token）
+
+	ProviderChain           []string      // 服务商故障转移顺序
+	ProviderMaxAttempts     int           // 每个服务商遇到临时错误时的最大尝试次数
+	ProviderRetryBackoff    time.Duration // 重试前的等待时间
+	CircuitBreakerThreshold int           // 连续临时错误达到该次数后熔断
+	CircuitBreakerCooldown  time.Duration // 熔断持续时间
+
+	EnableMockProvider   bool              // 是否启用本地模拟服务商（model为mock），用于开发和测试
+	MockResponses        []string          // 模拟服务商依次返回的脚本响应，为空时回显用户消息
+	MockFirstChunkDelay  time.Duration     // 模拟首字延迟
+	MockChunkDelay       time.Duration     // 模拟chunk间隔
+	MockChunkSize        int               // 每个chunk的字符数
+	MockErrorStatus      int               // 非0时模拟返回该状态码的错误
+	MockErrorAfterChunks int               // 输出多少个chunk后返回错误
+	MockReasoning        string            // 模拟推理模型在回答之前输出的思考过程
+	MockToolCalls        []models.ToolCall // 携带工具声明时模拟服务商要求调用的工具
+
+	EnableTools   bool // 灵感模式下是否允许助手调用内置工具
+	ToolMaxRounds int  // 单次回答中最多执行几轮工具调用
+
+	UploadDir      string // 上传图片的本地存储目录
+	UploadMaxBytes int64  // 单个上传文件的最大字节数
+
+	EnableEntityExtraction bool          // 保存正文和助手回答后是否自动提取设定建议
+	ExtractionModel        string        // 从正文提取设定时使用的模型
+	ExtractionDelay        time.Duration // 保存后等待多久再提取（期间再次保存会重新计时）
+
+	ConsistencyCheckInterval time.Duration // 一致性检查间隔（0表示不启用定时检查）
+	ConsistencyAutoRepair    bool          // 定时检查时是否自动修复
+
+	SessionTTL     time.Duration // 登录会话有效期
+	AdminUsernames []string      // 管理员用户名列表
 }
 
 // LoadConfig 加载应用配置
@@ -27,6 +78,76 @@ func LoadConfig() (*Config, error) {
 	_ = godotenv.Load()
 
 	enableRAG := getEnv("ENABLE_RAG", "true")
+	consistencyCheckInterval, err := time.ParseDuration(getEnv("CONSISTENCY_CHECK_INTERVAL", "6h"))
+	if err != nil {
+		return nil, err
+	}
+	sessionTTL, err := time.ParseDuration(getEnv("SESSION_TTL", "720h"))
+	if err != nil {
+		return nil, err
+	}
+	dailyTokenQuota, err := strconv.ParseInt(getEnv("DAILY_TOKEN_QUOTA", "0"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	monthlyTokenQuota, err := strconv.ParseInt(getEnv("MONTHLY_TOKEN_QUOTA", "0"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	providerMaxAttempts, err := strconv.Atoi(getEnv("PROVIDER_MAX_ATTEMPTS", "2"))
+	if err != nil {
+		return nil, err
+	}
+	providerRetryBackoff, err := time.ParseDuration(getEnv("PROVIDER_RETRY_BACKOFF", "500ms"))
+	if err != nil {
+		return nil, err
+	}
+	circuitBreakerThreshold, err := strconv.Atoi(getEnv("CIRCUIT_BREAKER_THRESHOLD", "5"))
+	if err != nil {
+		return nil, err
+	}
+	circuitBreakerCooldown, err := time.ParseDuration(getEnv("CIRCUIT_BREAKER_COOLDOWN", "30s"))
+	if err != nil {
+		return nil, err
+	}
+	mockFirstChunkDelay, err := time.ParseDuration(getEnv("MOCK_FIRST_CHUNK_DELAY", "200ms"))
+	if err != nil {
+		return nil, err
+	}
+	mockChunkDelay, err := time.ParseDuration(getEnv("MOCK_CHUNK_DELAY", "30ms"))
+	if err != nil {
+		return nil, err
+	}
+	mockChunkSize, err := strconv.Atoi(getEnv("MOCK_CHUNK_SIZE", "4"))
+	if err != nil {
+		return nil, err
+	}
+	mockErrorStatus, err := strconv.Atoi(getEnv("MOCK_ERROR_STATUS", "0"))
+	if err != nil {
+		return nil, err
+	}
+	mockErrorAfterChunks, err := strconv.Atoi(getEnv("MOCK_ERROR_AFTER_CHUNKS", "0"))
+	if err != nil {
+		return nil, err
+	}
+	toolMaxRounds, err := strconv.Atoi(getEnv("TOOL_MAX_ROUNDS", "5"))
+	if err != nil {
+		return nil, err
+	}
+	extractionDelay, err := time.ParseDuration(getEnv("EXTRACTION_DELAY", "30s"))
+	if err != nil {
+		return nil, err
+	}
+	uploadMaxBytes, err := strconv.ParseInt(getEnv("UPLOAD_MAX_BYTES", "10485760"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	var mockToolCalls []models.ToolCall
+	if value := getEnv("MOCK_TOOL_CALLS", ""); value != "" {
+		if err := json.Unmarshal([]byte(value), &mockToolCalls); err != nil {
+			return nil, fmt.Errorf("invalid MOCK_TOOL_CALLS: %w", err)
+		}
+	}
 	return &Config{
 		Port:               getEnv("PORT", "8080"),
 		CorsAllowedOrigins: getEnv("CORS_ALLOWED_ORIGINS", "http://localhost:3000,http://localhost:5173"),
@@ -34,11 +155,56 @@ func LoadConfig() (*Config, error) {
 		OpenAIBaseURL:      getEnv("OPENAI_BASE_URL", "https://api.openai.com/v1"),
 		AnthropicAPIKey:    getEnv("ANTHROPIC_API_KEY", ""),
 		AnthropicBaseURL:   getEnv("ANTHROPIC_BASE_URL", "https://api.anthropic.com"),
+		GeminiAPIKey:       getEnv("GEMINI_API_KEY", ""),
+		GeminiBaseURL:      getEnv("GEMINI_BASE_URL", "https://generativelanguage.googleapis.com/v1beta"),
+		GeminiModel:        getEnv("GEMINI_MODEL", "gemini-2.0-flash"),
+		OllamaBaseURL:      getEnv("OLLAMA_BASE_URL", ""),
+		OllamaAPIKey:       getEnv("OLLAMA_API_KEY", ""),
+		OllamaModel:        getEnv("OLLAMA_MODEL", "qwen2.5"),
 		DatabasePath:       getEnv("DATABASE_PATH", "grandma.db"),
 		EnableRAG:          enableRAG == "true",
 		EmbeddingModel:     getEnv("EMBEDDING_MODEL", "text-embedding-v4"),
 		EmbeddingBaseURL:   getEnv("EMBEDDING_BASE_URL", "https://dashscope.aliyuncs.com/compatible-mode/v1"),
-		EmbeddingAPIKey:    getEnv("EMBEDDING_API_KEY", "sk-2e38f082fb8f4be0aee3ba890f5475fa"),
+		EmbeddingAPIKey:    getEnv("EMBEDDING_API_KEY", ""),
+
+		CredentialMasterKey: getEnv("CREDENTIAL_MASTER_KEY", ""),
+		ProviderKeyFallback: getEnv("PROVIDER_KEY_FALLBACK", "all"),
+
+		DailyTokenQuota:   dailyTokenQuota,
+		MonthlyTokenQuota: monthlyTokenQuota,
+		UsagePricing:      getEnv("USAGE_PRICING", "deepseek-chat=0.27/1.10,claude-3-5-sonnet-20241022=3/15,text-embedding-v4=0.07/0"),
+
+		ProviderChain:           splitList(getEnv("PROVIDER_CHAIN", "openai,anthropic")),
+		ProviderMaxAttempts:     providerMaxAttempts,
+		ProviderRetryBackoff:    providerRetryBackoff,
+		CircuitBreakerThreshold: circuitBreakerThreshold,
+		CircuitBreakerCooldown:  circuitBreakerCooldown,
+
+		EnableMockProvider:   getEnv("ENABLE_MOCK_PROVIDER", "false") == "true",
+		MockResponses:        splitResponses(getEnv("MOCK_RESPONSES", "")),
+		MockFirstChunkDelay:  mockFirstChunkDelay,
+		MockChunkDelay:       mockChunkDelay,
+		MockChunkSize:        mockChunkSize,
+		MockErrorStatus:      mockErrorStatus,
+		MockErrorAfterChunks: mockErrorAfterChunks,
+		MockReasoning:        getEnv("MOCK_REASONING", ""),
+		MockToolCalls:        mockToolCalls,
+
+		EnableTools:   getEnv("ENABLE_TOOLS", "true") == "true",
+		ToolMaxRounds: toolMaxRounds,
+
+		UploadDir:      getEnv("UPLOAD_DIR", "uploads"),
+		UploadMaxBytes: uploadMaxBytes,
+
+		EnableEntityExtraction: getEnv("ENABLE_ENTITY_EXTRACTION", "true") == "true",
+		ExtractionModel:        getEnv("EXTRACTION_MODEL", "openai"),
+		ExtractionDelay:        extractionDelay,
+
+		ConsistencyCheckInterval: consistencyCheckInterval,
+		ConsistencyAutoRepair:    getEnv("CONSISTENCY_AUTO_REPAIR", "false") == "true",
+
+		SessionTTL:     sessionTTL,
+		AdminUsernames: splitList(getEnv("ADMIN_USERNAMES", "")),
 	}, nil
 }
 
@@ -48,3 +214,25 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// splitList 解析逗号分隔的列表，忽略空项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// splitResponses 解析以 "||" 分隔的脚本响应（响应内容本身可能包含逗号）
+func splitResponses(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, "||") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"grandma/backend/models"
 	"log"
+	"strings"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -25,12 +26,77 @@ func InitDB(dbPath string) error {
 		&models.Story{},
 		&models.Work{},
 		&models.WorkDocument{},
+		&models.WorkChatMessage{},
 		&models.VectorChunk{},
+		&models.User{},
+		&models.Session{},
+		&models.ProviderCredential{},
+		&models.UsageEvent{},
+		&models.UsageQuota{},
+		&models.StoryBibleEntry{},
+		&models.Upload{},
+		&models.PromptTemplate{},
+		&models.StyleProfile{},
+		&models.OutlineNode{},
+		&models.Revision{},
+		&models.DocumentPatch{},
+		&models.ContinuityCheck{},
+		&models.ContinuityIssue{},
+		&models.BibleProposal{},
+		&models.TimelineCalendar{},
+		&models.TimelineEvent{},
 	)
 	if err != nil {
 		return err
 	}
+	if err := migrateWorkChatMessages(DB); err != nil {
+		return err
+	}
 
 	log.Println("Database initialized successfully")
 	return nil
 }
+
+// legacyWorkDocumentColumns 旧版本work_documents表中属于灵感模式对话的列
+var legacyWorkDocumentColumns = []string{"role", "reasoning", "model", "attachments"}
+
+// migrateWorkChatMessages 把旧版本保存在work_documents中的对话消息（role不为空的行）移到work_chat_messages，
+// 并删除work_documents中不再使用的列。消息保留原ID，已有的向量chunks仍然有效
+func migrateWorkChatMessages(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasColumn(&models.WorkDocument{}, "role") {
+		return nil
+	}
+
+	columns := []string{"id", "work_id", "user_id", "content", "created_at", "updated_at"}
+	for _, column := range legacyWorkDocumentColumns {
+		if migrator.HasColumn(&models.WorkDocument{}, column) {
+			columns = append(columns, column)
+		}
+	}
+	list := strings.Join(columns, ", ")
+
+	var moved int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec("INSERT INTO work_chat_messages (" + list + ") SELECT " + list +
+			" FROM work_documents WHERE role IS NOT NULL AND role <> ''")
+		if result.Error != nil {
+			return result.Error
+		}
+		moved = result.RowsAffected
+		return tx.Exec("DELETE FROM work_documents WHERE role IS NOT NULL AND role <> ''").Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, column := range legacyWorkDocumentColumns {
+		if migrator.HasColumn(&models.WorkDocument{}, column) {
+			if err := migrator.DropColumn(&models.WorkDocument{}, column); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("Moved %d work chat message(s) out of work_documents", moved)
+	return nil
+}
@@ -41,13 +41,12 @@ func (h *ChatHandler) Chat(c *gin.Context) {
 	c.Header("Access-Control-Allow-Headers", "Content-Type")
 
 	// 创建provider
-	provider, err := services.GetProvider(
-		req.Model,
-		h.config.OpenAIAPIKey,
-		h.config.OpenAIBaseURL,
-		h.config.AnthropicAPIKey,
-		h.config.AnthropicBaseURL,
-	)
+	provider, err := services.GetProvider(req.Model, map[string]services.ProviderSettings{
+		services.ProviderOpenAI:    {APIKey: h.config.OpenAIAPIKey, BaseURL: h.config.OpenAIBaseURL},
+		services.ProviderAnthropic: {APIKey: h.config.AnthropicAPIKey, BaseURL: h.config.AnthropicBaseURL},
+		services.ProviderGemini:    {APIKey: h.config.GeminiAPIKey, BaseURL: h.config.GeminiBaseURL, Model: h.config.GeminiModel},
+		services.ProviderOllama:    {APIKey: h.config.OllamaAPIKey, BaseURL: h.config.OllamaBaseURL, Model: h.config.OllamaModel},
+	})
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -55,7 +54,7 @@ func (h *ChatHandler) Chat(c *gin.Context) {
 
 	// 流式返回响应
 	c.Stream(func(w io.Writer) bool {
-		if err := provider.ChatStream([]models.Message{
+		if err := provider.ChatStream(c.Request.Context(), []models.Message{
 			{
 				Role:    "user",
 				Content: req.Message,
@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// 设定建议的操作
+const (
+	ProposalActionAdd    = "add"    // 新建设定条目
+	ProposalActionUpdate = "update" // 为已有条目补充别名或内容
+)
+
+// 设定建议的状态
+const (
+	ProposalStatusPending  = "pending"
+	ProposalStatusAccepted = "accepted"
+	ProposalStatusRejected = "rejected"
+)
+
+// 设定建议的来源类型
+const (
+	ProposalSourceWorkDocument = "work_document" // 正文文档
+	ProposalSourceWorkMessage  = "work_message"  // 灵感模式的助手回答
+)
+
+// BibleProposal 从正文或助手回答中自动提取的设定建议，用户接受后才会写入设定集
+// Action为update时EntryID指向要补充的条目，Aliases是新增的别名，Content是要追加的内容
+type BibleProposal struct {
+	ID         string    `json:"id" gorm:"primaryKey"`
+	UserID     string    `json:"user_id" gorm:"index"`                     // 用户ID
+	WorkID     string    `json:"work_id" gorm:"index"`                     // 所属创作ID
+	Action     string    `json:"action"`                                   // 操作：add 或 update
+	EntryID    string    `json:"entry_id,omitempty" gorm:"index"`          // 要补充的设定条目ID
+	Category   string    `json:"category"`                                 // 分类：character、location、item 或 event
+	Name       string    `json:"name"`                                     // 提取出的名称
+	Aliases    []string  `json:"aliases" gorm:"serializer:json;type:text"` // 别名
+	Content    string    `json:"content" gorm:"type:text"`                 // 设定内容
+	Similarity float32   `json:"similarity,omitempty"`                     // 与已有条目的相似度（按别名匹配时为1）
+	SourceType string    `json:"source_type"`                              // 来源类型：work_document 或 work_message
+	SourceID   string    `json:"source_id" gorm:"index"`                   // 来源文档或消息ID
+	Model      string    `json:"model"`                                    // 使用的模型
+	Status     string    `json:"status" gorm:"index"`                      // 状态：pending、accepted 或 rejected
+	CreatedAt  time.Time `json:"created_at"`                               // 创建时间
+	UpdatedAt  time.Time `json:"updated_at"`                               // 更新时间
+}
+
+// TableName 指定表名
+func (BibleProposal) TableName() string {
+	return "bible_proposals"
+}
+
+// BibleProposalListResponse 设定建议列表响应
+type BibleProposalListResponse struct {
+	Proposals []BibleProposal `json:"proposals"`
+	Total     int             `json:"total"`
+}
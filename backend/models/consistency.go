@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// ConsistencyReport 数据一致性检查报告
+type ConsistencyReport struct {
+	OrphanChunkIDs         []string                `json:"orphan_chunk_ids"`          // 来源文档已不存在的向量chunk
+	OrphanDocumentIDs      []string                `json:"orphan_document_ids"`       // 所属对话已不存在的文档
+	OrphanWorkDocumentIDs  []string                `json:"orphan_work_document_ids"`  // 所属创作已不存在的创作文档
+	OrphanWorkMessageIDs   []string                `json:"orphan_work_message_ids"`   // 所属创作已不存在的灵感模式对话消息
+	DanglingDocumentIDRefs []DanglingDocumentIDRef `json:"dangling_document_id_refs"` // 对话DocumentIDs中指向不存在文档的ID
+	BrokenStoryIDs         []string                `json:"broken_story_ids"`          // 关联文档已不存在的故事
+	Repaired               bool                    `json:"repaired"`                  // 是否已执行修复
+	CheckedAt              time.Time               `json:"checked_at"`                // 检查时间
+}
+
+// DanglingDocumentIDRef 对话中失效的文档ID引用
+type DanglingDocumentIDRef struct {
+	ConversationID string   `json:"conversation_id"`
+	DocumentIDs    []string `json:"document_ids"`
+}
+
+// Total 发现的问题总数
+func (r *ConsistencyReport) Total() int {
+	total := len(r.OrphanChunkIDs) + len(r.OrphanDocumentIDs) + len(r.OrphanWorkDocumentIDs) + len(r.OrphanWorkMessageIDs) + len(r.BrokenStoryIDs)
+	for _, ref := range r.DanglingDocumentIDRefs {
+		total += len(ref.DocumentIDs)
+	}
+	return total
+}
@@ -0,0 +1,107 @@
+package models
+
+import "time"
+
+// 设定一致性检查任务的状态
+const (
+	ContinuityCheckRunning   = "running"
+	ContinuityCheckCompleted = "completed"
+	ContinuityCheckFailed    = "failed"
+)
+
+// 设定冲突的类型
+const (
+	ContinuityKindAppearance = "appearance" // 人物外貌、身份等属性前后不一致
+	ContinuityKindDeceased   = "deceased"   // 已死亡或离场的人物再次出现
+	ContinuityKindTimeline   = "timeline"   // 时间线矛盾
+	ContinuityKindWorldRule  = "world_rule" // 违反世界观规则
+	ContinuityKindOther      = "other"
+)
+
+// 设定冲突的处理状态
+const (
+	ContinuityIssueOpen     = "open"
+	ContinuityIssueResolved = "resolved"
+)
+
+// 设定冲突的来源类型
+const (
+	ContinuitySourceStoryBible   = "story_bible"   // 设定集条目
+	ContinuitySourceWorkDocument = "work_document" // 其他章节正文
+	ContinuitySourceWorkMessage  = "work_message"  // RAG检索到的灵感模式对话
+)
+
+// ContinuityCheck 设定一致性检查任务：在后台把一个章节与设定集和已有内容对照，找出矛盾之处
+type ContinuityCheck struct {
+	ID              string     `json:"id" gorm:"primaryKey"`
+	UserID          string     `json:"user_id" gorm:"index"`             // 用户ID
+	WorkID          string     `json:"work_id" gorm:"index"`             // 所属创作ID
+	DocumentID      string     `json:"document_id" gorm:"index"`         // 被检查的章节文档ID
+	DocumentVersion int64      `json:"document_version"`                 // 检查时文档的版本号
+	Model           string     `json:"model"`                            // 使用的模型
+	Status          string     `json:"status" gorm:"index"`              // 状态：running、completed 或 failed
+	Error           string     `json:"error,omitempty" gorm:"type:text"` // 失败原因
+	IssueCount      int        `json:"issue_count"`                      // 发现的冲突数量
+	CreatedAt       time.Time  `json:"created_at"`                       // 创建时间
+	UpdatedAt       time.Time  `json:"updated_at"`                       // 更新时间
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`           // 完成或失败的时间
+}
+
+// TableName 指定表名
+func (ContinuityCheck) TableName() string {
+	return "continuity_checks"
+}
+
+// ContinuityIssue 设定一致性检查发现的冲突
+// Quote是章节中的原文，SourceQuote是与之矛盾的设定或前文；位置按字符计，原文在文档中找不到时为空
+type ContinuityIssue struct {
+	ID          string     `json:"id" gorm:"primaryKey"`
+	UserID      string     `json:"user_id" gorm:"index"`                    // 用户ID
+	WorkID      string     `json:"work_id" gorm:"index"`                    // 所属创作ID
+	DocumentID  string     `json:"document_id" gorm:"index"`                // 出现冲突的章节文档ID
+	CheckID     string     `json:"check_id" gorm:"index"`                   // 所属检查任务ID
+	Kind        string     `json:"kind"`                                    // 冲突类型
+	Severity    string     `json:"severity"`                                // 严重程度：low、medium 或 high
+	Description string     `json:"description" gorm:"type:text"`            // 冲突说明
+	Quote       string     `json:"quote" gorm:"type:text"`                  // 章节中的原文
+	QuoteStart  *int       `json:"quote_start"`                             // 原文在章节中的起始位置
+	QuoteEnd    *int       `json:"quote_end"`                               // 原文在章节中的结束位置（不含）
+	SourceType  string     `json:"source_type,omitempty"`                   // 来源类型：story_bible、work_document 或 work_message
+	SourceID    string     `json:"source_id,omitempty"`                     // 来源的设定条目、文档或消息ID
+	SourceTitle string     `json:"source_title,omitempty"`                  // 来源的设定名称或章节标题
+	SourceQuote string     `json:"source_quote,omitempty" gorm:"type:text"` // 来源中与之矛盾的原文
+	SourceStart *int       `json:"source_start"`                            // 来源原文的起始位置
+	SourceEnd   *int       `json:"source_end"`                              // 来源原文的结束位置（不含）
+	Status      string     `json:"status" gorm:"index"`                     // 处理状态：open 或 resolved
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`                   // 标记为已解决的时间
+	CreatedAt   time.Time  `json:"created_at"`                              // 创建时间
+	UpdatedAt   time.Time  `json:"updated_at"`                              // 更新时间
+}
+
+// TableName 指定表名
+func (ContinuityIssue) TableName() string {
+	return "continuity_issues"
+}
+
+// ContinuityCheckRequest 发起设定一致性检查的请求
+type ContinuityCheckRequest struct {
+	Model string `json:"model"`
+}
+
+// ContinuityCheckResponse 检查任务及其发现的冲突
+type ContinuityCheckResponse struct {
+	Check  ContinuityCheck   `json:"check"`
+	Issues []ContinuityIssue `json:"issues"`
+}
+
+// ContinuityCheckListResponse 检查任务列表响应
+type ContinuityCheckListResponse struct {
+	Checks []ContinuityCheck `json:"checks"`
+	Total  int               `json:"total"`
+}
+
+// ContinuityIssueListResponse 设定冲突列表响应
+type ContinuityIssueListResponse struct {
+	Issues []ContinuityIssue `json:"issues"`
+	Total  int               `json:"total"`
+}
@@ -4,17 +4,26 @@ import "time"
 
 // Document 文档模型
 type Document struct {
-	ID             string    `json:"id" gorm:"primaryKey"`
-	UserID         string    `json:"user_id" gorm:"index"`     // 用户ID
-	ConversationID string    `json:"conversation_id"`          // 所属对话ID
-	Role           string    `json:"role"`                     // 角色：user 或 assistant
-	Content        string    `json:"content" gorm:"type:text"` // 文档内容
-	Model          string    `json:"model"`                    // 使用的模型
-	CreatedAt      time.Time `json:"created_at"`               // 创建时间
-	UpdatedAt      time.Time `json:"updated_at"`               // 更新时间
+	ID             string        `json:"id" gorm:"primaryKey"`
+	UserID         string        `json:"user_id" gorm:"index"`                                   // 用户ID
+	ConversationID string        `json:"conversation_id"`                                        // 所属对话ID
+	Role           string        `json:"role"`                                                   // 角色：user 或 assistant
+	Content        string        `json:"content" gorm:"type:text"`                               // 文档内容
+	Reasoning      string        `json:"reasoning,omitempty" gorm:"type:text"`                   // 推理过程（不参与RAG索引，也不作为历史发送给模型）
+	Model          string        `json:"model"`                                                  // 使用的模型
+	Attachments    []ContentPart `json:"attachments,omitempty" gorm:"serializer:json;type:text"` // 用户消息附带的图片（上传ID或URL）
+	Version        int64         `json:"version" gorm:"not null;default:1"`                      // 版本号，每次修改加一，作为ETag
+	CreatedAt      time.Time     `json:"created_at"`                                             // 创建时间
+	UpdatedAt      time.Time     `json:"updated_at"`                                             // 更新时间
 }
 
 // TableName 指定表名
 func (Document) TableName() string {
 	return "documents"
 }
+
+// DocumentConflictResponse 写入冲突响应，包含服务器上的当前文档
+type DocumentConflictResponse struct {
+	Error    string   `json:"error"`
+	Document Document `json:"document"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// 修改建议的状态
+const (
+	PatchStatusPending  = "pending"
+	PatchStatusAccepted = "accepted"
+	PatchStatusRejected = "rejected"
+)
+
+// DocumentPatch AI编辑生成的修改建议：把正文文档中[Start, End)范围（按字符计）的片段替换为Replacement，
+// 用户接受后才会写入文档
+type DocumentPatch struct {
+	ID          string    `json:"id" gorm:"primaryKey"`
+	UserID      string    `json:"user_id" gorm:"index"`                   // 用户ID
+	WorkID      string    `json:"work_id" gorm:"index"`                   // 所属创作ID
+	DocumentID  string    `json:"document_id" gorm:"index"`               // 正文文档ID
+	Operation   string    `json:"operation"`                              // 编辑操作
+	Instruction string    `json:"instruction,omitempty" gorm:"type:text"` // 目标语气、目标语言或自定义要求
+	Start       int       `json:"start"`                                  // 选中片段的起始位置（字符）
+	End         int       `json:"end"`                                    // 选中片段的结束位置（字符，不含）
+	Original    string    `json:"original" gorm:"type:text"`              // 选中的原文
+	Replacement string    `json:"replacement" gorm:"type:text"`           // 模型给出的替换文本
+	BaseVersion int64     `json:"base_version"`                           // 生成建议时文档的版本号
+	Model       string    `json:"model"`                                  // 使用的模型
+	Status      string    `json:"status" gorm:"index"`                    // 状态：pending、accepted 或 rejected
+	CreatedAt   time.Time `json:"created_at"`                             // 创建时间
+	UpdatedAt   time.Time `json:"updated_at"`                             // 更新时间
+}
+
+// TableName 指定表名
+func (DocumentPatch) TableName() string {
+	return "document_patches"
+}
+
+// DocumentPatchListResponse 修改建议列表响应
+type DocumentPatchListResponse struct {
+	Patches []DocumentPatch `json:"patches"`
+	Total   int             `json:"total"`
+}
+
+// TransformRequest 对正文选中片段的AI编辑请求
+// Instruction在tone时为目标语气，translate时为目标语言，custom时为修改要求，其他操作时为可选的补充要求
+type TransformRequest struct {
+	Start       int    `json:"start"`                        // 选中片段的起始位置（字符）
+	End         int    `json:"end"`                          // 选中片段的结束位置（字符，不含）
+	Operation   string `json:"operation" binding:"required"` // rewrite、expand、condense、tone、translate、grammar 或 custom
+	Instruction string `json:"instruction"`
+	Model       string `json:"model"`
+}
@@ -0,0 +1,21 @@
+package models
+
+// GenerateRequest 正文续写和补写请求
+// Start和End都为空时从文档结尾续写；只有Start时在该位置插入；两者都有时用生成的内容替换[Start, End)之间的文本
+type GenerateRequest struct {
+	Start        *int   `json:"start"`         // 写入位置（字符）
+	End          *int   `json:"end"`           // 补写范围的结束位置（字符，不含）
+	Instruction  string `json:"instruction"`   // 写作要求
+	TargetLength int    `json:"target_length"` // 目标字数，默认500
+	Model        string `json:"model"`
+}
+
+// GenerateResult 续写或补写完成后的结果
+type GenerateResult struct {
+	DocumentID   string `json:"document_id"`
+	Start        int    `json:"start"`         // 生成内容在文档中的起始位置（字符）
+	End          int    `json:"end"`           // 生成内容在文档中的结束位置（字符，不含）
+	Version      int64  `json:"version"`       // 写入后文档的版本号
+	Revision     int    `json:"revision"`      // 写入后的修订编号
+	UndoRevision int    `json:"undo_revision"` // 写入前的修订编号，恢复该修订即可撤销本次写入
+}
@@ -0,0 +1,76 @@
+package models
+
+import "time"
+
+// 大纲节点类型：卷（part）下可以有章（chapter），章下可以有场景（scene），章也可以直接位于顶层
+const (
+	OutlineKindPart    = "part"
+	OutlineKindChapter = "chapter"
+	OutlineKindScene   = "scene"
+)
+
+// 大纲节点状态
+const (
+	OutlineStatusPlanned  = "planned"
+	OutlineStatusDrafting = "drafting"
+	OutlineStatusDone     = "done"
+)
+
+// OutlineNode 创作大纲节点（卷、章或场景）
+type OutlineNode struct {
+	ID           string    `json:"id" gorm:"primaryKey"`
+	UserID       string    `json:"user_id" gorm:"index"`      // 用户ID
+	WorkID       string    `json:"work_id" gorm:"index"`      // 所属创作ID
+	ParentID     string    `json:"parent_id" gorm:"index"`    // 父节点ID，为空表示顶层
+	Kind         string    `json:"kind"`                      // 类型：part、chapter 或 scene
+	Title        string    `json:"title"`                     // 标题
+	Synopsis     string    `json:"synopsis" gorm:"type:text"` // 梗概
+	Status       string    `json:"status"`                    // 状态：planned、drafting 或 done
+	TargetLength int       `json:"target_length"`             // 目标字数，0表示不限
+	OrderIndex   int       `json:"order_index"`               // 在同级节点中的位置，从0开始连续编号
+	DocumentID   string    `json:"document_id"`               // 关联的正文文档（WorkDocument）ID
+	CreatedAt    time.Time `json:"created_at"`                // 创建时间
+	UpdatedAt    time.Time `json:"updated_at"`                // 更新时间
+}
+
+// TableName 指定表名
+func (OutlineNode) TableName() string {
+	return "outline_nodes"
+}
+
+// OutlineNodeRequest 创建或更新大纲节点请求
+type OutlineNodeRequest struct {
+	ParentID     string `json:"parent_id"` // 仅创建时使用，移动节点请使用move接口
+	Kind         string `json:"kind"`      // 仅创建时使用
+	Title        string `json:"title"`
+	Synopsis     string `json:"synopsis"`
+	Status       string `json:"status"` // 为空时为planned
+	TargetLength int    `json:"target_length"`
+	DocumentID   string `json:"document_id"`
+	Position     *int   `json:"position"` // 仅创建时使用，为空时追加到末尾
+}
+
+// ReorderOutlineRequest 调整同级节点顺序请求，NodeIDs必须恰好包含该父节点下的所有子节点
+type ReorderOutlineRequest struct {
+	ParentID string   `json:"parent_id"`
+	NodeIDs  []string `json:"node_ids" binding:"required"`
+}
+
+// MoveOutlineNodeRequest 移动节点请求
+type MoveOutlineNodeRequest struct {
+	ParentID string `json:"parent_id"` // 新的父节点ID，为空表示移动到顶层
+	Position *int   `json:"position"`  // 在新父节点下的位置，为空时追加到末尾
+}
+
+// OutlineTreeNode 大纲树中的节点
+type OutlineTreeNode struct {
+	OutlineNode
+	DraftLength int               `json:"draft_length"` // 关联正文的字数
+	Children    []OutlineTreeNode `json:"children"`
+}
+
+// OutlineResponse 大纲响应
+type OutlineResponse struct {
+	Nodes []OutlineTreeNode `json:"nodes"`
+	Total int               `json:"total"` // 节点总数
+}
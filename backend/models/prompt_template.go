@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// PromptTemplate 用户保存的提示词模板版本，每次保存生成一个新版本，版本号最大的生效
+type PromptTemplate struct {
+	ID        string    `json:"id" gorm:"primaryKey"`
+	UserID    string    `json:"user_id" gorm:"index"`     // 用户ID
+	WorkID    string    `json:"work_id" gorm:"index"`     // 所属创作ID，为空表示对该用户的所有创作生效
+	Name      string    `json:"name" gorm:"index"`        // 模板名称，如 work_system
+	Version   int       `json:"version"`                  // 版本号，从1开始
+	Content   string    `json:"content" gorm:"type:text"` // text/template 模板内容
+	CreatedAt time.Time `json:"created_at"`               // 创建时间
+}
+
+// TableName 指定表名
+func (PromptTemplate) TableName() string {
+	return "prompt_templates"
+}
+
+// PromptTemplateRequest 保存提示词模板请求
+type PromptTemplateRequest struct {
+	WorkID  string `json:"work_id"` // 为空时保存为用户级模板
+	Content string `json:"content" binding:"required"`
+}
+
+// PromptTemplateInfo 提示词模板的当前状态
+type PromptTemplateInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Source      string `json:"source"`  // 生效的来源：default、user 或 work
+	Version     int    `json:"version"` // 生效的版本号，默认模板为0
+	Content     string `json:"content"` // 生效的模板内容
+	Default     string `json:"default"` // 内置默认模板
+}
+
+// PromptTemplateListResponse 提示词模板列表响应
+type PromptTemplateListResponse struct {
+	Templates []PromptTemplateInfo `json:"templates"`
+	Total     int                  `json:"total"`
+}
+
+// PromptTemplateVersionsResponse 提示词模板版本列表响应
+type PromptTemplateVersionsResponse struct {
+	Versions []PromptTemplate `json:"versions"`
+	Total    int              `json:"total"`
+}
+
+// PromptPreviewResponse 提示词预览响应：发送给模型的完整消息
+type PromptPreviewResponse struct {
+	Messages []Message `json:"messages"`
+	Tools    []string  `json:"tools,omitempty"` // 本次请求会提供给模型的工具名称
+}
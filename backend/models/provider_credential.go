@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// ProviderCredential 用户自带的模型服务商凭证（API Key加密保存）
+type ProviderCredential struct {
+	ID              string    `json:"id" gorm:"primaryKey"`
+	UserID          string    `json:"user_id" gorm:"uniqueIndex:idx_user_provider"`  // 用户ID
+	Provider        string    `json:"provider" gorm:"uniqueIndex:idx_user_provider"` // 服务商：openai、anthropic、gemini、ollama
+	EncryptedAPIKey string    `json:"-"`                                             // 使用服务端主密钥加密后的API Key
+	KeyHint         string    `json:"key_hint"`                                      // API Key末尾几位，便于用户识别
+	BaseURL         string    `json:"base_url"`                                      // 自定义API地址（为空时使用服务端配置）
+	CreatedAt       time.Time `json:"created_at"`                                    // 创建时间
+	UpdatedAt       time.Time `json:"updated_at"`                                    // 更新时间
+}
+
+// TableName 指定表名
+func (ProviderCredential) TableName() string {
+	return "provider_credentials"
+}
@@ -1,25 +1,37 @@
 package models
 
+import "time"
+
 // ChatRequest 聊天请求
 type ChatRequest struct {
-	ConversationID string    `json:"conversation_id"` // 可选，如果为空则创建新对话（普通模式）
-	WorkID         string    `json:"work_id"`         // 可选，灵感模式下使用（v1.3）
-	Model          string    `json:"model" binding:"required"`
-	UserID         string    `json:"user_id" binding:"required"` // 用户ID
-	Messages       []Message `json:"messages" binding:"required"`
+	ConversationID  string    `json:"conversation_id"` // 可选，如果为空则创建新对话（普通模式）
+	WorkID          string    `json:"work_id"`         // 可选，灵感模式下使用（v1.3）
+	DocumentID      string    `json:"document_id"`     // 可选，灵感模式下正在写的章节，用于注入该章之前的时间线事件
+	Model           string    `json:"model" binding:"required"`
+	UserID          string    `json:"-"` // 用户ID（由认证中间件填充，不接受客户端传入）
+	Messages        []Message `json:"messages" binding:"required"`
+	StreamReasoning bool      `json:"stream_reasoning"` // 是否在流中单独返回推理过程（<GRANDMA_REASONING>标记）
 }
 
 // Message 消息结构体
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string        `json:"role"`
+	Content    string        `json:"content"`
+	Parts      []ContentPart `json:"parts,omitempty"`        // 多模态内容片段（文本和图片），为空时只使用Content
+	ToolCalls  []ToolCall    `json:"tool_calls,omitempty"`   // 助手消息中的工具调用
+	ToolCallID string        `json:"tool_call_id,omitempty"` // role为tool时对应的调用ID
+}
+
+// UploadResponse 上传文件响应
+type UploadResponse struct {
+	Upload
+	URL string `json:"url"` // 获取文件内容的接口地址
 }
 
 // ConversationListRequest 对话列表请求
 type ConversationListRequest struct {
-	UserID   string `json:"user_id" form:"user_id" binding:"required"` // 用户ID
-	Page     int    `json:"page" form:"page"`
-	PageSize int    `json:"page_size" form:"page_size"`
+	Page     int `json:"page" form:"page"`
+	PageSize int `json:"page_size" form:"page_size"`
 }
 
 // ConversationListResponse 对话列表响应
@@ -32,7 +44,6 @@ type ConversationListResponse struct {
 
 // DocumentListRequest 文档列表请求
 type DocumentListRequest struct {
-	UserID         string `json:"user_id" form:"user_id" binding:"required"` // 用户ID
 	ConversationID string `json:"conversation_id" form:"conversation_id" binding:"required"`
 	BeforeID       string `json:"before_id" form:"before_id"` // 用于翻页，获取比该ID更早的文档
 	Limit          int    `json:"limit" form:"limit"`         // 返回数量，默认10
@@ -40,7 +51,6 @@ type DocumentListRequest struct {
 
 // DocumentIDsRequest 获取文档ID列表的请求
 type DocumentIDsRequest struct {
-	UserID         string `json:"user_id" form:"user_id" binding:"required"` // 用户ID
 	ConversationID string `json:"conversation_id" form:"conversation_id" binding:"required"`
 	BeforeID       string `json:"before_id" form:"before_id"` // 用于翻页，获取比该ID更早的文档ID
 	Limit          int    `json:"limit" form:"limit"`         // 返回数量，默认10
@@ -57,16 +67,19 @@ type DocumentListResponse struct {
 	Total     int        `json:"total"`
 }
 
+// UpdateDocumentRequest 更新文档内容请求
+type UpdateDocumentRequest struct {
+	Content string `json:"content" binding:"required"` // 文档内容
+}
+
 // CreateConversationWithTitleRequest 创建对话并生成标题的请求
 type CreateConversationWithTitleRequest struct {
-	UserID     string   `json:"user_id" binding:"required"` // 用户ID
 	UserInputs []string `json:"user_inputs" binding:"required"`
 }
 
 // StoryRequest 故事列表请求
 type StoryRequest struct {
-	UserID      string `json:"user_id" form:"user_id" binding:"required"` // 用户ID
-	Guid        string `json:"guid" form:"guid"`                          // guid可选，默认为"default"
+	Guid        string `json:"guid" form:"guid"` // guid可选，默认为"default"
 	DocumentId  string `json:"document_id"`
 	Title       string `json:"title"`
 	Content     string `json:"content"`
@@ -81,8 +94,7 @@ type StoryResponse struct {
 
 // WorkRequest 创作请求
 type WorkRequest struct {
-	UserID string `json:"user_id" form:"user_id" binding:"required"` // 用户ID
-	Title  string `json:"title"`                                     // 创作标题
+	Title string `json:"title"` // 创作标题
 }
 
 // WorkResponse 创作响应
@@ -93,7 +105,6 @@ type WorkResponse struct {
 
 // WorkDocumentRequest 创作文档请求（用于创建）
 type WorkDocumentRequest struct {
-	UserID  string `json:"user_id" binding:"required"` // 用户ID
 	WorkID  string `json:"work_id" binding:"required"` // 创作ID
 	Title   string `json:"title" binding:"required"`   // 文档标题
 	Content string `json:"content"`                    // 文档内容
@@ -101,13 +112,11 @@ type WorkDocumentRequest struct {
 
 // UpdateWorkDocumentTitleRequest 更新文档标题请求
 type UpdateWorkDocumentTitleRequest struct {
-	UserID string `json:"user_id" binding:"required"` // 用户ID
-	Title  string `json:"title" binding:"required"`   // 文档标题
+	Title string `json:"title" binding:"required"` // 文档标题
 }
 
 // UpdateWorkDocumentContentRequest 更新文档内容请求
 type UpdateWorkDocumentContentRequest struct {
-	UserID  string `json:"user_id" binding:"required"` // 用户ID
 	Content string `json:"content" binding:"required"` // 文档内容
 }
 
@@ -116,3 +125,77 @@ type WorkDocumentResponse struct {
 	Documents []WorkDocument `json:"documents"`
 	Total     int            `json:"total"`
 }
+
+// AuthRequest 注册/登录请求
+type AuthRequest struct {
+	Username string `json:"username" binding:"required"` // 用户名
+	Password string `json:"password" binding:"required"` // 密码
+}
+
+// LoginResponse 登录响应
+type LoginResponse struct {
+	Token     string    `json:"token"`      // 会话令牌，通过 Authorization: Bearer <token> 传递
+	ExpiresAt time.Time `json:"expires_at"` // 过期时间
+	User      User      `json:"user"`       // 当前用户
+}
+
+// ProviderCredentialRequest 保存服务商凭证请求
+type ProviderCredentialRequest struct {
+	APIKey  string `json:"api_key" binding:"required"` // 服务商API Key
+	BaseURL string `json:"base_url"`                   // 自定义API地址（可选）
+}
+
+// ProviderCredentialListResponse 服务商凭证列表响应
+type ProviderCredentialListResponse struct {
+	Credentials    []ProviderCredential `json:"credentials"`
+	ServerFallback bool                 `json:"server_fallback"` // 未配置自己的Key时是否可以使用服务端Key
+}
+
+// UsageBreakdown 按功能和模型分组的用量统计
+type UsageBreakdown struct {
+	Feature      string  `json:"feature"`
+	Model        string  `json:"model"`
+	Calls        int64   `json:"calls"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+// UsagePeriodSummary 某个统计周期内的用量汇总
+type UsagePeriodSummary struct {
+	Since        time.Time        `json:"since"`         // 周期开始时间
+	InputTokens  int64            `json:"input_tokens"`  // 输入Token总数
+	OutputTokens int64            `json:"output_tokens"` // 输出Token总数
+	TotalTokens  int64            `json:"total_tokens"`  // Token总数（计入配额）
+	CostUSD      float64          `json:"cost_usd"`      // 估算费用（美元）
+	Limit        int64            `json:"limit"`         // Token上限（0表示不限制）
+	Remaining    int64            `json:"remaining"`     // 剩余Token（不限制时为-1）
+	Breakdown    []UsageBreakdown `json:"breakdown"`     // 按功能和模型分组
+}
+
+// UsageSummaryResponse 用量汇总响应
+type UsageSummaryResponse struct {
+	UserID  string             `json:"user_id"`
+	Daily   UsagePeriodSummary `json:"daily"`
+	Monthly UsagePeriodSummary `json:"monthly"`
+}
+
+// UsageEventListRequest 用量明细请求
+type UsageEventListRequest struct {
+	Page     int `json:"page" form:"page"`
+	PageSize int `json:"page_size" form:"page_size"`
+}
+
+// UsageEventListResponse 用量明细响应
+type UsageEventListResponse struct {
+	Events   []UsageEvent `json:"events"`
+	Total    int          `json:"total"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"page_size"`
+}
+
+// UpdateUsageQuotaRequest 更新用户配额请求（字段为空表示恢复默认配额）
+type UpdateUsageQuotaRequest struct {
+	DailyTokens   *int64 `json:"daily_tokens"`
+	MonthlyTokens *int64 `json:"monthly_tokens"`
+}
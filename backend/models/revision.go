@@ -0,0 +1,79 @@
+package models
+
+import "time"
+
+// 修订记录的对象类型
+const (
+	RevisionTargetWorkDocument = "work_document"
+	RevisionTargetStory        = "story"
+)
+
+// 修订记录的作者
+const (
+	RevisionAuthorUser  = "user"
+	RevisionAuthorModel = "model"
+)
+
+// 修订记录的存储方式
+const (
+	RevisionEncodingSnapshot = "snapshot" // 完整内容
+	RevisionEncodingDelta    = "delta"    // 相对上一个修订的行级增量
+)
+
+// Revision 创作文档或故事的一次保存记录，内容经过压缩，除定期保存的完整快照外只保存与上一个修订的差异
+type Revision struct {
+	ID          string    `json:"id" gorm:"primaryKey"`
+	UserID      string    `json:"user_id" gorm:"index"`                                    // 用户ID
+	WorkID      string    `json:"work_id,omitempty" gorm:"index"`                          // 所属创作ID（仅创作文档）
+	TargetType  string    `json:"target_type" gorm:"index:idx_revision_target,priority:1"` // 对象类型：work_document 或 story
+	TargetID    string    `json:"target_id" gorm:"index:idx_revision_target,priority:2"`   // 创作文档或故事的ID
+	Number      int       `json:"number" gorm:"index:idx_revision_target,priority:3"`      // 修订号，从1开始
+	Author      string    `json:"author"`                                                  // 作者：user 或 model
+	Model       string    `json:"model,omitempty"`                                         // 作者为model时使用的模型
+	ContentHash string    `json:"content_hash"`                                            // 内容的SHA256
+	Length      int       `json:"length"`                                                  // 内容的字符数
+	Encoding    string    `json:"encoding"`                                                // 存储方式：snapshot 或 delta
+	Data        []byte    `json:"-"`                                                       // 压缩后的内容或增量
+	CreatedAt   time.Time `json:"created_at"`                                              // 保存时间
+}
+
+// TableName 指定表名
+func (Revision) TableName() string {
+	return "revisions"
+}
+
+// RevisionListResponse 修订列表响应
+type RevisionListResponse struct {
+	Revisions []Revision `json:"revisions"`
+	Total     int        `json:"total"`
+}
+
+// RevisionContentResponse 单个修订及其完整内容
+type RevisionContentResponse struct {
+	Revision
+	Content         string `json:"content"`
+	DocumentVersion int64  `json:"document_version,omitempty"` // 恢复后创作文档或故事的版本号
+}
+
+// 差异片段类型
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+// DiffOp 差异片段
+type DiffOp struct {
+	Type string `json:"type"` // equal、insert 或 delete
+	Text string `json:"text"`
+}
+
+// RevisionDiffResponse 两个修订之间的差异
+type RevisionDiffResponse struct {
+	From     int      `json:"from"`
+	To       int      `json:"to"`
+	Mode     string   `json:"mode"` // line 或 rune
+	Ops      []DiffOp `json:"ops"`
+	Inserted int      `json:"inserted"` // 插入的行数或字符数
+	Deleted  int      `json:"deleted"`  // 删除的行数或字符数
+}
@@ -11,6 +11,7 @@ type Story struct {
 	Title       string    `json:"title"`
 	Content     string    `json:"content" gorm:"type:text"`
 	ContentHash string    `json:"content_hash" gorm:"index"`
+	Version     int64     `json:"version" gorm:"not null;default:1"` // 版本号，每次修改加一，作为ETag
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	Document    Document  `json:"document" gorm:"foreignKey:DocumentID"`
@@ -20,3 +21,9 @@ type Story struct {
 func (Story) TableName() string {
 	return "stories"
 }
+
+// StoryConflictResponse 写入冲突响应，包含服务器上的当前故事
+type StoryConflictResponse struct {
+	Error string `json:"error"`
+	Story Story  `json:"story"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// StoryBibleEntry 故事设定集条目（人物、地点、物品、世界观等设定）
+type StoryBibleEntry struct {
+	ID        string    `json:"id" gorm:"primaryKey"`
+	UserID    string    `json:"user_id" gorm:"index"`                     // 用户ID
+	WorkID    string    `json:"work_id" gorm:"index"`                     // 所属创作ID
+	Category  string    `json:"category"`                                 // 分类：character、location、item、event、lore、other
+	Name      string    `json:"name"`                                     // 条目名称
+	Aliases   []string  `json:"aliases" gorm:"serializer:json;type:text"` // 别名
+	Content   string    `json:"content" gorm:"type:text"`                 // 设定内容
+	Source    string    `json:"source"`                                   // 来源：user、assistant 或 extraction
+	Embedding string    `json:"-" gorm:"type:text"`                       // 名称和内容的向量嵌入（JSON格式，去重时按需计算，修改后清空）
+	CreatedAt time.Time `json:"created_at"`                               // 创建时间
+	UpdatedAt time.Time `json:"updated_at"`                               // 更新时间
+}
+
+// TableName 指定表名
+func (StoryBibleEntry) TableName() string {
+	return "story_bible_entries"
+}
+
+// StoryBibleResponse 故事设定集响应
+type StoryBibleResponse struct {
+	Entries []StoryBibleEntry `json:"entries"`
+	Total   int               `json:"total"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// StyleProfile 文风档案：描述写作的叙述声音、时态、视角、禁用词、阅读难度和示例段落
+type StyleProfile struct {
+	ID             string    `json:"id" gorm:"primaryKey"`
+	UserID         string    `json:"user_id" gorm:"index"`                             // 用户ID
+	Name           string    `json:"name"`                                             // 档案名称
+	Voice          string    `json:"voice" gorm:"type:text"`                           // 叙述声音和语气
+	Tense          string    `json:"tense"`                                            // 时态，如过去时、现在时
+	PointOfView    string    `json:"point_of_view"`                                    // 叙事视角，如第一人称、第三人称有限视角
+	BannedWords    []string  `json:"banned_words" gorm:"serializer:json;type:text"`    // 禁止使用的词语
+	ReadingLevel   string    `json:"reading_level"`                                    // 目标阅读难度
+	SamplePassages []string  `json:"sample_passages" gorm:"serializer:json;type:text"` // 示例段落
+	Source         string    `json:"source"`                                           // 来源：manual 手动创建，derived 从创作分析得出
+	SourceWorkID   string    `json:"source_work_id,omitempty"`                         // 分析得出时对应的创作ID
+	CreatedAt      time.Time `json:"created_at"`                                       // 创建时间
+	UpdatedAt      time.Time `json:"updated_at"`                                       // 更新时间
+}
+
+// TableName 指定表名
+func (StyleProfile) TableName() string {
+	return "style_profiles"
+}
+
+// StyleProfileRequest 创建或更新文风档案请求
+type StyleProfileRequest struct {
+	Name           string   `json:"name" binding:"required"`
+	Voice          string   `json:"voice"`
+	Tense          string   `json:"tense"`
+	PointOfView    string   `json:"point_of_view"`
+	BannedWords    []string `json:"banned_words"`
+	ReadingLevel   string   `json:"reading_level"`
+	SamplePassages []string `json:"sample_passages"`
+}
+
+// DeriveStyleProfileRequest 从创作正文分析文风档案请求
+type DeriveStyleProfileRequest struct {
+	WorkID string `json:"work_id" binding:"required"`
+	Name   string `json:"name"`  // 为空时使用创作标题
+	Model  string `json:"model"` // 用于分析的模型，为空时使用openai
+}
+
+// AttachStyleProfileRequest 为创作或对话设置文风档案，为空表示取消
+type AttachStyleProfileRequest struct {
+	StyleProfileID string `json:"style_profile_id"`
+}
+
+// StyleProfileListResponse 文风档案列表响应
+type StyleProfileListResponse struct {
+	Profiles []StyleProfile `json:"profiles"`
+	Total    int            `json:"total"`
+}
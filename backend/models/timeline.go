@@ -0,0 +1,83 @@
+package models
+
+import "time"
+
+// CalendarMonth 历法中的一个月
+type CalendarMonth struct {
+	Name string `json:"name"` // 月份名称
+	Days int    `json:"days"` // 天数
+}
+
+// TimelineCalendar 创作的历法，用于架空世界的故事内日期；未设置时使用公历月份（不含闰年）
+type TimelineCalendar struct {
+	ID        string          `json:"id" gorm:"primaryKey"`
+	UserID    string          `json:"user_id" gorm:"index"`                    // 用户ID
+	WorkID    string          `json:"work_id" gorm:"uniqueIndex"`              // 所属创作ID
+	Era       string          `json:"era"`                                     // 纪年名称，如"天启"，显示在年份前
+	Months    []CalendarMonth `json:"months" gorm:"serializer:json;type:text"` // 一年中的月份，按顺序排列
+	CreatedAt time.Time       `json:"created_at"`                              // 创建时间
+	UpdatedAt time.Time       `json:"updated_at"`                              // 更新时间
+}
+
+// TableName 指定表名
+func (TimelineCalendar) TableName() string {
+	return "timeline_calendars"
+}
+
+// TimelineEvent 时间线事件：故事内发生的事情及其在故事时间轴上的位置
+// 有日期的事件按历法计算Ordinal（从元年第一天起的天数），没有日期的事件由用户指定Ordinal，两者在同一时间轴上排序
+type TimelineEvent struct {
+	ID           string    `json:"id" gorm:"primaryKey"`
+	UserID       string    `json:"user_id" gorm:"index"`                          // 用户ID
+	WorkID       string    `json:"work_id" gorm:"index"`                          // 所属创作ID
+	Title        string    `json:"title"`                                         // 事件标题
+	Description  string    `json:"description" gorm:"type:text"`                  // 事件说明
+	Year         *int      `json:"year"`                                          // 故事内的年份
+	Month        *int      `json:"month"`                                         // 月份（从1开始，对应历法中的月份）
+	Day          *int      `json:"day"`                                           // 日（从1开始）
+	Ordinal      float64   `json:"ordinal" gorm:"index"`                          // 在故事时间轴上的位置
+	Date         string    `json:"date,omitempty" gorm:"-"`                       // 按历法格式化的日期
+	Participants []string  `json:"participants" gorm:"serializer:json;type:text"` // 参与的人物
+	Location     string    `json:"location"`                                      // 地点
+	DocumentID   string    `json:"document_id" gorm:"index"`                      // 出处章节文档ID
+	Chapter      int       `json:"chapter,omitempty" gorm:"-"`                    // 出处章节在阅读顺序中的序号（从1开始）
+	SourceQuote  string    `json:"source_quote" gorm:"type:text"`                 // 出处原文
+	SourceStart  *int      `json:"source_start"`                                  // 出处原文在章节中的起始位置
+	SourceEnd    *int      `json:"source_end"`                                    // 出处原文在章节中的结束位置（不含）
+	CreatedAt    time.Time `json:"created_at"`                                    // 创建时间
+	UpdatedAt    time.Time `json:"updated_at"`                                    // 更新时间
+}
+
+// TableName 指定表名
+func (TimelineEvent) TableName() string {
+	return "timeline_events"
+}
+
+// TimelineCalendarRequest 设置历法请求
+type TimelineCalendarRequest struct {
+	Era    string          `json:"era"`
+	Months []CalendarMonth `json:"months" binding:"required"`
+}
+
+// TimelineEventRequest 创建或更新时间线事件请求
+// Year、Month、Day可以只给出前一部分（如只有年份）；没有年份时必须给出Ordinal
+type TimelineEventRequest struct {
+	Title        string   `json:"title" binding:"required"`
+	Description  string   `json:"description"`
+	Year         *int     `json:"year"`
+	Month        *int     `json:"month"`
+	Day          *int     `json:"day"`
+	Ordinal      *float64 `json:"ordinal"` // 有年份时忽略，按历法计算
+	Participants []string `json:"participants"`
+	Location     string   `json:"location"`
+	DocumentID   string   `json:"document_id"`
+	SourceQuote  string   `json:"source_quote"`
+}
+
+// TimelineResponse 时间线响应，事件按故事时间排序
+type TimelineResponse struct {
+	Events   []TimelineEvent  `json:"events"`
+	Total    int              `json:"total"`
+	Calendar TimelineCalendar `json:"calendar"`
+	Until    *float64         `json:"until,omitempty"` // 按until_chapter查询时故事进行到的位置
+}
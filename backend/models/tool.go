@@ -0,0 +1,22 @@
+package models
+
+// 工具调用相关的文档角色
+const (
+	RoleToolCall   = "tool_call"   // 助手发起的工具调用
+	RoleToolResult = "tool_result" // 工具执行结果
+)
+
+// ToolCall 模型发起的一次工具调用
+type ToolCall struct {
+	ID        string `json:"id"`        // 调用ID，由服务商生成
+	Name      string `json:"name"`      // 工具名称
+	Arguments string `json:"arguments"` // JSON格式的参数
+}
+
+// ToolResult 工具执行结果
+type ToolResult struct {
+	ToolCallID string `json:"tool_call_id"`       // 对应的调用ID
+	Name       string `json:"name"`               // 工具名称
+	Content    string `json:"content"`            // 返回给模型的内容
+	IsError    bool   `json:"is_error,omitempty"` // 是否执行失败
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// 消息内容片段的类型
+const (
+	PartText  = "text"
+	PartImage = "image"
+)
+
+// Upload 用户上传的文件（目前只支持图片）
+type Upload struct {
+	ID        string    `json:"id" gorm:"primaryKey"`
+	UserID    string    `json:"user_id" gorm:"index"` // 用户ID
+	FileName  string    `json:"file_name"`            // 原始文件名
+	MimeType  string    `json:"mime_type"`            // 文件类型，如image/png
+	Size      int64     `json:"size"`                 // 文件大小（字节）
+	Path      string    `json:"-"`                    // 本地存储路径（相对于上传目录）
+	CreatedAt time.Time `json:"created_at"`           // 创建时间
+}
+
+// TableName 指定表名
+func (Upload) TableName() string {
+	return "uploads"
+}
+
+// ContentPart 消息内容片段：文本，或者通过上传ID/URL引用的图片
+type ContentPart struct {
+	Type     string `json:"type"`                // text 或 image
+	Text     string `json:"text,omitempty"`      // type为text时的文本
+	UploadID string `json:"upload_id,omitempty"` // type为image时引用的上传文件ID
+	URL      string `json:"url,omitempty"`       // type为image时的图片URL（与upload_id二选一）
+	MimeType string `json:"mime_type,omitempty"` // 图片类型，引用上传文件时由服务端填充
+	Data     string `json:"-"`                   // 上传图片的base64内容，发送给服务商之前由服务端填充，不保存也不返回
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// UsageEvent 模型调用用量记录
+type UsageEvent struct {
+	ID           string    `json:"id" gorm:"primaryKey"`
+	UserID       string    `json:"user_id" gorm:"index:idx_usage_user_created"`    // 用户ID
+	Feature      string    `json:"feature" gorm:"index"`                           // 功能标签：chat、work_chat、title、embedding
+	Model        string    `json:"model"`                                          // 实际调用的模型名称
+	InputTokens  int       `json:"input_tokens"`                                   // 输入Token数
+	OutputTokens int       `json:"output_tokens"`                                  // 输出Token数
+	Estimated    bool      `json:"estimated"`                                      // 服务商未返回用量时按文本长度估算
+	CostUSD      float64   `json:"cost_usd"`                                       // 估算费用（美元）
+	CreatedAt    time.Time `json:"created_at" gorm:"index:idx_usage_user_created"` // 创建时间
+}
+
+// TableName 指定表名
+func (UsageEvent) TableName() string {
+	return "usage_events"
+}
+
+// UsageQuota 用户配额（覆盖服务端默认配额）
+type UsageQuota struct {
+	UserID        string    `json:"user_id" gorm:"primaryKey"`
+	DailyTokens   *int64    `json:"daily_tokens"`   // 每日Token上限（为空时使用默认配额，0表示不限制）
+	MonthlyTokens *int64    `json:"monthly_tokens"` // 每月Token上限（为空时使用默认配额，0表示不限制）
+	UpdatedAt     time.Time `json:"updated_at"`     // 更新时间
+}
+
+// TableName 指定表名
+func (UsageQuota) TableName() string {
+	return "usage_quotas"
+}
+
+// ModelPrice 模型单价（美元/百万Token）
+type ModelPrice struct {
+	InputPerMillion  float64
+	OutputPerMillion float64
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// User 用户模型
+type User struct {
+	ID           string    `json:"id" gorm:"primaryKey"`
+	Username     string    `json:"username" gorm:"uniqueIndex"` // 登录用户名
+	PasswordHash string    `json:"-"`                           // bcrypt密码哈希，不对外返回
+	IsAdmin      bool      `json:"is_admin"`                    // 是否为管理员
+	CreatedAt    time.Time `json:"created_at"`                  // 创建时间
+	UpdatedAt    time.Time `json:"updated_at"`                  // 更新时间
+}
+
+// TableName 指定表名
+func (User) TableName() string {
+	return "users"
+}
+
+// Session 登录会话模型
+type Session struct {
+	TokenHash string    `json:"-" gorm:"primaryKey"`  // 会话令牌的SHA-256特征值（不保存明文令牌）
+	UserID    string    `json:"user_id" gorm:"index"` // 用户ID
+	ExpiresAt time.Time `json:"expires_at"`           // 过期时间
+	CreatedAt time.Time `json:"created_at"`           // 创建时间
+}
+
+// TableName 指定表名
+func (Session) TableName() string {
+	return "sessions"
+}
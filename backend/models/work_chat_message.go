@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// WorkChatMessage 灵感模式的对话消息（用户提问、助手回答和工具调用记录），与正文文档分开保存
+type WorkChatMessage struct {
+	ID                 string        `json:"id" gorm:"primaryKey"`
+	WorkID             string        `json:"work_id" gorm:"index"`                                   // 所属创作ID
+	UserID             string        `json:"user_id" gorm:"index"`                                   // 用户ID
+	Role               string        `json:"role"`                                                   // 角色：user、assistant、tool_call 或 tool_result
+	Content            string        `json:"content" gorm:"type:text"`                               // 消息内容
+	Reasoning          string        `json:"reasoning,omitempty" gorm:"type:text"`                   // 推理过程（不参与RAG索引，也不作为历史发送给模型）
+	Model              string        `json:"model"`                                                  // 使用的模型
+	Attachments        []ContentPart `json:"attachments,omitempty" gorm:"serializer:json;type:text"` // 用户消息附带的图片（上传ID或URL）
+	PromotedDocumentID string        `json:"promoted_document_id,omitempty"`                         // 最近一次加入正文时写入的文档ID
+	CreatedAt          time.Time     `json:"created_at"`                                             // 创建时间
+	UpdatedAt          time.Time     `json:"updated_at"`                                             // 更新时间
+}
+
+// TableName 指定表名
+func (WorkChatMessage) TableName() string {
+	return "work_chat_messages"
+}
+
+// WorkChatMessageResponse 灵感模式对话消息列表响应
+type WorkChatMessageResponse struct {
+	Messages []WorkChatMessage `json:"messages"`
+	Total    int               `json:"total"`
+}
+
+// PromoteWorkChatMessageRequest 把助手回答加入正文的请求
+// DocumentID为空时新建一篇正文文档（Title为空时使用回答的第一行），否则追加到该文档末尾
+type PromoteWorkChatMessageRequest struct {
+	DocumentID string `json:"document_id"`
+	Title      string `json:"title"`
+}
@@ -2,20 +2,27 @@ package models
 
 import "time"
 
-// WorkDocument 创作文档模型
+// WorkDocument 创作正文文档模型（灵感模式的对话消息保存在WorkChatMessage中）
 type WorkDocument struct {
-	ID        string    `json:"id" gorm:"primaryKey"`
-	WorkID    string    `json:"work_id" gorm:"index"`     // 所属创作ID
-	UserID    string    `json:"user_id" gorm:"index"`     // 用户ID
-	Title     string    `json:"title"`                    // 文档标题
-	Content   string    `json:"content" gorm:"type:text"` // 文档内容
-	Role      string    `json:"role"`                     // 角色：user或assistant（v1.3：用于灵感模式对话）
-	Model     string    `json:"model"`                    // 使用的模型（v1.3：用于灵感模式对话）
-	CreatedAt time.Time `json:"created_at"`               // 创建时间
-	UpdatedAt time.Time `json:"updated_at"`               // 更新时间
+	ID          string     `json:"id" gorm:"primaryKey"`
+	WorkID      string     `json:"work_id" gorm:"index"`              // 所属创作ID
+	UserID      string     `json:"user_id" gorm:"index"`              // 用户ID
+	Title       string     `json:"title"`                             // 文档标题
+	Content     string     `json:"content" gorm:"type:text"`          // 文档内容
+	Version     int64      `json:"version" gorm:"not null;default:1"` // 版本号，每次修改加一，作为ETag
+	LockToken   string     `json:"-"`                                 // 流式写入锁的持有者
+	LockedUntil *time.Time `json:"locked_until,omitempty"`            // 流式写入锁的过期时间，为空表示未锁定
+	CreatedAt   time.Time  `json:"created_at"`                        // 创建时间
+	UpdatedAt   time.Time  `json:"updated_at"`                        // 更新时间
 }
 
 // TableName 指定表名
 func (WorkDocument) TableName() string {
 	return "work_documents"
 }
+
+// WorkDocumentConflictResponse 写入冲突响应，包含服务器上的当前文档
+type WorkDocumentConflictResponse struct {
+	Error    string       `json:"error"`
+	Document WorkDocument `json:"document"`
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"errors"
+	"grandma/backend/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuthHandler 认证处理器
+type AuthHandler struct {
+	service *AuthService
+}
+
+// NewAuthHandler 创建认证处理器
+func NewAuthHandler(service *AuthService) *AuthHandler {
+	return &AuthHandler{
+		service: service,
+	}
+}
+
+// Register 注册账号
+func (h *AuthHandler) Register(c *gin.Context) {
+	var req models.AuthRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.service.Register(req.Username, req.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUsernameTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrInvalidUsername), errors.Is(err, ErrWeakPassword):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// Login 登录并获取会话令牌
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req models.AuthRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, session, user, err := h.service.Login(req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.LoginResponse{
+		Token:     token,
+		ExpiresAt: session.ExpiresAt,
+		User:      *user,
+	})
+}
+
+// Logout 注销当前会话
+func (h *AuthHandler) Logout(c *gin.Context) {
+	if err := h.service.Logout(currentToken(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+}
+
+// Me 获取当前登录用户
+func (h *AuthHandler) Me(c *gin.Context) {
+	c.JSON(http.StatusOK, CurrentUser(c))
+}
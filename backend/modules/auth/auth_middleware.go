@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"errors"
+	"grandma/backend/models"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	contextUserKey  = "auth_user"
+	contextTokenKey = "auth_token"
+)
+
+// RequireAuth 认证中间件：校验Authorization头中的Bearer令牌，并将当前用户放入请求上下文
+func RequireAuth(service *AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c.GetHeader("Authorization"))
+		user, err := service.Authenticate(token)
+		if err != nil {
+			if errors.Is(err, ErrInvalidSession) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Set(contextUserKey, user)
+		c.Set(contextTokenKey, token)
+		c.Next()
+	}
+}
+
+// RequireAdmin 管理员中间件，必须在RequireAuth之后使用
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if user == nil || !user.IsAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentUser 获取当前已认证的用户（未经过RequireAuth时返回nil）
+func CurrentUser(c *gin.Context) *models.User {
+	value, ok := c.Get(contextUserKey)
+	if !ok {
+		return nil
+	}
+	user, _ := value.(*models.User)
+	return user
+}
+
+// CurrentUserID 获取当前已认证用户的ID
+func CurrentUserID(c *gin.Context) string {
+	if user := CurrentUser(c); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// currentToken 获取当前请求使用的会话令牌
+func currentToken(c *gin.Context) string {
+	return c.GetString(contextTokenKey)
+}
+
+// bearerToken 从Authorization头中解析Bearer令牌
+func bearerToken(header string) string {
+	const prefix = "Bearer "
+	if len(header) > len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+		return strings.TrimSpace(header[len(prefix):])
+	}
+	return ""
+}
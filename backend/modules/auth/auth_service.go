@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/repository"
+	"grandma/backend/utils"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidCredentials 用户名或密码错误
+	ErrInvalidCredentials = errors.New("invalid_credentials")
+	// ErrUsernameTaken 用户名已被占用
+	ErrUsernameTaken = errors.New("username_taken")
+	// ErrInvalidUsername 用户名不合法
+	ErrInvalidUsername = errors.New("invalid_username")
+	// ErrWeakPassword 密码过短
+	ErrWeakPassword = errors.New("weak_password")
+	// ErrInvalidSession 会话无效或已过期
+	ErrInvalidSession = errors.New("invalid_session")
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 32
+	minPasswordLength = 8
+)
+
+// AuthService 认证服务
+type AuthService struct {
+	userRepo    *repository.UserRepository
+	sessionRepo *repository.SessionRepository
+	config      *AuthConfig
+}
+
+// AuthConfig 认证配置
+type AuthConfig struct {
+	SessionTTL     time.Duration // 会话有效期
+	AdminUsernames []string      // 管理员用户名列表
+}
+
+// NewAuthService 创建认证服务
+func NewAuthService(userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository, config *AuthConfig) *AuthService {
+	return &AuthService{
+		userRepo:    userRepo,
+		sessionRepo: sessionRepo,
+		config:      config,
+	}
+}
+
+// Register 注册本地账号
+func (s *AuthService) Register(username, password string) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if n := utf8.RuneCountInString(username); n < minUsernameLength || n > maxUsernameLength {
+		return nil, ErrInvalidUsername
+	}
+	if len(password) < minPasswordLength {
+		return nil, ErrWeakPassword
+	}
+
+	_, err := s.userRepo.GetByUsername(username)
+	if err == nil {
+		return nil, ErrUsernameTaken
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		ID:           utils.GenerateUserID(),
+		Username:     username,
+		PasswordHash: string(hash),
+		IsAdmin:      s.isAdminUsername(username),
+	}
+	if err := s.userRepo.Create(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Login 校验用户名密码并创建会话，返回明文令牌（只在此处返回一次）
+func (s *AuthService) Login(username, password string) (string, *models.Session, *models.User, error) {
+	user, err := s.userRepo.GetByUsername(strings.TrimSpace(username))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil, nil, ErrInvalidCredentials
+		}
+		return "", nil, nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return "", nil, nil, ErrInvalidCredentials
+	}
+
+	// 配置变化后同步管理员标记
+	if isAdmin := s.isAdminUsername(user.Username); isAdmin != user.IsAdmin {
+		if err := s.userRepo.SetAdmin(user.ID, isAdmin); err != nil {
+			return "", nil, nil, err
+		}
+		user.IsAdmin = isAdmin
+	}
+
+	token, err := utils.GenerateSessionToken()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	session := &models.Session{
+		TokenHash: utils.CalculateContentHash(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(s.config.SessionTTL),
+	}
+	if err := s.sessionRepo.Create(session); err != nil {
+		return "", nil, nil, err
+	}
+
+	// 顺便清理过期会话，失败不影响登录
+	_ = s.sessionRepo.DeleteExpired()
+
+	return token, session, user, nil
+}
+
+// Logout 注销会话
+func (s *AuthService) Logout(token string) error {
+	return s.sessionRepo.DeleteByTokenHash(utils.CalculateContentHash(token))
+}
+
+// Authenticate 根据令牌获取当前用户
+func (s *AuthService) Authenticate(token string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrInvalidSession
+	}
+
+	session, err := s.sessionRepo.GetValidByTokenHash(utils.CalculateContentHash(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidSession
+		}
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByID(session.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidSession
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// isAdminUsername 判断用户名是否在管理员列表中
+func (s *AuthService) isAdminUsername(username string) bool {
+	for _, admin := range s.config.AdminUsernames {
+		if admin == username {
+			return true
+		}
+	}
+	return false
+}
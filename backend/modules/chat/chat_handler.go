@@ -1,8 +1,15 @@
 package chat
 
 import (
+	"errors"
 	"fmt"
 	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/modules/credential"
+	"grandma/backend/modules/upload"
+	"grandma/backend/modules/usage"
+	"grandma/backend/repository"
+	"grandma/backend/services"
 	"io"
 	"net/http"
 
@@ -28,6 +35,7 @@ func (h *ChatHandler) Chat(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	req.UserID = auth.CurrentUserID(c)
 
 	// 设置流式响应头
 	c.Header("Content-Type", "text/event-stream")
@@ -37,12 +45,25 @@ func (h *ChatHandler) Chat(c *gin.Context) {
 	c.Header("Access-Control-Allow-Headers", "Content-Type")
 
 	// 创建writer来包装响应以支持流式输出
-	writer := &streamWriter{writer: c.Writer}
+	writer := &streamWriter{writer: c.Writer, reasoning: req.StreamReasoning}
 
 	// 发送消息并获取响应
 	conversationID, documentID, err := h.chatService.SendMessage(&req, writer)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		switch {
+		case repository.IsNotFound(err):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation or work not found"})
+		case errors.Is(err, upload.ErrInvalidContentPart), errors.Is(err, services.ErrImageURLUnsupported):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, credential.ErrProviderKeyMissing):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, usage.ErrQuotaExceeded):
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		case errors.Is(err, credential.ErrMasterKeyNotConfigured):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
 		return
 	}
 
@@ -59,7 +80,8 @@ func (h *ChatHandler) Chat(c *gin.Context) {
 
 // streamWriter 包装响应写入器以支持流式输出
 type streamWriter struct {
-	writer io.Writer
+	writer    io.Writer
+	reasoning bool // 是否向客户端输出推理过程
 }
 
 func (sw *streamWriter) Write(p []byte) (n int, err error) {
@@ -75,3 +97,38 @@ func (sw *streamWriter) Write(p []byte) (n int, err error) {
 
 	return n, nil
 }
+
+// WriteReasoning 推理过程用<GRANDMA_REASONING>标记包裹，与回答内容区分；客户端未请求时不输出
+func (sw *streamWriter) WriteReasoning(p []byte) (n int, err error) {
+	if !sw.reasoning {
+		return len(p), nil
+	}
+	if _, err := sw.Write([]byte("<GRANDMA_REASONING>" + string(p) + "</GRANDMA_REASONING>")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// PreviewPrompt 返回请求会发送给模型的完整消息，不调用模型
+func (h *ChatHandler) PreviewPrompt(c *gin.Context) {
+	var req models.ChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.UserID = auth.CurrentUserID(c)
+
+	response, err := h.chatService.PreviewMessages(&req)
+	if err != nil {
+		switch {
+		case repository.IsNotFound(err):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation or work not found"})
+		case errors.Is(err, upload.ErrInvalidContentPart):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -1,45 +1,100 @@
 package chat
 
 import (
+	"context"
+	"encoding/json"
 	"grandma/backend/models"
+	"grandma/backend/modules/credential"
+	"grandma/backend/modules/extraction"
+	"grandma/backend/modules/prompt"
 	"grandma/backend/modules/rag"
+	"grandma/backend/modules/style"
+	"grandma/backend/modules/timeline"
+	"grandma/backend/modules/upload"
+	"grandma/backend/modules/usage"
 	"grandma/backend/repository"
 	"grandma/backend/services"
 	"grandma/backend/utils"
 	"io"
+	"log"
 	"strings"
 )
 
 // ChatService 聊天服务
 type ChatService struct {
 	conversationRepo *repository.ConversationRepository
+	workRepo         *repository.WorkRepository
 	documentRepo     *repository.DocumentRepository
 	workDocumentRepo *repository.WorkDocumentRepository
+	workMessageRepo  *repository.WorkChatMessageRepository
 	ragService       *rag.RAGService
-	config           *ChatConfig
+	credentialSvc    *credential.CredentialService
+	usageSvc         *usage.UsageService
+	uploadSvc        *upload.UploadService
+	promptSvc        *prompt.PromptService
+	styleSvc         *style.StyleService
+	storyBibleRepo   *repository.StoryBibleRepository
+	extractionSvc    *extraction.ExtractionService
+	timelineSvc      *timeline.TimelineService
+	tools            *ToolRegistry
+	toolConfig       *ToolConfig
 }
 
-// ChatConfig 聊天配置
-type ChatConfig struct {
-	OpenAIAPIKey     string
-	OpenAIBaseURL    string
-	AnthropicAPIKey  string
-	AnthropicBaseURL string
+// ToolConfig 工具调用配置
+type ToolConfig struct {
+	Enabled   bool // 灵感模式下是否允许助手调用工具
+	MaxRounds int  // 单次回答中最多执行几轮工具调用
 }
 
 // NewChatService 创建聊天服务
-func NewChatService(conversationRepo *repository.ConversationRepository, documentRepo *repository.DocumentRepository, workDocumentRepo *repository.WorkDocumentRepository, ragService *rag.RAGService, config *ChatConfig) *ChatService {
-	return &ChatService{
+func NewChatService(conversationRepo *repository.ConversationRepository, workRepo *repository.WorkRepository, documentRepo *repository.DocumentRepository, workDocumentRepo *repository.WorkDocumentRepository, workMessageRepo *repository.WorkChatMessageRepository, storyBibleRepo *repository.StoryBibleRepository, ragService *rag.RAGService, credentialSvc *credential.CredentialService, usageSvc *usage.UsageService, uploadSvc *upload.UploadService, promptSvc *prompt.PromptService, styleSvc *style.StyleService, extractionSvc *extraction.ExtractionService, timelineSvc *timeline.TimelineService, toolConfig *ToolConfig) *ChatService {
+	if toolConfig == nil {
+		toolConfig = &ToolConfig{}
+	}
+	if toolConfig.MaxRounds <= 0 {
+		toolConfig.MaxRounds = 5
+	}
+	if promptSvc == nil {
+		promptSvc = prompt.NewPromptService(nil, nil)
+	}
+	s := &ChatService{
 		conversationRepo: conversationRepo,
+		workRepo:         workRepo,
 		documentRepo:     documentRepo,
 		workDocumentRepo: workDocumentRepo,
+		workMessageRepo:  workMessageRepo,
 		ragService:       ragService,
-		config:           config,
-	}
+		credentialSvc:    credentialSvc,
+		usageSvc:         usageSvc,
+		uploadSvc:        uploadSvc,
+		promptSvc:        promptSvc,
+		styleSvc:         styleSvc,
+		storyBibleRepo:   storyBibleRepo,
+		extractionSvc:    extractionSvc,
+		timelineSvc:      timelineSvc,
+		tools:            NewToolRegistry(),
+		toolConfig:       toolConfig,
+	}
+	s.registerBuiltinTools()
+	return s
+}
+
+// Tools 工具注册表，可以在内置工具之外注册新的工具
+func (s *ChatService) Tools() *ToolRegistry {
+	return s.tools
 }
 
 // SendMessage 发送消息并获取流式响应
 func (s *ChatService) SendMessage(req *models.ChatRequest, writer io.Writer) (string, string, error) {
+	// 校验消息中的图片并读取上传的文件，之后的流程都使用解析后的消息
+	if s.uploadSvc != nil {
+		messages, err := s.uploadSvc.ResolveParts(req.UserID, req.Messages)
+		if err != nil {
+			return "", "", err
+		}
+		req.Messages = messages
+	}
+
 	// v1.3: 支持灵感模式（work_id）和普通模式（conversation_id）
 	if req.WorkID != "" {
 		return s.sendMessageForWork(req, writer)
@@ -47,176 +102,188 @@ func (s *ChatService) SendMessage(req *models.ChatRequest, writer io.Writer) (st
 	return s.sendMessageForConversation(req, writer)
 }
 
-// sendMessageForWork 灵感模式：保存到WorkDocument
-func (s *ChatService) sendMessageForWork(req *models.ChatRequest, writer io.Writer) (string, string, error) {
-	workID := req.WorkID
-	var err error
-
-	// 构建API调用的消息数组
-	var apiMessages []models.Message
-
-	// 获取用户当前消息内容（用于RAG检索）
-	var userQuery string
-	if len(req.Messages) > 0 {
-		lastMsg := req.Messages[len(req.Messages)-1]
-		if lastMsg.Role == "user" {
-			userQuery = lastMsg.Content
+// PreviewMessages 渲染请求会发送给模型的完整消息，不调用模型也不保存任何数据
+// RAG启用时仍会调用Embedding接口检索背景信息
+func (s *ChatService) PreviewMessages(req *models.ChatRequest) (*models.PromptPreviewResponse, error) {
+	if s.uploadSvc != nil {
+		messages, err := s.uploadSvc.ResolveParts(req.UserID, req.Messages)
+		if err != nil {
+			return nil, err
 		}
+		req.Messages = messages
 	}
 
-	// 灵感模式：添加专门的系统提示
-	inspirationSystemPrompt := models.Message{
-		Role: "system",
-		Content: `你是一位专业的长篇故事创作助手。当前处于"灵感模式"，这是专门用于长篇故事创作的协作模式。
-
-## 创作模式说明
-- 你正在协助用户创作一部完整的长篇小说
-- 每次创作的内容都是故事的一部分，需要与整体保持高度一致
-- 用户可能会提出修改、补充、扩展等要求，你需要灵活应对
-
-## 创作原则
-1. **一致性优先**：严格保持人物、世界观、情节的一致性
-2. **自然衔接**：新内容必须与已有内容自然衔接，不能突兀
-3. **细节呼应**：注意伏笔、线索、细节的呼应和连贯
-4. **风格统一**：保持整体文风和叙事风格的一致性
-
-请根据用户的要求和提供的背景信息，创作高质量的故事内容。`,
+	if req.WorkID == "" {
+		var styleProfileID string
+		if req.ConversationID != "" {
+			conversation, err := s.conversationRepo.GetByIDAndUserID(req.ConversationID, req.UserID)
+			if err != nil {
+				return nil, err
+			}
+			styleProfileID = conversation.StyleProfileID
+		}
+		return &models.PromptPreviewResponse{Messages: s.buildConversationMessages(req, req.ConversationID, styleProfileID)}, nil
 	}
-	apiMessages = append(apiMessages, inspirationSystemPrompt)
 
-	// 使用RAG检索相关上下文（如果启用）
-	if s.ragService != nil && userQuery != "" {
-		ragContext, ragErr := s.ragService.BuildRAGContext(userQuery, req.UserID, "", workID)
-		if ragErr == nil && len(ragContext) > 0 {
-			// 将RAG检索到的上下文添加到系统提示之后
-			apiMessages = append(apiMessages, ragContext...)
+	work, err := s.workRepo.GetByIDAndUserID(req.WorkID, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := s.buildWorkMessages(req, work)
+	if err != nil {
+		return nil, err
+	}
+	response := &models.PromptPreviewResponse{Messages: messages}
+	if s.toolConfig.Enabled {
+		for _, tool := range s.tools.Definitions() {
+			response.Tools = append(response.Tools, tool.Name)
 		}
 	}
+	return response, nil
+}
 
-	// 从WorkDocument加载历史消息（只获取最近的5条，因为RAG已经提供了相关背景）
-	historyDocs, err := s.workDocumentRepo.GetLatestDocumentsByWorkID(workID, 5)
-	if err == nil && len(historyDocs) > 0 {
-		// 反转顺序，使其按时间正序排列（最新的在最后）
-		for i, j := 0, len(historyDocs)-1; i < j; i, j = i+1, j-1 {
-			historyDocs[i], historyDocs[j] = historyDocs[j], historyDocs[i]
-		}
-		// 将历史文档转换为消息格式
-		for _, doc := range historyDocs {
-			apiMessages = append(apiMessages, models.Message{
-				Role:    doc.Role,
-				Content: doc.Content,
-			})
-		}
+// sendMessageForWork 灵感模式：保存到WorkChatMessage
+func (s *ChatService) sendMessageForWork(req *models.ChatRequest, writer io.Writer) (string, string, error) {
+	workID := req.WorkID
+
+	// 验证创作属于该用户
+	work, err := s.workRepo.GetByIDAndUserID(workID, req.UserID)
+	if err != nil {
+		return "", "", err
 	}
 
-	// 添加当前用户消息
-	for _, msg := range req.Messages {
-		apiMessages = append(apiMessages, models.Message{
-			Role:    msg.Role,
-			Content: msg.Content,
-		})
+	// 在写入任何数据之前确定使用的服务商凭证（用户自己的Key优先）并检查配额
+	provider, err := s.meteredProvider(req, usage.FeatureWorkChat)
+	if err != nil {
+		return "", "", err
+	}
+
+	// 构建API调用的消息数组
+	apiMessages, err := s.buildWorkMessages(req, work)
+	if err != nil {
+		return "", "", err
 	}
 
 	// 保存最后一条用户消息（必须存在）
-	var userDocID string
 	if len(req.Messages) > 0 {
 		lastMsg := req.Messages[len(req.Messages)-1]
 		if lastMsg.Role == "user" {
-			userDocID = utils.GenerateDocumentID()
-			userDoc := &models.WorkDocument{
-				ID:      userDocID,
-				WorkID:  workID,
-				UserID:  req.UserID,
-				Title:   "", // 用户消息不需要标题
-				Content: lastMsg.Content,
-				Role:    "user",
-				Model:   req.Model,
+			userMessage := &models.WorkChatMessage{
+				ID:          utils.GenerateDocumentID(),
+				WorkID:      workID,
+				UserID:      req.UserID,
+				Role:        "user",
+				Content:     lastMsg.Content,
+				Model:       req.Model,
+				Attachments: upload.Attachments(lastMsg),
 			}
-			err = s.workDocumentRepo.Create(userDoc)
+			err = s.workMessageRepo.Create(userMessage)
 			if err != nil {
 				return "", "", err
 			}
 			// 索引用户消息（异步）
 			if s.ragService != nil {
-				s.ragService.IndexDocument(userDocID, req.UserID, "", workID, lastMsg.Content, "user")
+				s.ragService.IndexDocument(userMessage.ID, req.UserID, "", workID, lastMsg.Content, "user")
 			}
 		}
 	}
 
-	// 调用大模型API获取流式响应
-	provider, err := services.GetProvider(
-		req.Model,
-		s.config.OpenAIAPIKey,
-		s.config.OpenAIBaseURL,
-		s.config.AnthropicAPIKey,
-		s.config.AnthropicBaseURL,
-	)
-	if err != nil {
-		return "", "", err
-	}
-
-	// 首先创建助手文档（空内容）
+	// 首先创建助手消息（空内容）
 	assistantDocID := utils.GenerateDocumentID()
-	assistantDoc := &models.WorkDocument{
+	assistantDoc := &models.WorkChatMessage{
 		ID:      assistantDocID,
 		WorkID:  workID,
 		UserID:  req.UserID,
-		Title:   "", // AI响应不需要标题
-		Content: "",
 		Role:    "assistant",
+		Content: "",
 		Model:   req.Model,
 	}
-	err = s.workDocumentRepo.Create(assistantDoc)
+	err = s.workMessageRepo.Create(assistantDoc)
 	if err != nil {
 		return "", "", err
 	}
 
-	// 创建流式响应收集器，在流式返回时逐步更新文档
+	// 创建流式响应收集器，在流式返回时逐步更新消息
 	responseCollector := &workResponseCollector{
-		writer:           writer,
-		content:          "",
-		workDocumentRepo: s.workDocumentRepo,
-		documentID:       assistantDocID,
-		updateBuffer:     "",
-		bufferSize:       0,
-		ragService:       s.ragService,
-		userID:           req.UserID,
-		conversationID:   "",
-		workID:           workID,
-		role:             "assistant",
-		indexed:          false,
-	}
-	err = provider.ChatStream(apiMessages, responseCollector)
+		writer:          writer,
+		content:         "",
+		workMessageRepo: s.workMessageRepo,
+		documentID:      assistantDocID,
+		updateBuffer:    "",
+		bufferSize:      0,
+		ragService:      s.ragService,
+		userID:          req.UserID,
+		conversationID:  "",
+		workID:          workID,
+		role:            "assistant",
+		indexed:         false,
+	}
+	err = s.chatWithTools(provider, req, apiMessages, responseCollector)
 
 	// 无论流式响应是否成功，都要保存剩余的缓冲区内容
 	if responseCollector.updateBuffer != "" {
-		appendErr := s.workDocumentRepo.AppendContent(assistantDocID, responseCollector.updateBuffer)
+		appendErr := s.workMessageRepo.AppendContentByIDAndUserID(assistantDocID, req.UserID, responseCollector.updateBuffer)
 		if appendErr != nil {
 			if err == nil {
 				err = appendErr
 			}
 		}
 	}
+	if responseCollector.reasoning.Len() > 0 {
+		if updateErr := s.workMessageRepo.UpdateReasoningByIDAndUserID(assistantDocID, req.UserID, responseCollector.reasoning.String()); updateErr != nil && err == nil {
+			err = updateErr
+		}
+	}
+
+	logTruncated(provider, assistantDocID)
+
+	// 记录实际响应的后端（发生故障转移时与请求的模型不同）
+	if backend := activeBackend(provider); backend != "" && backend != assistantDoc.Model {
+		if updateErr := s.workMessageRepo.UpdateModelByIDAndUserID(assistantDocID, req.UserID, backend); updateErr != nil && err == nil {
+			err = updateErr
+		}
+	}
 
 	// 流式响应结束后，触发最终索引（如果还没有索引过）
 	if s.ragService != nil && !responseCollector.indexed && len(responseCollector.content) > 0 {
-		doc, docErr := s.workDocumentRepo.GetByIDAndUserID(assistantDocID, req.UserID)
+		doc, docErr := s.workMessageRepo.GetByIDAndUserID(assistantDocID, req.UserID)
 		if docErr == nil && doc != nil {
 			s.ragService.IndexDocument(doc.ID, doc.UserID, "", doc.WorkID, doc.Content, doc.Role)
 		}
 	}
 
+	// 从完整的回答中提取设定建议（异步）
+	if err == nil && len(responseCollector.content) > 0 {
+		s.extractionSvc.ScheduleWorkMessage(assistantDocID, req.UserID, req.Model)
+	}
+
 	// 返回workID和文档ID（为了兼容前端，返回workID作为conversationID）
 	return workID, assistantDocID, nil
 }
 
 // sendMessageForConversation 普通模式：保存到Document
 func (s *ChatService) sendMessageForConversation(req *models.ChatRequest, writer io.Writer) (string, string, error) {
-	var conversationID string
+	conversationID := req.ConversationID
+	var styleProfileID string
 	var err error
 
+	// 验证对话属于该用户
+	if conversationID != "" {
+		conversation, err := s.conversationRepo.GetByIDAndUserID(conversationID, req.UserID)
+		if err != nil {
+			return "", "", err
+		}
+		styleProfileID = conversation.StyleProfileID
+	}
+
+	// 在写入任何数据之前确定使用的服务商凭证（用户自己的Key优先）并检查配额
+	provider, err := s.meteredProvider(req, usage.FeatureChat)
+	if err != nil {
+		return "", "", err
+	}
+
 	// 如果没有提供对话ID，创建新对话
-	if req.ConversationID == "" {
+	if conversationID == "" {
 		conversationID = utils.GenerateConversationID()
 		conversation := &models.Conversation{
 			ID:          conversationID,
@@ -228,64 +295,10 @@ func (s *ChatService) sendMessageForConversation(req *models.ChatRequest, writer
 		if err != nil {
 			return "", "", err
 		}
-	} else {
-		conversationID = req.ConversationID
-		// 验证对话属于该用户
-		_, err = s.conversationRepo.GetByIDAndUserID(conversationID, req.UserID)
-		if err != nil {
-			return "", "", err
-		}
 	}
 
 	// 构建API调用的消息数组
-	var apiMessages []models.Message
-
-	// 获取用户当前消息内容（用于RAG检索）
-	var userQuery string
-	if len(req.Messages) > 0 {
-		lastMsg := req.Messages[len(req.Messages)-1]
-		if lastMsg.Role == "user" {
-			userQuery = lastMsg.Content
-		}
-	}
-
-	// 使用RAG检索相关上下文（如果启用）
-	if s.ragService != nil && userQuery != "" {
-		ragContext, err := s.ragService.BuildRAGContext(userQuery, req.UserID, conversationID, "")
-		if err == nil && len(ragContext) > 0 {
-			// 将RAG检索到的上下文添加到消息数组开头
-			apiMessages = append(apiMessages, ragContext...)
-		}
-	}
-
-	// 如果提供了对话ID，从数据库加载历史消息
-	// 优化：只加载最近的对话上下文，而不是全部历史
-	// 保留最近3-5条消息作为直接上下文（保证对话连贯性）
-	if req.ConversationID != "" {
-		// 获取对话的历史文档（只获取最近的5条，因为RAG已经提供了相关背景）
-		historyDocs, err := s.documentRepo.GetLatestDocumentsByConversationID(conversationID, 5)
-		if err == nil && len(historyDocs) > 0 {
-			// 反转顺序，使其按时间正序排列（最新的在最后）
-			for i, j := 0, len(historyDocs)-1; i < j; i, j = i+1, j-1 {
-				historyDocs[i], historyDocs[j] = historyDocs[j], historyDocs[i]
-			}
-			// 将历史文档转换为消息格式
-			for _, doc := range historyDocs {
-				apiMessages = append(apiMessages, models.Message{
-					Role:    doc.Role,
-					Content: doc.Content,
-				})
-			}
-		}
-	}
-
-	// 添加当前用户消息
-	for _, msg := range req.Messages {
-		apiMessages = append(apiMessages, models.Message{
-			Role:    msg.Role,
-			Content: msg.Content,
-		})
-	}
+	apiMessages := s.buildConversationMessages(req, conversationID, styleProfileID)
 
 	// 保存最后一条用户消息（必须存在）
 	var userDocID string
@@ -299,6 +312,7 @@ func (s *ChatService) sendMessageForConversation(req *models.ChatRequest, writer
 				ConversationID: conversationID,
 				Role:           "user",
 				Content:        lastMsg.Content,
+				Attachments:    upload.Attachments(lastMsg),
 				Model:          req.Model,
 			}
 			err = s.documentRepo.Create(userDoc)
@@ -306,7 +320,7 @@ func (s *ChatService) sendMessageForConversation(req *models.ChatRequest, writer
 				return "", "", err
 			}
 			// 添加用户文档ID到对话的文档ID列表
-			err = s.conversationRepo.AppendDocumentID(conversationID, userDocID)
+			err = s.conversationRepo.AppendDocumentID(conversationID, req.UserID, userDocID)
 			if err != nil {
 				return "", "", err
 			}
@@ -317,18 +331,6 @@ func (s *ChatService) sendMessageForConversation(req *models.ChatRequest, writer
 		}
 	}
 
-	// 调用大模型API获取流式响应
-	provider, err := services.GetProvider(
-		req.Model,
-		s.config.OpenAIAPIKey,
-		s.config.OpenAIBaseURL,
-		s.config.AnthropicAPIKey,
-		s.config.AnthropicBaseURL,
-	)
-	if err != nil {
-		return "", "", err
-	}
-
 	// 首先创建助手文档（空内容）
 	assistantDocID := utils.GenerateDocumentID()
 	assistantDoc := &models.Document{
@@ -358,12 +360,18 @@ func (s *ChatService) sendMessageForConversation(req *models.ChatRequest, writer
 		workID:         "",
 		role:           "assistant",
 	}
-	err = provider.ChatStream(apiMessages, responseCollector)
+	// 回答不随请求取消：客户端断开后继续生成并保存完整内容
+	err = provider.ChatStream(context.Background(), apiMessages, responseCollector)
 
 	// 无论流式响应是否成功，都要保存剩余的缓冲区内容
 	// 这样即使客户端断开连接，已接收的内容也会被保存
+	if responseCollector.reasoning.Len() > 0 {
+		if updateErr := s.documentRepo.UpdateReasoningByIDAndUserID(assistantDocID, req.UserID, responseCollector.reasoning.String()); updateErr != nil && err == nil {
+			err = updateErr
+		}
+	}
 	if responseCollector.updateBuffer != "" {
-		appendErr := s.documentRepo.AppendContent(assistantDocID, responseCollector.updateBuffer)
+		appendErr := s.documentRepo.AppendContentByIDAndUserID(assistantDocID, req.UserID, responseCollector.updateBuffer)
 		if appendErr != nil {
 			// 如果保存失败，记录错误但不影响主流程
 			// 因为如果流式响应成功，后续会继续保存
@@ -373,9 +381,18 @@ func (s *ChatService) sendMessageForConversation(req *models.ChatRequest, writer
 		}
 	}
 
+	logTruncated(provider, assistantDocID)
+
+	// 记录实际响应的后端（发生故障转移时与请求的模型不同）
+	if backend := activeBackend(provider); backend != "" && backend != assistantDoc.Model {
+		if updateErr := s.documentRepo.UpdateModelByIDAndUserID(assistantDocID, req.UserID, backend); updateErr != nil && err == nil {
+			err = updateErr
+		}
+	}
+
 	// 流式响应结束后，触发最终索引（如果还没有索引过）
 	if s.ragService != nil && !responseCollector.indexed && len(responseCollector.content) > 0 {
-		doc, docErr := s.documentRepo.GetByID(assistantDocID)
+		doc, docErr := s.documentRepo.GetByIDAndUserID(assistantDocID, req.UserID)
 		if docErr == nil && doc != nil {
 			s.ragService.IndexDocument(doc.ID, doc.UserID, doc.ConversationID, "", doc.Content, doc.Role)
 		}
@@ -388,7 +405,7 @@ func (s *ChatService) sendMessageForConversation(req *models.ChatRequest, writer
 	_ = err // 忽略流式响应错误，继续保存已接收的内容
 
 	// 添加助手文档ID到对话的文档ID列表（即使流式响应失败也要添加）
-	errAppend := s.conversationRepo.AppendDocumentID(conversationID, assistantDocID)
+	errAppend := s.conversationRepo.AppendDocumentID(conversationID, req.UserID, assistantDocID)
 	if errAppend != nil {
 		// 如果添加文档ID失败，记录错误
 		// 但继续执行，确保已保存的内容可以被访问
@@ -400,6 +417,268 @@ func (s *ChatService) sendMessageForConversation(req *models.ChatRequest, writer
 	return conversationID, assistantDocID, nil
 }
 
+// chatWithTools 灵感模式的工具调用循环：模型要求调用工具时执行工具并带着结果继续对话，
+// 直到模型给出最终回答；工具调用和结果分别保存为tool_call和tool_result角色的文档
+func (s *ChatService) chatWithTools(provider services.ChatProvider, req *models.ChatRequest, messages []models.Message, collector *workResponseCollector) error {
+	if !s.toolConfig.Enabled {
+		return provider.ChatStream(context.Background(), messages, collector)
+	}
+
+	definitions := s.tools.Definitions()
+	ctx := ToolContext{UserID: req.UserID, WorkID: req.WorkID}
+	for round := 0; round < s.toolConfig.MaxRounds; round++ {
+		start := len(collector.content)
+		calls, err := services.ChatStreamWithTools(context.Background(), provider, messages, definitions, collector)
+		if err != nil || len(calls) == 0 {
+			return err
+		}
+
+		messages = append(messages, models.Message{
+			Role:      "assistant",
+			Content:   collector.content[start:],
+			ToolCalls: calls,
+		})
+		for _, call := range calls {
+			if err := s.saveToolMessage(req, models.RoleToolCall, call); err != nil {
+				return err
+			}
+			result := s.tools.Execute(ctx, call)
+			if err := s.saveToolMessage(req, models.RoleToolResult, result); err != nil {
+				return err
+			}
+			messages = append(messages, models.Message{
+				Role:       "tool",
+				Content:    result.Content,
+				ToolCallID: call.ID,
+			})
+		}
+	}
+
+	// 达到最大轮数后不再提供工具，要求模型直接回答
+	log.Printf("Tool call rounds exceeded %d for work %s", s.toolConfig.MaxRounds, req.WorkID)
+	return provider.ChatStream(context.Background(), services.FlattenToolMessages(messages), collector)
+}
+
+// saveToolMessage 保存工具调用或结果（JSON格式），不参与RAG索引
+func (s *ChatService) saveToolMessage(req *models.ChatRequest, role string, payload interface{}) error {
+	content, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return s.workMessageRepo.Create(&models.WorkChatMessage{
+		ID:      utils.GenerateDocumentID(),
+		WorkID:  req.WorkID,
+		UserID:  req.UserID,
+		Content: string(content),
+		Role:    role,
+		Model:   req.Model,
+	})
+}
+
+// meteredProvider 检查用户配额，并返回记录用量的服务提供者
+func (s *ChatService) meteredProvider(req *models.ChatRequest, feature string) (services.ChatProvider, error) {
+	provider, err := s.credentialSvc.GetProvider(req.UserID, req.Model)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.usageSvc.CheckQuota(req.UserID); err != nil {
+		return nil, err
+	}
+	return s.usageSvc.Meter(provider, req.UserID, feature), nil
+}
+
+// activeBackend 获取实际响应的后端名称
+func activeBackend(provider services.ChatProvider) string {
+	if reporter, ok := provider.(services.BackendReporter); ok {
+		return reporter.ActiveBackend()
+	}
+	return ""
+}
+
+// logTruncated 回复因达到最大Token数被截断时记录日志
+func logTruncated(provider services.ChatProvider, docID string) {
+	reporter, ok := provider.(services.UsageReporter)
+	if !ok {
+		return
+	}
+	// OpenAI兼容接口为length，Anthropic为max_tokens
+	if reason := reporter.LastUsage().FinishReason; reason == "length" || reason == "max_tokens" {
+		log.Printf("Response %s was truncated by max tokens", docID)
+	}
+}
+
+// stylePrompt 把文风档案编译成系统提示，未设置文风档案或编译失败时返回空字符串
+func (s *ChatService) stylePrompt(userID, workID, profileID string) string {
+	if profileID == "" || s.styleSvc == nil {
+		return ""
+	}
+	stylePrompt, err := s.styleSvc.Compile(userID, workID, profileID)
+	if err != nil {
+		log.Printf("Failed to compile style profile %s: %v", profileID, err)
+		return ""
+	}
+	return stylePrompt
+}
+
+// WorkContextMessages 灵感模式的系统提示（包含创作的文风要求）、当前章节之前的时间线事件和与query相关的RAG背景信息，
+// documentID为空时不注入时间线；对话之外的写作功能（如片段编辑）也使用同样的上下文
+func (s *ChatService) WorkContextMessages(userID string, work *models.Work, documentID, query string) ([]models.Message, error) {
+	// 灵感模式：添加专门的系统提示（可由用户或创作的模板覆盖）
+	systemPrompt, err := s.promptSvc.Render(userID, work.ID, prompt.TemplateWorkSystem, prompt.WorkSystemData{WorkTitle: work.Title})
+	if err != nil {
+		return nil, err
+	}
+	// 创作设置了文风档案时，把文风要求追加到系统提示
+	if stylePrompt := s.stylePrompt(userID, work.ID, work.StyleProfileID); stylePrompt != "" {
+		systemPrompt += "\n\n" + stylePrompt
+	}
+	messages := []models.Message{{Role: "system", Content: systemPrompt}}
+
+	// 指定了当前章节时，注入故事时间早于该章的事件
+	if documentID != "" && s.timelineSvc != nil {
+		timelinePrompt, err := s.timelinePrompt(userID, work.ID, documentID)
+		if err != nil {
+			return nil, err
+		}
+		if timelinePrompt != "" {
+			messages = append(messages, models.Message{Role: "system", Content: timelinePrompt})
+		}
+	}
+
+	// 使用RAG检索相关上下文（如果启用）
+	if s.ragService != nil && query != "" {
+		ragContext, err := s.ragService.BuildRAGContext(query, userID, "", work.ID)
+		if err == nil && len(ragContext) > 0 {
+			// 将RAG检索到的上下文添加到系统提示之后
+			messages = append(messages, ragContext...)
+		}
+	}
+	return messages, nil
+}
+
+// timelinePrompt 把当前章节之前的时间线事件渲染成系统提示，没有事件时返回空字符串
+func (s *ChatService) timelinePrompt(userID, workID, documentID string) (string, error) {
+	doc, events, err := s.timelineSvc.PrecedingEvents(workID, documentID, userID)
+	if err != nil || len(events) == 0 {
+		return "", err
+	}
+	data := prompt.TimelineData{Chapter: doc.Title}
+	for _, event := range events {
+		data.Events = append(data.Events, prompt.TimelineItem{
+			Date:         event.Date,
+			Title:        event.Title,
+			Description:  event.Description,
+			Participants: event.Participants,
+			Location:     event.Location,
+		})
+	}
+	return s.promptSvc.Render(userID, workID, prompt.TemplateTimeline, data)
+}
+
+// buildWorkMessages 构建灵感模式发送给模型的消息：系统提示、RAG背景信息、最近的历史和当前消息
+func (s *ChatService) buildWorkMessages(req *models.ChatRequest, work *models.Work) ([]models.Message, error) {
+	// 获取用户当前消息内容（用于RAG检索）
+	var userQuery string
+	if len(req.Messages) > 0 {
+		lastMsg := req.Messages[len(req.Messages)-1]
+		if lastMsg.Role == "user" {
+			userQuery = lastMsg.Content
+		}
+	}
+
+	apiMessages, err := s.WorkContextMessages(req.UserID, work, req.DocumentID, userQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	// 加载最近的对话消息（只获取最近的5条，因为RAG已经提供了相关背景）
+	historyDocs, err := s.workMessageRepo.GetLatestByWorkIDAndUserID(work.ID, req.UserID, 5)
+	if err == nil && len(historyDocs) > 0 {
+		// 反转顺序，使其按时间正序排列（最新的在最后）
+		for i, j := 0, len(historyDocs)-1; i < j; i, j = i+1, j-1 {
+			historyDocs[i], historyDocs[j] = historyDocs[j], historyDocs[i]
+		}
+		// 将历史文档转换为消息格式
+		for _, doc := range historyDocs {
+			apiMessages = append(apiMessages, models.Message{
+				Role:    doc.Role,
+				Content: doc.Content,
+			})
+		}
+	}
+
+	// 添加当前用户消息（保留图片片段，历史消息只使用文本）
+	for _, msg := range req.Messages {
+		apiMessages = append(apiMessages, models.Message{
+			Role:    msg.Role,
+			Content: msg.Content,
+			Parts:   msg.Parts,
+		})
+	}
+
+	return apiMessages, nil
+}
+
+// buildConversationMessages 构建普通模式发送给模型的消息：文风要求、RAG背景信息、最近的历史和当前消息
+func (s *ChatService) buildConversationMessages(req *models.ChatRequest, conversationID, styleProfileID string) []models.Message {
+	var apiMessages []models.Message
+
+	// 对话设置了文风档案时，把文风要求作为系统提示
+	if stylePrompt := s.stylePrompt(req.UserID, "", styleProfileID); stylePrompt != "" {
+		apiMessages = append(apiMessages, models.Message{Role: "system", Content: stylePrompt})
+	}
+
+	// 获取用户当前消息内容（用于RAG检索）
+	var userQuery string
+	if len(req.Messages) > 0 {
+		lastMsg := req.Messages[len(req.Messages)-1]
+		if lastMsg.Role == "user" {
+			userQuery = lastMsg.Content
+		}
+	}
+
+	// 使用RAG检索相关上下文（如果启用）
+	if s.ragService != nil && userQuery != "" {
+		ragContext, err := s.ragService.BuildRAGContext(userQuery, req.UserID, conversationID, "")
+		if err == nil && len(ragContext) > 0 {
+			// 将RAG检索到的上下文添加到消息数组开头
+			apiMessages = append(apiMessages, ragContext...)
+		}
+	}
+
+	// 如果提供了对话ID，从数据库加载历史消息
+	// 优化：只加载最近的对话上下文，而不是全部历史
+	// 保留最近3-5条消息作为直接上下文（保证对话连贯性）
+	if req.ConversationID != "" {
+		// 获取对话的历史文档（只获取最近的5条，因为RAG已经提供了相关背景）
+		historyDocs, err := s.documentRepo.GetLatestDocumentsByConversationIDAndUserID(conversationID, req.UserID, 5)
+		if err == nil && len(historyDocs) > 0 {
+			// 反转顺序，使其按时间正序排列（最新的在最后）
+			for i, j := 0, len(historyDocs)-1; i < j; i, j = i+1, j-1 {
+				historyDocs[i], historyDocs[j] = historyDocs[j], historyDocs[i]
+			}
+			// 将历史文档转换为消息格式
+			for _, doc := range historyDocs {
+				apiMessages = append(apiMessages, models.Message{
+					Role:    doc.Role,
+					Content: doc.Content,
+				})
+			}
+		}
+	}
+
+	// 添加当前用户消息（保留图片片段，历史消息只使用文本）
+	for _, msg := range req.Messages {
+		apiMessages = append(apiMessages, models.Message{
+			Role:    msg.Role,
+			Content: msg.Content,
+			Parts:   msg.Parts,
+		})
+	}
+
+	return apiMessages
+}
+
 // generateTitle 从消息内容生成对话标题
 func (s *ChatService) generateTitle(messages []models.Message) string {
 	if len(messages) == 0 {
@@ -409,9 +688,7 @@ func (s *ChatService) generateTitle(messages []models.Message) string {
 	for _, msg := range messages {
 		if msg.Role == "user" {
 			title := strings.TrimSpace(msg.Content)
-			if len(title) > 50 {
-				title = title[:50] + "..."
-			}
+			title = utils.TruncateRunes(title, 50, "...")
 			if title == "" {
 				title = "新对话"
 			}
@@ -434,23 +711,25 @@ type responseCollector struct {
 	conversationID string
 	workID         string
 	role           string
-	indexed        bool // 是否已经触发索引
+	indexed        bool            // 是否已经触发索引
+	reasoning      strings.Builder // 推理过程，单独保存，不参与索引
 }
 
-// workResponseCollector 收集流式响应内容并在流式返回时逐步更新WorkDocument（v1.3：灵感模式）
+// workResponseCollector 收集流式响应内容并在流式返回时逐步更新WorkChatMessage（v1.3：灵感模式）
 type workResponseCollector struct {
-	writer           io.Writer
-	content          string
-	workDocumentRepo *repository.WorkDocumentRepository
-	documentID       string
-	updateBuffer     string
-	bufferSize       int
-	ragService       *rag.RAGService
-	userID           string
-	conversationID   string
-	workID           string
-	role             string
-	indexed          bool // 是否已经触发索引
+	writer          io.Writer
+	content         string
+	workMessageRepo *repository.WorkChatMessageRepository
+	documentID      string
+	updateBuffer    string
+	bufferSize      int
+	ragService      *rag.RAGService
+	userID          string
+	conversationID  string
+	workID          string
+	role            string
+	indexed         bool            // 是否已经触发索引
+	reasoning       strings.Builder // 推理过程，单独保存，不参与索引
 }
 
 const updateBufferThreshold = 100 // 每100个字符更新一次数据库
@@ -468,7 +747,7 @@ func (rc *responseCollector) Write(p []byte) (n int, err error) {
 	// 当缓冲区达到阈值时，更新数据库
 	// 即使写入客户端失败，也要保存到数据库
 	if rc.bufferSize >= updateBufferThreshold {
-		err = rc.documentRepo.AppendContent(rc.documentID, rc.updateBuffer)
+		err = rc.documentRepo.AppendContentByIDAndUserID(rc.documentID, rc.userID, rc.updateBuffer)
 		if err != nil {
 			// 如果保存数据库失败，返回错误
 			// 但如果只是写入客户端失败，不影响数据库保存
@@ -484,7 +763,7 @@ func (rc *responseCollector) Write(p []byte) (n int, err error) {
 		// 异步索引，不阻塞流式响应
 		go func() {
 			// 获取当前文档内容进行索引
-			doc, err := rc.documentRepo.GetByID(rc.documentID)
+			doc, err := rc.documentRepo.GetByIDAndUserID(rc.documentID, rc.userID)
 			if err == nil && doc != nil {
 				rc.ragService.IndexDocument(doc.ID, doc.UserID, doc.ConversationID, "", doc.Content, doc.Role)
 			}
@@ -513,7 +792,7 @@ func (rc *workResponseCollector) Write(p []byte) (n int, err error) {
 	// 当缓冲区达到阈值时，更新数据库
 	// 即使写入客户端失败，也要保存到数据库
 	if rc.bufferSize >= updateBufferThreshold {
-		err = rc.workDocumentRepo.AppendContent(rc.documentID, rc.updateBuffer)
+		err = rc.workMessageRepo.AppendContentByIDAndUserID(rc.documentID, rc.userID, rc.updateBuffer)
 		if err != nil {
 			// 如果保存数据库失败，返回错误
 			// 但如果只是写入客户端失败，不影响数据库保存
@@ -529,7 +808,7 @@ func (rc *workResponseCollector) Write(p []byte) (n int, err error) {
 		// 异步索引，不阻塞流式响应
 		go func() {
 			// 获取当前文档内容进行索引
-			doc, err := rc.workDocumentRepo.GetByIDAndUserID(rc.documentID, rc.userID)
+			doc, err := rc.workMessageRepo.GetByIDAndUserID(rc.documentID, rc.userID)
 			if err == nil && doc != nil {
 				rc.ragService.IndexDocument(doc.ID, doc.UserID, "", doc.WorkID, doc.Content, doc.Role)
 			}
@@ -544,3 +823,15 @@ func (rc *workResponseCollector) Write(p []byte) (n int, err error) {
 
 	return len(p), nil
 }
+
+// WriteReasoning 转发推理内容，只在流结束后保存到文档的reasoning字段
+func (rc *responseCollector) WriteReasoning(p []byte) (int, error) {
+	rc.reasoning.Write(p)
+	return len(p), services.WriteReasoning(rc.writer, string(p))
+}
+
+// WriteReasoning 转发推理内容，只在流结束后保存到文档的reasoning字段
+func (rc *workResponseCollector) WriteReasoning(p []byte) (int, error) {
+	rc.reasoning.Write(p)
+	return len(p), services.WriteReasoning(rc.writer, string(p))
+}
@@ -0,0 +1,339 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grandma/backend/models"
+	"grandma/backend/repository"
+	"grandma/backend/services"
+	"grandma/backend/utils"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxToolOutputRunes 单次工具结果返回给模型的最大字符数
+const maxToolOutputRunes = 8000
+
+// storyBibleCategories 设定集允许的分类
+var storyBibleCategories = []string{"character", "location", "item", "event", "lore", "other"}
+
+// ToolContext 工具执行时的上下文，工具只能访问当前用户当前创作的数据
+type ToolContext struct {
+	UserID string
+	WorkID string
+}
+
+// ToolHandler 工具的处理函数，args为模型给出的JSON参数，返回交给模型的文本
+type ToolHandler func(ctx ToolContext, args json.RawMessage) (string, error)
+
+// Tool 助手可以调用的工具
+type Tool struct {
+	Name        string                 // 工具名称
+	Description string                 // 工具用途说明
+	Parameters  map[string]interface{} // 参数的JSON Schema
+	Handler     ToolHandler
+}
+
+// ToolRegistry 已注册的工具
+type ToolRegistry struct {
+	tools []Tool
+	index map[string]int
+}
+
+// NewToolRegistry 创建工具注册表
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{index: map[string]int{}}
+}
+
+// Register 注册工具，同名工具会被替换
+func (r *ToolRegistry) Register(tool Tool) {
+	if i, ok := r.index[tool.Name]; ok {
+		r.tools[i] = tool
+		return
+	}
+	r.index[tool.Name] = len(r.tools)
+	r.tools = append(r.tools, tool)
+}
+
+// Definitions 提供给模型的工具声明
+func (r *ToolRegistry) Definitions() []services.ToolDefinition {
+	definitions := make([]services.ToolDefinition, len(r.tools))
+	for i, tool := range r.tools {
+		definitions[i] = services.ToolDefinition{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.Parameters,
+		}
+	}
+	return definitions
+}
+
+// Execute 执行一次工具调用，失败时把错误信息作为结果返回给模型
+func (r *ToolRegistry) Execute(ctx ToolContext, call models.ToolCall) models.ToolResult {
+	result := models.ToolResult{ToolCallID: call.ID, Name: call.Name}
+
+	i, ok := r.index[call.Name]
+	if !ok {
+		result.Content = fmt.Sprintf("unknown tool: %s", call.Name)
+		result.IsError = true
+		return result
+	}
+
+	args := json.RawMessage(call.Arguments)
+	if strings.TrimSpace(call.Arguments) == "" {
+		args = json.RawMessage("{}")
+	}
+	if !json.Valid(args) {
+		result.Content = "invalid arguments: not a JSON object"
+		result.IsError = true
+		return result
+	}
+
+	content, err := r.tools[i].Handler(ctx, args)
+	if err != nil {
+		result.Content = err.Error()
+		result.IsError = true
+		return result
+	}
+	result.Content = utils.TruncateRunes(content, maxToolOutputRunes, "\n（内容过长，已截断）")
+	return result
+}
+
+// registerBuiltinTools 注册访问当前创作数据的内置工具
+func (s *ChatService) registerBuiltinTools() {
+	s.tools.Register(Tool{
+		Name:        "search_work",
+		Description: "在当前创作的正文和灵感对话中搜索与查询相关的片段，用于查找人物、情节、伏笔等已有内容",
+		Parameters: objectSchema(map[string]interface{}{
+			"query": stringProperty("要搜索的内容"),
+			"limit": map[string]interface{}{"type": "integer", "description": "最多返回的片段数，默认5"},
+		}, "query"),
+		Handler: s.searchWorkTool,
+	})
+	s.tools.Register(Tool{
+		Name:        "read_document",
+		Description: "按标题读取当前创作中的一篇正文文档（章节）的全文",
+		Parameters: objectSchema(map[string]interface{}{
+			"title": stringProperty("文档标题"),
+		}, "title"),
+		Handler: s.readDocumentTool,
+	})
+	s.tools.Register(Tool{
+		Name:        "list_chapters",
+		Description: "列出当前创作的所有正文文档（章节）的标题和字数",
+		Parameters:  objectSchema(map[string]interface{}{}),
+		Handler:     s.listChaptersTool,
+	})
+	s.tools.Register(Tool{
+		Name:        "append_story_bible",
+		Description: "向当前创作的设定集追加设定；同一分类下已有同名条目时追加到该条目，否则新建条目",
+		Parameters: objectSchema(map[string]interface{}{
+			"category": map[string]interface{}{
+				"type":        "string",
+				"enum":        storyBibleCategories,
+				"description": "设定分类：character人物、location地点、item物品、event事件、lore世界观、other其他",
+			},
+			"name":    stringProperty("条目名称，如人物姓名"),
+			"content": stringProperty("要追加的设定内容"),
+		}, "category", "name", "content"),
+		Handler: s.appendStoryBibleTool,
+	})
+}
+
+// searchWorkTool 优先使用向量检索，RAG未启用或没有结果时按关键字搜索正文
+func (s *ChatService) searchWorkTool(ctx ToolContext, raw json.RawMessage) (string, error) {
+	var args struct {
+		Query string `json:"query"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", err
+	}
+	args.Query = strings.TrimSpace(args.Query)
+	if args.Query == "" {
+		return "", errors.New("query is required")
+	}
+	if args.Limit <= 0 || args.Limit > 10 {
+		args.Limit = 5
+	}
+
+	var snippets []string
+	if s.ragService != nil {
+		chunks, err := s.ragService.SearchWork(args.Query, ctx.UserID, ctx.WorkID, args.Limit)
+		if err != nil {
+			return "", err
+		}
+		for _, chunk := range chunks {
+			snippets = append(snippets, chunk.Content)
+		}
+	}
+
+	if len(snippets) == 0 {
+		docs, err := s.workDocumentRepo.GetByWorkIDAndUserID(ctx.WorkID, ctx.UserID)
+		if err != nil {
+			return "", err
+		}
+		for _, doc := range docs {
+			if len(snippets) >= args.Limit {
+				break
+			}
+			if snippet, ok := keywordSnippet(doc.Content, args.Query, 200); ok {
+				snippets = append(snippets, fmt.Sprintf("《%s》…%s…", doc.Title, snippet))
+			}
+		}
+	}
+
+	if len(snippets) == 0 {
+		return "没有找到相关内容", nil
+	}
+	var out strings.Builder
+	for i, snippet := range snippets {
+		fmt.Fprintf(&out, "[%d]\n%s\n\n", i+1, snippet)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// readDocumentTool 按标题读取正文，没有完全匹配时使用第一篇包含该标题的文档
+func (s *ChatService) readDocumentTool(ctx ToolContext, raw json.RawMessage) (string, error) {
+	var args struct {
+		Title string `json:"title"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", err
+	}
+	title := strings.TrimSpace(args.Title)
+	if title == "" {
+		return "", errors.New("title is required")
+	}
+
+	docs, err := s.workDocumentRepo.GetByWorkIDAndUserID(ctx.WorkID, ctx.UserID)
+	if err != nil {
+		return "", err
+	}
+	var found *models.WorkDocument
+	for i := range docs {
+		if docs[i].Title == title {
+			found = &docs[i]
+			break
+		}
+		if found == nil && strings.Contains(docs[i].Title, title) {
+			found = &docs[i]
+		}
+	}
+	if found == nil {
+		return "", fmt.Errorf("document not found: %s", title)
+	}
+	return fmt.Sprintf("《%s》\n\n%s", found.Title, found.Content), nil
+}
+
+// listChaptersTool 按创建顺序列出正文文档
+func (s *ChatService) listChaptersTool(ctx ToolContext, _ json.RawMessage) (string, error) {
+	docs, err := s.workDocumentRepo.GetByWorkIDAndUserID(ctx.WorkID, ctx.UserID)
+	if err != nil {
+		return "", err
+	}
+	if len(docs) == 0 {
+		return "当前创作还没有正文文档", nil
+	}
+	var out strings.Builder
+	for i, doc := range docs {
+		title := doc.Title
+		if title == "" {
+			title = "（无标题）"
+		}
+		fmt.Fprintf(&out, "%d. %s（%d字）\n", i+1, title, utf8.RuneCountInString(doc.Content))
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// appendStoryBibleTool 追加或新建设定条目
+func (s *ChatService) appendStoryBibleTool(ctx ToolContext, raw json.RawMessage) (string, error) {
+	var args struct {
+		Category string `json:"category"`
+		Name     string `json:"name"`
+		Content  string `json:"content"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", err
+	}
+	args.Category = strings.TrimSpace(args.Category)
+	args.Name = strings.TrimSpace(args.Name)
+	args.Content = strings.TrimSpace(args.Content)
+	if args.Name == "" || args.Content == "" {
+		return "", errors.New("name and content are required")
+	}
+	if !validStoryBibleCategory(args.Category) {
+		return "", fmt.Errorf("invalid category: %s", args.Category)
+	}
+
+	entry, err := s.storyBibleRepo.GetByNameAndUserID(ctx.WorkID, ctx.UserID, args.Category, args.Name)
+	if err != nil && !repository.IsNotFound(err) {
+		return "", err
+	}
+	if entry != nil {
+		if err := s.storyBibleRepo.AppendContentByIDAndUserID(entry.ID, ctx.UserID, "\n"+args.Content); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("已追加到设定「%s」", args.Name), nil
+	}
+
+	entry = &models.StoryBibleEntry{
+		ID:       utils.GenerateStoryBibleEntryID(),
+		UserID:   ctx.UserID,
+		WorkID:   ctx.WorkID,
+		Category: args.Category,
+		Name:     args.Name,
+		Content:  args.Content,
+		Source:   "assistant",
+	}
+	if err := s.storyBibleRepo.Create(entry); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("已新建设定「%s」", args.Name), nil
+}
+
+// validStoryBibleCategory 检查设定分类是否合法
+func validStoryBibleCategory(category string) bool {
+	for _, c := range storyBibleCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// objectSchema 构建object类型的参数Schema
+func objectSchema(properties map[string]interface{}, required ...string) map[string]interface{} {
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// stringProperty 字符串类型的参数
+func stringProperty(description string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "description": description}
+}
+
+// keywordSnippet 返回关键字前后各radius个字符的片段
+func keywordSnippet(content, keyword string, radius int) (string, bool) {
+	pos := strings.Index(content, keyword)
+	if pos < 0 {
+		return "", false
+	}
+	runes := []rune(content)
+	start := utf8.RuneCountInString(content[:pos]) - radius
+	if start < 0 {
+		start = 0
+	}
+	end := utf8.RuneCountInString(content[:pos]) + utf8.RuneCountInString(keyword) + radius
+	if end > len(runes) {
+		end = len(runes)
+	}
+	return string(runes[start:end]), true
+}
@@ -0,0 +1,115 @@
+package continuity
+
+import (
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/modules/credential"
+	"grandma/backend/modules/usage"
+	"grandma/backend/repository"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContinuityHandler 设定一致性检查处理器
+type ContinuityHandler struct {
+	service *ContinuityService
+}
+
+// NewContinuityHandler 创建设定一致性检查处理器
+func NewContinuityHandler(service *ContinuityService) *ContinuityHandler {
+	return &ContinuityHandler{
+		service: service,
+	}
+}
+
+// StartCheck 发起章节的设定一致性检查，返回202和状态为running的任务
+func (h *ContinuityHandler) StartCheck(c *gin.Context) {
+	var req models.ContinuityCheckRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	check, err := h.service.StartCheck(c.Param("id"), auth.CurrentUserID(c), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, check)
+}
+
+// GetCheck 获取检查任务及其发现的冲突
+func (h *ContinuityHandler) GetCheck(c *gin.Context) {
+	response, err := h.service.GetCheck(c.Param("id"), auth.CurrentUserID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ListChecks 获取章节的检查任务
+func (h *ContinuityHandler) ListChecks(c *gin.Context) {
+	response, err := h.service.ListChecks(c.Param("id"), auth.CurrentUserID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ListIssues 获取创作的设定冲突，document_id和status参数用于过滤
+func (h *ContinuityHandler) ListIssues(c *gin.Context) {
+	response, err := h.service.ListIssues(c.Param("work_id"), auth.CurrentUserID(c), c.Query("document_id"), c.Query("status"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ResolveIssue 把冲突标记为已解决
+func (h *ContinuityHandler) ResolveIssue(c *gin.Context) {
+	h.setIssueStatus(c, models.ContinuityIssueResolved)
+}
+
+// ReopenIssue 重新打开已解决的冲突
+func (h *ContinuityHandler) ReopenIssue(c *gin.Context) {
+	h.setIssueStatus(c, models.ContinuityIssueOpen)
+}
+
+func (h *ContinuityHandler) setIssueStatus(c *gin.Context, status string) {
+	issue, err := h.service.SetIssueStatus(c.Param("id"), auth.CurrentUserID(c), status)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, issue)
+}
+
+// writeError 把服务错误转换为HTTP响应
+func writeError(c *gin.Context, err error) {
+	switch {
+	case repository.IsNotFound(err):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document, check or issue not found"})
+	case errors.Is(err, ErrCheckRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrEmptyChapter), errors.Is(err, ErrInvalidIssueStatus), errors.Is(err, credential.ErrProviderKeyMissing):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, usage.ErrQuotaExceeded):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, credential.ErrMasterKeyNotConfigured):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,446 @@
+package continuity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grandma/backend/models"
+	"grandma/backend/modules/credential"
+	"grandma/backend/modules/rag"
+	"grandma/backend/modules/usage"
+	"grandma/backend/repository"
+	"grandma/backend/services"
+	"grandma/backend/utils"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxChapterRunes = 12000           // 发送给模型的章节正文最大字符数
+	maxEntryRunes   = 600             // 每个设定条目的最大字符数
+	maxBibleRunes   = 8000            // 设定集的总字符数上限
+	excerptRadius   = 150             // 从其他章节截取片段时名称前后的字符数
+	maxExcerpts     = 12              // 从其他章节截取的片段数上限
+	ragQueryRunes   = 1000            // 用于RAG检索的章节开头字符数
+	ragTopK         = 5               // RAG检索的片段数
+	checkTimeout    = 5 * time.Minute // 单次检查的超时时间，超时未结束的任务不再阻止发起新检查
+)
+
+var (
+	// ErrEmptyChapter 章节没有可供检查的正文
+	ErrEmptyChapter = errors.New("empty_chapter")
+	// ErrCheckRunning 该章节已有正在进行的检查
+	ErrCheckRunning = errors.New("check_running")
+	// ErrInvalidIssueStatus 冲突状态不是open或resolved
+	ErrInvalidIssueStatus = errors.New("invalid_issue_status")
+)
+
+// issueKinds 冲突类型，模型给出的其他类型归为other
+var issueKinds = []string{
+	models.ContinuityKindAppearance,
+	models.ContinuityKindDeceased,
+	models.ContinuityKindTimeline,
+	models.ContinuityKindWorldRule,
+	models.ContinuityKindOther,
+}
+
+// continuitySchema 设定一致性检查的结构化输出
+var continuitySchema = services.JSONSchema{
+	Name:        "continuity_issues",
+	Description: "章节与设定集、前文之间的矛盾",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"issues": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"kind":         map[string]interface{}{"type": "string", "enum": issueKinds, "description": "appearance外貌属性改变，deceased已死亡人物再次出现，timeline时间线矛盾，world_rule违反世界观规则"},
+						"severity":     map[string]interface{}{"type": "string", "enum": []string{"low", "medium", "high"}},
+						"description":  map[string]interface{}{"type": "string", "description": "用一两句话说明矛盾之处", "maxLength": 300},
+						"quote":        map[string]interface{}{"type": "string", "description": "逐字摘自待检查章节的原文"},
+						"source":       map[string]interface{}{"type": "string", "description": "与之矛盾的资料编号，如B1、D2、M1，没有对应资料时为空"},
+						"source_quote": map[string]interface{}{"type": "string", "description": "逐字摘自该资料的原文"},
+					},
+					"required": []string{"kind", "severity", "description", "quote", "source", "source_quote"},
+				},
+			},
+		},
+		"required": []string{"issues"},
+	},
+}
+
+// canonSource 提供给模型对照的资料，Text是资料全文，用于定位来源原文
+type canonSource struct {
+	label      string
+	sourceType string
+	id         string
+	title      string
+	excerpt    string
+	text       string
+}
+
+// ContinuityService 设定一致性检查服务：把章节与设定集、其他章节和检索到的对话对照，找出前后矛盾之处
+type ContinuityService struct {
+	continuityRepo   *repository.ContinuityRepository
+	workRepo         *repository.WorkRepository
+	workDocumentRepo *repository.WorkDocumentRepository
+	workMessageRepo  *repository.WorkChatMessageRepository
+	storyBibleRepo   *repository.StoryBibleRepository
+	ragService       *rag.RAGService
+	credentialSvc    *credential.CredentialService
+	usageSvc         *usage.UsageService
+}
+
+// NewContinuityService 创建设定一致性检查服务
+func NewContinuityService(continuityRepo *repository.ContinuityRepository, workRepo *repository.WorkRepository, workDocumentRepo *repository.WorkDocumentRepository, workMessageRepo *repository.WorkChatMessageRepository, storyBibleRepo *repository.StoryBibleRepository, ragService *rag.RAGService, credentialSvc *credential.CredentialService, usageSvc *usage.UsageService) *ContinuityService {
+	return &ContinuityService{
+		continuityRepo:   continuityRepo,
+		workRepo:         workRepo,
+		workDocumentRepo: workDocumentRepo,
+		workMessageRepo:  workMessageRepo,
+		storyBibleRepo:   storyBibleRepo,
+		ragService:       ragService,
+		credentialSvc:    credentialSvc,
+		usageSvc:         usageSvc,
+	}
+}
+
+// StartCheck 发起对章节的设定一致性检查，检查在后台进行，返回状态为running的任务
+func (s *ContinuityService) StartCheck(documentID, userID string, req *models.ContinuityCheckRequest) (*models.ContinuityCheck, error) {
+	doc, err := s.workDocumentRepo.GetByIDAndUserID(documentID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(doc.Content) == "" {
+		return nil, ErrEmptyChapter
+	}
+	checks, err := s.continuityRepo.GetChecksByDocumentIDAndUserID(doc.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(checks) > 0 && checks[0].Status == models.ContinuityCheckRunning && time.Since(checks[0].CreatedAt) < checkTimeout {
+		return nil, ErrCheckRunning
+	}
+
+	model := req.Model
+	if model == "" {
+		model = "openai"
+	}
+	provider, err := s.credentialSvc.GetProvider(userID, model)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.usageSvc.CheckQuota(userID); err != nil {
+		return nil, err
+	}
+	provider = s.usageSvc.Meter(provider, userID, usage.FeatureContinuity)
+
+	check := &models.ContinuityCheck{
+		ID:              utils.GenerateContinuityCheckID(),
+		UserID:          userID,
+		WorkID:          doc.WorkID,
+		DocumentID:      doc.ID,
+		DocumentVersion: doc.Version,
+		Model:           model,
+		Status:          models.ContinuityCheckRunning,
+	}
+	if err := s.continuityRepo.CreateCheck(check); err != nil {
+		return nil, err
+	}
+
+	result := *check
+	go s.runCheck(check, doc, provider)
+	return &result, nil
+}
+
+// GetCheck 获取检查任务及其发现的冲突
+func (s *ContinuityService) GetCheck(id, userID string) (*models.ContinuityCheckResponse, error) {
+	check, err := s.continuityRepo.GetCheckByIDAndUserID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	issues, err := s.continuityRepo.GetIssuesByCheckIDAndUserID(check.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ContinuityCheckResponse{Check: *check, Issues: issues}, nil
+}
+
+// ListChecks 获取章节的检查任务
+func (s *ContinuityService) ListChecks(documentID, userID string) (*models.ContinuityCheckListResponse, error) {
+	if _, err := s.workDocumentRepo.GetByIDAndUserID(documentID, userID); err != nil {
+		return nil, err
+	}
+	checks, err := s.continuityRepo.GetChecksByDocumentIDAndUserID(documentID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ContinuityCheckListResponse{Checks: checks, Total: len(checks)}, nil
+}
+
+// ListIssues 获取创作的设定冲突，可按章节和处理状态过滤
+func (s *ContinuityService) ListIssues(workID, userID, documentID, status string) (*models.ContinuityIssueListResponse, error) {
+	if status != "" && status != models.ContinuityIssueOpen && status != models.ContinuityIssueResolved {
+		return nil, ErrInvalidIssueStatus
+	}
+	if _, err := s.workRepo.GetByIDAndUserID(workID, userID); err != nil {
+		return nil, err
+	}
+	issues, err := s.continuityRepo.GetIssuesByWorkIDAndUserID(workID, userID, documentID, status)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ContinuityIssueListResponse{Issues: issues, Total: len(issues)}, nil
+}
+
+// SetIssueStatus 把冲突标记为已解决或重新打开
+func (s *ContinuityService) SetIssueStatus(id, userID, status string) (*models.ContinuityIssue, error) {
+	if status != models.ContinuityIssueOpen && status != models.ContinuityIssueResolved {
+		return nil, ErrInvalidIssueStatus
+	}
+	if err := s.continuityRepo.UpdateIssueStatusByIDAndUserID(id, userID, status); err != nil {
+		return nil, err
+	}
+	return s.continuityRepo.GetIssueByIDAndUserID(id, userID)
+}
+
+// runCheck 在后台调用模型检查章节，保存结果或失败原因
+func (s *ContinuityService) runCheck(check *models.ContinuityCheck, doc *models.WorkDocument, provider services.ChatProvider) {
+	issues, err := s.analyze(check, doc, provider)
+	if err == nil {
+		err = s.continuityRepo.CompleteCheck(check, issues)
+	}
+	if err != nil {
+		if failErr := s.continuityRepo.FailCheck(check, err.Error()); failErr != nil {
+			log.Printf("Failed to save continuity check %s: %v", check.ID, failErr)
+		}
+	}
+}
+
+// analyze 收集对照资料，请模型找出矛盾并定位原文
+func (s *ContinuityService) analyze(check *models.ContinuityCheck, doc *models.WorkDocument, provider services.ChatProvider) ([]models.ContinuityIssue, error) {
+	sources, err := s.collectSources(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	messages := []models.Message{{Role: "user", Content: buildPrompt(doc, sources)}}
+	var result struct {
+		Issues []struct {
+			Kind        string `json:"kind"`
+			Severity    string `json:"severity"`
+			Description string `json:"description"`
+			Quote       string `json:"quote"`
+			Source      string `json:"source"`
+			SourceQuote string `json:"source_quote"`
+		} `json:"issues"`
+	}
+	if err := services.ChatJSON(ctx, provider, messages, continuitySchema, &result); err != nil {
+		return nil, err
+	}
+
+	byLabel := make(map[string]canonSource, len(sources))
+	for _, source := range sources {
+		byLabel[source.label] = source
+	}
+
+	var issues []models.ContinuityIssue
+	for _, found := range result.Issues {
+		description := strings.TrimSpace(found.Description)
+		if description == "" {
+			continue
+		}
+		issue := models.ContinuityIssue{
+			ID:          utils.GenerateContinuityIssueID(),
+			UserID:      check.UserID,
+			WorkID:      check.WorkID,
+			DocumentID:  check.DocumentID,
+			CheckID:     check.ID,
+			Kind:        normalizeKind(found.Kind),
+			Severity:    normalizeSeverity(found.Severity),
+			Description: description,
+			Quote:       strings.TrimSpace(found.Quote),
+			SourceQuote: strings.TrimSpace(found.SourceQuote),
+			Status:      models.ContinuityIssueOpen,
+		}
+		issue.QuoteStart, issue.QuoteEnd = utils.LocateRunes(doc.Content, issue.Quote)
+		if source, ok := byLabel[strings.Trim(strings.TrimSpace(found.Source), "[]")]; ok {
+			issue.SourceType = source.sourceType
+			issue.SourceID = source.id
+			issue.SourceTitle = source.title
+			issue.SourceStart, issue.SourceEnd = utils.LocateRunes(source.text, issue.SourceQuote)
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+// collectSources 收集对照资料：设定集条目、其他章节中提到设定名称的片段、RAG检索到的对话
+func (s *ContinuityService) collectSources(doc *models.WorkDocument) ([]canonSource, error) {
+	entries, err := s.storyBibleRepo.GetByWorkIDAndUserID(doc.WorkID, doc.UserID)
+	if err != nil {
+		return nil, err
+	}
+	var sources []canonSource
+	bibleRunes := 0
+	for _, entry := range entries {
+		excerpt := utils.TruncateRunes(strings.TrimSpace(entry.Content), maxEntryRunes, "...")
+		bibleRunes += utf8.RuneCountInString(excerpt)
+		if bibleRunes > maxBibleRunes {
+			break
+		}
+		sources = append(sources, canonSource{
+			label:      fmt.Sprintf("B%d", len(sources)+1),
+			sourceType: models.ContinuitySourceStoryBible,
+			id:         entry.ID,
+			title:      entry.Name,
+			excerpt:    fmt.Sprintf("%s（%s）：%s", entry.Name, entry.Category, excerpt),
+			text:       entry.Content,
+		})
+	}
+
+	// 本章提到的设定名称在其他章节中的上下文，用于发现外貌改变、人物复活等与前文的矛盾
+	var names []string
+	for _, entry := range entries {
+		if name := strings.TrimSpace(entry.Name); name != "" && strings.Contains(doc.Content, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		docs, err := s.workDocumentRepo.GetByWorkIDAndUserID(doc.WorkID, doc.UserID)
+		if err != nil {
+			return nil, err
+		}
+		excerpts := 0
+		for _, other := range docs {
+			if other.ID == doc.ID {
+				continue
+			}
+			for _, excerpt := range nameExcerpts(other.Content, names) {
+				if excerpts >= maxExcerpts {
+					break
+				}
+				excerpts++
+				sources = append(sources, canonSource{
+					label:      fmt.Sprintf("D%d", excerpts),
+					sourceType: models.ContinuitySourceWorkDocument,
+					id:         other.ID,
+					title:      other.Title,
+					excerpt:    fmt.Sprintf("《%s》：%s", other.Title, excerpt),
+					text:       other.Content,
+				})
+			}
+		}
+	}
+
+	chunks, err := s.ragService.SearchWork(utils.TruncateRunes(doc.Content, ragQueryRunes, ""), doc.UserID, doc.WorkID, ragTopK)
+	if err != nil {
+		log.Printf("Failed to search work for continuity check: %v", err)
+	}
+	for i, chunk := range chunks {
+		text := chunk.Content
+		if message, err := s.workMessageRepo.GetByIDAndUserID(chunk.DocumentID, doc.UserID); err == nil {
+			text = message.Content
+		}
+		sources = append(sources, canonSource{
+			label:      fmt.Sprintf("M%d", i+1),
+			sourceType: models.ContinuitySourceWorkMessage,
+			id:         chunk.DocumentID,
+			excerpt:    chunk.Content,
+			text:       text,
+		})
+	}
+	return sources, nil
+}
+
+// buildPrompt 生成检查章节的提示词
+func buildPrompt(doc *models.WorkDocument, sources []canonSource) string {
+	var sb strings.Builder
+	sb.WriteString("请把【待检查章节】与下面的资料逐一对照，找出与设定或前文矛盾的地方，例如人物外貌等属性改变、已死亡的人物再次出现、时间线矛盾、违反世界观规则。")
+	sb.WriteString("只报告确实矛盾的地方，不要报告文笔问题。quote必须逐字摘自待检查章节；source填写资料编号，source_quote必须逐字摘自该资料。没有矛盾时返回空数组。\n")
+
+	sections := []struct {
+		title      string
+		sourceType string
+	}{
+		{"设定集", models.ContinuitySourceStoryBible},
+		{"其他章节片段", models.ContinuitySourceWorkDocument},
+		{"相关对话", models.ContinuitySourceWorkMessage},
+	}
+	for _, section := range sections {
+		written := false
+		for _, source := range sources {
+			if source.sourceType != section.sourceType {
+				continue
+			}
+			if !written {
+				sb.WriteString("\n【" + section.title + "】\n")
+				written = true
+			}
+			sb.WriteString("[" + source.label + "] " + source.excerpt + "\n")
+		}
+	}
+
+	sb.WriteString("\n【待检查章节】《" + doc.Title + "》\n")
+	sb.WriteString(utils.TruncateRunes(doc.Content, maxChapterRunes, "..."))
+	return sb.String()
+}
+
+// nameExcerpts 截取正文中各名称第一次出现位置前后的片段
+func nameExcerpts(content string, names []string) []string {
+	runes := []rune(content)
+	var excerpts []string
+	var taken [][2]int
+	for _, name := range names {
+		index := strings.Index(content, name)
+		if index < 0 {
+			continue
+		}
+		start := utf8.RuneCountInString(content[:index])
+		from := max(0, start-excerptRadius)
+		to := min(len(runes), start+utf8.RuneCountInString(name)+excerptRadius)
+		if overlaps(taken, from, to) {
+			continue
+		}
+		taken = append(taken, [2]int{from, to})
+		excerpts = append(excerpts, string(runes[from:to]))
+	}
+	return excerpts
+}
+
+// overlaps 判断[from, to)是否与已截取的片段重叠
+func overlaps(taken [][2]int, from, to int) bool {
+	for _, r := range taken {
+		if from < r[1] && r[0] < to {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeKind 把模型给出的冲突类型规范为已知类型
+func normalizeKind(kind string) string {
+	kind = strings.TrimSpace(kind)
+	for _, known := range issueKinds {
+		if kind == known {
+			return kind
+		}
+	}
+	return models.ContinuityKindOther
+}
+
+// normalizeSeverity 把模型给出的严重程度规范为low、medium或high
+func normalizeSeverity(severity string) string {
+	switch severity = strings.TrimSpace(severity); severity {
+	case "low", "medium", "high":
+		return severity
+	}
+	return "medium"
+}
@@ -3,6 +3,8 @@ package conversation
 import (
 	"fmt"
 	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/repository"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -24,7 +26,7 @@ func NewConversationHandler(service *ConversationService) *ConversationHandler {
 func (h *ConversationHandler) GetConversationByID(c *gin.Context) {
 	fmt.Println("[conversation_handler GetConversationByID] Start")
 	id := c.Param("id")
-	conv, err := h.service.GetConversationByID(id)
+	conv, err := h.service.GetConversationByIDAndUserID(id, auth.CurrentUserID(c))
 	fmt.Printf("[conversation_handler GetConversationByID] conversationID:%v conversationInfo:%v\n", id, conv)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
@@ -49,7 +51,7 @@ func (h *ConversationHandler) CreateConversation(c *gin.Context) {
 		req.Title = "新对话"
 	}
 
-	conv, err := h.service.CreateConversation(req.Title)
+	conv, err := h.service.CreateConversation(auth.CurrentUserID(c), req.Title)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -69,8 +71,13 @@ func (h *ConversationHandler) UpdateConversation(c *gin.Context) {
 	}
 
 	conv.ID = id
+	conv.UserID = auth.CurrentUserID(c)
 	err := h.service.UpdateConversation(&conv)
 	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -83,16 +90,19 @@ func (h *ConversationHandler) UpdateConversationTitle(c *gin.Context) {
 	fmt.Println("[conversation_handler UpdateConversationTitle] Start")
 	id := c.Param("id")
 	var req struct {
-		Title  string `json:"title" binding:"required"`
-		UserID string `json:"user_id" binding:"required"`
+		Title string `json:"title" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	err := h.service.UpdateConversationTitle(id, req.UserID, req.Title)
+	err := h.service.UpdateConversationTitle(id, auth.CurrentUserID(c), req.Title)
 	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -104,14 +114,12 @@ func (h *ConversationHandler) UpdateConversationTitle(c *gin.Context) {
 func (h *ConversationHandler) DeleteConversation(c *gin.Context) {
 	fmt.Println("[conversation_handler DeleteConversation] Start")
 	id := c.Param("id")
-	userID := c.Query("user_id")
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
-		return
-	}
-
-	err := h.service.DeleteConversation(id, userID)
+	err := h.service.DeleteConversation(id, auth.CurrentUserID(c))
 	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
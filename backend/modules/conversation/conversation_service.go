@@ -4,32 +4,39 @@ import (
 	"grandma/backend/models"
 	"grandma/backend/repository"
 	"grandma/backend/utils"
+
+	"gorm.io/gorm"
 )
 
 // ConversationService 对话服务
 type ConversationService struct {
 	conversationRepo *repository.ConversationRepository
 	documentRepo     *repository.DocumentRepository
+	vectorChunkRepo  *repository.VectorChunkRepository
+	storyRepo        *repository.StoryRepository
 }
 
 // NewConversationService 创建对话服务
-func NewConversationService(conversationRepo *repository.ConversationRepository, documentRepo *repository.DocumentRepository) *ConversationService {
+func NewConversationService(conversationRepo *repository.ConversationRepository, documentRepo *repository.DocumentRepository, vectorChunkRepo *repository.VectorChunkRepository, storyRepo *repository.StoryRepository) *ConversationService {
 	return &ConversationService{
 		conversationRepo: conversationRepo,
 		documentRepo:     documentRepo,
+		vectorChunkRepo:  vectorChunkRepo,
+		storyRepo:        storyRepo,
 	}
 }
 
-// GetConversationByID 根据ID获取对话
-func (s *ConversationService) GetConversationByID(id string) (*models.Conversation, error) {
-	return s.conversationRepo.GetByID(id)
+// GetConversationByIDAndUserID 根据ID和用户ID获取对话（确保数据隔离）
+func (s *ConversationService) GetConversationByIDAndUserID(id, userID string) (*models.Conversation, error) {
+	return s.conversationRepo.GetByIDAndUserID(id, userID)
 }
 
 // CreateConversation 创建对话
-func (s *ConversationService) CreateConversation(title string) (*models.Conversation, error) {
+func (s *ConversationService) CreateConversation(userID, title string) (*models.Conversation, error) {
 	conversation := &models.Conversation{
-		ID:    utils.GenerateConversationID(),
-		Title: title,
+		ID:     utils.GenerateConversationID(),
+		UserID: userID,
+		Title:  title,
 	}
 	err := s.conversationRepo.Create(conversation)
 	if err != nil {
@@ -38,9 +45,16 @@ func (s *ConversationService) CreateConversation(title string) (*models.Conversa
 	return conversation, nil
 }
 
-// UpdateConversation 更新对话
+// UpdateConversation 更新对话（只允许更新属于该用户的对话的标题和文档ID列表）
 func (s *ConversationService) UpdateConversation(conversation *models.Conversation) error {
-	return s.conversationRepo.Update(conversation)
+	existing, err := s.conversationRepo.GetByIDAndUserID(conversation.ID, conversation.UserID)
+	if err != nil {
+		return err
+	}
+
+	existing.Title = conversation.Title
+	existing.DocumentIDs = conversation.DocumentIDs
+	return s.conversationRepo.UpdateByIDAndUserID(existing)
 }
 
 // UpdateConversationTitle 更新对话标题
@@ -48,7 +62,7 @@ func (s *ConversationService) UpdateConversationTitle(id, userID, title string)
 	return s.conversationRepo.UpdateTitleByIDAndUserID(id, userID, title)
 }
 
-// DeleteConversation 删除对话（包括关联的文档）
+// DeleteConversation 删除对话（级联删除关联的文档、向量chunks，并解除故事对文档的引用）
 func (s *ConversationService) DeleteConversation(id, userID string) error {
 	// 先验证对话属于该用户
 	conversation, err := s.conversationRepo.GetByIDAndUserID(id, userID)
@@ -56,12 +70,31 @@ func (s *ConversationService) DeleteConversation(id, userID string) error {
 		return err
 	}
 
-	// 先删除关联的文档（需要验证用户ID）
-	// 注意：这里需要确保文档也属于该用户
-	err = s.documentRepo.DeleteByConversationID(conversation.ID)
+	documentIDs, err := s.documentRepo.GetIDsByConversationIDAndUserID(conversation.ID, userID)
 	if err != nil {
 		return err
 	}
-	// 再删除对话
-	return s.conversationRepo.DeleteByIDAndUserID(id, userID)
+
+	return s.conversationRepo.Transaction(func(tx *gorm.DB) error {
+		// 解除故事对这些文档的引用（故事内容本身保留）
+		if err := s.storyRepo.WithTx(tx).ClearDocumentIDsByUserID(userID, documentIDs); err != nil {
+			return err
+		}
+
+		// 删除对话的向量chunks（按对话和按文档各删一次，覆盖缺少conversation_id的chunks）
+		vectorChunkRepo := s.vectorChunkRepo.WithTx(tx)
+		if err := vectorChunkRepo.DeleteByConversationID(conversation.ID); err != nil {
+			return err
+		}
+		if err := vectorChunkRepo.DeleteByDocumentIDs(documentIDs); err != nil {
+			return err
+		}
+
+		// 删除关联的文档
+		if err := s.documentRepo.WithTx(tx).DeleteByConversationIDAndUserID(conversation.ID, userID); err != nil {
+			return err
+		}
+		// 再删除对话
+		return s.conversationRepo.WithTx(tx).DeleteByIDAndUserID(id, userID)
+	})
 }
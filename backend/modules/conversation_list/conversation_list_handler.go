@@ -2,6 +2,7 @@ package conversation_list
 
 import (
 	"grandma/backend/models"
+	"grandma/backend/modules/auth"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -36,7 +37,7 @@ func (h *ConversationListHandler) GetConversationList(c *gin.Context) {
 		pageSize = 20
 	}
 
-	response, err := h.service.GetConversationList(req.UserID, page, pageSize)
+	response, err := h.service.GetConversationList(auth.CurrentUserID(c), page, pageSize)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -47,15 +48,7 @@ func (h *ConversationListHandler) GetConversationList(c *gin.Context) {
 
 // CreateNewConversation 创建新对话
 func (h *ConversationListHandler) CreateNewConversation(c *gin.Context) {
-	var req struct {
-		UserID string `json:"user_id" binding:"required"`
-	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	conversation, err := h.service.CreateNewConversation(req.UserID)
+	conversation, err := h.service.CreateNewConversation(auth.CurrentUserID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -72,7 +65,7 @@ func (h *ConversationListHandler) CreateNewConversationWithTitle(c *gin.Context)
 		return
 	}
 
-	conversation, err := h.service.CreateNewConversationWithTitle(req.UserID, req.UserInputs)
+	conversation, err := h.service.CreateNewConversationWithTitle(c.Request.Context(), auth.CurrentUserID(c), req.UserInputs)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -89,7 +82,7 @@ func (h *ConversationListHandler) GenerateTitle(c *gin.Context) {
 		return
 	}
 
-	title, err := h.service.GenerateTitleForConversation(req.UserInputs)
+	title, err := h.service.GenerateTitleForConversation(c.Request.Context(), auth.CurrentUserID(c), req.UserInputs)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
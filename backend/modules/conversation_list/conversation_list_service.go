@@ -1,32 +1,61 @@
 package conversation_list
 
 import (
+	"context"
 	"grandma/backend/models"
+	"grandma/backend/modules/credential"
+	"grandma/backend/modules/prompt"
+	"grandma/backend/modules/usage"
 	"grandma/backend/repository"
 	"grandma/backend/services"
 	"grandma/backend/utils"
 	"strings"
 )
 
+// titleMaxRunes 标题的最大字符数
+const titleMaxRunes = 20
+
+// titleSchema 标题生成的结构化输出
+var titleSchema = services.JSONSchema{
+	Name:        "conversation_title",
+	Description: "对话标题",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"title": map[string]interface{}{
+				"type":        "string",
+				"description": "简洁的对话标题，不包含标点符号",
+				"maxLength":   titleMaxRunes,
+			},
+		},
+		"required": []string{"title"},
+	},
+}
+
 // ConversationListService 对话列表服务
 type ConversationListService struct {
 	conversationRepo *repository.ConversationRepository
+	credentialSvc    *credential.CredentialService
+	usageSvc         *usage.UsageService
+	promptSvc        *prompt.PromptService
 	config           *TitleGenerationConfig
 }
 
 // TitleGenerationConfig 标题生成配置
 type TitleGenerationConfig struct {
-	OpenAIAPIKey     string
-	OpenAIBaseURL    string
-	AnthropicAPIKey  string
-	AnthropicBaseURL string
-	DefaultModel     string // 默认使用哪个模型生成标题
+	DefaultModel string // 默认使用哪个模型生成标题
 }
 
 // NewConversationListService 创建对话列表服务
-func NewConversationListService(conversationRepo *repository.ConversationRepository, config *TitleGenerationConfig) *ConversationListService {
+func NewConversationListService(conversationRepo *repository.ConversationRepository, credentialSvc *credential.CredentialService, usageSvc *usage.UsageService, promptSvc *prompt.PromptService, config *TitleGenerationConfig) *ConversationListService {
+	if promptSvc == nil {
+		promptSvc = prompt.NewPromptService(nil, nil)
+	}
 	return &ConversationListService{
 		conversationRepo: conversationRepo,
+		credentialSvc:    credentialSvc,
+		usageSvc:         usageSvc,
+		promptSvc:        promptSvc,
 		config:           config,
 	}
 }
@@ -70,13 +99,13 @@ func (s *ConversationListService) CreateNewConversation(userID string) (*models.
 }
 
 // CreateNewConversationWithTitle 创建新对话并生成标题
-func (s *ConversationListService) CreateNewConversationWithTitle(userID string, userInputs []string) (*models.Conversation, error) {
+func (s *ConversationListService) CreateNewConversationWithTitle(ctx context.Context, userID string, userInputs []string) (*models.Conversation, error) {
 	conversationID := utils.GenerateConversationID()
 
 	// 生成标题
 	title := "新对话"
 	if len(userInputs) > 0 {
-		generatedTitle, err := s.generateTitle(userInputs)
+		generatedTitle, err := s.generateTitle(ctx, userID, userInputs)
 		if err == nil && generatedTitle != "" {
 			title = generatedTitle
 		}
@@ -95,21 +124,23 @@ func (s *ConversationListService) CreateNewConversationWithTitle(userID string,
 	return conversation, nil
 }
 
-// generateTitle 根据用户输入生成对话标题
-func (s *ConversationListService) generateTitle(userInputs []string) (string, error) {
+// generateTitle 根据用户输入生成对话标题（使用该用户的服务商凭证）
+func (s *ConversationListService) generateTitle(ctx context.Context, userID string, userInputs []string) (string, error) {
 	if len(userInputs) == 0 {
 		return "新对话", nil
 	}
 
-	// 构建提示词
-	userMessagesText := strings.Join(userInputs, "\n")
-	prompt := "请根据以下用户输入，生成一个简洁的对话标题（不超过20个字，不要包含标点符号）：\n\n" + userMessagesText
+	// 构建提示词（可由用户的模板覆盖）
+	titlePrompt, err := s.promptSvc.Render(userID, "", prompt.TemplateTitle, prompt.TitleData{Inputs: strings.Join(userInputs, "\n")})
+	if err != nil {
+		return "", err
+	}
 
 	// 构建消息
 	messages := []models.Message{
 		{
 			Role:    "user",
-			Content: prompt,
+			Content: titlePrompt,
 		},
 	}
 
@@ -119,32 +150,30 @@ func (s *ConversationListService) generateTitle(userInputs []string) (string, er
 		model = "openai" // 默认使用openai
 	}
 
-	provider, err := services.GetProvider(
-		model,
-		s.config.OpenAIAPIKey,
-		s.config.OpenAIBaseURL,
-		s.config.AnthropicAPIKey,
-		s.config.AnthropicBaseURL,
-	)
+	provider, err := s.credentialSvc.GetProvider(userID, model)
 	if err != nil {
 		return "", err
 	}
+	if err := s.usageSvc.CheckQuota(userID); err != nil {
+		return "", err
+	}
+	provider = s.usageSvc.Meter(provider, userID, usage.FeatureTitle)
 
-	// 调用LLM生成标题
-	title, err := provider.Chat(messages)
-	if err != nil {
+	// 调用LLM生成标题（结构化输出）
+	var result struct {
+		Title string `json:"title"`
+	}
+	if err := services.ChatJSON(ctx, provider, messages, titleSchema, &result); err != nil {
 		return "", err
 	}
 
 	// 清理标题（去除前后空格、换行等）
-	title = strings.TrimSpace(title)
+	title := strings.TrimSpace(result.Title)
 	title = strings.ReplaceAll(title, "\n", "")
 	title = strings.ReplaceAll(title, "\r", "")
 
-	// 限制长度
-	if len(title) > 50 {
-		title = title[:50]
-	}
+	// 按字符限制长度，避免截断多字节字符
+	title = utils.TruncateRunes(title, titleMaxRunes, "")
 
 	if title == "" {
 		title = "新对话"
@@ -154,6 +183,6 @@ func (s *ConversationListService) generateTitle(userInputs []string) (string, er
 }
 
 // GenerateTitleForConversation 为对话生成标题（公开方法，用于智能命名接口）
-func (s *ConversationListService) GenerateTitleForConversation(userInputs []string) (string, error) {
-	return s.generateTitle(userInputs)
+func (s *ConversationListService) GenerateTitleForConversation(ctx context.Context, userID string, userInputs []string) (string, error) {
+	return s.generateTitle(ctx, userID, userInputs)
 }
@@ -0,0 +1,76 @@
+package credential
+
+import (
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/repository"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CredentialHandler 服务商凭证处理器
+type CredentialHandler struct {
+	service *CredentialService
+}
+
+// NewCredentialHandler 创建服务商凭证处理器
+func NewCredentialHandler(service *CredentialService) *CredentialHandler {
+	return &CredentialHandler{
+		service: service,
+	}
+}
+
+// GetCredentialList 获取当前用户的凭证列表
+func (h *CredentialHandler) GetCredentialList(c *gin.Context) {
+	response, err := h.service.ListCredentials(auth.CurrentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SetCredential 保存当前用户某个服务商的API Key
+func (h *CredentialHandler) SetCredential(c *gin.Context) {
+	var req models.ProviderCredentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	credential, err := h.service.SetCredential(auth.CurrentUserID(c), c.Param("provider"), req.APIKey, req.BaseURL)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnsupportedProvider), errors.Is(err, ErrInvalidCredential):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrMasterKeyNotConfigured):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, credential)
+}
+
+// DeleteCredential 删除当前用户某个服务商的API Key
+func (h *CredentialHandler) DeleteCredential(c *gin.Context) {
+	err := h.service.DeleteCredential(auth.CurrentUserID(c), c.Param("provider"))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnsupportedProvider):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case repository.IsNotFound(err):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Credential not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Credential deleted successfully"})
+}
@@ -0,0 +1,324 @@
+package credential
+
+import (
+	"errors"
+	"fmt"
+	"grandma/backend/models"
+	"grandma/backend/repository"
+	"grandma/backend/services"
+	"grandma/backend/utils"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMasterKeyNotConfigured 服务端未配置主密钥，无法保存用户凭证
+	ErrMasterKeyNotConfigured = errors.New("master_key_not_configured")
+	// ErrUnsupportedProvider 不支持的服务商
+	ErrUnsupportedProvider = errors.New("unsupported_provider")
+	// ErrProviderKeyMissing 用户未配置该服务商的API Key，且不允许回退到服务端Key
+	ErrProviderKeyMissing = errors.New("provider_key_missing")
+	// ErrInvalidCredential API Key为空或自定义API地址不合法
+	ErrInvalidCredential = errors.New("invalid_credential")
+)
+
+// 服务端Key回退策略
+const (
+	FallbackAll   = "all"   // 所有用户都可以使用服务端Key
+	FallbackAdmin = "admin" // 仅管理员可以使用服务端Key
+	FallbackNone  = "none"  // 必须使用自己的Key
+)
+
+// CredentialService 服务商凭证服务：管理用户自带的API Key，并为请求选择实际使用的Key
+type CredentialService struct {
+	credentialRepo *repository.ProviderCredentialRepository
+	userRepo       *repository.UserRepository
+	config         *CredentialConfig
+	key            []byte
+	breakers       *services.BreakerRegistry
+}
+
+// CredentialConfig 凭证配置
+type CredentialConfig struct {
+	MasterKey        string // 用于加密用户API Key的服务端主密钥（为空时不允许保存用户凭证）
+	FallbackPolicy   string // 用户未配置Key时的服务端Key回退策略
+	OpenAIAPIKey     string
+	OpenAIBaseURL    string
+	AnthropicAPIKey  string
+	AnthropicBaseURL string
+	GeminiAPIKey     string
+	GeminiBaseURL    string
+	GeminiModel      string
+	OllamaAPIKey     string
+	OllamaBaseURL    string
+	OllamaModel      string
+
+	ProviderChain    []string                // 故障转移顺序，请求的服务商失败后按此顺序尝试其他服务商
+	Routing          services.RoutingOptions // 每个后端的重试配置
+	BreakerThreshold int                     // 连续临时错误达到该次数后熔断
+	BreakerCooldown  time.Duration           // 熔断持续时间
+}
+
+// NewCredentialService 创建服务商凭证服务
+func NewCredentialService(credentialRepo *repository.ProviderCredentialRepository, userRepo *repository.UserRepository, config *CredentialConfig) *CredentialService {
+	s := &CredentialService{
+		credentialRepo: credentialRepo,
+		userRepo:       userRepo,
+		config:         config,
+		breakers:       services.NewBreakerRegistry(config.BreakerThreshold, config.BreakerCooldown),
+	}
+	if config.MasterKey != "" {
+		s.key = utils.DeriveKey(config.MasterKey)
+	}
+	return s
+}
+
+// SetCredential 保存用户某个服务商的API Key（加密保存）
+func (s *CredentialService) SetCredential(userID, provider, apiKey, baseURL string) (*models.ProviderCredential, error) {
+	if !isSupportedProvider(provider) {
+		return nil, ErrUnsupportedProvider
+	}
+	if s.key == nil {
+		return nil, ErrMasterKeyNotConfigured
+	}
+
+	apiKey = strings.TrimSpace(apiKey)
+	if apiKey == "" {
+		return nil, fmt.Errorf("%w: api_key is empty", ErrInvalidCredential)
+	}
+	baseURL = strings.TrimSpace(baseURL)
+	if baseURL != "" {
+		if err := validateBaseURL(baseURL); err != nil {
+			return nil, err
+		}
+	}
+
+	encrypted, err := utils.EncryptString(s.key, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &models.ProviderCredential{
+		ID:              utils.GenerateID(),
+		UserID:          userID,
+		Provider:        provider,
+		EncryptedAPIKey: encrypted,
+		KeyHint:         keyHint(apiKey),
+		BaseURL:         baseURL,
+	}
+	if err := s.credentialRepo.Upsert(credential); err != nil {
+		return nil, err
+	}
+	return s.credentialRepo.GetByUserIDAndProvider(userID, provider)
+}
+
+// ListCredentials 获取用户的凭证列表（不包含明文Key）
+func (s *CredentialService) ListCredentials(userID string) (*models.ProviderCredentialListResponse, error) {
+	credentials, err := s.credentialRepo.ListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	serverFallback, err := s.canUseServerKey(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.ProviderCredentialListResponse{
+		Credentials:    credentials,
+		ServerFallback: serverFallback,
+	}, nil
+}
+
+// DeleteCredential 删除用户某个服务商的凭证
+func (s *CredentialService) DeleteCredential(userID, provider string) error {
+	if !isSupportedProvider(provider) {
+		return ErrUnsupportedProvider
+	}
+	return s.credentialRepo.DeleteByUserIDAndProvider(userID, provider)
+}
+
+// GetProvider 为用户创建聊天服务提供者
+// 请求的服务商优先，失败时按ProviderChain顺序切换到用户可用的其他服务商
+func (s *CredentialService) GetProvider(userID, model string) (services.ChatProvider, error) {
+	primary := services.ResolveProviderName(model)
+	if primary == "" {
+		return nil, fmt.Errorf("unsupported provider: %s", model)
+	}
+
+	backend, err := s.backend(userID, primary)
+	if err != nil {
+		return nil, err
+	}
+	backends := []services.RouteBackend{*backend}
+
+	for _, name := range s.config.ProviderChain {
+		if name == primary || services.ResolveProviderName(name) != name {
+			continue
+		}
+		fallback, err := s.backend(userID, name)
+		if err != nil {
+			// 备用服务商没有可用的Key时跳过
+			if !errors.Is(err, ErrProviderKeyMissing) {
+				log.Printf("Skip fallback provider %s for user %s: %v", name, userID, err)
+			}
+			continue
+		}
+		backends = append(backends, *fallback)
+	}
+
+	return services.NewRoutingProvider(backends, s.config.Routing), nil
+}
+
+// backend 创建某个服务商的后端：优先使用用户自己的Key，否则按回退策略使用服务端Key
+// 熔断器按服务商和Key来源区分，避免一个用户的Key被限流影响其他用户
+func (s *CredentialService) backend(userID, providerName string) (*services.RouteBackend, error) {
+	// 模拟服务商不需要凭证
+	if providerName == services.ProviderMock {
+		provider, err := services.NewChatProvider(providerName, services.ProviderSettings{})
+		if err != nil {
+			return nil, err
+		}
+		return &services.RouteBackend{
+			Name:     providerName,
+			Provider: provider,
+			Breaker:  s.breakers.Get(providerName),
+		}, nil
+	}
+
+	server := s.serverSettings(providerName)
+
+	credential, err := s.credentialRepo.GetByUserIDAndProvider(userID, providerName)
+	if err == nil {
+		if s.key == nil {
+			return nil, ErrMasterKeyNotConfigured
+		}
+		apiKey, err := utils.DecryptString(s.key, credential.EncryptedAPIKey)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt %s credential: %w", providerName, err)
+		}
+		// 用户自定义的API地址在每次连接时再检查一次实际连接的IP
+		settings := services.ProviderSettings{APIKey: apiKey, BaseURL: credential.BaseURL, Model: server.Model, Restricted: credential.BaseURL != ""}
+		if settings.BaseURL == "" {
+			settings.BaseURL = server.BaseURL
+		}
+		provider, err := services.NewChatProvider(providerName, settings)
+		if err != nil {
+			return nil, err
+		}
+		return &services.RouteBackend{
+			Name:     providerName,
+			Provider: provider,
+			Breaker:  s.breakers.Get(providerName + "|user:" + userID),
+		}, nil
+	}
+	if !repository.IsNotFound(err) {
+		return nil, err
+	}
+
+	allowed, err := s.canUseServerKey(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed || !serverConfigured(providerName, server) {
+		return nil, ErrProviderKeyMissing
+	}
+	provider, err := services.NewChatProvider(providerName, server)
+	if err != nil {
+		return nil, err
+	}
+	return &services.RouteBackend{
+		Name:     providerName,
+		Provider: provider,
+		Breaker:  s.breakers.Get(providerName + "|server"),
+	}, nil
+}
+
+// canUseServerKey 判断用户是否允许回退到服务端Key
+func (s *CredentialService) canUseServerKey(userID string) (bool, error) {
+	switch s.config.FallbackPolicy {
+	case FallbackNone:
+		return false, nil
+	case FallbackAdmin:
+		user, err := s.userRepo.GetByID(userID)
+		if err != nil {
+			if repository.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return user.IsAdmin, nil
+	default:
+		return true, nil
+	}
+}
+
+// serverSettings 获取服务端配置的Key、地址和模型
+func (s *CredentialService) serverSettings(providerName string) services.ProviderSettings {
+	switch providerName {
+	case services.ProviderOpenAI:
+		return services.ProviderSettings{APIKey: s.config.OpenAIAPIKey, BaseURL: s.config.OpenAIBaseURL}
+	case services.ProviderAnthropic:
+		return services.ProviderSettings{APIKey: s.config.AnthropicAPIKey, BaseURL: s.config.AnthropicBaseURL}
+	case services.ProviderGemini:
+		return services.ProviderSettings{APIKey: s.config.GeminiAPIKey, BaseURL: s.config.GeminiBaseURL, Model: s.config.GeminiModel}
+	case services.ProviderOllama:
+		return services.ProviderSettings{APIKey: s.config.OllamaAPIKey, BaseURL: s.config.OllamaBaseURL, Model: s.config.OllamaModel}
+	default:
+		return services.ProviderSettings{}
+	}
+}
+
+// serverConfigured 服务端是否配置了该服务商：需要Key的看Key，本地模型看地址
+func serverConfigured(providerName string, settings services.ProviderSettings) bool {
+	if services.RequiresAPIKey(providerName) {
+		return settings.APIKey != ""
+	}
+	return settings.BaseURL != ""
+}
+
+// isSupportedProvider 判断是否为支持的服务商名称
+func isSupportedProvider(provider string) bool {
+	switch provider {
+	case services.ProviderOpenAI, services.ProviderAnthropic, services.ProviderGemini, services.ProviderOllama:
+		return true
+	default:
+		return false
+	}
+}
+
+// validateBaseURL 检查用户自定义的API地址：只允许http(s)，且不能指向本机或内网地址，避免借服务端访问内部服务
+// 这里只是保存时的提前反馈，域名之后可能解析到其他地址，真正的限制在连接时由services检查
+func validateBaseURL(baseURL string) error {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return fmt.Errorf("%w: base_url must be an http(s) URL", ErrInvalidCredential)
+	}
+
+	host := parsed.Hostname()
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		addrs, err := net.LookupIP(host)
+		if err != nil {
+			return fmt.Errorf("%w: cannot resolve base_url host %s", ErrInvalidCredential, host)
+		}
+		ips = addrs
+	}
+	for _, ip := range ips {
+		if !services.IsPublicIP(ip) {
+			return fmt.Errorf("%w: base_url must not point to a private or loopback address", ErrInvalidCredential)
+		}
+	}
+	return nil
+}
+
+// keyHint 生成API Key提示（只保留末尾4位）
+func keyHint(apiKey string) string {
+	if len(apiKey) < 8 {
+		return "****"
+	}
+	return "****" + apiKey[len(apiKey)-4:]
+}
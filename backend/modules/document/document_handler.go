@@ -1,8 +1,12 @@
 package document
 
 import (
+	"errors"
 	"fmt"
 	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/repository"
+	"grandma/backend/utils"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -35,8 +39,12 @@ func (h *DocumentHandler) GetDocumentList(c *gin.Context) {
 		limit = 10 // 默认10个
 	}
 
-	response, err := h.service.GetDocumentList(req.ConversationID, req.BeforeID, limit)
+	response, err := h.service.GetDocumentList(req.ConversationID, auth.CurrentUserID(c), req.BeforeID, limit)
 	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -48,36 +56,49 @@ func (h *DocumentHandler) GetDocumentList(c *gin.Context) {
 func (h *DocumentHandler) GetDocumentByID(c *gin.Context) {
 	fmt.Println("[document_handler GetDocumentByID] Start")
 	id := c.Param("id")
-	userID := c.Query("user_id")
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
-		return
-	}
-
-	doc, err := h.service.GetDocumentByIDAndUserID(id, userID)
+	doc, err := h.service.GetDocumentByIDAndUserID(id, auth.CurrentUserID(c))
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
 		return
 	}
 
+	c.Header("ETag", utils.FormatETag(doc.Version))
 	c.JSON(http.StatusOK, doc)
 }
 
 // UpdateDocument 更新文档
 func (h *DocumentHandler) UpdateDocument(c *gin.Context) {
 	fmt.Println("[document_handler UpdateDocument] Start")
-	var doc models.Document
-	if err := c.ShouldBindJSON(&doc); err != nil {
+	id := c.Param("id")
+	var req models.UpdateDocumentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	version, err := utils.ParseIfMatch(c.GetHeader("If-Match"))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	err := h.service.UpdateDocument(&doc)
+	doc, err := h.service.UpdateDocument(id, auth.CurrentUserID(c), req.Content, version)
 	if err != nil {
+		var conflict *repository.DocumentConflictError
+		if errors.As(err, &conflict) {
+			c.Header("ETag", utils.FormatETag(conflict.Document.Version))
+			c.JSON(http.StatusConflict, models.DocumentConflictResponse{Error: err.Error(), Document: *conflict.Document})
+			return
+		}
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	c.Header("ETag", utils.FormatETag(doc.Version))
 	c.JSON(http.StatusOK, gin.H{"message": "Document updated successfully"})
 }
 
@@ -85,8 +106,12 @@ func (h *DocumentHandler) UpdateDocument(c *gin.Context) {
 func (h *DocumentHandler) DeleteDocument(c *gin.Context) {
 	fmt.Println("[document_handler DeleteDocument] Start")
 	id := c.Param("id")
-	err := h.service.DeleteDocument(id)
+	err := h.service.DeleteDocument(id, auth.CurrentUserID(c))
 	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -107,8 +132,12 @@ func (h *DocumentHandler) GetDocumentIDs(c *gin.Context) {
 		limit = 10
 	}
 
-	documentIDs, err := h.service.GetDocumentIDsByConversationIDAndUserID(req.ConversationID, req.UserID, req.BeforeID, limit)
+	documentIDs, err := h.service.GetDocumentIDsByConversationIDAndUserID(req.ConversationID, auth.CurrentUserID(c), req.BeforeID, limit)
 	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -4,22 +4,35 @@ import (
 	"grandma/backend/models"
 	"grandma/backend/repository"
 	"grandma/backend/utils"
+
+	"gorm.io/gorm"
 )
 
 // DocumentService 文档服务
 type DocumentService struct {
-	documentRepo *repository.DocumentRepository
+	documentRepo     *repository.DocumentRepository
+	conversationRepo *repository.ConversationRepository
+	vectorChunkRepo  *repository.VectorChunkRepository
+	storyRepo        *repository.StoryRepository
 }
 
 // NewDocumentService 创建文档服务
-func NewDocumentService(documentRepo *repository.DocumentRepository) *DocumentService {
+func NewDocumentService(documentRepo *repository.DocumentRepository, conversationRepo *repository.ConversationRepository, vectorChunkRepo *repository.VectorChunkRepository, storyRepo *repository.StoryRepository) *DocumentService {
 	return &DocumentService{
-		documentRepo: documentRepo,
+		documentRepo:     documentRepo,
+		conversationRepo: conversationRepo,
+		vectorChunkRepo:  vectorChunkRepo,
+		storyRepo:        storyRepo,
 	}
 }
 
-// GetDocumentList 获取文档列表（支持翻页）
-func (s *DocumentService) GetDocumentList(conversationID string, beforeID string, limit int) (*models.DocumentListResponse, error) {
+// GetDocumentList 获取文档列表（支持翻页，确保数据隔离）
+func (s *DocumentService) GetDocumentList(conversationID, userID string, beforeID string, limit int) (*models.DocumentListResponse, error) {
+	// 先验证对话属于该用户
+	if _, err := s.conversationRepo.GetByIDAndUserID(conversationID, userID); err != nil {
+		return nil, err
+	}
+
 	// 如果没有指定limit，默认为10
 	if limit <= 0 {
 		limit = 10
@@ -27,7 +40,7 @@ func (s *DocumentService) GetDocumentList(conversationID string, beforeID string
 
 	// 如果没有指定beforeID，获取最新的文档（按created_at倒序，取前limit个，然后反转）
 	if beforeID == "" {
-		documents, err := s.documentRepo.GetLatestDocumentsByConversationID(conversationID, limit)
+		documents, err := s.documentRepo.GetLatestDocumentsByConversationIDAndUserID(conversationID, userID, limit)
 		if err != nil {
 			return nil, err
 		}
@@ -50,30 +63,49 @@ func (s *DocumentService) GetDocumentList(conversationID string, beforeID string
 	}, nil
 }
 
-// GetDocumentByID 根据ID获取文档
-func (s *DocumentService) GetDocumentByID(id string) (*models.Document, error) {
-	return s.documentRepo.GetByID(id)
-}
-
 // GetDocumentByIDAndUserID 根据ID和用户ID获取文档（确保数据隔离）
 func (s *DocumentService) GetDocumentByIDAndUserID(id, userID string) (*models.Document, error) {
 	return s.documentRepo.GetByIDAndUserID(id, userID)
 }
 
-// UpdateDocument 更新文档
-func (s *DocumentService) UpdateDocument(document *models.Document) error {
-	return s.documentRepo.Update(document)
+// UpdateDocument 更新文档内容（确保数据隔离），version大于0时要求与文档的当前版本号相同，返回更新后的文档
+func (s *DocumentService) UpdateDocument(id, userID, content string, version int64) (*models.Document, error) {
+	if err := s.documentRepo.UpdateContentByIDAndUserID(id, userID, content, version); err != nil {
+		return nil, err
+	}
+	return s.documentRepo.GetByIDAndUserID(id, userID)
 }
 
-// DeleteDocument 删除文档
-func (s *DocumentService) DeleteDocument(id string) error {
-	return s.documentRepo.Delete(id)
+// DeleteDocument 删除文档（级联删除向量chunks，并从对话的文档ID列表中移除）
+func (s *DocumentService) DeleteDocument(id, userID string) error {
+	doc, err := s.documentRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.documentRepo.Transaction(func(tx *gorm.DB) error {
+		if err := s.vectorChunkRepo.WithTx(tx).DeleteByDocumentID(doc.ID); err != nil {
+			return err
+		}
+		if err := s.storyRepo.WithTx(tx).ClearDocumentIDsByUserID(userID, []string{doc.ID}); err != nil {
+			return err
+		}
+		if doc.ConversationID != "" {
+			err := s.conversationRepo.WithTx(tx).RemoveDocumentID(doc.ConversationID, userID, doc.ID)
+			if err != nil && !repository.IsNotFound(err) {
+				return err
+			}
+		}
+
+		return s.documentRepo.WithTx(tx).DeleteByIDAndUserID(doc.ID, userID)
+	})
 }
 
 // CreateDocument 创建文档
-func (s *DocumentService) CreateDocument(conversationID, role, content, model string) (*models.Document, error) {
+func (s *DocumentService) CreateDocument(userID, conversationID, role, content, model string) (*models.Document, error) {
 	doc := &models.Document{
 		ID:             utils.GenerateDocumentID(),
+		UserID:         userID,
 		ConversationID: conversationID,
 		Role:           role,
 		Content:        content,
@@ -86,12 +118,11 @@ func (s *DocumentService) CreateDocument(conversationID, role, content, model st
 	return doc, nil
 }
 
-// GetDocumentIDsByConversationID 获取对话的文档ID列表（用于翻页）
-func (s *DocumentService) GetDocumentIDsByConversationID(conversationID string, beforeDocumentID string, limit int) ([]string, error) {
-	return s.documentRepo.GetDocumentIDsByConversationID(conversationID, beforeDocumentID, limit)
-}
-
 // GetDocumentIDsByConversationIDAndUserID 获取对话的文档ID列表（确保数据隔离）
 func (s *DocumentService) GetDocumentIDsByConversationIDAndUserID(conversationID, userID string, beforeDocumentID string, limit int) ([]string, error) {
+	// 先验证对话属于该用户
+	if _, err := s.conversationRepo.GetByIDAndUserID(conversationID, userID); err != nil {
+		return nil, err
+	}
 	return s.documentRepo.GetDocumentIDsByConversationIDAndUserID(conversationID, userID, beforeDocumentID, limit)
 }
@@ -0,0 +1,68 @@
+package extraction
+
+import (
+	"errors"
+	"grandma/backend/modules/auth"
+	"grandma/backend/repository"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExtractionHandler 设定建议处理器
+type ExtractionHandler struct {
+	service *ExtractionService
+}
+
+// NewExtractionHandler 创建设定建议处理器
+func NewExtractionHandler(service *ExtractionService) *ExtractionHandler {
+	return &ExtractionHandler{
+		service: service,
+	}
+}
+
+// ListProposals 获取创作的设定建议，status参数用于过滤
+func (h *ExtractionHandler) ListProposals(c *gin.Context) {
+	response, err := h.service.ListProposals(c.Param("work_id"), auth.CurrentUserID(c), c.Query("status"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// AcceptProposal 接受设定建议，返回写入后的设定条目
+func (h *ExtractionHandler) AcceptProposal(c *gin.Context) {
+	entry, err := h.service.AcceptProposal(c.Param("id"), auth.CurrentUserID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// RejectProposal 拒绝设定建议
+func (h *ExtractionHandler) RejectProposal(c *gin.Context) {
+	if err := h.service.RejectProposal(c.Param("id"), auth.CurrentUserID(c)); err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "rejected"})
+}
+
+// writeError 把服务错误转换为HTTP响应
+func writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrProposalResolved):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidProposalStatus):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case repository.IsNotFound(err):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Work, proposal or entry not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
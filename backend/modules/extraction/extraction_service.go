@@ -0,0 +1,549 @@
+package extraction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/modules/credential"
+	"grandma/backend/modules/rag"
+	"grandma/backend/modules/usage"
+	"grandma/backend/repository"
+	"grandma/backend/services"
+	"grandma/backend/utils"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxSourceRunes      = 12000           // 单次提取发送给模型的最大字符数
+	embeddingTextRunes  = 200             // 计算向量嵌入时使用的设定内容字符数
+	similarityThreshold = 0.85            // 名称不同但向量相似度达到该值时视为同一设定
+	extractTimeout      = 2 * time.Minute // 单次提取的超时时间
+)
+
+var (
+	// ErrProposalResolved 设定建议已经被接受或拒绝
+	ErrProposalResolved = errors.New("proposal_resolved")
+	// ErrInvalidProposalStatus 设定建议状态不是pending、accepted或rejected
+	ErrInvalidProposalStatus = errors.New("invalid_proposal_status")
+)
+
+// entityCategories 自动提取的设定分类
+var entityCategories = []string{"character", "location", "item", "event"}
+
+// entitySchema 设定提取的结构化输出
+var entitySchema = services.JSONSchema{
+	Name:        "story_entities",
+	Description: "文中出现的有名字的人物、地点、物品和事件",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"entities": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"category":    map[string]interface{}{"type": "string", "enum": entityCategories},
+						"name":        map[string]interface{}{"type": "string", "description": "文中最常用的称呼", "maxLength": 40},
+						"aliases":     map[string]interface{}{"type": "array", "description": "文中出现的其他称呼", "items": map[string]interface{}{"type": "string"}},
+						"description": map[string]interface{}{"type": "string", "description": "一两句话概括文中明确给出的信息", "maxLength": 300},
+					},
+					"required": []string{"category", "name", "aliases", "description"},
+				},
+			},
+		},
+		"required": []string{"entities"},
+	},
+}
+
+// entity 模型提取出的设定
+type entity struct {
+	Category    string   `json:"category"`
+	Name        string   `json:"name"`
+	Aliases     []string `json:"aliases"`
+	Description string   `json:"description"`
+}
+
+// ExtractionConfig 设定自动提取配置
+type ExtractionConfig struct {
+	Enabled bool          // 是否在保存正文和助手回答后自动提取设定
+	Model   string        // 提取正文时使用的模型（默认openai），助手回答使用生成它的模型
+	Delay   time.Duration // 保存后等待多久再提取，期间再次保存会重新计时
+}
+
+// ExtractionService 设定自动提取服务：在正文或助手回答保存后从中提取人物、地点、物品和事件，
+// 与已有设定按别名和向量相似度去重后生成待用户确认的设定建议
+type ExtractionService struct {
+	proposalRepo     *repository.BibleProposalRepository
+	storyBibleRepo   *repository.StoryBibleRepository
+	workRepo         *repository.WorkRepository
+	workDocumentRepo *repository.WorkDocumentRepository
+	workMessageRepo  *repository.WorkChatMessageRepository
+	ragService       *rag.RAGService
+	credentialSvc    *credential.CredentialService
+	usageSvc         *usage.UsageService
+	config           *ExtractionConfig
+
+	mu        sync.Mutex
+	timers    map[string]*time.Timer // 等待提取的来源
+	workLocks sync.Map               // 作品ID -> *sync.Mutex，同一作品的建议依次去重保存，避免并发生成重复的建议
+}
+
+// NewExtractionService 创建设定自动提取服务
+func NewExtractionService(proposalRepo *repository.BibleProposalRepository, storyBibleRepo *repository.StoryBibleRepository, workRepo *repository.WorkRepository, workDocumentRepo *repository.WorkDocumentRepository, workMessageRepo *repository.WorkChatMessageRepository, ragService *rag.RAGService, credentialSvc *credential.CredentialService, usageSvc *usage.UsageService, config *ExtractionConfig) *ExtractionService {
+	if config == nil {
+		config = &ExtractionConfig{}
+	}
+	if config.Model == "" {
+		config.Model = "openai"
+	}
+	return &ExtractionService{
+		proposalRepo:     proposalRepo,
+		storyBibleRepo:   storyBibleRepo,
+		workRepo:         workRepo,
+		workDocumentRepo: workDocumentRepo,
+		workMessageRepo:  workMessageRepo,
+		ragService:       ragService,
+		credentialSvc:    credentialSvc,
+		usageSvc:         usageSvc,
+		config:           config,
+		timers:           make(map[string]*time.Timer),
+	}
+}
+
+// ScheduleWorkDocument 正文文档保存后安排提取，提取时读取文档的最新内容
+func (s *ExtractionService) ScheduleWorkDocument(documentID, userID string) {
+	s.schedule("document:"+documentID, func() {
+		doc, err := s.workDocumentRepo.GetByIDAndUserID(documentID, userID)
+		if err != nil {
+			return // 文档已被删除
+		}
+		s.run(userID, doc.WorkID, models.ProposalSourceWorkDocument, doc.ID, doc.Content, s.config.Model)
+	})
+}
+
+// ScheduleWorkMessage 助手回答保存后安排提取，使用生成该回答的模型
+func (s *ExtractionService) ScheduleWorkMessage(messageID, userID, model string) {
+	s.schedule("message:"+messageID, func() {
+		message, err := s.workMessageRepo.GetByIDAndUserID(messageID, userID)
+		if err != nil || message.Role != "assistant" {
+			return
+		}
+		if model == "" {
+			model = s.config.Model
+		}
+		s.run(userID, message.WorkID, models.ProposalSourceWorkMessage, message.ID, message.Content, model)
+	})
+}
+
+// schedule 延迟执行提取，同一来源在等待期间再次保存时重新计时
+func (s *ExtractionService) schedule(key string, extract func()) {
+	if s == nil || !s.config.Enabled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if timer, ok := s.timers[key]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(s.config.Delay, func() {
+		s.mu.Lock()
+		if s.timers[key] == timer {
+			delete(s.timers, key)
+		}
+		s.mu.Unlock()
+		extract()
+	})
+	s.timers[key] = timer
+}
+
+// run 执行提取并记录错误
+func (s *ExtractionService) run(userID, workID, sourceType, sourceID, content, model string) {
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+	if err := s.extract(userID, workID, sourceType, sourceID, content, model); err != nil {
+		log.Printf("Failed to extract story entities from %s %s: %v", sourceType, sourceID, err)
+	}
+}
+
+// extract 调用模型提取设定，与已有设定和待处理的建议去重后保存新的建议
+func (s *ExtractionService) extract(userID, workID, sourceType, sourceID, content, model string) error {
+	provider, err := s.credentialSvc.GetProvider(userID, model)
+	if err != nil {
+		return err
+	}
+	if err := s.usageSvc.CheckQuota(userID); err != nil {
+		return err
+	}
+	provider = s.usageSvc.Meter(provider, userID, usage.FeatureExtraction)
+
+	entries, err := s.storyBibleRepo.GetByWorkIDAndUserID(workID, userID)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), extractTimeout)
+	defer cancel()
+	messages := []models.Message{{Role: "user", Content: buildPrompt(entries, content)}}
+	var result struct {
+		Entities []entity `json:"entities"`
+	}
+	if err := services.ChatJSON(ctx, provider, messages, entitySchema, &result); err != nil {
+		return err
+	}
+
+	candidates := normalizeEntities(result.Entities)
+	matches, similarities := s.match(userID, entries, candidates)
+
+	// 模型调用期间不加锁，只在读取待处理建议到保存新建议之间锁住当前作品
+	lock, _ := s.workLocks.LoadOrStore(workID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	pending, err := s.proposalRepo.GetByWorkIDAndUserID(workID, userID, models.ProposalStatusPending)
+	if err != nil {
+		return err
+	}
+	for i, candidate := range candidates {
+		proposal := &models.BibleProposal{
+			ID:         utils.GenerateBibleProposalID(),
+			UserID:     userID,
+			WorkID:     workID,
+			Action:     models.ProposalActionAdd,
+			Category:   candidate.Category,
+			Name:       candidate.Name,
+			Aliases:    candidate.Aliases,
+			Content:    candidate.Description,
+			SourceType: sourceType,
+			SourceID:   sourceID,
+			Model:      model,
+			Status:     models.ProposalStatusPending,
+		}
+		if entry := matches[i]; entry != nil {
+			// 已有设定：只建议补充新的称呼和设定中还没有的内容
+			proposal.Action = models.ProposalActionUpdate
+			proposal.EntryID = entry.ID
+			proposal.Name = entry.Name
+			proposal.Category = entry.Category
+			proposal.Aliases = newNames(entry, append([]string{candidate.Name}, candidate.Aliases...))
+			proposal.Similarity = similarities[i]
+			if strings.Contains(entry.Content, candidate.Description) {
+				proposal.Content = ""
+			}
+			if len(proposal.Aliases) == 0 && proposal.Content == "" {
+				continue
+			}
+		}
+		if duplicateProposal(pending, proposal) {
+			continue
+		}
+		if err := s.proposalRepo.Create(proposal); err != nil {
+			return err
+		}
+		pending = append(pending, *proposal)
+	}
+	return nil
+}
+
+// match 为每个候选设定找出对应的已有条目：先按名称和别名匹配，再按同分类条目的向量相似度匹配（RAG未启用时跳过）
+func (s *ExtractionService) match(userID string, entries []models.StoryBibleEntry, candidates []entity) ([]*models.StoryBibleEntry, []float32) {
+	matches := make([]*models.StoryBibleEntry, len(candidates))
+	similarities := make([]float32, len(candidates))
+	var unmatched []int
+	for i, candidate := range candidates {
+		names := append([]string{candidate.Name}, candidate.Aliases...)
+		for j := range entries {
+			if len(newNames(&entries[j], names)) < len(names) {
+				matches[i] = &entries[j]
+				similarities[i] = 1
+				break
+			}
+		}
+		if matches[i] == nil {
+			unmatched = append(unmatched, i)
+		}
+	}
+	if len(unmatched) == 0 || len(entries) == 0 {
+		return matches, similarities
+	}
+
+	entryEmbeddings, err := s.entryEmbeddings(userID, entries)
+	if err != nil || entryEmbeddings == nil {
+		if err != nil {
+			log.Printf("Failed to embed story bible entries: %v", err)
+		}
+		return matches, similarities
+	}
+	texts := make([]string, len(unmatched))
+	for k, i := range unmatched {
+		texts[k] = embeddingText(candidates[i].Name, candidates[i].Aliases, candidates[i].Description)
+	}
+	candidateEmbeddings, err := s.ragService.Embed(userID, texts)
+	if err != nil {
+		log.Printf("Failed to embed extracted entities: %v", err)
+		return matches, similarities
+	}
+	for k, i := range unmatched {
+		for j := range entries {
+			if entries[j].Category != candidates[i].Category || entryEmbeddings[j] == nil {
+				continue
+			}
+			if similarity := s.ragService.Similarity(candidateEmbeddings[k], entryEmbeddings[j]); similarity >= similarityThreshold && similarity > similarities[i] {
+				matches[i] = &entries[j]
+				similarities[i] = similarity
+			}
+		}
+	}
+	return matches, similarities
+}
+
+// entryEmbeddings 获取设定条目的向量嵌入，缺少的批量计算后保存；RAG未启用时返回nil
+func (s *ExtractionService) entryEmbeddings(userID string, entries []models.StoryBibleEntry) ([][]float32, error) {
+	embeddings := make([][]float32, len(entries))
+	var missing []int
+	var texts []string
+	for i, entry := range entries {
+		if entry.Embedding != "" && json.Unmarshal([]byte(entry.Embedding), &embeddings[i]) == nil {
+			continue
+		}
+		missing = append(missing, i)
+		texts = append(texts, embeddingText(entry.Name, entry.Aliases, entry.Content))
+	}
+	if len(missing) == 0 {
+		return embeddings, nil
+	}
+
+	computed, err := s.ragService.Embed(userID, texts)
+	if err != nil || computed == nil {
+		return nil, err
+	}
+	for k, i := range missing {
+		embeddings[i] = computed[k]
+		data, err := json.Marshal(computed[k])
+		if err != nil {
+			continue
+		}
+		if err := s.storyBibleRepo.UpdateEmbeddingByIDAndUserID(entries[i].ID, userID, string(data)); err != nil {
+			log.Printf("Failed to save embedding of story bible entry %s: %v", entries[i].ID, err)
+		}
+	}
+	return embeddings, nil
+}
+
+// ListProposals 获取创作的设定建议，status为空时返回全部
+func (s *ExtractionService) ListProposals(workID, userID, status string) (*models.BibleProposalListResponse, error) {
+	switch status {
+	case "", models.ProposalStatusPending, models.ProposalStatusAccepted, models.ProposalStatusRejected:
+	default:
+		return nil, ErrInvalidProposalStatus
+	}
+	if _, err := s.workRepo.GetByIDAndUserID(workID, userID); err != nil {
+		return nil, err
+	}
+	proposals, err := s.proposalRepo.GetByWorkIDAndUserID(workID, userID, status)
+	if err != nil {
+		return nil, err
+	}
+	return &models.BibleProposalListResponse{Proposals: proposals, Total: len(proposals)}, nil
+}
+
+// AcceptProposal 接受设定建议：新建设定条目，或为已有条目补充别名和内容，返回写入后的条目
+// 新建时如果同分类下已有同名条目，则合并到该条目
+func (s *ExtractionService) AcceptProposal(id, userID string) (*models.StoryBibleEntry, error) {
+	proposal, err := s.proposalRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if proposal.Status != models.ProposalStatusPending {
+		return nil, ErrProposalResolved
+	}
+	// 先把建议标记为已接受，避免并发接受时重复写入
+	if err := s.proposalRepo.UpdateStatusByIDAndUserID(proposal.ID, userID, models.ProposalStatusPending, models.ProposalStatusAccepted); err != nil {
+		if repository.IsNotFound(err) {
+			return nil, ErrProposalResolved
+		}
+		return nil, err
+	}
+
+	entry, err := s.applyProposal(proposal)
+	if err != nil {
+		if revertErr := s.proposalRepo.UpdateStatusByIDAndUserID(proposal.ID, userID, models.ProposalStatusAccepted, models.ProposalStatusPending); revertErr != nil {
+			log.Printf("Failed to revert bible proposal %s: %v", proposal.ID, revertErr)
+		}
+		return nil, err
+	}
+	return entry, nil
+}
+
+// RejectProposal 拒绝设定建议
+func (s *ExtractionService) RejectProposal(id, userID string) error {
+	err := s.proposalRepo.UpdateStatusByIDAndUserID(id, userID, models.ProposalStatusPending, models.ProposalStatusRejected)
+	if repository.IsNotFound(err) {
+		if _, getErr := s.proposalRepo.GetByIDAndUserID(id, userID); getErr == nil {
+			return ErrProposalResolved
+		}
+	}
+	return err
+}
+
+// applyProposal 把设定建议写入设定集
+func (s *ExtractionService) applyProposal(proposal *models.BibleProposal) (*models.StoryBibleEntry, error) {
+	var entry *models.StoryBibleEntry
+	var err error
+	if proposal.Action == models.ProposalActionUpdate {
+		entry, err = s.storyBibleRepo.GetByIDAndUserID(proposal.EntryID, proposal.UserID)
+	} else {
+		entry, err = s.storyBibleRepo.GetByNameAndUserID(proposal.WorkID, proposal.UserID, proposal.Category, proposal.Name)
+		if repository.IsNotFound(err) {
+			entry = &models.StoryBibleEntry{
+				ID:       utils.GenerateStoryBibleEntryID(),
+				UserID:   proposal.UserID,
+				WorkID:   proposal.WorkID,
+				Category: proposal.Category,
+				Name:     proposal.Name,
+				Aliases:  proposal.Aliases,
+				Content:  proposal.Content,
+				Source:   "extraction",
+			}
+			if err := s.storyBibleRepo.Create(entry); err != nil {
+				return nil, err
+			}
+			return entry, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := append(append([]string{}, entry.Aliases...), newNames(entry, proposal.Aliases)...)
+	content := ""
+	if proposal.Content != "" && !strings.Contains(entry.Content, proposal.Content) {
+		content = proposal.Content
+		if entry.Content != "" {
+			content = "\n" + content
+		}
+	}
+	if err := s.storyBibleRepo.MergeByIDAndUserID(entry.ID, entry.UserID, aliases, content); err != nil {
+		return nil, err
+	}
+	return s.storyBibleRepo.GetByIDAndUserID(entry.ID, entry.UserID)
+}
+
+// buildPrompt 生成提取设定的提示词，列出已有设定以便模型沿用相同的名称
+func buildPrompt(entries []models.StoryBibleEntry, content string) string {
+	var sb strings.Builder
+	sb.WriteString("请从下面的文字中找出有名字的人物（character）、地点（location）、物品（item）和事件（event）。")
+	sb.WriteString("name使用文中最常用的称呼，aliases列出文中出现的其他称呼；description用一两句话概括文中明确给出的信息，不要推测。没有时返回空数组。\n")
+	if len(entries) > 0 {
+		sb.WriteString("\n已有设定（提到它们时请沿用相同的名称）：\n")
+		for _, entry := range entries {
+			sb.WriteString("- " + entry.Name)
+			if len(entry.Aliases) > 0 {
+				sb.WriteString("（" + strings.Join(entry.Aliases, "、") + "）")
+			}
+			sb.WriteString("\n")
+		}
+	}
+	sb.WriteString("\n【文字】\n")
+	sb.WriteString(utils.TruncateRunes(strings.TrimSpace(content), maxSourceRunes, "..."))
+	return sb.String()
+}
+
+// normalizeEntities 去掉空白和没有名称的设定，别名去重并去掉与名称相同的项
+func normalizeEntities(entities []entity) []entity {
+	var result []entity
+	for _, e := range entities {
+		e.Name = strings.TrimSpace(e.Name)
+		e.Description = strings.TrimSpace(e.Description)
+		if e.Name == "" {
+			continue
+		}
+		seen := map[string]bool{nameKey(e.Name): true}
+		var aliases []string
+		for _, alias := range e.Aliases {
+			alias = strings.TrimSpace(alias)
+			if alias != "" && !seen[nameKey(alias)] {
+				seen[nameKey(alias)] = true
+				aliases = append(aliases, alias)
+			}
+		}
+		e.Aliases = aliases
+		result = append(result, e)
+	}
+	return result
+}
+
+// newNames 返回names中不是条目名称或别名的称呼
+func newNames(entry *models.StoryBibleEntry, names []string) []string {
+	known := map[string]bool{nameKey(entry.Name): true}
+	for _, alias := range entry.Aliases {
+		known[nameKey(alias)] = true
+	}
+	var result []string
+	for _, name := range names {
+		if key := nameKey(name); key != "" && !known[key] {
+			known[key] = true
+			result = append(result, strings.TrimSpace(name))
+		}
+	}
+	return result
+}
+
+// duplicateProposal 判断是否已有相同的待处理建议：新建时名称或别名相同，补充时针对同一条目且没有新的内容和别名
+func duplicateProposal(pending []models.BibleProposal, proposal *models.BibleProposal) bool {
+	names := map[string]bool{nameKey(proposal.Name): true}
+	for _, alias := range proposal.Aliases {
+		names[nameKey(alias)] = true
+	}
+	for _, p := range pending {
+		if p.Action != proposal.Action {
+			continue
+		}
+		switch proposal.Action {
+		case models.ProposalActionAdd:
+			if names[nameKey(p.Name)] {
+				return true
+			}
+			for _, alias := range p.Aliases {
+				if names[nameKey(alias)] {
+					return true
+				}
+			}
+		case models.ProposalActionUpdate:
+			if p.EntryID != proposal.EntryID || !strings.Contains(p.Content, proposal.Content) {
+				continue
+			}
+			known := map[string]bool{}
+			for _, alias := range p.Aliases {
+				known[nameKey(alias)] = true
+			}
+			covered := true
+			for _, alias := range proposal.Aliases {
+				covered = covered && known[nameKey(alias)]
+			}
+			if covered {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nameKey 比较名称时忽略大小写和空白
+func nameKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), ""))
+}
+
+// embeddingText 计算向量嵌入使用的文本
+func embeddingText(name string, aliases []string, content string) string {
+	text := name
+	if len(aliases) > 0 {
+		text += "（" + strings.Join(aliases, "、") + "）"
+	}
+	return text + "：" + utils.TruncateRunes(content, embeddingTextRunes, "")
+}
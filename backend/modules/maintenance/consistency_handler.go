@@ -0,0 +1,41 @@
+package maintenance
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConsistencyHandler 数据一致性检查处理器
+type ConsistencyHandler struct {
+	service *ConsistencyService
+}
+
+// NewConsistencyHandler 创建数据一致性检查处理器
+func NewConsistencyHandler(service *ConsistencyService) *ConsistencyHandler {
+	return &ConsistencyHandler{
+		service: service,
+	}
+}
+
+// CheckConsistency 执行一致性检查（只报告，不修复）
+func (h *ConsistencyHandler) CheckConsistency(c *gin.Context) {
+	report, err := h.service.Check(false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// RepairConsistency 执行一致性检查并修复发现的问题
+func (h *ConsistencyHandler) RepairConsistency(c *gin.Context) {
+	report, err := h.service.Check(true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,202 @@
+package maintenance
+
+import (
+	"grandma/backend/models"
+	"grandma/backend/repository"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConsistencyService 数据一致性检查服务
+// 查找并（可选）修复孤立数据：来源文档已删除的向量chunks、所属对话/创作已删除的文档和对话消息、
+// 对话DocumentIDs中失效的文档ID、以及关联文档已删除的故事
+type ConsistencyService struct {
+	conversationRepo *repository.ConversationRepository
+	documentRepo     *repository.DocumentRepository
+	workDocumentRepo *repository.WorkDocumentRepository
+	workMessageRepo  *repository.WorkChatMessageRepository
+	vectorChunkRepo  *repository.VectorChunkRepository
+	storyRepo        *repository.StoryRepository
+
+	mu     sync.Mutex // 保证同一时间只有一次检查在执行
+	stopCh chan struct{}
+}
+
+// NewConsistencyService 创建数据一致性检查服务
+func NewConsistencyService(conversationRepo *repository.ConversationRepository, documentRepo *repository.DocumentRepository, workDocumentRepo *repository.WorkDocumentRepository, workMessageRepo *repository.WorkChatMessageRepository, vectorChunkRepo *repository.VectorChunkRepository, storyRepo *repository.StoryRepository) *ConsistencyService {
+	return &ConsistencyService{
+		conversationRepo: conversationRepo,
+		documentRepo:     documentRepo,
+		workDocumentRepo: workDocumentRepo,
+		workMessageRepo:  workMessageRepo,
+		vectorChunkRepo:  vectorChunkRepo,
+		storyRepo:        storyRepo,
+	}
+}
+
+// Check 执行一次一致性检查，repair为true时同时修复发现的问题
+// 修复时会先删除孤立文档，再查找孤立chunks，因此被删除文档的chunks也会在同一轮被清理
+func (s *ConsistencyService) Check(repair bool) (*models.ConsistencyReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := &models.ConsistencyReport{
+		OrphanChunkIDs:         []string{},
+		OrphanDocumentIDs:      []string{},
+		OrphanWorkDocumentIDs:  []string{},
+		OrphanWorkMessageIDs:   []string{},
+		DanglingDocumentIDRefs: []models.DanglingDocumentIDRef{},
+		BrokenStoryIDs:         []string{},
+		Repaired:               repair,
+		CheckedAt:              time.Now(),
+	}
+
+	// 1. 所属对话已不存在的文档
+	orphanDocs, err := s.documentRepo.GetOrphans()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range orphanDocs {
+		report.OrphanDocumentIDs = append(report.OrphanDocumentIDs, doc.ID)
+	}
+	if repair {
+		if err := s.documentRepo.DeleteByIDs(report.OrphanDocumentIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	// 2. 所属创作已不存在的创作文档
+	orphanWorkDocs, err := s.workDocumentRepo.GetOrphans()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range orphanWorkDocs {
+		report.OrphanWorkDocumentIDs = append(report.OrphanWorkDocumentIDs, doc.ID)
+	}
+	if repair {
+		if err := s.workDocumentRepo.DeleteByIDs(report.OrphanWorkDocumentIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	// 3. 所属创作已不存在的灵感模式对话消息
+	orphanMessages, err := s.workMessageRepo.GetOrphans()
+	if err != nil {
+		return nil, err
+	}
+	for _, message := range orphanMessages {
+		report.OrphanWorkMessageIDs = append(report.OrphanWorkMessageIDs, message.ID)
+	}
+	if repair {
+		if err := s.workMessageRepo.DeleteByIDs(report.OrphanWorkMessageIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	// 4. 来源文档已不存在的向量chunks
+	orphanChunks, err := s.vectorChunkRepo.GetOrphans()
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range orphanChunks {
+		report.OrphanChunkIDs = append(report.OrphanChunkIDs, chunk.ID)
+	}
+	if repair {
+		if err := s.vectorChunkRepo.DeleteByIDs(report.OrphanChunkIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	// 5. 对话DocumentIDs中指向不存在文档的ID
+	conversations, err := s.conversationRepo.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, conversation := range conversations {
+		if conversation.DocumentIDs == "" {
+			continue
+		}
+		ids := strings.Split(conversation.DocumentIDs, ",")
+		existing, err := s.documentRepo.GetExistingIDs(ids)
+		if err != nil {
+			return nil, err
+		}
+
+		kept := make([]string, 0, len(ids))
+		missing := make([]string, 0)
+		for _, id := range ids {
+			if existing[id] {
+				kept = append(kept, id)
+			} else {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		report.DanglingDocumentIDRefs = append(report.DanglingDocumentIDRefs, models.DanglingDocumentIDRef{
+			ConversationID: conversation.ID,
+			DocumentIDs:    missing,
+		})
+		if repair {
+			if err := s.conversationRepo.UpdateDocumentIDs(conversation.ID, strings.Join(kept, ",")); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// 6. 关联文档已不存在的故事（故事内容本身保留，只清除失效的引用）
+	brokenStories, err := s.storyRepo.GetWithMissingDocument()
+	if err != nil {
+		return nil, err
+	}
+	for _, story := range brokenStories {
+		report.BrokenStoryIDs = append(report.BrokenStoryIDs, story.ID)
+		if repair {
+			if err := s.storyRepo.ClearDocumentID(story.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// StartPeriodicCheck 启动定时一致性检查
+func (s *ConsistencyService) StartPeriodicCheck(interval time.Duration, repair bool) {
+	if interval <= 0 || s.stopCh != nil {
+		return
+	}
+	s.stopCh = make(chan struct{})
+
+	go func(stopCh chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				report, err := s.Check(repair)
+				if err != nil {
+					log.Printf("Consistency check failed: %v", err)
+					continue
+				}
+				if total := report.Total(); total > 0 {
+					log.Printf("Consistency check found %d issue(s), repaired: %v", total, repair)
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}(s.stopCh)
+}
+
+// StopPeriodicCheck 停止定时一致性检查
+func (s *ConsistencyService) StopPeriodicCheck() {
+	if s.stopCh != nil {
+		close(s.stopCh)
+		s.stopCh = nil
+	}
+}
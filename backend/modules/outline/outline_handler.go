@@ -0,0 +1,127 @@
+package outline
+
+import (
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/repository"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OutlineHandler 大纲处理器
+type OutlineHandler struct {
+	service *OutlineService
+}
+
+// NewOutlineHandler 创建大纲处理器
+func NewOutlineHandler(service *OutlineService) *OutlineHandler {
+	return &OutlineHandler{
+		service: service,
+	}
+}
+
+// GetOutline 获取创作的大纲树
+func (h *OutlineHandler) GetOutline(c *gin.Context) {
+	response, err := h.service.GetOutline(c.Param("work_id"), auth.CurrentUserID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// CreateNode 创建大纲节点
+func (h *OutlineHandler) CreateNode(c *gin.Context) {
+	var req models.OutlineNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	node, err := h.service.CreateNode(c.Param("work_id"), auth.CurrentUserID(c), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, node)
+}
+
+// Reorder 调整同级节点顺序
+func (h *OutlineHandler) Reorder(c *gin.Context) {
+	var req models.ReorderOutlineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.Reorder(c.Param("work_id"), auth.CurrentUserID(c), &req); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Outline reordered successfully"})
+}
+
+// UpdateNode 更新大纲节点
+func (h *OutlineHandler) UpdateNode(c *gin.Context) {
+	var req models.OutlineNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	node, err := h.service.UpdateNode(c.Param("id"), auth.CurrentUserID(c), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, node)
+}
+
+// DeleteNode 删除大纲节点及其子孙节点
+func (h *OutlineHandler) DeleteNode(c *gin.Context) {
+	if err := h.service.DeleteNode(c.Param("id"), auth.CurrentUserID(c)); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Outline node deleted successfully"})
+}
+
+// MoveNode 移动大纲节点
+func (h *OutlineHandler) MoveNode(c *gin.Context) {
+	var req models.MoveOutlineNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	node, err := h.service.Move(c.Param("id"), auth.CurrentUserID(c), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, node)
+}
+
+// CreateDraft 为章或场景创建关联的正文文档
+func (h *OutlineHandler) CreateDraft(c *gin.Context) {
+	doc, err := h.service.CreateDraft(c.Param("id"), auth.CurrentUserID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, doc)
+}
+
+// writeError 把服务错误转换为HTTP响应
+func writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidOutlineNode), errors.Is(err, repository.ErrInvalidOrder):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case repository.IsNotFound(err):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Outline node, work or document not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,320 @@
+package outline
+
+import (
+	"errors"
+	"fmt"
+	"grandma/backend/models"
+	"grandma/backend/repository"
+	"grandma/backend/utils"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxTitleRunes 节点标题的最大字符数
+const maxTitleRunes = 200
+
+// ErrInvalidOutlineNode 节点内容或位置不合法
+var ErrInvalidOutlineNode = errors.New("invalid_outline_node")
+
+// allowedChildren 每种父节点下允许的子节点类型，空字符串表示顶层
+// 类型层级严格递减（卷 → 章 → 场景），因此移动节点不会产生环
+var allowedChildren = map[string][]string{
+	"":                        {models.OutlineKindPart, models.OutlineKindChapter},
+	models.OutlineKindPart:    {models.OutlineKindChapter},
+	models.OutlineKindChapter: {models.OutlineKindScene},
+}
+
+// OutlineService 大纲服务
+type OutlineService struct {
+	outlineRepo      *repository.OutlineRepository
+	workRepo         *repository.WorkRepository
+	workDocumentRepo *repository.WorkDocumentRepository
+}
+
+// NewOutlineService 创建大纲服务
+func NewOutlineService(outlineRepo *repository.OutlineRepository, workRepo *repository.WorkRepository, workDocumentRepo *repository.WorkDocumentRepository) *OutlineService {
+	return &OutlineService{
+		outlineRepo:      outlineRepo,
+		workRepo:         workRepo,
+		workDocumentRepo: workDocumentRepo,
+	}
+}
+
+// GetOutline 获取创作的大纲树，附带每个节点关联正文的字数
+func (s *OutlineService) GetOutline(workID, userID string) (*models.OutlineResponse, error) {
+	// 先验证创作属于该用户
+	if _, err := s.workRepo.GetByIDAndUserID(workID, userID); err != nil {
+		return nil, err
+	}
+
+	nodes, err := s.outlineRepo.GetByWorkIDAndUserID(workID, userID)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := s.workDocumentRepo.GetByWorkIDAndUserID(workID, userID)
+	if err != nil {
+		return nil, err
+	}
+	lengths := make(map[string]int, len(docs))
+	for _, doc := range docs {
+		lengths[doc.ID] = utf8.RuneCountInString(doc.Content)
+	}
+
+	children := make(map[string][]models.OutlineNode)
+	for _, node := range nodes {
+		children[node.ParentID] = append(children[node.ParentID], node)
+	}
+	return &models.OutlineResponse{
+		Nodes: buildTree(children, lengths, ""),
+		Total: len(nodes),
+	}, nil
+}
+
+// ReadingOrder 按阅读顺序返回创作的正文文档：先按大纲顺序排列关联了大纲节点的文档，再按创建顺序排列其余文档
+func (s *OutlineService) ReadingOrder(workID, userID string) ([]models.WorkDocument, error) {
+	nodes, err := s.outlineRepo.GetByWorkIDAndUserID(workID, userID)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := s.workDocumentRepo.GetByWorkIDAndUserID(workID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[string][]models.OutlineNode)
+	for _, node := range nodes {
+		children[node.ParentID] = append(children[node.ParentID], node)
+	}
+	var documentIDs []string
+	var walk func(parentID string)
+	walk = func(parentID string) {
+		for _, node := range children[parentID] {
+			if node.DocumentID != "" {
+				documentIDs = append(documentIDs, node.DocumentID)
+			}
+			walk(node.ID)
+		}
+	}
+	walk("")
+
+	byID := make(map[string]models.WorkDocument, len(docs))
+	for _, doc := range docs {
+		byID[doc.ID] = doc
+	}
+	ordered := make([]models.WorkDocument, 0, len(docs))
+	for _, id := range documentIDs {
+		if doc, ok := byID[id]; ok {
+			ordered = append(ordered, doc)
+			delete(byID, id)
+		}
+	}
+	for _, doc := range docs {
+		if _, ok := byID[doc.ID]; ok {
+			ordered = append(ordered, doc)
+		}
+	}
+	return ordered, nil
+}
+
+// buildTree 按父节点构建子树（节点已按order_index排序）
+func buildTree(children map[string][]models.OutlineNode, lengths map[string]int, parentID string) []models.OutlineTreeNode {
+	tree := make([]models.OutlineTreeNode, 0, len(children[parentID]))
+	for _, node := range children[parentID] {
+		tree = append(tree, models.OutlineTreeNode{
+			OutlineNode: node,
+			DraftLength: lengths[node.DocumentID],
+			Children:    buildTree(children, lengths, node.ID),
+		})
+	}
+	return tree
+}
+
+// CreateNode 在创作大纲中创建节点
+func (s *OutlineService) CreateNode(workID, userID string, req *models.OutlineNodeRequest) (*models.OutlineNode, error) {
+	// 先验证创作属于该用户
+	if _, err := s.workRepo.GetByIDAndUserID(workID, userID); err != nil {
+		return nil, err
+	}
+	if err := s.checkPlacement(workID, userID, req.ParentID, req.Kind); err != nil {
+		return nil, err
+	}
+
+	node := &models.OutlineNode{
+		ID:       utils.GenerateOutlineNodeID(),
+		UserID:   userID,
+		WorkID:   workID,
+		ParentID: req.ParentID,
+		Kind:     req.Kind,
+	}
+	if err := s.applyFields(node, req); err != nil {
+		return nil, err
+	}
+	if err := s.outlineRepo.Create(node, req.Position); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// UpdateNode 更新节点的标题、梗概、状态、目标字数和关联正文
+func (s *OutlineService) UpdateNode(id, userID string, req *models.OutlineNodeRequest) (*models.OutlineNode, error) {
+	node, err := s.outlineRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applyFields(node, req); err != nil {
+		return nil, err
+	}
+	if err := s.outlineRepo.UpdateByIDAndUserID(node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// DeleteNode 删除节点及其所有子孙节点（关联的正文文档保留）
+func (s *OutlineService) DeleteNode(id, userID string) error {
+	node, err := s.outlineRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return err
+	}
+	nodes, err := s.outlineRepo.GetByWorkIDAndUserID(node.WorkID, userID)
+	if err != nil {
+		return err
+	}
+
+	children := make(map[string][]string)
+	for _, n := range nodes {
+		children[n.ParentID] = append(children[n.ParentID], n.ID)
+	}
+	ids := []string{node.ID}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, children[ids[i]]...)
+	}
+	return s.outlineRepo.DeleteSubtree(node, ids)
+}
+
+// Reorder 调整同一父节点下子节点的顺序
+func (s *OutlineService) Reorder(workID, userID string, req *models.ReorderOutlineRequest) error {
+	// 先验证创作属于该用户
+	if _, err := s.workRepo.GetByIDAndUserID(workID, userID); err != nil {
+		return err
+	}
+	return s.outlineRepo.Reorder(workID, userID, req.ParentID, req.NodeIDs)
+}
+
+// Move 把节点（连同子孙节点）移动到新父节点下的指定位置
+func (s *OutlineService) Move(id, userID string, req *models.MoveOutlineNodeRequest) (*models.OutlineNode, error) {
+	node, err := s.outlineRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkPlacement(node.WorkID, userID, req.ParentID, node.Kind); err != nil {
+		return nil, err
+	}
+	if err := s.outlineRepo.Move(node, req.ParentID, req.Position); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// CreateDraft 为章或场景创建关联的正文文档；已有关联文档时直接返回该文档
+func (s *OutlineService) CreateDraft(id, userID string) (*models.WorkDocument, error) {
+	node, err := s.outlineRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if node.Kind == models.OutlineKindPart {
+		return nil, fmt.Errorf("%w: only chapters and scenes can have drafts", ErrInvalidOutlineNode)
+	}
+	if node.DocumentID != "" {
+		if doc, err := s.workDocumentRepo.GetByIDAndUserID(node.DocumentID, userID); err == nil {
+			return doc, nil
+		}
+	}
+
+	doc := &models.WorkDocument{
+		ID:     utils.GenerateID(),
+		WorkID: node.WorkID,
+		UserID: userID,
+		Title:  node.Title,
+	}
+	if err := s.workDocumentRepo.Create(doc); err != nil {
+		return nil, err
+	}
+
+	node.DocumentID = doc.ID
+	if node.Status == models.OutlineStatusPlanned {
+		node.Status = models.OutlineStatusDrafting
+	}
+	if err := s.outlineRepo.UpdateByIDAndUserID(node); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// checkPlacement 验证父节点属于同一创作，并且允许放置该类型的节点
+func (s *OutlineService) checkPlacement(workID, userID, parentID, kind string) error {
+	parentKind := ""
+	if parentID != "" {
+		parent, err := s.outlineRepo.GetByIDAndUserID(parentID, userID)
+		if err != nil {
+			return err
+		}
+		if parent.WorkID != workID {
+			return fmt.Errorf("%w: parent belongs to another work", ErrInvalidOutlineNode)
+		}
+		parentKind = parent.Kind
+	}
+
+	for _, allowed := range allowedChildren[parentKind] {
+		if kind == allowed {
+			return nil
+		}
+	}
+	if parentKind == "" {
+		return fmt.Errorf("%w: %q cannot be placed at the top level", ErrInvalidOutlineNode, kind)
+	}
+	return fmt.Errorf("%w: %q cannot be placed under a %s", ErrInvalidOutlineNode, kind, parentKind)
+}
+
+// applyFields 校验并写入节点的内容字段
+func (s *OutlineService) applyFields(node *models.OutlineNode, req *models.OutlineNodeRequest) error {
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		return fmt.Errorf("%w: title is required", ErrInvalidOutlineNode)
+	}
+	if utf8.RuneCountInString(title) > maxTitleRunes {
+		return fmt.Errorf("%w: title exceeds %d characters", ErrInvalidOutlineNode, maxTitleRunes)
+	}
+	if req.TargetLength < 0 {
+		return fmt.Errorf("%w: target_length must not be negative", ErrInvalidOutlineNode)
+	}
+
+	status := req.Status
+	switch status {
+	case "":
+		status = models.OutlineStatusPlanned
+	case models.OutlineStatusPlanned, models.OutlineStatusDrafting, models.OutlineStatusDone:
+	default:
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidOutlineNode, status)
+	}
+
+	if req.DocumentID != "" {
+		if node.Kind == models.OutlineKindPart {
+			return fmt.Errorf("%w: only chapters and scenes can have drafts", ErrInvalidOutlineNode)
+		}
+		doc, err := s.workDocumentRepo.GetByIDAndUserID(req.DocumentID, node.UserID)
+		if err != nil {
+			return err
+		}
+		if doc.WorkID != node.WorkID {
+			return fmt.Errorf("%w: document belongs to another work", ErrInvalidOutlineNode)
+		}
+	}
+
+	node.Title = title
+	node.Synopsis = req.Synopsis
+	node.Status = status
+	node.TargetLength = req.TargetLength
+	node.DocumentID = req.DocumentID
+	return nil
+}
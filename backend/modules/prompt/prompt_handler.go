@@ -0,0 +1,97 @@
+package prompt
+
+import (
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/repository"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PromptHandler 提示词模板处理器
+type PromptHandler struct {
+	service *PromptService
+}
+
+// NewPromptHandler 创建提示词模板处理器
+func NewPromptHandler(service *PromptService) *PromptHandler {
+	return &PromptHandler{
+		service: service,
+	}
+}
+
+// GetPromptList 获取所有模板及当前生效的版本（可选work_id查询参数）
+func (h *PromptHandler) GetPromptList(c *gin.Context) {
+	response, err := h.service.ListTemplates(auth.CurrentUserID(c), c.Query("work_id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetPromptVersions 获取用户级或创作级（work_id查询参数）模板的所有版本
+func (h *PromptHandler) GetPromptVersions(c *gin.Context) {
+	response, err := h.service.GetVersions(auth.CurrentUserID(c), c.Query("work_id"), c.Param("name"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// SavePrompt 保存模板的新版本
+func (h *PromptHandler) SavePrompt(c *gin.Context) {
+	var req models.PromptTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	saved, err := h.service.SaveTemplate(auth.CurrentUserID(c), req.WorkID, c.Param("name"), req.Content)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, saved)
+}
+
+// RestorePromptVersion 把旧版本恢复为最新版本
+func (h *PromptHandler) RestorePromptVersion(c *gin.Context) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+
+	saved, err := h.service.RestoreVersion(auth.CurrentUserID(c), c.Query("work_id"), c.Param("name"), version)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, saved)
+}
+
+// DeletePrompt 删除自定义模板，恢复使用上一级模板
+func (h *PromptHandler) DeletePrompt(c *gin.Context) {
+	if err := h.service.DeleteTemplate(auth.CurrentUserID(c), c.Query("work_id"), c.Param("name")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Prompt template deleted successfully"})
+}
+
+// writeError 把服务错误转换为HTTP响应
+func writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrUnknownTemplate), errors.Is(err, ErrInvalidTemplate):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case repository.IsNotFound(err):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Prompt template or work not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
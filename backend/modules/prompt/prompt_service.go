@@ -0,0 +1,367 @@
+package prompt
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"grandma/backend/models"
+	"grandma/backend/repository"
+	"grandma/backend/utils"
+	"log"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+// 内置模板名称
+const (
+	TemplateWorkSystem  = "work_system"  // 灵感模式的系统提示
+	TemplateWorkContext = "work_context" // 灵感模式的RAG背景信息
+	TemplateChatContext = "chat_context" // 普通模式的RAG背景信息
+	TemplateTitle       = "title"        // 对话标题生成
+	TemplateStyle       = "style"        // 文风档案编译成的系统提示
+	TemplateTransform   = "transform"    // 对正文选中片段的AI编辑
+	TemplateContinue    = "continue"     // 正文续写和补写
+	TemplateTimeline    = "timeline"     // 灵感模式中当前章节之前的时间线事件
+)
+
+// 模板来源
+const (
+	SourceDefault = "default"
+	SourceUser    = "user"
+	SourceWork    = "work"
+)
+
+// maxTemplateRunes 单个模板的最大字符数
+const maxTemplateRunes = 20000
+
+var (
+	// ErrUnknownTemplate 模板名称不存在
+	ErrUnknownTemplate = errors.New("unknown_template")
+	// ErrInvalidTemplate 模板无法解析或无法用示例数据渲染
+	ErrInvalidTemplate = errors.New("invalid_template")
+)
+
+//go:embed defaults/*.tmpl
+var defaultFS embed.FS
+
+// WorkSystemData work_system模板的数据
+type WorkSystemData struct {
+	WorkTitle string // 创作标题
+}
+
+// WorkContextData work_context模板的数据：按内容分类的检索片段
+type WorkContextData struct {
+	Characters       []string // 人物设定
+	World            []string // 世界观设定
+	Plot             []string // 已有情节
+	UserRequirements []string // 用户要求与设定
+	Other            []string // 其他相关信息
+}
+
+// ContextChunk 检索到的片段
+type ContextChunk struct {
+	Role    string // 片段来源的角色：user 或 assistant
+	Content string
+}
+
+// ChatContextData chat_context模板的数据
+type ChatContextData struct {
+	Chunks []ContextChunk
+}
+
+// TitleData title模板的数据
+type TitleData struct {
+	Inputs string // 用户输入，多条以换行分隔
+}
+
+// StyleData style模板的数据
+type StyleData struct {
+	Name           string   // 档案名称
+	Voice          string   // 叙述声音和语气
+	Tense          string   // 时态
+	PointOfView    string   // 叙事视角
+	BannedWords    []string // 禁用词
+	ReadingLevel   string   // 目标阅读难度
+	SamplePassages []string // 示例段落
+}
+
+// TransformData transform模板的数据
+type TransformData struct {
+	Instruction string // 修改要求
+	Before      string // 选中片段之前的内容
+	Selection   string // 选中的片段
+	After       string // 选中片段之后的内容
+}
+
+// ContinueData continue模板的数据，After为空时表示从前文结尾续写
+type ContinueData struct {
+	Instruction  string // 写作要求
+	Before       string // 写入位置之前的内容
+	After        string // 写入位置之后的内容
+	TargetLength int    // 目标字数
+}
+
+// TimelineItem 时间线事件，Date为空表示没有具体日期
+type TimelineItem struct {
+	Date         string
+	Title        string
+	Description  string
+	Participants []string
+	Location     string
+}
+
+// TimelineData timeline模板的数据
+type TimelineData struct {
+	Chapter string         // 当前章节标题
+	Events  []TimelineItem // 按故事时间排序的事件
+}
+
+// definition 内置模板的说明和用于校验的示例数据
+type definition struct {
+	Name        string
+	Description string
+	Sample      interface{}
+}
+
+// definitions 所有内置模板，顺序即列表顺序
+var definitions = []definition{
+	{TemplateWorkSystem, "灵感模式的系统提示，可用字段：.WorkTitle", WorkSystemData{WorkTitle: "示例创作"}},
+	{TemplateWorkContext, "灵感模式的RAG背景信息，可用字段：.Characters、.World、.Plot、.UserRequirements、.Other（均为字符串列表）", WorkContextData{
+		Characters: []string{"人物"}, World: []string{"世界观"}, Plot: []string{"情节"}, UserRequirements: []string{"要求"}, Other: []string{"其他"},
+	}},
+	{TemplateChatContext, "普通模式的RAG背景信息，可用字段：.Chunks（每项包含.Role和.Content）", ChatContextData{
+		Chunks: []ContextChunk{{Role: "user", Content: "用户输入"}, {Role: "assistant", Content: "回答"}},
+	}},
+	{TemplateTitle, "对话标题生成，可用字段：.Inputs", TitleData{Inputs: "示例输入"}},
+	{TemplateStyle, "文风档案，可用字段：.Name、.Voice、.Tense、.PointOfView、.BannedWords、.ReadingLevel、.SamplePassages", StyleData{
+		Name: "示例文风", Voice: "冷静克制", Tense: "过去时", PointOfView: "第三人称", BannedWords: []string{"突然"}, ReadingLevel: "成人", SamplePassages: []string{"示例段落"},
+	}},
+	{TemplateTransform, "对正文选中片段的AI编辑，可用字段：.Instruction、.Before、.Selection、.After", TransformData{
+		Instruction: "改写这段文字", Before: "前文", Selection: "选中的片段", After: "后文",
+	}},
+	{TemplateContinue, "正文续写和补写，可用字段：.Instruction、.Before、.After（为空表示从结尾续写）、.TargetLength", ContinueData{
+		Instruction: "写一场追逐", Before: "前文", After: "后文", TargetLength: 500,
+	}},
+	{TemplateTimeline, "灵感模式中当前章节之前的时间线事件，可用字段：.Chapter、.Events（每项包含.Date、.Title、.Description、.Participants、.Location）", TimelineData{
+		Chapter: "第一章", Events: []TimelineItem{{Date: "1年1月1日", Title: "事件", Description: "说明", Participants: []string{"人物"}, Location: "地点"}},
+	}},
+}
+
+// funcs 模板中可用的函数
+var funcs = template.FuncMap{
+	"inc":  func(i int) int { return i + 1 },
+	"join": strings.Join,
+}
+
+// PromptService 提示词模板服务：按 创作模板 → 用户模板 → 内置默认模板 的顺序确定生效的模板
+type PromptService struct {
+	promptRepo *repository.PromptTemplateRepository
+	workRepo   *repository.WorkRepository
+	defaults   map[string]*template.Template
+	sources    map[string]string
+}
+
+// NewPromptService 创建提示词模板服务，promptRepo为nil时只使用内置默认模板
+func NewPromptService(promptRepo *repository.PromptTemplateRepository, workRepo *repository.WorkRepository) *PromptService {
+	s := &PromptService{
+		promptRepo: promptRepo,
+		workRepo:   workRepo,
+		defaults:   map[string]*template.Template{},
+		sources:    map[string]string{},
+	}
+	for _, def := range definitions {
+		data, err := defaultFS.ReadFile("defaults/" + def.Name + ".tmpl")
+		if err != nil {
+			panic(fmt.Sprintf("missing default prompt template %s: %v", def.Name, err))
+		}
+		s.sources[def.Name] = string(data)
+		s.defaults[def.Name] = template.Must(parse(def.Name, string(data)))
+	}
+	return s
+}
+
+// Render 渲染生效的模板；自定义模板渲染失败时记录日志并使用内置默认模板
+func (s *PromptService) Render(userID, workID, name string, data interface{}) (string, error) {
+	if _, ok := s.defaults[name]; !ok {
+		return "", ErrUnknownTemplate
+	}
+
+	if override := s.override(userID, workID, name); override != nil {
+		tmpl, err := parse(name, override.Content)
+		if err == nil {
+			var out string
+			if out, err = execute(tmpl, data); err == nil {
+				return out, nil
+			}
+		}
+		log.Printf("Prompt template %s (version %d) failed for user %s, using default: %v", name, override.Version, userID, err)
+	}
+	return execute(s.defaults[name], data)
+}
+
+// override 查找生效的自定义模板，创作模板优先于用户模板
+func (s *PromptService) override(userID, workID, name string) *models.PromptTemplate {
+	if s.promptRepo == nil || userID == "" {
+		return nil
+	}
+	if workID != "" {
+		if override, err := s.promptRepo.GetLatest(userID, workID, name); err == nil {
+			return override
+		}
+	}
+	if override, err := s.promptRepo.GetLatest(userID, "", name); err == nil {
+		return override
+	}
+	return nil
+}
+
+// ListTemplates 列出所有模板及其对该用户（和创作）生效的版本
+func (s *PromptService) ListTemplates(userID, workID string) (*models.PromptTemplateListResponse, error) {
+	if err := s.checkWork(userID, workID); err != nil {
+		return nil, err
+	}
+
+	templates := make([]models.PromptTemplateInfo, 0, len(definitions))
+	for _, def := range definitions {
+		info := models.PromptTemplateInfo{
+			Name:        def.Name,
+			Description: def.Description,
+			Source:      SourceDefault,
+			Content:     s.sources[def.Name],
+			Default:     s.sources[def.Name],
+		}
+		if override := s.override(userID, workID, def.Name); override != nil {
+			info.Source = SourceUser
+			if override.WorkID != "" {
+				info.Source = SourceWork
+			}
+			info.Version = override.Version
+			info.Content = override.Content
+		}
+		templates = append(templates, info)
+	}
+	return &models.PromptTemplateListResponse{
+		Templates: templates,
+		Total:     len(templates),
+	}, nil
+}
+
+// GetVersions 获取用户级（workID为空）或创作级模板的所有版本
+func (s *PromptService) GetVersions(userID, workID, name string) (*models.PromptTemplateVersionsResponse, error) {
+	if _, ok := s.defaults[name]; !ok {
+		return nil, ErrUnknownTemplate
+	}
+	if err := s.checkWork(userID, workID); err != nil {
+		return nil, err
+	}
+
+	versions, err := s.promptRepo.GetVersions(userID, workID, name)
+	if err != nil {
+		return nil, err
+	}
+	return &models.PromptTemplateVersionsResponse{
+		Versions: versions,
+		Total:    len(versions),
+	}, nil
+}
+
+// SaveTemplate 校验模板并保存为新版本
+func (s *PromptService) SaveTemplate(userID, workID, name, content string) (*models.PromptTemplate, error) {
+	if err := s.Validate(name, content); err != nil {
+		return nil, err
+	}
+	if err := s.checkWork(userID, workID); err != nil {
+		return nil, err
+	}
+
+	saved := &models.PromptTemplate{
+		ID:      utils.GeneratePromptTemplateID(),
+		UserID:  userID,
+		WorkID:  workID,
+		Name:    name,
+		Content: content,
+	}
+	if err := s.promptRepo.CreateVersion(saved); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// RestoreVersion 把旧版本的内容保存为新版本
+func (s *PromptService) RestoreVersion(userID, workID, name string, version int) (*models.PromptTemplate, error) {
+	if _, ok := s.defaults[name]; !ok {
+		return nil, ErrUnknownTemplate
+	}
+	if err := s.checkWork(userID, workID); err != nil {
+		return nil, err
+	}
+
+	old, err := s.promptRepo.GetVersion(userID, workID, name, version)
+	if err != nil {
+		return nil, err
+	}
+	return s.SaveTemplate(userID, workID, name, old.Content)
+}
+
+// DeleteTemplate 删除用户级或创作级模板的所有版本，恢复使用上一级模板
+func (s *PromptService) DeleteTemplate(userID, workID, name string) error {
+	if _, ok := s.defaults[name]; !ok {
+		return ErrUnknownTemplate
+	}
+	if err := s.checkWork(userID, workID); err != nil {
+		return err
+	}
+	return s.promptRepo.DeleteByNameAndUserID(userID, workID, name)
+}
+
+// Validate 检查模板能否解析，并能用示例数据渲染
+func (s *PromptService) Validate(name, content string) error {
+	var sample interface{}
+	for _, def := range definitions {
+		if def.Name == name {
+			sample = def.Sample
+		}
+	}
+	if sample == nil {
+		return ErrUnknownTemplate
+	}
+	if strings.TrimSpace(content) == "" {
+		return fmt.Errorf("%w: template is empty", ErrInvalidTemplate)
+	}
+	if utf8.RuneCountInString(content) > maxTemplateRunes {
+		return fmt.Errorf("%w: template exceeds %d characters", ErrInvalidTemplate, maxTemplateRunes)
+	}
+
+	tmpl, err := parse(name, content)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	if _, err := execute(tmpl, sample); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	return nil
+}
+
+// checkWork 验证创作属于该用户
+func (s *PromptService) checkWork(userID, workID string) error {
+	if workID == "" {
+		return nil
+	}
+	_, err := s.workRepo.GetByIDAndUserID(workID, userID)
+	return err
+}
+
+// parse 解析模板
+func parse(name, content string) (*template.Template, error) {
+	return template.New(name).Funcs(funcs).Option("missingkey=error").Parse(content)
+}
+
+// execute 渲染模板并去掉首尾空白
+func execute(tmpl *template.Template, data interface{}) (string, error) {
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
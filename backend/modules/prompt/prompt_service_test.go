@@ -0,0 +1,63 @@
+package prompt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDefaultTemplatesRender(t *testing.T) {
+	s := NewPromptService(nil, nil)
+
+	out, err := s.Render("", "", TemplateWorkContext, WorkContextData{
+		Characters: []string{"林舟，渔村少年"},
+		Other:      []string{"海边有座灯塔", "潮水每晚上涨"},
+	})
+	if err != nil {
+		t.Fatalf("render work_context: %v", err)
+	}
+	want := "以下是检索到的相关背景信息，请仔细参考并在创作中体现：\n\n" +
+		"### 人物设定\n**人物信息 1：**\n林舟，渔村少年\n\n" +
+		"### 其他相关信息\n**相关信息 1：**\n海边有座灯塔\n\n**相关信息 2：**\n潮水每晚上涨\n\n" +
+		"## 创作要求\n"
+	if !strings.Contains(out, want) || strings.Contains(out, "### 世界观设定") {
+		t.Errorf("work_context:\n%s", out)
+	}
+	if !strings.HasSuffix(out, "请基于以上背景信息，创作符合要求的新内容。") {
+		t.Errorf("work_context suffix:\n%s", out)
+	}
+
+	out, err = s.Render("", "", TemplateChatContext, ChatContextData{Chunks: []ContextChunk{
+		{Role: "user", Content: "我想写科幻"},
+		{Role: "assistant", Content: "可以从飞船写起"},
+	}})
+	if err != nil || out != "以下是相关的历史背景信息，供参考：\n\n[用户之前提到 1]\n我想写科幻\n\n[背景信息 2]\n可以从飞船写起" {
+		t.Errorf("chat_context: %q, %v", out, err)
+	}
+
+	if _, err := s.Render("", "", "missing", nil); !errors.Is(err, ErrUnknownTemplate) {
+		t.Errorf("unknown template: %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := NewPromptService(nil, nil)
+	cases := []struct {
+		name    string
+		content string
+		err     error
+	}{
+		{TemplateTitle, "为以下内容起标题：{{.Inputs}}", nil},
+		{TemplateWorkSystem, "你在创作《{{.WorkTitle}}》", nil},
+		{TemplateWorkSystem, "{{.WorkTitle", ErrInvalidTemplate},
+		{TemplateWorkSystem, "{{.Missing}}", ErrInvalidTemplate},
+		{TemplateWorkSystem, "   ", ErrInvalidTemplate},
+		{TemplateWorkSystem, strings.Repeat("字", maxTemplateRunes+1), ErrInvalidTemplate},
+		{"missing", "内容", ErrUnknownTemplate},
+	}
+	for _, tc := range cases {
+		if err := s.Validate(tc.name, tc.content); !errors.Is(err, tc.err) || (tc.err == nil && err != nil) {
+			t.Errorf("%s %.20q: got %v, want %v", tc.name, tc.content, err, tc.err)
+		}
+	}
+}
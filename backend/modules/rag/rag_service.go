@@ -3,14 +3,20 @@ package rag
 import (
 	"fmt"
 	"grandma/backend/models"
+	"grandma/backend/modules/prompt"
+	"grandma/backend/modules/usage"
 	"grandma/backend/repository"
 	"grandma/backend/services"
 	"grandma/backend/utils"
 	"log"
 	"strings"
+	"sync"
 	"time"
 )
 
+// RoleManuscript 正文文档chunk的角色，与对话消息的user、assistant区分
+const RoleManuscript = "manuscript"
+
 // RAGService RAG服务
 type RAGService struct {
 	embeddingService *services.EmbeddingService
@@ -19,7 +25,11 @@ type RAGService struct {
 	vectorChunkRepo  *repository.VectorChunkRepository
 	documentRepo     *repository.DocumentRepository
 	workDocumentRepo *repository.WorkDocumentRepository
+	usageService     *usage.UsageService
+	promptService    *prompt.PromptService
 	enabled          bool
+
+	indexLocks sync.Map // 文档ID -> *sync.Mutex，同一文档的索引依次执行，避免新旧chunks交错
 }
 
 // RAGConfig RAG配置
@@ -29,6 +39,8 @@ type RAGConfig struct {
 	VectorChunkRepo  *repository.VectorChunkRepository
 	DocumentRepo     *repository.DocumentRepository
 	WorkDocumentRepo *repository.WorkDocumentRepository
+	UsageService     *usage.UsageService   // 记录向量化用量（可选）
+	PromptService    *prompt.PromptService // 渲染背景信息的提示词模板（可选，默认使用内置模板）
 }
 
 // NewRAGService 创建RAG服务
@@ -37,6 +49,11 @@ func NewRAGService(config *RAGConfig) *RAGService {
 		return &RAGService{enabled: false}
 	}
 
+	promptService := config.PromptService
+	if promptService == nil {
+		promptService = prompt.NewPromptService(nil, nil)
+	}
+
 	return &RAGService{
 		embeddingService: config.EmbeddingService,
 		chunkingService:  services.NewChunkingService(),
@@ -44,6 +61,8 @@ func NewRAGService(config *RAGConfig) *RAGService {
 		vectorChunkRepo:  config.VectorChunkRepo,
 		documentRepo:     config.DocumentRepo,
 		workDocumentRepo: config.WorkDocumentRepo,
+		usageService:     config.UsageService,
+		promptService:    promptService,
 		enabled:          true,
 	}
 }
@@ -64,6 +83,31 @@ func (r *RAGService) IndexDocument(documentID, userID, conversationID, workID, c
 	return nil
 }
 
+// IndexWorkDocument 索引创作的正文文档（异步处理），索引时读取文档的最新内容
+func (r *RAGService) IndexWorkDocument(documentID, userID string) {
+	if !r.enabled {
+		return
+	}
+
+	go func() {
+		lock, _ := r.indexLocks.LoadOrStore(documentID, &sync.Mutex{})
+		lock.(*sync.Mutex).Lock()
+		defer lock.(*sync.Mutex).Unlock()
+
+		doc, err := r.workDocumentRepo.GetByIDAndUserID(documentID, userID)
+		if err != nil {
+			// 文档已被删除时其chunks随文档一起删除
+			if !repository.IsNotFound(err) {
+				log.Printf("Failed to load work document %s for indexing: %v", documentID, err)
+			}
+			return
+		}
+		if err := r.indexDocumentSync(doc.ID, doc.UserID, "", doc.WorkID, doc.Content, RoleManuscript); err != nil {
+			log.Printf("Failed to index work document %s: %v", documentID, err)
+		}
+	}()
+}
+
 // indexDocumentSync 同步索引文档
 func (r *RAGService) indexDocumentSync(documentID, userID, conversationID, workID, content, role string) error {
 	// 删除旧的chunks
@@ -88,10 +132,11 @@ func (r *RAGService) indexDocumentSync(documentID, userID, conversationID, workI
 		chunkTexts[i] = chunk.Content
 	}
 
-	embeddings, err := r.embeddingService.GetEmbeddings(chunkTexts)
+	embeddings, embeddingUsage, err := r.embeddingService.GetEmbeddingsWithUsage(chunkTexts)
 	if err != nil {
 		return fmt.Errorf("failed to get embeddings: %w", err)
 	}
+	r.recordEmbeddingUsage(userID, embeddingUsage, strings.Join(chunkTexts, ""))
 
 	// 创建vector chunks
 	for i, chunk := range chunks {
@@ -144,22 +189,78 @@ func (r *RAGService) RetrieveRelevantChunks(query string, userID, conversationID
 		topK = 5
 	}
 
-	// 获取查询的embedding
-	queryEmbedding, err := r.embeddingService.GetEmbedding(query)
+	// 获取所有相关的chunks（排除当前对话/创作）
+	allChunks, err := r.vectorChunkRepo.GetAllWithEmbeddings(userID, conversationID, workID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get query embedding: %w", err)
+		return nil, fmt.Errorf("failed to get chunks: %w", err)
 	}
 
-	// 获取所有相关的chunks（排除当前对话/创作）
-	allChunks, err := r.vectorChunkRepo.GetAllWithEmbeddings(userID, conversationID, workID)
+	return r.rankChunks(query, userID, allChunks, topK)
+}
+
+// SearchWork 只在指定创作的内容中检索相关chunks，供助手的search_work工具使用
+func (r *RAGService) SearchWork(query, userID, workID string, topK int) ([]models.VectorChunk, error) {
+	if !r.enabled {
+		return nil, nil
+	}
+
+	if topK <= 0 {
+		topK = 5
+	}
+
+	chunks, err := r.vectorChunkRepo.GetByWorkIDWithEmbeddings(userID, workID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get chunks: %w", err)
 	}
 
+	return r.rankChunks(query, userID, chunks, topK)
+}
+
+// Embed 计算一组文本的向量嵌入并记录用量，RAG未启用时返回nil
+func (r *RAGService) Embed(userID string, texts []string) ([][]float32, error) {
+	if !r.enabled || len(texts) == 0 {
+		return nil, nil
+	}
+	embeddings, embeddingUsage, err := r.embeddingService.GetEmbeddingsWithUsage(texts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get embeddings: %w", err)
+	}
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("failed to get embeddings: expected %d, got %d", len(texts), len(embeddings))
+	}
+	r.recordEmbeddingUsage(userID, embeddingUsage, strings.Join(texts, "\n"))
+	return embeddings, nil
+}
+
+// Similarity 计算两个向量的余弦相似度，长度不一致时返回0
+func (r *RAGService) Similarity(a, b []float32) float32 {
+	if r.vectorStore == nil {
+		return 0
+	}
+	similarity, err := r.vectorStore.CosineSimilarity(a, b)
+	if err != nil {
+		return 0
+	}
+	return similarity
+}
+
+// rankChunks 按与查询的相似度（含时间衰减）选出最相关的chunks
+func (r *RAGService) rankChunks(query, userID string, allChunks []models.VectorChunk, topK int) ([]models.VectorChunk, error) {
 	if len(allChunks) == 0 {
 		return nil, nil
 	}
 
+	// 获取查询的embedding
+	queryEmbeddings, embeddingUsage, err := r.embeddingService.GetEmbeddingsWithUsage([]string{query})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get query embedding: %w", err)
+	}
+	if len(queryEmbeddings) == 0 {
+		return nil, fmt.Errorf("failed to get query embedding: no embedding returned")
+	}
+	queryEmbedding := queryEmbeddings[0]
+	r.recordEmbeddingUsage(userID, embeddingUsage, query)
+
 	// 提取所有embeddings
 	embeddings := make([][]float32, 0, len(allChunks))
 	validChunks := make([]models.VectorChunk, 0, len(allChunks))
@@ -204,6 +305,16 @@ func (r *RAGService) RetrieveRelevantChunks(query string, userID, conversationID
 	return results, nil
 }
 
+// recordEmbeddingUsage 记录向量化用量
+func (r *RAGService) recordEmbeddingUsage(userID string, embeddingUsage services.Usage, input string) {
+	if r.usageService == nil {
+		return
+	}
+	if err := r.usageService.Record(userID, usage.FeatureEmbedding, embeddingUsage, input, ""); err != nil {
+		log.Printf("Failed to record embedding usage for user %s: %v", userID, err)
+	}
+}
+
 // calculateTimeDecay 计算时间衰减因子
 // 较新的内容权重更高，但相似度仍然是主要因素
 func (r *RAGService) calculateTimeDecay(createdAt time.Time, similarity float32) float32 {
@@ -239,64 +350,37 @@ func (r *RAGService) BuildRAGContext(userMessage string, userID, conversationID,
 		return nil, nil
 	}
 
-	// 构建上下文消息
-	contextMessages := make([]models.Message, 0, len(chunks)+1)
-
 	// 判断是否为灵感模式（长篇故事写作模式）
 	isInspirationMode := workID != ""
 
+	var contextText string
 	if isInspirationMode {
 		// 灵感模式：针对长篇故事写作的优化prompt
-		contextText := r.buildInspirationModeContext(chunks)
-		contextMessages = append(contextMessages, models.Message{
-			Role:    "system",
-			Content: contextText,
-		})
+		contextText, err = r.promptService.Render(userID, workID, prompt.TemplateWorkContext, buildInspirationModeContext(chunks))
 	} else {
 		// 普通对话模式：使用简单的背景信息提示
-		contextText := "以下是相关的历史背景信息，供参考：\n\n"
-		for i, chunk := range chunks {
+		data := prompt.ChatContextData{Chunks: make([]prompt.ContextChunk, 0, len(chunks))}
+		for _, chunk := range chunks {
 			metadata, _ := chunk.GetMetadataMap()
 			role, _ := metadata["role"].(string)
-
-			if role == "assistant" {
-				contextText += fmt.Sprintf("[背景信息 %d]\n%s\n\n", i+1, chunk.Content)
-			} else if role == "user" {
-				contextText += fmt.Sprintf("[用户之前提到 %d]\n%s\n\n", i+1, chunk.Content)
-			} else {
-				contextText += fmt.Sprintf("[相关信息 %d]\n%s\n\n", i+1, chunk.Content)
-			}
+			data.Chunks = append(data.Chunks, prompt.ContextChunk{Role: role, Content: chunk.Content})
 		}
-		contextMessages = append(contextMessages, models.Message{
-			Role:    "system",
-			Content: contextText,
-		})
+		contextText, err = r.promptService.Render(userID, "", prompt.TemplateChatContext, data)
+	}
+	if err != nil {
+		return nil, err
 	}
 
+	contextMessages := []models.Message{{
+		Role:    "system",
+		Content: contextText,
+	}}
 	return contextMessages, nil
 }
 
-// buildInspirationModeContext 构建灵感模式（长篇故事写作）的上下文
-func (r *RAGService) buildInspirationModeContext(chunks []models.VectorChunk) string {
-	var sb strings.Builder
-
-	// 系统提示：强调长篇故事写作的要求
-	sb.WriteString("你是一位专业的长篇故事创作助手。在创作时，请严格遵循以下要求：\n\n")
-	sb.WriteString("## 核心创作原则\n")
-	sb.WriteString("1. **保持一致性**：人物性格、外貌、行为方式必须与已有设定保持一致\n")
-	sb.WriteString("2. **世界观统一**：遵循已建立的世界观、规则和设定，不要出现矛盾\n")
-	sb.WriteString("3. **情节连贯**：新内容要与已有情节自然衔接，注意伏笔和线索的呼应\n")
-	sb.WriteString("4. **风格统一**：保持整体文风和叙事风格的一致性\n\n")
-
-	sb.WriteString("## 重要背景信息\n")
-	sb.WriteString("以下是检索到的相关背景信息，请仔细参考并在创作中体现：\n\n")
-
-	// 分类组织chunks：按角色和内容类型
-	characterInfo := make([]models.VectorChunk, 0)
-	worldInfo := make([]models.VectorChunk, 0)
-	plotInfo := make([]models.VectorChunk, 0)
-	userRequirements := make([]models.VectorChunk, 0)
-	otherInfo := make([]models.VectorChunk, 0)
+// buildInspirationModeContext 把检索到的chunks按角色和内容类型分类，作为灵感模式背景信息模板的数据
+func buildInspirationModeContext(chunks []models.VectorChunk) prompt.WorkContextData {
+	var data prompt.WorkContextData
 
 	for _, chunk := range chunks {
 		metadata, _ := chunk.GetMetadataMap()
@@ -309,79 +393,32 @@ func (r *RAGService) buildInspirationModeContext(chunks []models.VectorChunk) st
 			strings.Contains(contentLower, "性格") || strings.Contains(contentLower, "外貌") ||
 			strings.Contains(contentLower, "名字") {
 			if role == "assistant" {
-				characterInfo = append(characterInfo, chunk)
+				data.Characters = append(data.Characters, content)
 			} else {
-				userRequirements = append(userRequirements, chunk)
+				data.UserRequirements = append(data.UserRequirements, content)
 			}
 		} else if strings.Contains(contentLower, "世界") || strings.Contains(contentLower, "设定") ||
 			strings.Contains(contentLower, "规则") || strings.Contains(contentLower, "背景") {
 			if role == "assistant" {
-				worldInfo = append(worldInfo, chunk)
+				data.World = append(data.World, content)
 			} else {
-				userRequirements = append(userRequirements, chunk)
+				data.UserRequirements = append(data.UserRequirements, content)
 			}
 		} else if strings.Contains(contentLower, "情节") || strings.Contains(contentLower, "故事") ||
 			strings.Contains(contentLower, "发生") || strings.Contains(contentLower, "事件") {
 			if role == "assistant" {
-				plotInfo = append(plotInfo, chunk)
+				data.Plot = append(data.Plot, content)
 			} else {
-				userRequirements = append(userRequirements, chunk)
+				data.UserRequirements = append(data.UserRequirements, content)
 			}
 		} else {
 			if role == "user" {
-				userRequirements = append(userRequirements, chunk)
+				data.UserRequirements = append(data.UserRequirements, content)
 			} else {
-				otherInfo = append(otherInfo, chunk)
+				data.Other = append(data.Other, content)
 			}
 		}
 	}
 
-	// 按优先级组织信息
-	if len(characterInfo) > 0 {
-		sb.WriteString("### 人物设定\n")
-		for i, chunk := range characterInfo {
-			sb.WriteString(fmt.Sprintf("**人物信息 %d：**\n%s\n\n", i+1, chunk.Content))
-		}
-	}
-
-	if len(worldInfo) > 0 {
-		sb.WriteString("### 世界观设定\n")
-		for i, chunk := range worldInfo {
-			sb.WriteString(fmt.Sprintf("**世界观信息 %d：**\n%s\n\n", i+1, chunk.Content))
-		}
-	}
-
-	if len(plotInfo) > 0 {
-		sb.WriteString("### 已有情节\n")
-		for i, chunk := range plotInfo {
-			sb.WriteString(fmt.Sprintf("**情节摘要 %d：**\n%s\n\n", i+1, chunk.Content))
-		}
-	}
-
-	if len(userRequirements) > 0 {
-		sb.WriteString("### 用户要求与设定\n")
-		for i, chunk := range userRequirements {
-			sb.WriteString(fmt.Sprintf("**用户要求 %d：**\n%s\n\n", i+1, chunk.Content))
-		}
-	}
-
-	if len(otherInfo) > 0 {
-		sb.WriteString("### 其他相关信息\n")
-		for i, chunk := range otherInfo {
-			sb.WriteString(fmt.Sprintf("**相关信息 %d：**\n%s\n\n", i+1, chunk.Content))
-		}
-	}
-
-	sb.WriteString("## 创作要求\n")
-	sb.WriteString("在创作新内容时：\n")
-	sb.WriteString("- 必须严格遵循上述人物设定，不得改变已有角色的性格、外貌、能力等核心特征\n")
-	sb.WriteString("- 必须遵循已建立的世界观和规则，不得出现逻辑矛盾\n")
-	sb.WriteString("- 新情节必须与已有情节自然衔接，注意前后呼应\n")
-	sb.WriteString("- 如果用户要求与已有设定冲突，请优先遵循已有设定，并在创作中巧妙处理冲突\n")
-	sb.WriteString("- 保持文风一致，延续已有的叙事风格\n")
-	sb.WriteString("- 注意细节的连贯性，如时间线、地点、人物关系等\n\n")
-
-	sb.WriteString("请基于以上背景信息，创作符合要求的新内容。")
-
-	return sb.String()
+	return data
 }
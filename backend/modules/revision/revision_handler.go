@@ -0,0 +1,121 @@
+package revision
+
+import (
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/repository"
+	"grandma/backend/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RevisionHandler 修订历史处理器，同一组接口同时用于创作文档和故事
+type RevisionHandler struct {
+	service *RevisionService
+}
+
+// NewRevisionHandler 创建修订历史处理器
+func NewRevisionHandler(service *RevisionService) *RevisionHandler {
+	return &RevisionHandler{
+		service: service,
+	}
+}
+
+// ListRevisions 获取修订列表
+func (h *RevisionHandler) ListRevisions(targetType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response, err := h.service.ListRevisions(auth.CurrentUserID(c), targetType, c.Param("id"))
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// GetRevision 获取指定修订的内容
+func (h *RevisionHandler) GetRevision(targetType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		number, err := strconv.Atoi(c.Param("number"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision number"})
+			return
+		}
+
+		response, err := h.service.GetRevision(auth.CurrentUserID(c), targetType, c.Param("id"), number)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// Diff 比较两个修订（from、to查询参数为修订号，mode为line或rune）
+func (h *RevisionHandler) Diff(targetType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, fromErr := strconv.Atoi(c.Query("from"))
+		to, toErr := strconv.Atoi(c.Query("to"))
+		if fromErr != nil || toErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be revision numbers"})
+			return
+		}
+
+		response, err := h.service.Diff(auth.CurrentUserID(c), targetType, c.Param("id"), from, to, c.Query("mode"))
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// Restore 恢复到指定修订
+func (h *RevisionHandler) Restore(targetType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		number, err := strconv.Atoi(c.Param("number"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision number"})
+			return
+		}
+
+		version, err := utils.ParseIfMatch(c.GetHeader("If-Match"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		response, err := h.service.Restore(auth.CurrentUserID(c), targetType, c.Param("id"), number, version)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		if response.DocumentVersion > 0 {
+			c.Header("ETag", utils.FormatETag(response.DocumentVersion))
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// writeError 把服务错误转换为HTTP响应
+func writeError(c *gin.Context, err error) {
+	var conflict *repository.WriteConflictError
+	var storyConflict *repository.StoryConflictError
+	switch {
+	case errors.As(err, &conflict):
+		c.Header("ETag", utils.FormatETag(conflict.Document.Version))
+		c.JSON(http.StatusConflict, models.WorkDocumentConflictResponse{Error: err.Error(), Document: *conflict.Document})
+	case errors.As(err, &storyConflict):
+		c.Header("ETag", utils.FormatETag(storyConflict.Story.Version))
+		c.JSON(http.StatusConflict, models.StoryConflictResponse{Error: err.Error(), Story: *storyConflict.Story})
+	case errors.Is(err, ErrInvalidDiffMode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case repository.IsNotFound(err):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document, story or revision not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
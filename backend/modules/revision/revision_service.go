@@ -0,0 +1,353 @@
+package revision
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/modules/rag"
+	"grandma/backend/repository"
+	"grandma/backend/services"
+	"grandma/backend/utils"
+	"io"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// snapshotInterval 每隔多少个修订保存一次完整快照，限制还原时需要应用的增量数量
+const snapshotInterval = 20
+
+var (
+	// ErrInvalidDiffMode 差异比较方式不是line或rune
+	ErrInvalidDiffMode = errors.New("invalid_diff_mode")
+	// errUnchanged 内容与最新修订相同，不需要保存
+	errUnchanged = errors.New("unchanged")
+)
+
+// Target 修订记录的对象
+type Target struct {
+	Type   string // models.RevisionTargetWorkDocument 或 models.RevisionTargetStory
+	ID     string
+	WorkID string // 仅创作文档
+}
+
+// RevisionService 修订历史服务：记录创作文档和故事的每次保存，支持查看、比较和恢复
+type RevisionService struct {
+	revisionRepo     *repository.RevisionRepository
+	workDocumentRepo *repository.WorkDocumentRepository
+	storyRepo        *repository.StoryRepository
+	ragSvc           *rag.RAGService
+}
+
+// NewRevisionService 创建修订历史服务
+func NewRevisionService(revisionRepo *repository.RevisionRepository, workDocumentRepo *repository.WorkDocumentRepository, storyRepo *repository.StoryRepository, ragSvc *rag.RAGService) *RevisionService {
+	return &RevisionService{
+		revisionRepo:     revisionRepo,
+		workDocumentRepo: workDocumentRepo,
+		storyRepo:        storyRepo,
+		ragSvc:           ragSvc,
+	}
+}
+
+// WithTx 返回在事务tx中读写修订的服务副本
+func (s *RevisionService) WithTx(tx *gorm.DB) *RevisionService {
+	return &RevisionService{
+		revisionRepo:     s.revisionRepo.WithTx(tx),
+		workDocumentRepo: s.workDocumentRepo.WithTx(tx),
+		storyRepo:        s.storyRepo.WithTx(tx),
+		ragSvc:           s.ragSvc,
+	}
+}
+
+// RecordChange 记录一次保存。对象还没有修订且修改前的内容不为空时（功能上线前创建的内容），先把修改前的内容记录为第一个修订
+// 内容与最新修订相同时不会重复记录，此时返回nil
+func (s *RevisionService) RecordChange(userID string, target Target, author, model, before, after string) (*models.Revision, error) {
+	if before != "" {
+		if _, err := s.revisionRepo.GetLatest(userID, target.Type, target.ID); repository.IsNotFound(err) {
+			if _, err := s.record(userID, target, models.RevisionAuthorUser, "", before); err != nil {
+				return nil, err
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return s.record(userID, target, author, model, after)
+}
+
+// EnsureBaseline 确保对象当前的内容（包括空内容）已经是最近的修订，返回该修订，用作写入前可以恢复的撤销点
+func (s *RevisionService) EnsureBaseline(userID string, target Target, content string) (*models.Revision, error) {
+	latest, err := s.revisionRepo.GetLatest(userID, target.Type, target.ID)
+	if err == nil && latest.ContentHash == utils.CalculateContentHash(content) {
+		return latest, nil
+	}
+	if err != nil && !repository.IsNotFound(err) {
+		return nil, err
+	}
+
+	revision, err := s.record(userID, target, models.RevisionAuthorUser, "", content)
+	if err != nil {
+		return nil, err
+	}
+	if revision == nil {
+		// 并发的保存已经记录了相同的内容
+		return s.revisionRepo.GetLatest(userID, target.Type, target.ID)
+	}
+	return revision, nil
+}
+
+// record 保存一个新修订：每snapshotInterval个修订保存一次完整快照，其余只保存与上一个修订的行级增量
+func (s *RevisionService) record(userID string, target Target, author, model, content string) (*models.Revision, error) {
+	revision := &models.Revision{
+		ID:          utils.GenerateRevisionID(),
+		UserID:      userID,
+		WorkID:      target.WorkID,
+		TargetType:  target.Type,
+		TargetID:    target.ID,
+		Author:      author,
+		Model:       model,
+		ContentHash: utils.CalculateContentHash(content),
+		Length:      utf8.RuneCountInString(content),
+	}
+
+	err := s.revisionRepo.CreateNext(revision, func(tx *repository.RevisionRepository, latest *models.Revision) error {
+		if latest != nil && latest.ContentHash == revision.ContentHash {
+			return errUnchanged
+		}
+
+		payload := []byte(content)
+		revision.Encoding = models.RevisionEncodingSnapshot
+		if latest != nil && (revision.Number-1)%snapshotInterval != 0 {
+			base, err := s.rebuild(tx, userID, target.Type, target.ID, latest.Number)
+			if err != nil {
+				return err
+			}
+			if payload, err = services.MakeDelta(base, content); err != nil {
+				return err
+			}
+			revision.Encoding = models.RevisionEncodingDelta
+		}
+
+		data, err := compress(payload)
+		if err != nil {
+			return err
+		}
+		revision.Data = data
+		return nil
+	})
+	if errors.Is(err, errUnchanged) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+// ListRevisions 获取对象的所有修订（最新的在前）
+func (s *RevisionService) ListRevisions(userID, targetType, targetID string) (*models.RevisionListResponse, error) {
+	if err := s.checkTarget(userID, targetType, targetID); err != nil {
+		return nil, err
+	}
+
+	revisions, err := s.revisionRepo.GetList(userID, targetType, targetID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.RevisionListResponse{
+		Revisions: revisions,
+		Total:     len(revisions),
+	}, nil
+}
+
+// GetRevision 获取指定修订及其完整内容
+func (s *RevisionService) GetRevision(userID, targetType, targetID string, number int) (*models.RevisionContentResponse, error) {
+	if err := s.checkTarget(userID, targetType, targetID); err != nil {
+		return nil, err
+	}
+
+	revision, err := s.revisionRepo.GetByNumber(userID, targetType, targetID, number)
+	if err != nil {
+		return nil, err
+	}
+	content, err := s.rebuild(s.revisionRepo, userID, targetType, targetID, number)
+	if err != nil {
+		return nil, err
+	}
+	return &models.RevisionContentResponse{Revision: *revision, Content: content}, nil
+}
+
+// Diff 比较两个修订，mode为line（默认）或rune
+func (s *RevisionService) Diff(userID, targetType, targetID string, from, to int, mode string) (*models.RevisionDiffResponse, error) {
+	if mode == "" {
+		mode = "line"
+	}
+	if mode != "line" && mode != "rune" {
+		return nil, ErrInvalidDiffMode
+	}
+
+	before, err := s.GetRevision(userID, targetType, targetID, from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := s.GetRevision(userID, targetType, targetID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []models.DiffOp
+	if mode == "rune" {
+		ops = services.DiffRunes(before.Content, after.Content)
+	} else {
+		ops = services.DiffLines(before.Content, after.Content)
+	}
+	inserted, deleted := services.CountDiff(ops, mode)
+	return &models.RevisionDiffResponse{
+		From:     from,
+		To:       to,
+		Mode:     mode,
+		Ops:      ops,
+		Inserted: inserted,
+		Deleted:  deleted,
+	}, nil
+}
+
+// Restore 把对象的内容恢复为指定修订，并记录为新的修订；version大于0时要求创作文档或故事为该版本
+func (s *RevisionService) Restore(userID, targetType, targetID string, number int, version int64) (*models.RevisionContentResponse, error) {
+	old, err := s.GetRevision(userID, targetType, targetID, number)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.RevisionContentResponse{Content: old.Content}
+	// 写入内容和记录修订在同一个事务中完成，修订失败时内容不会改变，并发的保存也不会交错
+	err = s.revisionRepo.Transaction(func(tx *gorm.DB) error {
+		txSvc := s.WithTx(tx)
+		target := Target{Type: targetType, ID: targetID}
+		var before string
+		switch targetType {
+		case models.RevisionTargetWorkDocument:
+			doc, err := txSvc.workDocumentRepo.GetByIDAndUserID(targetID, userID)
+			if err != nil {
+				return err
+			}
+			if err := repository.CheckVersion(doc, version); err != nil {
+				return err
+			}
+			before, target.WorkID = doc.Content, doc.WorkID
+			if err := txSvc.workDocumentRepo.UpdateContentByIDAndUserID(targetID, userID, old.Content, doc.Version); err != nil {
+				return err
+			}
+			if doc, err = txSvc.workDocumentRepo.GetByIDAndUserID(targetID, userID); err != nil {
+				return err
+			}
+			response.DocumentVersion = doc.Version
+		case models.RevisionTargetStory:
+			story, err := txSvc.storyRepo.GetByIDAndUserID(targetID, userID)
+			if err != nil {
+				return err
+			}
+			if err := repository.CheckStoryVersion(story, version); err != nil {
+				return err
+			}
+			before = story.Content
+			story.Content = old.Content
+			story.ContentHash = old.ContentHash
+			story.UpdatedAt = time.Now()
+			if err := txSvc.storyRepo.UpdateByIDAndUserID(story); err != nil {
+				return err
+			}
+			response.DocumentVersion = story.Version
+		}
+
+		revision, err := txSvc.RecordChange(userID, target, models.RevisionAuthorUser, "", before, old.Content)
+		if err != nil {
+			return err
+		}
+		if revision == nil {
+			// 当前内容已经与该修订相同
+			if revision, err = txSvc.revisionRepo.GetLatest(userID, targetType, targetID); err != nil {
+				return err
+			}
+		}
+		response.Revision = *revision
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if targetType == models.RevisionTargetWorkDocument {
+		s.ragSvc.IndexWorkDocument(targetID, userID)
+	}
+	return response, nil
+}
+
+// DeleteHistory 删除对象的所有修订
+func (s *RevisionService) DeleteHistory(userID, targetType, targetID string) error {
+	return s.revisionRepo.DeleteByTargetAndUserID(userID, targetType, targetID)
+}
+
+// DeleteWorkHistory 删除创作下所有文档的修订
+func (s *RevisionService) DeleteWorkHistory(workID, userID string) error {
+	return s.revisionRepo.DeleteByWorkIDAndUserID(workID, userID)
+}
+
+// checkTarget 验证对象属于该用户
+func (s *RevisionService) checkTarget(userID, targetType, targetID string) error {
+	var err error
+	switch targetType {
+	case models.RevisionTargetWorkDocument:
+		_, err = s.workDocumentRepo.GetByIDAndUserID(targetID, userID)
+	case models.RevisionTargetStory:
+		_, err = s.storyRepo.GetByIDAndUserID(targetID, userID)
+	}
+	return err
+}
+
+// rebuild 从最近的完整快照开始依次应用增量，还原指定修订的内容
+func (s *RevisionService) rebuild(repo *repository.RevisionRepository, userID, targetType, targetID string, number int) (string, error) {
+	chain, err := repo.GetChain(userID, targetType, targetID, number)
+	if err != nil {
+		return "", err
+	}
+
+	var content string
+	for _, revision := range chain {
+		payload, err := decompress(revision.Data)
+		if err != nil {
+			return "", err
+		}
+		if revision.Encoding == models.RevisionEncodingSnapshot {
+			content = string(payload)
+			continue
+		}
+		if content, err = services.ApplyDelta(content, payload); err != nil {
+			return "", err
+		}
+	}
+	return content, nil
+}
+
+// compress 使用DEFLATE压缩修订内容
+func compress(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress 解压修订内容
+func decompress(data []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(data))
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
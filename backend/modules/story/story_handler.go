@@ -1,8 +1,12 @@
 package story
 
 import (
+	"errors"
 	"fmt"
 	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/repository"
+	"grandma/backend/utils"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -30,12 +34,7 @@ func (h *StoryHandler) GetStoryList(c *gin.Context) {
 		req.Guid = "default"
 	}
 
-	if req.UserID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
-		return
-	}
-
-	response, err := h.service.GetStoryList(req.UserID, req.Guid)
+	response, err := h.service.GetStoryList(auth.CurrentUserID(c), req.Guid)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -59,7 +58,7 @@ func (h *StoryHandler) CreateStory(c *gin.Context) {
 		return
 	}
 
-	story, err := h.service.CreateStory(req.UserID, req.Guid, req.DocumentId, req.Title, req.Content, req.ContentHash)
+	story, err := h.service.CreateStory(auth.CurrentUserID(c), req.Guid, req.DocumentId, req.Title, req.Content, req.ContentHash)
 	if err != nil {
 		// 根据错误类型返回不同的状态码
 		if err.Error() == "duplicate_story" {
@@ -70,10 +69,15 @@ func (h *StoryHandler) CreateStory(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "hash_mismatch"})
 			return
 		}
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	c.Header("ETag", utils.FormatETag(story.Version))
 	c.JSON(http.StatusOK, story)
 }
 
@@ -81,14 +85,12 @@ func (h *StoryHandler) CreateStory(c *gin.Context) {
 func (h *StoryHandler) DeleteStory(c *gin.Context) {
 	fmt.Println("[story_handler DeleteStory] Start")
 	id := c.Param("id")
-	userID := c.Query("user_id")
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
-		return
-	}
-
-	err := h.service.DeleteStory(id, userID)
+	err := h.service.DeleteStory(id, auth.CurrentUserID(c))
 	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Story not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -114,13 +116,20 @@ func (h *StoryHandler) UpdateStory(c *gin.Context) {
 		return
 	}
 
-	if req.UserID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+	version, err := utils.ParseIfMatch(c.GetHeader("If-Match"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	story, err := h.service.UpdateStory(id, req.UserID, req.Title, req.Content, req.ContentHash)
+	story, err := h.service.UpdateStory(id, auth.CurrentUserID(c), req.Title, req.Content, req.ContentHash, version)
 	if err != nil {
+		var conflict *repository.StoryConflictError
+		if errors.As(err, &conflict) {
+			c.Header("ETag", utils.FormatETag(conflict.Story.Version))
+			c.JSON(http.StatusConflict, models.StoryConflictResponse{Error: err.Error(), Story: *conflict.Story})
+			return
+		}
 		// 根据错误类型返回不同的状态码
 		if err.Error() == "duplicate_story" {
 			c.JSON(http.StatusConflict, gin.H{"error": "duplicate_story"})
@@ -130,9 +139,14 @@ func (h *StoryHandler) UpdateStory(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "hash_mismatch"})
 			return
 		}
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Story not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	c.Header("ETag", utils.FormatETag(story.Version))
 	c.JSON(http.StatusOK, story)
 }
@@ -3,20 +3,27 @@ package story
 import (
 	"errors"
 	"grandma/backend/models"
+	"grandma/backend/modules/revision"
 	"grandma/backend/repository"
 	"grandma/backend/utils"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // StoryService 故事服务
 type StoryService struct {
-	storyRepo *repository.StoryRepository
+	storyRepo    *repository.StoryRepository
+	documentRepo *repository.DocumentRepository
+	revisionSvc  *revision.RevisionService
 }
 
 // NewStoryService 创建故事服务
-func NewStoryService(storyRepo *repository.StoryRepository) *StoryService {
+func NewStoryService(storyRepo *repository.StoryRepository, documentRepo *repository.DocumentRepository, revisionSvc *revision.RevisionService) *StoryService {
 	return &StoryService{
-		storyRepo: storyRepo,
+		storyRepo:    storyRepo,
+		documentRepo: documentRepo,
+		revisionSvc:  revisionSvc,
 	}
 }
 
@@ -42,9 +49,12 @@ func (s *StoryService) GetStoryList(userID, guid string) (*models.StoryResponse,
 	}, nil
 }
 
-// DeleteStory 删除文档
+// DeleteStory 删除文档（级联删除修订）
 func (s *StoryService) DeleteStory(id, userID string) error {
-	return s.storyRepo.DeleteByIDAndUserID(id, userID)
+	if err := s.storyRepo.DeleteByIDAndUserID(id, userID); err != nil {
+		return err
+	}
+	return s.revisionSvc.DeleteHistory(userID, models.RevisionTargetStory, id)
 }
 
 // CreateStory 创建故事
@@ -54,6 +64,13 @@ func (s *StoryService) CreateStory(userID, guid, documentID, title, content, cli
 		guid = "default"
 	}
 
+	// 关联的文档必须属于该用户
+	if documentID != "" {
+		if _, err := s.documentRepo.GetByIDAndUserID(documentID, userID); err != nil {
+			return nil, err
+		}
+	}
+
 	// 计算服务端内容特征值
 	serverContentHash := utils.CalculateContentHash(content)
 
@@ -85,20 +102,29 @@ func (s *StoryService) CreateStory(userID, guid, documentID, title, content, cli
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
-	err = s.storyRepo.Create(story)
+	err = s.storyRepo.Transaction(func(tx *gorm.DB) error {
+		if err := s.storyRepo.WithTx(tx).Create(story); err != nil {
+			return err
+		}
+		_, err := s.revisionSvc.WithTx(tx).RecordChange(userID, storyTarget(story), models.RevisionAuthorUser, "", "", content)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	return story, nil
 }
 
-// UpdateStory 更新故事
-func (s *StoryService) UpdateStory(id, userID, title, content, clientContentHash string) (*models.Story, error) {
+// UpdateStory 更新故事，version大于0时要求与故事的当前版本号相同
+func (s *StoryService) UpdateStory(id, userID, title, content, clientContentHash string, version int64) (*models.Story, error) {
 	// 获取现有故事（验证用户ID）
 	story, err := s.storyRepo.GetByIDAndUserID(id, userID)
 	if err != nil {
 		return nil, err
 	}
+	if err := repository.CheckStoryVersion(story, version); err != nil {
+		return nil, err
+	}
 
 	// 计算服务端内容特征值
 	serverContentHash := utils.CalculateContentHash(content)
@@ -119,14 +145,27 @@ func (s *StoryService) UpdateStory(id, userID, title, content, clientContentHash
 	}
 
 	// 更新故事
+	before := story.Content
 	story.Title = title
 	story.Content = content
 	story.ContentHash = serverContentHash
 	story.UpdatedAt = time.Now()
 
-	err = s.storyRepo.Update(story)
+	// 更新故事和记录修订在同一个事务中完成
+	err = s.storyRepo.Transaction(func(tx *gorm.DB) error {
+		if err := s.storyRepo.WithTx(tx).UpdateByIDAndUserID(story); err != nil {
+			return err
+		}
+		_, err := s.revisionSvc.WithTx(tx).RecordChange(userID, storyTarget(story), models.RevisionAuthorUser, "", before, content)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	return story, nil
 }
+
+// storyTarget 故事对应的修订对象
+func storyTarget(story *models.Story) revision.Target {
+	return revision.Target{Type: models.RevisionTargetStory, ID: story.ID}
+}
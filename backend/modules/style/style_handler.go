@@ -0,0 +1,151 @@
+package style
+
+import (
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/modules/credential"
+	"grandma/backend/modules/usage"
+	"grandma/backend/repository"
+	"grandma/backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StyleHandler 文风档案处理器
+type StyleHandler struct {
+	service *StyleService
+}
+
+// NewStyleHandler 创建文风档案处理器
+func NewStyleHandler(service *StyleService) *StyleHandler {
+	return &StyleHandler{
+		service: service,
+	}
+}
+
+// GetProfileList 获取文风档案列表
+func (h *StyleHandler) GetProfileList(c *gin.Context) {
+	response, err := h.service.GetProfileList(auth.CurrentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetProfile 获取文风档案
+func (h *StyleHandler) GetProfile(c *gin.Context) {
+	profile, err := h.service.GetProfile(c.Param("id"), auth.CurrentUserID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// CreateProfile 创建文风档案
+func (h *StyleHandler) CreateProfile(c *gin.Context) {
+	var req models.StyleProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile, err := h.service.CreateProfile(auth.CurrentUserID(c), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateProfile 更新文风档案
+func (h *StyleHandler) UpdateProfile(c *gin.Context) {
+	var req models.StyleProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile, err := h.service.UpdateProfile(c.Param("id"), auth.CurrentUserID(c), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// DeleteProfile 删除文风档案
+func (h *StyleHandler) DeleteProfile(c *gin.Context) {
+	if err := h.service.DeleteProfile(c.Param("id"), auth.CurrentUserID(c)); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Style profile deleted successfully"})
+}
+
+// DeriveProfile 分析创作正文生成文风档案
+func (h *StyleHandler) DeriveProfile(c *gin.Context) {
+	var req models.DeriveStyleProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile, err := h.service.DeriveProfile(c.Request.Context(), auth.CurrentUserID(c), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// AttachToWork 为创作设置文风档案
+func (h *StyleHandler) AttachToWork(c *gin.Context) {
+	var req models.AttachStyleProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.AttachToWork(c.Param("id"), auth.CurrentUserID(c), req.StyleProfileID); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Style profile updated successfully"})
+}
+
+// AttachToConversation 为对话设置文风档案
+func (h *StyleHandler) AttachToConversation(c *gin.Context) {
+	var req models.AttachStyleProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.AttachToConversation(c.Param("id"), auth.CurrentUserID(c), req.StyleProfileID); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Style profile updated successfully"})
+}
+
+// writeError 把服务错误转换为HTTP响应
+func writeError(c *gin.Context, err error) {
+	switch {
+	case repository.IsNotFound(err):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Style profile, work or conversation not found"})
+	case errors.Is(err, ErrInvalidStyleProfile), errors.Is(err, ErrEmptyCorpus), errors.Is(err, credential.ErrProviderKeyMissing):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, usage.ErrQuotaExceeded):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidJSONOutput):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	case errors.Is(err, credential.ErrMasterKeyNotConfigured):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,347 @@
+package style
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grandma/backend/models"
+	"grandma/backend/modules/credential"
+	"grandma/backend/modules/prompt"
+	"grandma/backend/modules/usage"
+	"grandma/backend/repository"
+	"grandma/backend/services"
+	"grandma/backend/utils"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// 档案来源
+const (
+	SourceManual  = "manual"
+	SourceDerived = "derived"
+)
+
+const (
+	maxBannedWords       = 100   // 禁用词的最大数量
+	maxSamplePassages    = 5     // 示例段落的最大数量
+	maxSamplePassageRune = 2000  // 单个示例段落的最大字符数
+	corpusMaxRunes       = 12000 // 分析文风时发送给模型的正文最大字符数
+	derivedSampleCount   = 3     // 分析文风时选取的示例段落数
+	sampleMinRunes       = 80    // 自动选取的示例段落的最小字符数
+	sampleMaxRunes       = 400   // 自动选取的示例段落的最大字符数
+)
+
+var (
+	// ErrInvalidStyleProfile 文风档案内容不合法
+	ErrInvalidStyleProfile = errors.New("invalid_style_profile")
+	// ErrEmptyCorpus 创作还没有可供分析的正文
+	ErrEmptyCorpus = errors.New("empty_corpus")
+)
+
+// styleSchema 文风分析的结构化输出
+var styleSchema = services.JSONSchema{
+	Name:        "style_profile",
+	Description: "从小说正文中总结出的文风档案",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"voice":         map[string]interface{}{"type": "string", "description": "叙述声音和语气，一两句话", "maxLength": 200},
+			"tense":         map[string]interface{}{"type": "string", "description": "主要时态，如过去时、现在时", "maxLength": 20},
+			"point_of_view": map[string]interface{}{"type": "string", "description": "叙事视角，如第一人称、第三人称有限视角", "maxLength": 40},
+			"reading_level": map[string]interface{}{"type": "string", "description": "目标读者和阅读难度", "maxLength": 40},
+			"banned_words": map[string]interface{}{
+				"type":        "array",
+				"description": "与这种文风不符、续写时应避免的词语或套话",
+				"items":       map[string]interface{}{"type": "string"},
+			},
+		},
+		"required": []string{"voice", "tense", "point_of_view", "reading_level", "banned_words"},
+	},
+}
+
+// StyleService 文风档案服务
+type StyleService struct {
+	styleRepo        *repository.StyleProfileRepository
+	workRepo         *repository.WorkRepository
+	conversationRepo *repository.ConversationRepository
+	workDocumentRepo *repository.WorkDocumentRepository
+	credentialSvc    *credential.CredentialService
+	usageSvc         *usage.UsageService
+	promptSvc        *prompt.PromptService
+}
+
+// NewStyleService 创建文风档案服务
+func NewStyleService(styleRepo *repository.StyleProfileRepository, workRepo *repository.WorkRepository, conversationRepo *repository.ConversationRepository, workDocumentRepo *repository.WorkDocumentRepository, credentialSvc *credential.CredentialService, usageSvc *usage.UsageService, promptSvc *prompt.PromptService) *StyleService {
+	return &StyleService{
+		styleRepo:        styleRepo,
+		workRepo:         workRepo,
+		conversationRepo: conversationRepo,
+		workDocumentRepo: workDocumentRepo,
+		credentialSvc:    credentialSvc,
+		usageSvc:         usageSvc,
+		promptSvc:        promptSvc,
+	}
+}
+
+// GetProfileList 获取用户的所有文风档案
+func (s *StyleService) GetProfileList(userID string) (*models.StyleProfileListResponse, error) {
+	profiles, err := s.styleRepo.GetAllByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.StyleProfileListResponse{
+		Profiles: profiles,
+		Total:    len(profiles),
+	}, nil
+}
+
+// GetProfile 获取文风档案
+func (s *StyleService) GetProfile(id, userID string) (*models.StyleProfile, error) {
+	return s.styleRepo.GetByIDAndUserID(id, userID)
+}
+
+// CreateProfile 手动创建文风档案
+func (s *StyleService) CreateProfile(userID string, req *models.StyleProfileRequest) (*models.StyleProfile, error) {
+	profile := &models.StyleProfile{
+		ID:     utils.GenerateStyleProfileID(),
+		UserID: userID,
+		Source: SourceManual,
+	}
+	if err := applyRequest(profile, req); err != nil {
+		return nil, err
+	}
+	if err := s.styleRepo.Create(profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// UpdateProfile 更新文风档案
+func (s *StyleService) UpdateProfile(id, userID string, req *models.StyleProfileRequest) (*models.StyleProfile, error) {
+	profile, err := s.styleRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyRequest(profile, req); err != nil {
+		return nil, err
+	}
+	if err := s.styleRepo.UpdateByIDAndUserID(profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// DeleteProfile 删除文风档案，并取消创作和对话对它的引用
+func (s *StyleService) DeleteProfile(id, userID string) error {
+	profile, err := s.styleRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return err
+	}
+	return s.workRepo.Transaction(func(tx *gorm.DB) error {
+		if err := s.workRepo.WithTx(tx).ClearStyleProfileByUserID(userID, profile.ID); err != nil {
+			return err
+		}
+		if err := s.conversationRepo.WithTx(tx).ClearStyleProfileByUserID(userID, profile.ID); err != nil {
+			return err
+		}
+		return s.styleRepo.WithTx(tx).DeleteByIDAndUserID(profile.ID, userID)
+	})
+}
+
+// AttachToWork 为创作设置文风档案，profileID为空时取消
+func (s *StyleService) AttachToWork(workID, userID, profileID string) error {
+	if err := s.checkProfile(profileID, userID); err != nil {
+		return err
+	}
+	return s.workRepo.UpdateStyleProfileByIDAndUserID(workID, userID, profileID)
+}
+
+// AttachToConversation 为对话设置文风档案，profileID为空时取消
+func (s *StyleService) AttachToConversation(conversationID, userID, profileID string) error {
+	if err := s.checkProfile(profileID, userID); err != nil {
+		return err
+	}
+	return s.conversationRepo.UpdateStyleProfileByIDAndUserID(conversationID, userID, profileID)
+}
+
+// Compile 把文风档案编译成系统提示，workID用于查找创作级的style模板
+func (s *StyleService) Compile(userID, workID, profileID string) (string, error) {
+	profile, err := s.styleRepo.GetByIDAndUserID(profileID, userID)
+	if err != nil {
+		return "", err
+	}
+	return s.promptSvc.Render(userID, workID, prompt.TemplateStyle, prompt.StyleData{
+		Name:           profile.Name,
+		Voice:          profile.Voice,
+		Tense:          profile.Tense,
+		PointOfView:    profile.PointOfView,
+		BannedWords:    profile.BannedWords,
+		ReadingLevel:   profile.ReadingLevel,
+		SamplePassages: profile.SamplePassages,
+	})
+}
+
+// DeriveProfile 分析创作的正文，生成新的文风档案
+// 叙述声音、时态、视角、阅读难度和禁用词由模型总结，示例段落直接从正文中选取
+func (s *StyleService) DeriveProfile(ctx context.Context, userID string, req *models.DeriveStyleProfileRequest) (*models.StyleProfile, error) {
+	work, err := s.workRepo.GetByIDAndUserID(req.WorkID, userID)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := s.workDocumentRepo.GetByWorkIDAndUserID(work.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	var contents []string
+	for _, doc := range docs {
+		if content := strings.TrimSpace(doc.Content); content != "" {
+			contents = append(contents, content)
+		}
+	}
+	if len(contents) == 0 {
+		return nil, ErrEmptyCorpus
+	}
+
+	model := req.Model
+	if model == "" {
+		model = "openai"
+	}
+	provider, err := s.credentialSvc.GetProvider(userID, model)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.usageSvc.CheckQuota(userID); err != nil {
+		return nil, err
+	}
+	provider = s.usageSvc.Meter(provider, userID, usage.FeatureStyle)
+
+	corpus := utils.TruncateRunes(strings.Join(contents, "\n\n"), corpusMaxRunes, "")
+	messages := []models.Message{{
+		Role:    "user",
+		Content: "请分析以下小说正文的文风，总结叙述声音和语气、时态、叙事视角、目标阅读难度，并列出与这种文风不符、续写时应避免的词语或套话：\n\n" + corpus,
+	}}
+	var result struct {
+		Voice        string   `json:"voice"`
+		Tense        string   `json:"tense"`
+		PointOfView  string   `json:"point_of_view"`
+		ReadingLevel string   `json:"reading_level"`
+		BannedWords  []string `json:"banned_words"`
+	}
+	if err := services.ChatJSON(ctx, provider, messages, styleSchema, &result); err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = work.Title + " 文风"
+	}
+	profile := &models.StyleProfile{
+		ID:             utils.GenerateStyleProfileID(),
+		UserID:         userID,
+		Name:           name,
+		Voice:          strings.TrimSpace(result.Voice),
+		Tense:          strings.TrimSpace(result.Tense),
+		PointOfView:    strings.TrimSpace(result.PointOfView),
+		ReadingLevel:   strings.TrimSpace(result.ReadingLevel),
+		BannedWords:    normalizeWords(result.BannedWords),
+		SamplePassages: pickSamplePassages(contents, derivedSampleCount),
+		Source:         SourceDerived,
+		SourceWorkID:   work.ID,
+	}
+	if len(profile.BannedWords) > maxBannedWords {
+		profile.BannedWords = profile.BannedWords[:maxBannedWords]
+	}
+	if err := s.styleRepo.Create(profile); err != nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// checkProfile 验证文风档案属于该用户，空ID表示取消关联
+func (s *StyleService) checkProfile(profileID, userID string) error {
+	if profileID == "" {
+		return nil
+	}
+	_, err := s.styleRepo.GetByIDAndUserID(profileID, userID)
+	return err
+}
+
+// applyRequest 校验请求并写入档案
+func applyRequest(profile *models.StyleProfile, req *models.StyleProfileRequest) error {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidStyleProfile)
+	}
+	bannedWords := normalizeWords(req.BannedWords)
+	if len(bannedWords) > maxBannedWords {
+		return fmt.Errorf("%w: at most %d banned words", ErrInvalidStyleProfile, maxBannedWords)
+	}
+	var passages []string
+	for _, passage := range req.SamplePassages {
+		passage = strings.TrimSpace(passage)
+		if passage == "" {
+			continue
+		}
+		if utf8.RuneCountInString(passage) > maxSamplePassageRune {
+			return fmt.Errorf("%w: sample passages must be at most %d characters", ErrInvalidStyleProfile, maxSamplePassageRune)
+		}
+		passages = append(passages, passage)
+	}
+	if len(passages) > maxSamplePassages {
+		return fmt.Errorf("%w: at most %d sample passages", ErrInvalidStyleProfile, maxSamplePassages)
+	}
+
+	profile.Name = name
+	profile.Voice = strings.TrimSpace(req.Voice)
+	profile.Tense = strings.TrimSpace(req.Tense)
+	profile.PointOfView = strings.TrimSpace(req.PointOfView)
+	profile.ReadingLevel = strings.TrimSpace(req.ReadingLevel)
+	profile.BannedWords = bannedWords
+	profile.SamplePassages = passages
+	return nil
+}
+
+// normalizeWords 去掉空白和重复的词语，保持原有顺序
+func normalizeWords(words []string) []string {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if word == "" || seen[word] {
+			continue
+		}
+		seen[word] = true
+		normalized = append(normalized, word)
+	}
+	return normalized
+}
+
+// pickSamplePassages 从正文中均匀选取长度适中的段落作为示例
+// 没有长度合适的段落时截取第一段
+func pickSamplePassages(contents []string, count int) []string {
+	var candidates []string
+	for _, content := range contents {
+		for _, paragraph := range strings.Split(content, "\n") {
+			paragraph = strings.TrimSpace(paragraph)
+			length := utf8.RuneCountInString(paragraph)
+			if length >= sampleMinRunes && length <= sampleMaxRunes {
+				candidates = append(candidates, paragraph)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		first := strings.TrimSpace(strings.SplitN(contents[0], "\n", 2)[0])
+		return []string{utils.TruncateRunes(first, sampleMaxRunes, "")}
+	}
+	if len(candidates) <= count {
+		return candidates
+	}
+
+	passages := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		passages = append(passages, candidates[i*len(candidates)/count])
+	}
+	return passages
+}
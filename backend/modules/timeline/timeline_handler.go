@@ -0,0 +1,125 @@
+package timeline
+
+import (
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/repository"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TimelineHandler 时间线处理器
+type TimelineHandler struct {
+	service *TimelineService
+}
+
+// NewTimelineHandler 创建时间线处理器
+func NewTimelineHandler(service *TimelineService) *TimelineHandler {
+	return &TimelineHandler{
+		service: service,
+	}
+}
+
+// GetTimeline 获取创作的时间线，participant参数按人物过滤，until_chapter参数只返回到该章为止已经发生的事件
+func (h *TimelineHandler) GetTimeline(c *gin.Context) {
+	untilChapter := 0
+	if value := c.Query("until_chapter"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "until_chapter must be a positive integer"})
+			return
+		}
+		untilChapter = n
+	}
+
+	response, err := h.service.ListEvents(c.Param("work_id"), auth.CurrentUserID(c), c.Query("participant"), untilChapter)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// CreateEvent 创建时间线事件
+func (h *TimelineHandler) CreateEvent(c *gin.Context) {
+	var req models.TimelineEventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	event, err := h.service.CreateEvent(c.Param("work_id"), auth.CurrentUserID(c), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, event)
+}
+
+// UpdateEvent 更新时间线事件
+func (h *TimelineHandler) UpdateEvent(c *gin.Context) {
+	var req models.TimelineEventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	event, err := h.service.UpdateEvent(c.Param("id"), auth.CurrentUserID(c), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, event)
+}
+
+// DeleteEvent 删除时间线事件
+func (h *TimelineHandler) DeleteEvent(c *gin.Context) {
+	if err := h.service.DeleteEvent(c.Param("id"), auth.CurrentUserID(c)); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Timeline event deleted successfully"})
+}
+
+// GetCalendar 获取创作的历法
+func (h *TimelineHandler) GetCalendar(c *gin.Context) {
+	calendar, err := h.service.GetCalendar(c.Param("work_id"), auth.CurrentUserID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, calendar)
+}
+
+// SetCalendar 设置创作的历法（PUT路由与 /works/:id 共用前缀，创作ID参数名为id）
+func (h *TimelineHandler) SetCalendar(c *gin.Context) {
+	var req models.TimelineCalendarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	calendar, err := h.service.SetCalendar(c.Param("id"), auth.CurrentUserID(c), &req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, calendar)
+}
+
+// writeError 把服务错误转换为HTTP响应
+func writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidCalendar), errors.Is(err, ErrInvalidTimelineEvent):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrCalendarConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case repository.IsNotFound(err):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Work, document or timeline event not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,486 @@
+package timeline
+
+import (
+	"errors"
+	"fmt"
+	"grandma/backend/models"
+	"grandma/backend/modules/outline"
+	"grandma/backend/repository"
+	"grandma/backend/utils"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+const (
+	maxTitleRunes       = 200  // 事件标题的最大字符数
+	maxNameRunes        = 50   // 纪年、月份名称的最大字符数
+	maxParticipantRunes = 100  // 参与人物名称的最大字符数
+	maxParticipants     = 50   // 单个事件的参与人物数上限
+	maxMonths           = 100  // 历法中的月份数上限
+	maxMonthDays        = 1000 // 单月的天数上限
+	maxPrecedingEvents  = 30   // 注入灵感模式上下文的事件数上限
+)
+
+var (
+	// ErrInvalidCalendar 历法不合法
+	ErrInvalidCalendar = errors.New("invalid_calendar")
+	// ErrInvalidTimelineEvent 事件内容或日期不合法
+	ErrInvalidTimelineEvent = errors.New("invalid_timeline_event")
+	// ErrCalendarConflict 新历法无法表示已有事件的日期
+	ErrCalendarConflict = errors.New("calendar_conflict")
+)
+
+// defaultMonths 未设置历法时使用的公历月份（不含闰年）
+var defaultMonths = []models.CalendarMonth{
+	{Name: "1月", Days: 31}, {Name: "2月", Days: 28}, {Name: "3月", Days: 31}, {Name: "4月", Days: 30},
+	{Name: "5月", Days: 31}, {Name: "6月", Days: 30}, {Name: "7月", Days: 31}, {Name: "8月", Days: 31},
+	{Name: "9月", Days: 30}, {Name: "10月", Days: 31}, {Name: "11月", Days: 30}, {Name: "12月", Days: 31},
+}
+
+// TimelineService 时间线服务：故事内的历法、事件和按章节的时间线查询
+type TimelineService struct {
+	timelineRepo     *repository.TimelineRepository
+	workRepo         *repository.WorkRepository
+	workDocumentRepo *repository.WorkDocumentRepository
+	storyBibleRepo   *repository.StoryBibleRepository
+	outlineSvc       *outline.OutlineService
+}
+
+// NewTimelineService 创建时间线服务
+func NewTimelineService(timelineRepo *repository.TimelineRepository, workRepo *repository.WorkRepository, workDocumentRepo *repository.WorkDocumentRepository, storyBibleRepo *repository.StoryBibleRepository, outlineSvc *outline.OutlineService) *TimelineService {
+	return &TimelineService{
+		timelineRepo:     timelineRepo,
+		workRepo:         workRepo,
+		workDocumentRepo: workDocumentRepo,
+		storyBibleRepo:   storyBibleRepo,
+		outlineSvc:       outlineSvc,
+	}
+}
+
+// GetCalendar 获取创作的历法，未设置时返回默认历法
+func (s *TimelineService) GetCalendar(workID, userID string) (*models.TimelineCalendar, error) {
+	// 先验证创作属于该用户
+	if _, err := s.workRepo.GetByIDAndUserID(workID, userID); err != nil {
+		return nil, err
+	}
+	return s.calendar(workID, userID)
+}
+
+// SetCalendar 设置创作的历法，并按新历法重新计算有日期事件的位置
+func (s *TimelineService) SetCalendar(workID, userID string, req *models.TimelineCalendarRequest) (*models.TimelineCalendar, error) {
+	// 先验证创作属于该用户
+	if _, err := s.workRepo.GetByIDAndUserID(workID, userID); err != nil {
+		return nil, err
+	}
+	era := strings.TrimSpace(req.Era)
+	if utf8.RuneCountInString(era) > maxNameRunes {
+		return nil, fmt.Errorf("%w: era is too long", ErrInvalidCalendar)
+	}
+	if len(req.Months) == 0 || len(req.Months) > maxMonths {
+		return nil, fmt.Errorf("%w: a calendar needs 1 to %d months", ErrInvalidCalendar, maxMonths)
+	}
+	months := make([]models.CalendarMonth, len(req.Months))
+	for i, month := range req.Months {
+		name := strings.TrimSpace(month.Name)
+		if name == "" || utf8.RuneCountInString(name) > maxNameRunes {
+			return nil, fmt.Errorf("%w: month %d needs a name of at most %d characters", ErrInvalidCalendar, i+1, maxNameRunes)
+		}
+		if month.Days < 1 || month.Days > maxMonthDays {
+			return nil, fmt.Errorf("%w: month %d needs 1 to %d days", ErrInvalidCalendar, i+1, maxMonthDays)
+		}
+		months[i] = models.CalendarMonth{Name: name, Days: month.Days}
+	}
+
+	calendar, err := s.calendar(workID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if calendar.ID == "" {
+		calendar.ID = utils.GenerateTimelineCalendarID()
+	}
+	calendar.Era = era
+	calendar.Months = months
+
+	events, err := s.timelineRepo.GetEventsByWorkIDAndUserID(workID, userID)
+	if err != nil {
+		return nil, err
+	}
+	ordinals := make(map[string]float64)
+	for _, event := range events {
+		if event.Year == nil {
+			continue
+		}
+		if err := validateDate(calendar, event.Year, event.Month, event.Day); err != nil {
+			return nil, fmt.Errorf("%w: event %q cannot be dated in the new calendar", ErrCalendarConflict, event.Title)
+		}
+		ordinals[event.ID] = ordinalOf(calendar, event.Year, event.Month, event.Day)
+	}
+	if err := s.timelineRepo.SaveCalendar(calendar, ordinals); err != nil {
+		return nil, err
+	}
+	return calendar, nil
+}
+
+// ListEvents 获取创作的时间线
+// participant不为空时只返回该人物参与的事件（按设定集的名称和别名匹配）；
+// untilChapter大于0时只返回故事时间不晚于前untilChapter章所写到的最晚事件的事件，即到该章为止已经发生的事情
+func (s *TimelineService) ListEvents(workID, userID, participant string, untilChapter int) (*models.TimelineResponse, error) {
+	// 先验证创作属于该用户
+	if _, err := s.workRepo.GetByIDAndUserID(workID, userID); err != nil {
+		return nil, err
+	}
+	calendar, err := s.calendar(workID, userID)
+	if err != nil {
+		return nil, err
+	}
+	events, _, err := s.events(workID, userID, calendar)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.TimelineResponse{Calendar: *calendar}
+	if untilChapter > 0 {
+		var until *float64
+		for _, event := range events {
+			if event.Chapter >= 1 && event.Chapter <= untilChapter && (until == nil || event.Ordinal > *until) {
+				ordinal := event.Ordinal
+				until = &ordinal
+			}
+		}
+		response.Until = until
+		events = before(events, until, true)
+	}
+	if participant != "" {
+		names, err := s.participantNames(workID, userID, participant)
+		if err != nil {
+			return nil, err
+		}
+		var filtered []models.TimelineEvent
+		for _, event := range events {
+			if involves(event, names) {
+				filtered = append(filtered, event)
+			}
+		}
+		events = filtered
+	}
+
+	if events == nil {
+		events = []models.TimelineEvent{}
+	}
+	response.Events = events
+	response.Total = len(events)
+	return response, nil
+}
+
+// CreateEvent 创建时间线事件
+func (s *TimelineService) CreateEvent(workID, userID string, req *models.TimelineEventRequest) (*models.TimelineEvent, error) {
+	// 先验证创作属于该用户
+	if _, err := s.workRepo.GetByIDAndUserID(workID, userID); err != nil {
+		return nil, err
+	}
+
+	event := &models.TimelineEvent{
+		ID:     utils.GenerateTimelineEventID(),
+		UserID: userID,
+		WorkID: workID,
+	}
+	calendar, err := s.applyFields(event, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.timelineRepo.CreateEvent(event); err != nil {
+		return nil, err
+	}
+	event.Date = formatDate(calendar, event)
+	return event, nil
+}
+
+// UpdateEvent 更新时间线事件
+func (s *TimelineService) UpdateEvent(id, userID string, req *models.TimelineEventRequest) (*models.TimelineEvent, error) {
+	event, err := s.timelineRepo.GetEventByIDAndUserID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	calendar, err := s.applyFields(event, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.timelineRepo.UpdateEventByIDAndUserID(event); err != nil {
+		return nil, err
+	}
+	event.Date = formatDate(calendar, event)
+	return event, nil
+}
+
+// DeleteEvent 删除时间线事件
+func (s *TimelineService) DeleteEvent(id, userID string) error {
+	return s.timelineRepo.DeleteEventByIDAndUserID(id, userID)
+}
+
+// PrecedingEvents 获取故事时间早于当前章节的事件，用于灵感模式的上下文
+// 当前章节已有事件时返回早于其中最早事件的事件，否则返回不晚于前面章节所写到的最晚事件的事件；
+// 事件较多时只保留离当前章节最近的部分。文档不属于该创作时返回gorm.ErrRecordNotFound
+func (s *TimelineService) PrecedingEvents(workID, documentID, userID string) (*models.WorkDocument, []models.TimelineEvent, error) {
+	doc, err := s.workDocumentRepo.GetByIDAndUserID(documentID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc.WorkID != workID {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	calendar, err := s.calendar(workID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	events, chapters, err := s.events(workID, userID, calendar)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var current, earlier *float64
+	for _, event := range events {
+		if event.DocumentID == documentID && (current == nil || event.Ordinal < *current) {
+			ordinal := event.Ordinal
+			current = &ordinal
+		}
+	}
+	if current != nil {
+		events = before(events, current, false)
+	} else {
+		chapter := chapters[documentID]
+		for _, event := range events {
+			if event.Chapter >= 1 && event.Chapter < chapter && (earlier == nil || event.Ordinal > *earlier) {
+				ordinal := event.Ordinal
+				earlier = &ordinal
+			}
+		}
+		events = before(events, earlier, true)
+	}
+
+	if len(events) > maxPrecedingEvents {
+		events = events[len(events)-maxPrecedingEvents:]
+	}
+	return doc, events, nil
+}
+
+// calendar 获取创作的历法，未设置时返回不带ID的默认历法
+func (s *TimelineService) calendar(workID, userID string) (*models.TimelineCalendar, error) {
+	calendar, err := s.timelineRepo.GetCalendarByWorkIDAndUserID(workID, userID)
+	if err == nil {
+		return calendar, nil
+	}
+	if !repository.IsNotFound(err) {
+		return nil, err
+	}
+	return &models.TimelineCalendar{
+		UserID: userID,
+		WorkID: workID,
+		Months: defaultMonths,
+	}, nil
+}
+
+// events 获取创作的所有事件，并填入格式化的日期和出处章节序号；同时返回正文文档ID到阅读顺序序号（从1开始）的映射
+func (s *TimelineService) events(workID, userID string, calendar *models.TimelineCalendar) ([]models.TimelineEvent, map[string]int, error) {
+	events, err := s.timelineRepo.GetEventsByWorkIDAndUserID(workID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	docs, err := s.outlineSvc.ReadingOrder(workID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	chapters := make(map[string]int, len(docs))
+	for i, doc := range docs {
+		chapters[doc.ID] = i + 1
+	}
+	for i := range events {
+		events[i].Date = formatDate(calendar, &events[i])
+		events[i].Chapter = chapters[events[i].DocumentID]
+	}
+	return events, chapters, nil
+}
+
+// applyFields 校验请求并写入事件的字段，返回计算日期所用的历法
+func (s *TimelineService) applyFields(event *models.TimelineEvent, req *models.TimelineEventRequest) (*models.TimelineCalendar, error) {
+	title := strings.TrimSpace(req.Title)
+	if title == "" || utf8.RuneCountInString(title) > maxTitleRunes {
+		return nil, fmt.Errorf("%w: title must be 1 to %d characters", ErrInvalidTimelineEvent, maxTitleRunes)
+	}
+	participants, err := normalizeParticipants(req.Participants)
+	if err != nil {
+		return nil, err
+	}
+
+	calendar, err := s.calendar(event.WorkID, event.UserID)
+	if err != nil {
+		return nil, err
+	}
+	var ordinal float64
+	if req.Year != nil {
+		if err := validateDate(calendar, req.Year, req.Month, req.Day); err != nil {
+			return nil, err
+		}
+		ordinal = ordinalOf(calendar, req.Year, req.Month, req.Day)
+	} else {
+		if req.Month != nil || req.Day != nil {
+			return nil, fmt.Errorf("%w: month and day need a year", ErrInvalidTimelineEvent)
+		}
+		if req.Ordinal == nil {
+			return nil, fmt.Errorf("%w: events without a year need an ordinal", ErrInvalidTimelineEvent)
+		}
+		ordinal = *req.Ordinal
+	}
+
+	// 出处章节必须属于同一创作，出处原文在章节中找不到时只保存原文
+	var sourceStart, sourceEnd *int
+	quote := strings.TrimSpace(req.SourceQuote)
+	if req.DocumentID != "" {
+		doc, err := s.workDocumentRepo.GetByIDAndUserID(req.DocumentID, event.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if doc.WorkID != event.WorkID {
+			return nil, gorm.ErrRecordNotFound
+		}
+		sourceStart, sourceEnd = utils.LocateRunes(doc.Content, quote)
+	}
+
+	event.Title = title
+	event.Description = strings.TrimSpace(req.Description)
+	event.Year, event.Month, event.Day = req.Year, req.Month, req.Day
+	event.Ordinal = ordinal
+	event.Participants = participants
+	event.Location = strings.TrimSpace(req.Location)
+	event.DocumentID = req.DocumentID
+	event.SourceQuote = quote
+	event.SourceStart, event.SourceEnd = sourceStart, sourceEnd
+	return calendar, nil
+}
+
+// participantNames 人物名称及其在设定集中的所有名称和别名（小写）
+func (s *TimelineService) participantNames(workID, userID, participant string) (map[string]bool, error) {
+	key := strings.ToLower(strings.TrimSpace(participant))
+	names := map[string]bool{key: true}
+	entries, err := s.storyBibleRepo.GetByWorkIDAndUserID(workID, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		entryNames := append([]string{entry.Name}, entry.Aliases...)
+		matched := false
+		for _, name := range entryNames {
+			if strings.ToLower(strings.TrimSpace(name)) == key {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		for _, name := range entryNames {
+			names[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+	return names, nil
+}
+
+// involves 事件的参与人物中是否有names中的名称
+func involves(event models.TimelineEvent, names map[string]bool) bool {
+	for _, participant := range event.Participants {
+		if names[strings.ToLower(participant)] {
+			return true
+		}
+	}
+	return false
+}
+
+// before 按故事时间截取事件（events已排序），inclusive为true时包含位置等于cutoff的事件；cutoff为nil时返回空
+func before(events []models.TimelineEvent, cutoff *float64, inclusive bool) []models.TimelineEvent {
+	if cutoff == nil {
+		return nil
+	}
+	var result []models.TimelineEvent
+	for _, event := range events {
+		if event.Ordinal < *cutoff || (inclusive && event.Ordinal == *cutoff) {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+// normalizeParticipants 去掉空白和重复的人物名称
+func normalizeParticipants(participants []string) ([]string, error) {
+	if len(participants) > maxParticipants {
+		return nil, fmt.Errorf("%w: at most %d participants", ErrInvalidTimelineEvent, maxParticipants)
+	}
+	seen := make(map[string]bool, len(participants))
+	result := []string{}
+	for _, participant := range participants {
+		participant = strings.TrimSpace(participant)
+		if participant == "" || seen[strings.ToLower(participant)] {
+			continue
+		}
+		if utf8.RuneCountInString(participant) > maxParticipantRunes {
+			return nil, fmt.Errorf("%w: participant names must be at most %d characters", ErrInvalidTimelineEvent, maxParticipantRunes)
+		}
+		seen[strings.ToLower(participant)] = true
+		result = append(result, participant)
+	}
+	return result, nil
+}
+
+// validateDate 验证日期在历法中存在，月份需要年份，日需要月份
+func validateDate(calendar *models.TimelineCalendar, year, month, day *int) error {
+	if year == nil {
+		return fmt.Errorf("%w: a date needs a year", ErrInvalidTimelineEvent)
+	}
+	if month == nil {
+		if day != nil {
+			return fmt.Errorf("%w: day needs a month", ErrInvalidTimelineEvent)
+		}
+		return nil
+	}
+	if *month < 1 || *month > len(calendar.Months) {
+		return fmt.Errorf("%w: month must be between 1 and %d", ErrInvalidTimelineEvent, len(calendar.Months))
+	}
+	if day != nil && (*day < 1 || *day > calendar.Months[*month-1].Days) {
+		return fmt.Errorf("%w: %s has %d days", ErrInvalidTimelineEvent, calendar.Months[*month-1].Name, calendar.Months[*month-1].Days)
+	}
+	return nil
+}
+
+// ordinalOf 日期在故事时间轴上的位置：从元年第一天起的天数，缺少月或日时取该年或该月的第一天
+func ordinalOf(calendar *models.TimelineCalendar, year, month, day *int) float64 {
+	daysPerYear := 0
+	for _, m := range calendar.Months {
+		daysPerYear += m.Days
+	}
+	days := (*year - 1) * daysPerYear
+	if month != nil {
+		for _, m := range calendar.Months[:*month-1] {
+			days += m.Days
+		}
+		if day != nil {
+			days += *day - 1
+		}
+	}
+	return float64(days)
+}
+
+// formatDate 按历法格式化事件日期，如"天启3年霜月5日"；没有年份时返回空字符串
+func formatDate(calendar *models.TimelineCalendar, event *models.TimelineEvent) string {
+	if event.Year == nil {
+		return ""
+	}
+	date := fmt.Sprintf("%s%d年", calendar.Era, *event.Year)
+	if event.Month == nil || *event.Month < 1 || *event.Month > len(calendar.Months) {
+		return date
+	}
+	date += calendar.Months[*event.Month-1].Name
+	if event.Day != nil {
+		date += fmt.Sprintf("%d日", *event.Day)
+	}
+	return date
+}
@@ -0,0 +1,84 @@
+package upload
+
+import (
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/repository"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UploadHandler 上传处理器
+type UploadHandler struct {
+	service *UploadService
+}
+
+// NewUploadHandler 创建上传处理器
+func NewUploadHandler(service *UploadService) *UploadHandler {
+	return &UploadHandler{
+		service: service,
+	}
+}
+
+// CreateUpload 上传图片（multipart/form-data，字段名为file）
+func (h *UploadHandler) CreateUpload(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	upload, err := h.service.SaveUpload(auth.CurrentUserID(c), fileHeader.Filename, file)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnsupportedFileType):
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrFileTooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, models.UploadResponse{Upload: *upload, URL: "/api/uploads/" + upload.ID})
+}
+
+// GetUpload 获取上传的文件内容
+func (h *UploadHandler) GetUpload(c *gin.Context) {
+	upload, fullPath, err := h.service.GetUpload(c.Param("id"), auth.CurrentUserID(c))
+	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", upload.MimeType)
+	c.File(fullPath)
+}
+
+// DeleteUpload 删除上传的文件
+func (h *UploadHandler) DeleteUpload(c *gin.Context) {
+	err := h.service.DeleteUpload(c.Param("id"), auth.CurrentUserID(c))
+	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
@@ -0,0 +1,190 @@
+package upload
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"grandma/backend/models"
+	"grandma/backend/repository"
+	"grandma/backend/utils"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrUnsupportedFileType 不支持的文件类型（只支持PNG、JPEG、GIF和WebP图片）
+	ErrUnsupportedFileType = errors.New("unsupported_file_type")
+	// ErrFileTooLarge 文件超过大小限制
+	ErrFileTooLarge = errors.New("file_too_large")
+	// ErrInvalidContentPart 消息内容片段不合法
+	ErrInvalidContentPart = errors.New("invalid_content_part")
+)
+
+// imageExtensions 支持的图片类型及其扩展名
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// UploadConfig 上传配置
+type UploadConfig struct {
+	Dir      string // 本地存储目录
+	MaxBytes int64  // 单个文件的最大字节数
+}
+
+// UploadService 上传服务：保存用户上传的图片，并在发送给服务商之前解析消息中引用的图片
+type UploadService struct {
+	uploadRepo *repository.UploadRepository
+	config     *UploadConfig
+}
+
+// NewUploadService 创建上传服务
+func NewUploadService(uploadRepo *repository.UploadRepository, config *UploadConfig) *UploadService {
+	if config.Dir == "" {
+		config.Dir = "uploads"
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = 10 << 20
+	}
+	return &UploadService{
+		uploadRepo: uploadRepo,
+		config:     config,
+	}
+}
+
+// SaveUpload 校验并保存上传的图片，文件类型根据内容判断而不是扩展名
+func (s *UploadService) SaveUpload(userID, fileName string, reader io.Reader) (*models.Upload, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, s.config.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.config.MaxBytes {
+		return nil, ErrFileTooLarge
+	}
+
+	mimeType := http.DetectContentType(data)
+	ext, ok := imageExtensions[mimeType]
+	if !ok {
+		return nil, ErrUnsupportedFileType
+	}
+
+	upload := &models.Upload{
+		ID:       utils.GenerateUploadID(),
+		UserID:   userID,
+		FileName: filepath.Base(fileName),
+		MimeType: mimeType,
+		Size:     int64(len(data)),
+	}
+	upload.Path = filepath.Join(userID, upload.ID+ext)
+
+	fullPath := filepath.Join(s.config.Dir, upload.Path)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(fullPath, data, 0o644); err != nil {
+		return nil, err
+	}
+	if err := s.uploadRepo.Create(upload); err != nil {
+		os.Remove(fullPath)
+		return nil, err
+	}
+	return upload, nil
+}
+
+// GetUpload 获取上传记录和文件的本地路径
+func (s *UploadService) GetUpload(id, userID string) (*models.Upload, string, error) {
+	upload, err := s.uploadRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	return upload, filepath.Join(s.config.Dir, upload.Path), nil
+}
+
+// DeleteUpload 删除上传记录和文件，已保存的消息中对该文件的引用保持不变
+func (s *UploadService) DeleteUpload(id, userID string) error {
+	upload, fullPath, err := s.GetUpload(id, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.uploadRepo.DeleteByIDAndUserID(upload.ID, userID); err != nil {
+		return err
+	}
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ResolveParts 校验消息中的内容片段，并把引用的上传图片读取为base64
+// 只有文本片段时合并到Content；Content为空时用文本片段填充，便于保存和RAG检索
+func (s *UploadService) ResolveParts(userID string, messages []models.Message) ([]models.Message, error) {
+	resolved := make([]models.Message, len(messages))
+	for i, msg := range messages {
+		resolved[i] = msg
+		if len(msg.Parts) == 0 {
+			continue
+		}
+
+		parts := make([]models.ContentPart, len(msg.Parts))
+		var texts []string
+		for j, part := range msg.Parts {
+			switch part.Type {
+			case models.PartText:
+				texts = append(texts, part.Text)
+			case models.PartImage:
+				if err := s.resolveImage(userID, &part); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidContentPart, part.Type)
+			}
+			parts[j] = part
+		}
+		resolved[i].Parts = parts
+		if strings.TrimSpace(msg.Content) == "" {
+			resolved[i].Content = strings.Join(texts, "\n")
+		}
+	}
+	return resolved, nil
+}
+
+// resolveImage 读取上传图片，或校验图片URL
+func (s *UploadService) resolveImage(userID string, part *models.ContentPart) error {
+	switch {
+	case part.UploadID != "" && part.URL != "":
+		return fmt.Errorf("%w: image needs either upload_id or url", ErrInvalidContentPart)
+	case part.UploadID != "":
+		upload, fullPath, err := s.GetUpload(part.UploadID, userID)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(fullPath)
+		if err != nil {
+			return err
+		}
+		part.MimeType = upload.MimeType
+		part.Data = base64.StdEncoding.EncodeToString(data)
+		return nil
+	case strings.HasPrefix(part.URL, "https://") || strings.HasPrefix(part.URL, "http://"):
+		return nil
+	default:
+		return fmt.Errorf("%w: image needs upload_id or an http(s) url", ErrInvalidContentPart)
+	}
+}
+
+// Attachments 消息中的图片片段（不含base64内容），用于保存到文档
+func Attachments(msg models.Message) []models.ContentPart {
+	var attachments []models.ContentPart
+	for _, part := range msg.Parts {
+		if part.Type == models.PartImage {
+			part.Data = ""
+			attachments = append(attachments, part)
+		}
+	}
+	return attachments
+}
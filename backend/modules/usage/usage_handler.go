@@ -0,0 +1,90 @@
+package usage
+
+import (
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/repository"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UsageHandler 用量处理器
+type UsageHandler struct {
+	service *UsageService
+}
+
+// NewUsageHandler 创建用量处理器
+func NewUsageHandler(service *UsageService) *UsageHandler {
+	return &UsageHandler{
+		service: service,
+	}
+}
+
+// GetUsageSummary 获取当前用户的当日和当月用量汇总
+func (h *UsageHandler) GetUsageSummary(c *gin.Context) {
+	summary, err := h.service.GetSummary(auth.CurrentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// GetUsageEvents 分页获取当前用户的用量明细
+func (h *UsageHandler) GetUsageEvents(c *gin.Context) {
+	var req models.UsageEventListRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.service.ListEvents(auth.CurrentUserID(c), req.Page, req.PageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetUserUsage 管理员查看指定用户的用量汇总
+func (h *UsageHandler) GetUserUsage(c *gin.Context) {
+	summary, err := h.service.GetUserSummary(c.Param("user_id"))
+	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// UpdateUserQuota 管理员设置指定用户的配额
+func (h *UsageHandler) UpdateUserQuota(c *gin.Context) {
+	var req models.UpdateUsageQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	quota, err := h.service.SetQuota(c.Param("user_id"), req.DailyTokens, req.MonthlyTokens)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidQuota):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case repository.IsNotFound(err):
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, quota)
+}
@@ -0,0 +1,407 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grandma/backend/models"
+	"grandma/backend/repository"
+	"grandma/backend/services"
+	"grandma/backend/utils"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	// ErrQuotaExceeded 用户已达到Token配额上限
+	ErrQuotaExceeded = errors.New("quota_exceeded")
+	// ErrInvalidQuota 配额不能为负数
+	ErrInvalidQuota = errors.New("invalid_quota")
+)
+
+// 用量记录的功能标签
+const (
+	FeatureChat       = "chat"       // 普通对话
+	FeatureWorkChat   = "work_chat"  // 灵感模式创作
+	FeatureTitle      = "title"      // 标题生成
+	FeatureEmbedding  = "embedding"  // RAG向量化（索引和检索）
+	FeatureStyle      = "style"      // 文风分析
+	FeatureTransform  = "transform"  // 正文片段的AI编辑
+	FeatureContinue   = "continue"   // 正文续写和补写
+	FeatureContinuity = "continuity" // 设定一致性检查
+	FeatureExtraction = "extraction" // 设定自动提取
+)
+
+// UsageService 用量计量与配额服务
+type UsageService struct {
+	usageRepo *repository.UsageRepository
+	userRepo  *repository.UserRepository
+	config    *UsageConfig
+}
+
+// UsageConfig 用量配置
+type UsageConfig struct {
+	DailyTokenQuota   int64                        // 默认每日Token上限（0表示不限制）
+	MonthlyTokenQuota int64                        // 默认每月Token上限（0表示不限制）
+	Pricing           map[string]models.ModelPrice // 模型单价，用于估算费用
+}
+
+// NewUsageService 创建用量服务
+func NewUsageService(usageRepo *repository.UsageRepository, userRepo *repository.UserRepository, config *UsageConfig) *UsageService {
+	return &UsageService{
+		usageRepo: usageRepo,
+		userRepo:  userRepo,
+		config:    config,
+	}
+}
+
+// Record 记录一次模型调用的用量
+// 服务商未返回用量时，根据输入和输出文本估算Token数
+func (s *UsageService) Record(userID, feature string, usage services.Usage, input, output string) error {
+	estimated := false
+	if usage.InputTokens == 0 && usage.OutputTokens == 0 {
+		usage.InputTokens = EstimateTokens(input)
+		usage.OutputTokens = EstimateTokens(output)
+		estimated = true
+	}
+	if usage.Model == "" {
+		usage.Model = "unknown"
+	}
+
+	return s.usageRepo.CreateEvent(&models.UsageEvent{
+		ID:           utils.GenerateID(),
+		UserID:       userID,
+		Feature:      feature,
+		Model:        usage.Model,
+		InputTokens:  usage.InputTokens,
+		OutputTokens: usage.OutputTokens,
+		Estimated:    estimated,
+		CostUSD:      s.estimateCost(usage),
+	})
+}
+
+// CheckQuota 检查用户是否还有剩余配额，在调用服务商之前执行
+func (s *UsageService) CheckQuota(userID string) error {
+	daily, monthly, err := s.limits(userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	checks := []struct {
+		period string
+		limit  int64
+		since  time.Time
+	}{
+		{"daily", daily, startOfDay(now)},
+		{"monthly", monthly, startOfMonth(now)},
+	}
+	for _, check := range checks {
+		if check.limit <= 0 {
+			continue
+		}
+		used, err := s.usageRepo.SumTokensSince(userID, check.since)
+		if err != nil {
+			return err
+		}
+		if used >= check.limit {
+			return fmt.Errorf("%w: %s token quota of %d reached", ErrQuotaExceeded, check.period, check.limit)
+		}
+	}
+	return nil
+}
+
+// GetSummary 获取用户当日和当月的用量汇总
+func (s *UsageService) GetSummary(userID string) (*models.UsageSummaryResponse, error) {
+	daily, monthly, err := s.limits(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	dailySummary, err := s.summarize(userID, startOfDay(now), daily)
+	if err != nil {
+		return nil, err
+	}
+	monthlySummary, err := s.summarize(userID, startOfMonth(now), monthly)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.UsageSummaryResponse{
+		UserID:  userID,
+		Daily:   *dailySummary,
+		Monthly: *monthlySummary,
+	}, nil
+}
+
+// GetUserSummary 管理员查看指定用户的用量汇总
+func (s *UsageService) GetUserSummary(userID string) (*models.UsageSummaryResponse, error) {
+	if _, err := s.userRepo.GetByID(userID); err != nil {
+		return nil, err
+	}
+	return s.GetSummary(userID)
+}
+
+// ListEvents 分页获取用户的用量明细
+func (s *UsageService) ListEvents(userID string, page, pageSize int) (*models.UsageEventListResponse, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 50
+	}
+
+	events, total, err := s.usageRepo.ListEventsByUserID(userID, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.UsageEventListResponse{
+		Events:   events,
+		Total:    int(total),
+		Page:     page,
+		PageSize: pageSize,
+	}, nil
+}
+
+// SetQuota 设置用户配额（字段为空表示使用默认配额）
+func (s *UsageService) SetQuota(userID string, dailyTokens, monthlyTokens *int64) (*models.UsageQuota, error) {
+	if (dailyTokens != nil && *dailyTokens < 0) || (monthlyTokens != nil && *monthlyTokens < 0) {
+		return nil, ErrInvalidQuota
+	}
+	if _, err := s.userRepo.GetByID(userID); err != nil {
+		return nil, err
+	}
+
+	quota := &models.UsageQuota{
+		UserID:        userID,
+		DailyTokens:   dailyTokens,
+		MonthlyTokens: monthlyTokens,
+	}
+	if err := s.usageRepo.UpsertQuota(quota); err != nil {
+		return nil, err
+	}
+	return quota, nil
+}
+
+// Meter 包装服务提供者，在每次调用结束后记录用量
+func (s *UsageService) Meter(provider services.ChatProvider, userID, feature string) services.ChatProvider {
+	return &meteredProvider{
+		provider: provider,
+		service:  s,
+		userID:   userID,
+		feature:  feature,
+	}
+}
+
+// summarize 汇总某个周期内的用量
+func (s *UsageService) summarize(userID string, since time.Time, limit int64) (*models.UsagePeriodSummary, error) {
+	breakdown, err := s.usageRepo.SummarizeSince(userID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &models.UsagePeriodSummary{
+		Since:     since,
+		Limit:     limit,
+		Breakdown: breakdown,
+	}
+	for _, item := range breakdown {
+		summary.InputTokens += item.InputTokens
+		summary.OutputTokens += item.OutputTokens
+		summary.CostUSD += item.CostUSD
+	}
+	summary.TotalTokens = summary.InputTokens + summary.OutputTokens
+
+	summary.Remaining = -1
+	if limit > 0 {
+		summary.Remaining = limit - summary.TotalTokens
+		if summary.Remaining < 0 {
+			summary.Remaining = 0
+		}
+	}
+	return summary, nil
+}
+
+// limits 获取用户生效的每日和每月配额
+func (s *UsageService) limits(userID string) (int64, int64, error) {
+	daily, monthly := s.config.DailyTokenQuota, s.config.MonthlyTokenQuota
+
+	quota, err := s.usageRepo.GetQuota(userID)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return daily, monthly, nil
+		}
+		return 0, 0, err
+	}
+	if quota.DailyTokens != nil {
+		daily = *quota.DailyTokens
+	}
+	if quota.MonthlyTokens != nil {
+		monthly = *quota.MonthlyTokens
+	}
+	return daily, monthly, nil
+}
+
+// estimateCost 根据模型单价估算费用
+func (s *UsageService) estimateCost(usage services.Usage) float64 {
+	price, ok := s.config.Pricing[usage.Model]
+	if !ok {
+		return 0
+	}
+	return float64(usage.InputTokens)*price.InputPerMillion/1e6 + float64(usage.OutputTokens)*price.OutputPerMillion/1e6
+}
+
+// EstimateTokens 粗略估算文本的Token数：中日韩字符按每字1个，其他字符按每4个1个
+func EstimateTokens(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// ParsePricing 解析模型单价配置，格式为 "模型=输入单价/输出单价,..."（美元/百万Token）
+func ParsePricing(spec string) (map[string]models.ModelPrice, error) {
+	pricing := make(map[string]models.ModelPrice)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		model, prices, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid pricing entry: %q", item)
+		}
+		inputPrice, outputPrice, ok := strings.Cut(prices, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid pricing entry: %q", item)
+		}
+		input, err := strconv.ParseFloat(strings.TrimSpace(inputPrice), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pricing entry: %q", item)
+		}
+		output, err := strconv.ParseFloat(strings.TrimSpace(outputPrice), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pricing entry: %q", item)
+		}
+		pricing[strings.TrimSpace(model)] = models.ModelPrice{InputPerMillion: input, OutputPerMillion: output}
+	}
+	return pricing, nil
+}
+
+// meteredProvider 记录用量的服务提供者包装
+type meteredProvider struct {
+	provider services.ChatProvider
+	service  *UsageService
+	userID   string
+	feature  string
+}
+
+func (m *meteredProvider) ChatStream(ctx context.Context, messages []models.Message, writer io.Writer) error {
+	collector := &outputCollector{writer: writer}
+	err := m.provider.ChatStream(ctx, messages, collector)
+	m.record(messages, collector.output.String(), err)
+	return err
+}
+
+// ChatStreamWithTools 携带工具声明的流式聊天，工具调用的参数同样计入输出
+func (m *meteredProvider) ChatStreamWithTools(ctx context.Context, messages []models.Message, tools []services.ToolDefinition, writer io.Writer) ([]models.ToolCall, error) {
+	collector := &outputCollector{writer: writer}
+	calls, err := services.ChatStreamWithTools(ctx, m.provider, messages, tools, collector)
+	for _, call := range calls {
+		collector.output.WriteString(call.Arguments)
+	}
+	m.record(messages, collector.output.String(), err)
+	return calls, err
+}
+
+func (m *meteredProvider) Chat(ctx context.Context, messages []models.Message) (string, error) {
+	reply, err := m.provider.Chat(ctx, messages)
+	m.record(messages, reply, err)
+	return reply, err
+}
+
+// ChatJSONMode 结构化输出，被包装的服务提供者不支持原生JSON模式时使用普通聊天
+func (m *meteredProvider) ChatJSONMode(ctx context.Context, messages []models.Message, schema services.JSONSchema) (string, error) {
+	var reply string
+	var err error
+	if native, ok := m.provider.(services.JSONModeProvider); ok {
+		reply, err = native.ChatJSONMode(ctx, messages, schema)
+	} else {
+		reply, err = m.provider.Chat(ctx, messages)
+	}
+	m.record(messages, reply, err)
+	return reply, err
+}
+
+// ActiveBackend 实际响应的后端（被包装的服务提供者支持时）
+func (m *meteredProvider) ActiveBackend() string {
+	if reporter, ok := m.provider.(services.BackendReporter); ok {
+		return reporter.ActiveBackend()
+	}
+	return ""
+}
+
+// LastUsage 被包装的服务提供者最近一次调用的用量
+func (m *meteredProvider) LastUsage() services.Usage {
+	if reporter, ok := m.provider.(services.UsageReporter); ok {
+		return reporter.LastUsage()
+	}
+	return services.Usage{}
+}
+
+// record 记录本次调用的用量，请求失败且没有任何输出时不记录
+func (m *meteredProvider) record(messages []models.Message, output string, callErr error) {
+	var usage services.Usage
+	if reporter, ok := m.provider.(services.UsageReporter); ok {
+		usage = reporter.LastUsage()
+	}
+	if callErr != nil && output == "" && usage.InputTokens == 0 && usage.OutputTokens == 0 {
+		return
+	}
+
+	var input strings.Builder
+	for _, msg := range messages {
+		input.WriteString(msg.Content)
+	}
+	if err := m.service.Record(m.userID, m.feature, usage, input.String(), output); err != nil {
+		log.Printf("Failed to record usage for user %s: %v", m.userID, err)
+	}
+}
+
+// outputCollector 转发流式输出并保留一份副本用于估算用量
+type outputCollector struct {
+	writer io.Writer
+	output strings.Builder
+}
+
+func (c *outputCollector) Write(p []byte) (int, error) {
+	c.output.Write(p)
+	return c.writer.Write(p)
+}
+
+// WriteReasoning 转发推理内容，推理同样消耗输出Token
+func (c *outputCollector) WriteReasoning(p []byte) (int, error) {
+	c.output.Write(p)
+	return len(p), services.WriteReasoning(c.writer, string(p))
+}
+
+// startOfDay 当天零点
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// startOfMonth 当月1日零点
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
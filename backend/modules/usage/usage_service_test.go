@@ -0,0 +1,39 @@
+package usage
+
+import (
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	cases := map[string]int{
+		"":         0,
+		"abcd":     1,
+		"abcde":    2,
+		"你好":       2,
+		"你好 world": 4,
+	}
+	for text, want := range cases {
+		if got := EstimateTokens(text); got != want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", text, got, want)
+		}
+	}
+}
+
+func TestParsePricing(t *testing.T) {
+	pricing, err := ParsePricing("deepseek-chat=0.27/1.10, text-embedding-v4 = 0.07/0")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if p := pricing["deepseek-chat"]; p.InputPerMillion != 0.27 || p.OutputPerMillion != 1.10 {
+		t.Errorf("deepseek-chat price: %+v", p)
+	}
+	if p := pricing["text-embedding-v4"]; p.InputPerMillion != 0.07 || p.OutputPerMillion != 0 {
+		t.Errorf("embedding price: %+v", p)
+	}
+
+	for _, bad := range []string{"model", "model=1", "model=x/1"} {
+		if _, err := ParsePricing(bad); err == nil {
+			t.Errorf("ParsePricing(%q) should fail", bad)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package work
 
 import (
+	"errors"
 	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/repository"
+	"grandma/backend/utils"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -21,13 +25,7 @@ func NewWorkHandler(service *WorkService) *WorkHandler {
 
 // GetWorkList 获取创作列表
 func (h *WorkHandler) GetWorkList(c *gin.Context) {
-	var req models.WorkRequest
-	if err := c.ShouldBindQuery(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	response, err := h.service.GetWorkList(req.UserID)
+	response, err := h.service.GetWorkList(auth.CurrentUserID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -44,7 +42,7 @@ func (h *WorkHandler) CreateWork(c *gin.Context) {
 		return
 	}
 
-	work, err := h.service.CreateWork(req.UserID, req.Title)
+	work, err := h.service.CreateWork(auth.CurrentUserID(c), req.Title)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -62,7 +60,11 @@ func (h *WorkHandler) UpdateWorkTitle(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.UpdateWorkTitle(id, req.UserID, req.Title); err != nil {
+	if err := h.service.UpdateWorkTitle(id, auth.CurrentUserID(c), req.Title); err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Work not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -73,14 +75,11 @@ func (h *WorkHandler) UpdateWorkTitle(c *gin.Context) {
 // DeleteWork 删除创作
 func (h *WorkHandler) DeleteWork(c *gin.Context) {
 	id := c.Param("id")
-	userID := c.Query("user_id")
-
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
-		return
-	}
-
-	if err := h.service.DeleteWork(id, userID); err != nil {
+	if err := h.service.DeleteWork(id, auth.CurrentUserID(c)); err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Work not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -91,15 +90,90 @@ func (h *WorkHandler) DeleteWork(c *gin.Context) {
 // GetWorkDocuments 获取创作的所有文档
 func (h *WorkHandler) GetWorkDocuments(c *gin.Context) {
 	workID := c.Param("work_id")
-	userID := c.Query("user_id")
+	response, err := h.service.GetWorkDocuments(workID, auth.CurrentUserID(c))
+	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Work not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetWorkMessages 获取创作的灵感模式对话消息
+func (h *WorkHandler) GetWorkMessages(c *gin.Context) {
+	response, err := h.service.GetWorkMessages(c.Param("work_id"), auth.CurrentUserID(c))
+	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Work not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// PromoteWorkMessage 把助手回答加入正文
+func (h *WorkHandler) PromoteWorkMessage(c *gin.Context) {
+	var req models.PromoteWorkChatMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	doc, err := h.service.PromoteWorkMessage(c.Param("id"), auth.CurrentUserID(c), &req, version)
+	if err != nil {
+		if writeConflict(c, err) {
+			return
+		}
+		switch {
+		case repository.IsNotFound(err):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message or document not found"})
+		case errors.Is(err, ErrNotPromotable), errors.Is(err, ErrDocumentNotInWork):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
 
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+	c.Header("ETag", utils.FormatETag(doc.Version))
+	c.JSON(http.StatusOK, doc)
+}
+
+// DeleteWorkMessage 删除灵感模式对话消息
+func (h *WorkHandler) DeleteWorkMessage(c *gin.Context) {
+	if err := h.service.DeleteWorkMessage(c.Param("id"), auth.CurrentUserID(c)); err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	response, err := h.service.GetWorkDocuments(workID, userID)
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// GetStoryBible 获取创作的设定集
+func (h *WorkHandler) GetStoryBible(c *gin.Context) {
+	workID := c.Param("work_id")
+	response, err := h.service.GetStoryBible(workID, auth.CurrentUserID(c))
 	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Work not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -115,12 +189,17 @@ func (h *WorkHandler) CreateWorkDocument(c *gin.Context) {
 		return
 	}
 
-	doc, err := h.service.CreateWorkDocument(req.UserID, req.WorkID, req.Title, req.Content)
+	doc, err := h.service.CreateWorkDocument(auth.CurrentUserID(c), req.WorkID, req.Title, req.Content)
 	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Work not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	c.Header("ETag", utils.FormatETag(doc.Version))
 	c.JSON(http.StatusOK, doc)
 }
 
@@ -133,12 +212,26 @@ func (h *WorkHandler) UpdateWorkDocumentTitle(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.UpdateWorkDocumentTitle(id, req.UserID, req.Title); err != nil {
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	doc, err := h.service.UpdateWorkDocumentTitle(id, auth.CurrentUserID(c), req.Title, version)
+	if err != nil {
+		if writeConflict(c, err) {
+			return
+		}
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "updated"})
+	c.Header("ETag", utils.FormatETag(doc.Version))
+	c.JSON(http.StatusOK, gin.H{"message": "updated", "version": doc.Version})
 }
 
 // UpdateWorkDocumentContent 更新文档内容
@@ -150,25 +243,44 @@ func (h *WorkHandler) UpdateWorkDocumentContent(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.UpdateWorkDocumentContent(id, req.UserID, req.Content); err != nil {
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	doc, err := h.service.UpdateWorkDocumentContent(id, auth.CurrentUserID(c), req.Content, version)
+	if err != nil {
+		if writeConflict(c, err) {
+			return
+		}
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "updated"})
+	c.Header("ETag", utils.FormatETag(doc.Version))
+	c.JSON(http.StatusOK, gin.H{"message": "updated", "version": doc.Version})
 }
 
 // DeleteWorkDocument 删除文档
 func (h *WorkHandler) DeleteWorkDocument(c *gin.Context) {
 	id := c.Param("id")
-	userID := c.Query("user_id")
-
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
+	version, ok := ifMatchVersion(c)
+	if !ok {
 		return
 	}
 
-	if err := h.service.DeleteWorkDocument(id, userID); err != nil {
+	if err := h.service.DeleteWorkDocument(id, auth.CurrentUserID(c), version); err != nil {
+		if writeConflict(c, err) {
+			return
+		}
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -179,19 +291,37 @@ func (h *WorkHandler) DeleteWorkDocument(c *gin.Context) {
 // GetWorkDocumentByID 根据ID获取文档
 func (h *WorkHandler) GetWorkDocumentByID(c *gin.Context) {
 	id := c.Param("id")
-	userID := c.Query("user_id")
-
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
-		return
-	}
-
-	doc, err := h.service.GetWorkDocumentByID(id, userID)
+	doc, err := h.service.GetWorkDocumentByID(id, auth.CurrentUserID(c))
 	if err != nil {
+		if repository.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	c.Header("ETag", utils.FormatETag(doc.Version))
 	c.JSON(http.StatusOK, doc)
 }
 
+// ifMatchVersion 读取If-Match请求头中的文档版本号，格式不正确时返回400
+func ifMatchVersion(c *gin.Context) (int64, bool) {
+	version, err := utils.ParseIfMatch(c.GetHeader("If-Match"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	return version, true
+}
+
+// writeConflict 文档写入冲突时返回409和服务器上的当前文档
+func writeConflict(c *gin.Context, err error) bool {
+	var conflict *repository.WriteConflictError
+	if !errors.As(err, &conflict) {
+		return false
+	}
+	c.Header("ETag", utils.FormatETag(conflict.Document.Version))
+	c.JSON(http.StatusConflict, models.WorkDocumentConflictResponse{Error: err.Error(), Document: *conflict.Document})
+	return true
+}
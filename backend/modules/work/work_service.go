@@ -1,22 +1,63 @@
 package work
 
 import (
+	"errors"
 	"grandma/backend/models"
+	"grandma/backend/modules/extraction"
+	"grandma/backend/modules/rag"
+	"grandma/backend/modules/revision"
 	"grandma/backend/repository"
 	"grandma/backend/utils"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// promotedTitleRunes 加入正文时由回答第一行生成的标题最大字符数
+const promotedTitleRunes = 30
+
+var (
+	// ErrNotPromotable 只有内容非空的助手回答才能加入正文
+	ErrNotPromotable = errors.New("not_promotable")
+	// ErrDocumentNotInWork 目标文档不属于该消息所在的创作
+	ErrDocumentNotInWork = errors.New("document_not_in_work")
 )
 
 // WorkService 创作服务
 type WorkService struct {
 	workRepo         *repository.WorkRepository
 	workDocumentRepo *repository.WorkDocumentRepository
+	workMessageRepo  *repository.WorkChatMessageRepository
+	vectorChunkRepo  *repository.VectorChunkRepository
+	storyBibleRepo   *repository.StoryBibleRepository
+	promptRepo       *repository.PromptTemplateRepository
+	outlineRepo      *repository.OutlineRepository
+	patchRepo        *repository.DocumentPatchRepository
+	continuityRepo   *repository.ContinuityRepository
+	proposalRepo     *repository.BibleProposalRepository
+	timelineRepo     *repository.TimelineRepository
+	revisionSvc      *revision.RevisionService
+	extractionSvc    *extraction.ExtractionService
+	ragSvc           *rag.RAGService
 }
 
 // NewWorkService 创建创作服务
-func NewWorkService(workRepo *repository.WorkRepository, workDocumentRepo *repository.WorkDocumentRepository) *WorkService {
+func NewWorkService(workRepo *repository.WorkRepository, workDocumentRepo *repository.WorkDocumentRepository, workMessageRepo *repository.WorkChatMessageRepository, vectorChunkRepo *repository.VectorChunkRepository, storyBibleRepo *repository.StoryBibleRepository, promptRepo *repository.PromptTemplateRepository, outlineRepo *repository.OutlineRepository, patchRepo *repository.DocumentPatchRepository, continuityRepo *repository.ContinuityRepository, proposalRepo *repository.BibleProposalRepository, timelineRepo *repository.TimelineRepository, revisionSvc *revision.RevisionService, extractionSvc *extraction.ExtractionService, ragSvc *rag.RAGService) *WorkService {
 	return &WorkService{
 		workRepo:         workRepo,
 		workDocumentRepo: workDocumentRepo,
+		workMessageRepo:  workMessageRepo,
+		vectorChunkRepo:  vectorChunkRepo,
+		storyBibleRepo:   storyBibleRepo,
+		promptRepo:       promptRepo,
+		outlineRepo:      outlineRepo,
+		patchRepo:        patchRepo,
+		continuityRepo:   continuityRepo,
+		proposalRepo:     proposalRepo,
+		timelineRepo:     timelineRepo,
+		revisionSvc:      revisionSvc,
+		extractionSvc:    extractionSvc,
+		ragSvc:           ragSvc,
 	}
 }
 
@@ -53,13 +94,60 @@ func (s *WorkService) UpdateWorkTitle(id, userID, title string) error {
 	return s.workRepo.UpdateTitleByIDAndUserID(id, userID, title)
 }
 
-// DeleteWork 删除创作
+// DeleteWork 删除创作（级联删除创作文档及其修订、对话消息、向量chunks、设定集、提示词模板和大纲）
 func (s *WorkService) DeleteWork(id, userID string) error {
-	return s.workRepo.DeleteByIDAndUserID(id, userID)
+	// 先验证创作属于该用户
+	work, err := s.workRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return err
+	}
+
+	// 在同一个事务中删除创作及其所有关联数据，中途失败时不会留下孤立的数据
+	return s.workRepo.Transaction(func(tx *gorm.DB) error {
+		if err := s.vectorChunkRepo.WithTx(tx).DeleteByWorkID(work.ID); err != nil {
+			return err
+		}
+		if err := s.workDocumentRepo.WithTx(tx).DeleteByWorkIDAndUserID(work.ID, userID); err != nil {
+			return err
+		}
+		if err := s.workMessageRepo.WithTx(tx).DeleteByWorkIDAndUserID(work.ID, userID); err != nil {
+			return err
+		}
+		if err := s.revisionSvc.WithTx(tx).DeleteWorkHistory(work.ID, userID); err != nil {
+			return err
+		}
+		if err := s.storyBibleRepo.WithTx(tx).DeleteByWorkIDAndUserID(work.ID, userID); err != nil {
+			return err
+		}
+		if err := s.promptRepo.WithTx(tx).DeleteByWorkIDAndUserID(work.ID, userID); err != nil {
+			return err
+		}
+		if err := s.outlineRepo.WithTx(tx).DeleteByWorkIDAndUserID(work.ID, userID); err != nil {
+			return err
+		}
+		if err := s.patchRepo.WithTx(tx).DeleteByWorkIDAndUserID(work.ID, userID); err != nil {
+			return err
+		}
+		if err := s.continuityRepo.WithTx(tx).DeleteByWorkIDAndUserID(work.ID, userID); err != nil {
+			return err
+		}
+		if err := s.proposalRepo.WithTx(tx).DeleteByWorkIDAndUserID(work.ID, userID); err != nil {
+			return err
+		}
+		if err := s.timelineRepo.WithTx(tx).DeleteByWorkIDAndUserID(work.ID, userID); err != nil {
+			return err
+		}
+		return s.workRepo.WithTx(tx).DeleteByIDAndUserID(work.ID, userID)
+	})
 }
 
 // GetWorkDocuments 获取创作的所有文档
 func (s *WorkService) GetWorkDocuments(workID, userID string) (*models.WorkDocumentResponse, error) {
+	// 先验证创作属于该用户
+	if _, err := s.workRepo.GetByIDAndUserID(workID, userID); err != nil {
+		return nil, err
+	}
+
 	docs, err := s.workDocumentRepo.GetByWorkIDAndUserID(workID, userID)
 	if err != nil {
 		return nil, err
@@ -71,8 +159,134 @@ func (s *WorkService) GetWorkDocuments(workID, userID string) (*models.WorkDocum
 	}, nil
 }
 
+// GetWorkMessages 获取创作的灵感模式对话消息
+func (s *WorkService) GetWorkMessages(workID, userID string) (*models.WorkChatMessageResponse, error) {
+	// 先验证创作属于该用户
+	if _, err := s.workRepo.GetByIDAndUserID(workID, userID); err != nil {
+		return nil, err
+	}
+
+	messages, err := s.workMessageRepo.GetByWorkIDAndUserID(workID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.WorkChatMessageResponse{
+		Messages: messages,
+		Total:    len(messages),
+	}, nil
+}
+
+// PromoteWorkMessage 把助手回答复制到正文：新建一篇文档，或追加到已有文档末尾（version大于0时要求目标文档为该版本）
+func (s *WorkService) PromoteWorkMessage(id, userID string, req *models.PromoteWorkChatMessageRequest, version int64) (*models.WorkDocument, error) {
+	message, err := s.workMessageRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	content := strings.TrimSpace(message.Content)
+	if message.Role != "assistant" || content == "" {
+		return nil, ErrNotPromotable
+	}
+
+	var doc *models.WorkDocument
+	if req.DocumentID != "" {
+		doc, err = s.workDocumentRepo.GetByIDAndUserID(req.DocumentID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if doc.WorkID != message.WorkID {
+			return nil, ErrDocumentNotInWork
+		}
+		if err := repository.CheckVersion(doc, version); err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(doc.Content) != "" {
+			content = "\n\n" + content
+		}
+		before := doc.Content
+		err = s.workRepo.Transaction(func(tx *gorm.DB) error {
+			docRepo := s.workDocumentRepo.WithTx(tx)
+			if err := docRepo.AppendContentByIDAndUserID(doc.ID, userID, content, doc.Version); err != nil {
+				return err
+			}
+			if doc, err = docRepo.GetByIDAndUserID(doc.ID, userID); err != nil {
+				return err
+			}
+			_, err := s.revisionSvc.WithTx(tx).RecordChange(userID, documentTarget(doc), models.RevisionAuthorModel, message.Model, before, doc.Content)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		title := strings.TrimSpace(req.Title)
+		if title == "" {
+			title = utils.TruncateRunes(strings.TrimSpace(strings.SplitN(content, "\n", 2)[0]), promotedTitleRunes, "…")
+		}
+		if doc, err = s.createWorkDocument(userID, message.WorkID, title, content, models.RevisionAuthorModel, message.Model); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.workMessageRepo.UpdatePromotedDocumentByIDAndUserID(message.ID, userID, doc.ID); err != nil {
+		return nil, err
+	}
+	s.extractionSvc.ScheduleWorkDocument(doc.ID, userID)
+	s.ragSvc.IndexWorkDocument(doc.ID, userID)
+	return doc, nil
+}
+
+// DeleteWorkMessage 删除灵感模式对话消息（级联删除向量chunks）
+func (s *WorkService) DeleteWorkMessage(id, userID string) error {
+	message, err := s.workMessageRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.vectorChunkRepo.DeleteByDocumentID(message.ID); err != nil {
+		return err
+	}
+	return s.workMessageRepo.DeleteByIDAndUserID(message.ID, userID)
+}
+
+// GetStoryBible 获取创作的设定集
+func (s *WorkService) GetStoryBible(workID, userID string) (*models.StoryBibleResponse, error) {
+	// 先验证创作属于该用户
+	if _, err := s.workRepo.GetByIDAndUserID(workID, userID); err != nil {
+		return nil, err
+	}
+
+	entries, err := s.storyBibleRepo.GetByWorkIDAndUserID(workID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.StoryBibleResponse{
+		Entries: entries,
+		Total:   len(entries),
+	}, nil
+}
+
 // CreateWorkDocument 创建创作文档
 func (s *WorkService) CreateWorkDocument(userID, workID, title, content string) (*models.WorkDocument, error) {
+	doc, err := s.createWorkDocument(userID, workID, title, content, models.RevisionAuthorUser, "")
+	if err != nil {
+		return nil, err
+	}
+	if content != "" {
+		s.extractionSvc.ScheduleWorkDocument(doc.ID, userID)
+		s.ragSvc.IndexWorkDocument(doc.ID, userID)
+	}
+	return doc, nil
+}
+
+// createWorkDocument 创建创作文档，内容不为空时记录第一个修订
+func (s *WorkService) createWorkDocument(userID, workID, title, content, author, model string) (*models.WorkDocument, error) {
+	// 先验证创作属于该用户
+	if _, err := s.workRepo.GetByIDAndUserID(workID, userID); err != nil {
+		return nil, err
+	}
+
 	doc := &models.WorkDocument{
 		ID:      utils.GenerateID(),
 		WorkID:  workID,
@@ -81,26 +295,102 @@ func (s *WorkService) CreateWorkDocument(userID, workID, title, content string)
 		Content: content,
 	}
 
-	if err := s.workDocumentRepo.Create(doc); err != nil {
+	err := s.workRepo.Transaction(func(tx *gorm.DB) error {
+		if err := s.workDocumentRepo.WithTx(tx).Create(doc); err != nil {
+			return err
+		}
+		if content == "" {
+			return nil
+		}
+		_, err := s.revisionSvc.WithTx(tx).RecordChange(userID, documentTarget(doc), author, model, "", content)
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return doc, nil
 }
 
-// UpdateWorkDocumentTitle 更新文档标题
-func (s *WorkService) UpdateWorkDocumentTitle(id, userID, title string) error {
-	return s.workDocumentRepo.UpdateTitleByIDAndUserID(id, userID, title)
+// UpdateWorkDocumentTitle 更新文档标题（version大于0时要求文档为该版本），返回更新后的文档
+func (s *WorkService) UpdateWorkDocumentTitle(id, userID, title string, version int64) (*models.WorkDocument, error) {
+	doc, err := s.workDocumentRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := repository.CheckVersion(doc, version); err != nil {
+		return nil, err
+	}
+
+	if err := s.workDocumentRepo.UpdateTitleByIDAndUserID(doc.ID, userID, title, doc.Version); err != nil {
+		return nil, err
+	}
+	return s.workDocumentRepo.GetByIDAndUserID(doc.ID, userID)
+}
+
+// UpdateWorkDocumentContent 更新文档内容（version大于0时要求文档为该版本），并记录为新的修订
+func (s *WorkService) UpdateWorkDocumentContent(id, userID, content string, version int64) (*models.WorkDocument, error) {
+	doc, err := s.workDocumentRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := repository.CheckVersion(doc, version); err != nil {
+		return nil, err
+	}
+
+	// 按读取时的版本号写入，保证记录修订时的原内容就是被覆盖的内容；写入和记录修订在同一个事务中完成
+	err = s.workRepo.Transaction(func(tx *gorm.DB) error {
+		if err := s.workDocumentRepo.WithTx(tx).UpdateContentByIDAndUserID(doc.ID, userID, content, doc.Version); err != nil {
+			return err
+		}
+		_, err := s.revisionSvc.WithTx(tx).RecordChange(userID, documentTarget(doc), models.RevisionAuthorUser, "", doc.Content, content)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.extractionSvc.ScheduleWorkDocument(doc.ID, userID)
+	s.ragSvc.IndexWorkDocument(doc.ID, userID)
+	return s.workDocumentRepo.GetByIDAndUserID(doc.ID, userID)
 }
 
-// UpdateWorkDocumentContent 更新文档内容
-func (s *WorkService) UpdateWorkDocumentContent(id, userID, content string) error {
-	return s.workDocumentRepo.UpdateContentByIDAndUserID(id, userID, content)
+// documentTarget 创作文档对应的修订对象
+func documentTarget(doc *models.WorkDocument) revision.Target {
+	return revision.Target{Type: models.RevisionTargetWorkDocument, ID: doc.ID, WorkID: doc.WorkID}
 }
 
-// DeleteWorkDocument 删除文档
-func (s *WorkService) DeleteWorkDocument(id, userID string) error {
-	return s.workDocumentRepo.DeleteByIDAndUserID(id, userID)
+// DeleteWorkDocument 删除文档（version大于0时要求文档为该版本），级联删除向量chunks和修订，并取消大纲节点的关联
+func (s *WorkService) DeleteWorkDocument(id, userID string, version int64) error {
+	doc, err := s.workDocumentRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return err
+	}
+	if err := repository.CheckVersion(doc, version); err != nil {
+		return err
+	}
+
+	return s.workRepo.Transaction(func(tx *gorm.DB) error {
+		// 先删除文档本身，正在流式写入或已被修改时不删除任何关联数据
+		if err := s.workDocumentRepo.WithTx(tx).DeleteByIDAndUserID(doc.ID, userID, doc.Version); err != nil {
+			return err
+		}
+		if err := s.vectorChunkRepo.WithTx(tx).DeleteByDocumentID(doc.ID); err != nil {
+			return err
+		}
+		if err := s.outlineRepo.WithTx(tx).ClearDocumentByUserID(userID, doc.ID); err != nil {
+			return err
+		}
+		if err := s.patchRepo.WithTx(tx).DeleteByDocumentIDAndUserID(doc.ID, userID); err != nil {
+			return err
+		}
+		if err := s.continuityRepo.WithTx(tx).DeleteByDocumentIDAndUserID(doc.ID, userID); err != nil {
+			return err
+		}
+		if err := s.timelineRepo.WithTx(tx).ClearDocumentByUserID(userID, doc.ID); err != nil {
+			return err
+		}
+		return s.revisionSvc.WithTx(tx).DeleteHistory(userID, models.RevisionTargetWorkDocument, doc.ID)
+	})
 }
 
 // GetWorkDocumentByID 根据ID获取文档
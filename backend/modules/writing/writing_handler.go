@@ -0,0 +1,167 @@
+package writing
+
+import (
+	"encoding/json"
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	"grandma/backend/modules/credential"
+	"grandma/backend/modules/usage"
+	"grandma/backend/repository"
+	"grandma/backend/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WritingHandler 写作助手处理器
+type WritingHandler struct {
+	service *WritingService
+}
+
+// NewWritingHandler 创建写作助手处理器
+func NewWritingHandler(service *WritingService) *WritingHandler {
+	return &WritingHandler{
+		service: service,
+	}
+}
+
+// Transform 流式返回选中片段的替换文本，结束时以<GRANDMA_PATCH>标记返回修改建议
+func (h *WritingHandler) Transform(c *gin.Context) {
+	var req models.TransformRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	version, err := utils.ParseIfMatch(c.GetHeader("If-Match"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	writer := &flushWriter{writer: c.Writer}
+	patch, err := h.service.Transform(c.Param("id"), auth.CurrentUserID(c), &req, version, writer)
+	if err != nil {
+		writeStreamError(c, writer, err)
+		return
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return
+	}
+	writer.Write([]byte("\n\n<GRANDMA_PATCH>" + string(data) + "</GRANDMA_PATCH>"))
+}
+
+// Generate 续写或补写正文：生成的内容直接写入文档并流式返回，结束时以<GRANDMA_GENERATION>标记返回写入结果和撤销点
+func (h *WritingHandler) Generate(c *gin.Context) {
+	var req models.GenerateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	version, err := utils.ParseIfMatch(c.GetHeader("If-Match"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	writer := &flushWriter{writer: c.Writer}
+	result, err := h.service.Generate(c.Param("id"), auth.CurrentUserID(c), &req, version, writer)
+	if result != nil {
+		data, _ := json.Marshal(result)
+		writer.Write([]byte("\n\n<GRANDMA_GENERATION>" + string(data) + "</GRANDMA_GENERATION>"))
+	}
+	if err != nil {
+		writeStreamError(c, writer, err)
+	}
+}
+
+// ListPatches 获取文档待处理的修改建议
+func (h *WritingHandler) ListPatches(c *gin.Context) {
+	response, err := h.service.ListPatches(c.Param("id"), auth.CurrentUserID(c))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// AcceptPatch 接受修改建议
+func (h *WritingHandler) AcceptPatch(c *gin.Context) {
+	version, err := utils.ParseIfMatch(c.GetHeader("If-Match"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	doc, err := h.service.AcceptPatch(c.Param("id"), auth.CurrentUserID(c), version)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Header("ETag", utils.FormatETag(doc.Version))
+	c.JSON(http.StatusOK, doc)
+}
+
+// RejectPatch 拒绝修改建议
+func (h *WritingHandler) RejectPatch(c *gin.Context) {
+	if err := h.service.RejectPatch(c.Param("id"), auth.CurrentUserID(c)); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "rejected"})
+}
+
+// writeStreamError 还没有输出任何内容时按writeError返回，已经开始流式输出时无法再修改状态码，以<GRANDMA_ERROR>标记返回错误
+func writeStreamError(c *gin.Context, writer *flushWriter, err error) {
+	if !c.Writer.Written() {
+		writeError(c, err)
+		return
+	}
+	data, _ := json.Marshal(gin.H{"error": err.Error()})
+	writer.Write([]byte("\n\n<GRANDMA_ERROR>" + string(data) + "</GRANDMA_ERROR>"))
+}
+
+// writeError 把服务错误转换为HTTP响应
+func writeError(c *gin.Context, err error) {
+	var conflict *repository.WriteConflictError
+	switch {
+	case errors.As(err, &conflict):
+		c.Header("ETag", utils.FormatETag(conflict.Document.Version))
+		c.JSON(http.StatusConflict, models.WorkDocumentConflictResponse{Error: err.Error(), Document: *conflict.Document})
+	case errors.Is(err, ErrPatchResolved):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case repository.IsNotFound(err):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document or patch not found"})
+	case errors.Is(err, ErrInvalidOperation), errors.Is(err, ErrInvalidSelection), errors.Is(err, ErrInvalidPosition), errors.Is(err, credential.ErrProviderKeyMissing):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, usage.ErrQuotaExceeded):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case errors.Is(err, credential.ErrMasterKeyNotConfigured):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrEmptyReplacement), errors.Is(err, ErrEmptyGeneration):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// flushWriter 第一次写入时设置流式响应头，每次写入后立即刷新；开始输出之前发生的错误仍可以返回JSON
+type flushWriter struct {
+	writer gin.ResponseWriter
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	if !fw.writer.Written() {
+		fw.writer.Header().Set("Content-Type", "text/event-stream")
+		fw.writer.Header().Set("Cache-Control", "no-cache")
+		fw.writer.Header().Set("Connection", "keep-alive")
+	}
+	n, err := fw.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	fw.writer.Flush()
+	return n, nil
+}
@@ -0,0 +1,498 @@
+package writing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grandma/backend/models"
+	"grandma/backend/modules/chat"
+	"grandma/backend/modules/credential"
+	"grandma/backend/modules/extraction"
+	"grandma/backend/modules/prompt"
+	"grandma/backend/modules/rag"
+	"grandma/backend/modules/revision"
+	"grandma/backend/modules/usage"
+	"grandma/backend/repository"
+	"grandma/backend/services"
+	"grandma/backend/utils"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+const (
+	maxSelectionRunes   = 4000            // 单次编辑选中片段的最大字符数
+	contextRunes        = 800             // 提供给模型的选中片段前后内容的字符数
+	generateBeforeRunes = 2000            // 续写时提供给模型的前文字符数
+	generateAfterRunes  = 1000            // 补写时提供给模型的后文字符数
+	generateQueryRunes  = 300             // 续写时用于RAG检索的前文字符数
+	defaultTargetLength = 500             // 续写的默认目标字数
+	maxTargetLength     = 3000            // 续写的最大目标字数
+	streamFlushBytes    = 100             // 流式写入文档时每累积多少字节保存一次
+	streamLockTTL       = 2 * time.Minute // 流式写入锁的有效期，每次保存时延长
+)
+
+// 编辑操作
+const (
+	OperationRewrite   = "rewrite"
+	OperationExpand    = "expand"
+	OperationCondense  = "condense"
+	OperationTone      = "tone"
+	OperationTranslate = "translate"
+	OperationGrammar   = "grammar"
+	OperationCustom    = "custom"
+)
+
+// operations 各编辑操作的修改要求，%s为请求中的Instruction
+var operations = map[string]string{
+	OperationRewrite:   "改写这段文字，保持原意和情节不变，让表达更生动流畅",
+	OperationExpand:    "扩写这段文字，补充细节、动作和心理描写，篇幅约为原来的两倍",
+	OperationCondense:  "精简这段文字，保留关键情节和信息，篇幅约为原来的一半",
+	OperationTone:      "保持内容不变，把这段文字的语气改为：%s",
+	OperationTranslate: "把这段文字翻译成%s，保持原文的语气和文风",
+	OperationGrammar:   "只修正这段文字中的错别字、语法和标点错误，不要改动其他内容",
+	OperationCustom:    "%s",
+}
+
+var (
+	// ErrInvalidOperation 编辑操作不存在，或缺少该操作需要的Instruction
+	ErrInvalidOperation = errors.New("invalid_operation")
+	// ErrInvalidSelection 选中范围超出文档、为空或过长
+	ErrInvalidSelection = errors.New("invalid_selection")
+	// ErrEmptyReplacement 模型没有给出替换文本
+	ErrEmptyReplacement = errors.New("empty_replacement")
+	// ErrInvalidPosition 续写位置超出文档，或目标字数不合法
+	ErrInvalidPosition = errors.New("invalid_position")
+	// ErrEmptyGeneration 模型没有给出任何内容，文档没有被修改
+	ErrEmptyGeneration = errors.New("empty_generation")
+	// ErrPatchResolved 修改建议已经被接受或拒绝
+	ErrPatchResolved = errors.New("patch_resolved")
+	// ErrPatchConflict 文档已被修改，找不到修改建议对应的原文
+	ErrPatchConflict = errors.New("patch_conflict")
+)
+
+// WritingService 写作助手服务：对正文选中片段的AI编辑和修改建议
+type WritingService struct {
+	workRepo         *repository.WorkRepository
+	workDocumentRepo *repository.WorkDocumentRepository
+	patchRepo        *repository.DocumentPatchRepository
+	chatSvc          *chat.ChatService
+	credentialSvc    *credential.CredentialService
+	usageSvc         *usage.UsageService
+	promptSvc        *prompt.PromptService
+	revisionSvc      *revision.RevisionService
+	extractionSvc    *extraction.ExtractionService
+	ragSvc           *rag.RAGService
+}
+
+// NewWritingService 创建写作助手服务
+func NewWritingService(workRepo *repository.WorkRepository, workDocumentRepo *repository.WorkDocumentRepository, patchRepo *repository.DocumentPatchRepository, chatSvc *chat.ChatService, credentialSvc *credential.CredentialService, usageSvc *usage.UsageService, promptSvc *prompt.PromptService, revisionSvc *revision.RevisionService, extractionSvc *extraction.ExtractionService, ragSvc *rag.RAGService) *WritingService {
+	return &WritingService{
+		workRepo:         workRepo,
+		workDocumentRepo: workDocumentRepo,
+		patchRepo:        patchRepo,
+		chatSvc:          chatSvc,
+		credentialSvc:    credentialSvc,
+		usageSvc:         usageSvc,
+		promptSvc:        promptSvc,
+		revisionSvc:      revisionSvc,
+		extractionSvc:    extractionSvc,
+		ragSvc:           ragSvc,
+	}
+}
+
+// Transform 按编辑操作修改文档中选中的片段：替换文本流式写入writer，完成后保存为待确认的修改建议
+// version大于0时要求文档为该版本（选中范围是相对该版本计算的）
+func (s *WritingService) Transform(documentID, userID string, req *models.TransformRequest, version int64, writer io.Writer) (*models.DocumentPatch, error) {
+	instruction, err := operationInstruction(req.Operation, req.Instruction)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := s.workDocumentRepo.GetByIDAndUserID(documentID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := repository.CheckVersion(doc, version); err != nil {
+		return nil, err
+	}
+	work, err := s.workRepo.GetByIDAndUserID(doc.WorkID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	runes := []rune(doc.Content)
+	if req.Start < 0 || req.End <= req.Start || req.End > len(runes) || req.End-req.Start > maxSelectionRunes {
+		return nil, ErrInvalidSelection
+	}
+	selection := string(runes[req.Start:req.End])
+
+	provider, model, err := s.meteredProvider(userID, req.Model, usage.FeatureTransform)
+	if err != nil {
+		return nil, err
+	}
+
+	// 与灵感模式对话使用相同的系统提示、文风要求、时间线和RAG背景信息，以选中的片段作为检索内容
+	messages, err := s.chatSvc.WorkContextMessages(userID, work, doc.ID, selection)
+	if err != nil {
+		return nil, err
+	}
+	userPrompt, err := s.promptSvc.Render(userID, work.ID, prompt.TemplateTransform, prompt.TransformData{
+		Instruction: instruction,
+		Before:      string(runes[max(0, req.Start-contextRunes):req.Start]),
+		Selection:   selection,
+		After:       string(runes[req.End:min(len(runes), req.End+contextRunes)]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	messages = append(messages, models.Message{Role: "user", Content: userPrompt})
+
+	collector := &replacementCollector{writer: writer}
+	if err := provider.ChatStream(context.Background(), messages, collector); err != nil {
+		return nil, err
+	}
+	replacement := strings.TrimSpace(collector.content.String())
+	if replacement == "" {
+		return nil, ErrEmptyReplacement
+	}
+
+	patch := &models.DocumentPatch{
+		ID:          utils.GenerateDocumentPatchID(),
+		UserID:      userID,
+		WorkID:      doc.WorkID,
+		DocumentID:  doc.ID,
+		Operation:   req.Operation,
+		Instruction: strings.TrimSpace(req.Instruction),
+		Start:       req.Start,
+		End:         req.End,
+		Original:    selection,
+		Replacement: replacement,
+		BaseVersion: doc.Version,
+		Model:       activeModel(provider, model),
+		Status:      models.PatchStatusPending,
+	}
+	if err := s.patchRepo.Create(patch); err != nil {
+		return nil, err
+	}
+	return patch, nil
+}
+
+// Generate 从文档结尾或指定位置续写，或补写两个位置之间的内容：生成的内容一边流式写入writer一边直接写入文档，
+// 写入前把原内容记录为修订，写入期间文档被锁定；完成后记录为模型修订，返回的UndoRevision为写入前的修订（空文档也有撤销点）
+// 生成中途出错时已写入的内容会保留并记录修订，此时同时返回结果和错误
+func (s *WritingService) Generate(documentID, userID string, req *models.GenerateRequest, version int64, writer io.Writer) (*models.GenerateResult, error) {
+	doc, err := s.workDocumentRepo.GetByIDAndUserID(documentID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := repository.CheckVersion(doc, version); err != nil {
+		return nil, err
+	}
+	work, err := s.workRepo.GetByIDAndUserID(doc.WorkID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	runes := []rune(doc.Content)
+	start, end := len(runes), len(runes)
+	if req.Start != nil {
+		start, end = *req.Start, *req.Start
+		if req.End != nil {
+			end = *req.End
+		}
+	} else if req.End != nil {
+		return nil, ErrInvalidPosition
+	}
+	targetLength := req.TargetLength
+	if targetLength == 0 {
+		targetLength = defaultTargetLength
+	}
+	if start < 0 || end < start || end > len(runes) || targetLength < 0 || targetLength > maxTargetLength {
+		return nil, ErrInvalidPosition
+	}
+	prefix, suffix := string(runes[:start]), string(runes[end:])
+
+	provider, model, err := s.meteredProvider(userID, req.Model, usage.FeatureContinue)
+	if err != nil {
+		return nil, err
+	}
+
+	// 以前文结尾检索创作中的相关背景信息
+	before := []rune(prefix)
+	messages, err := s.chatSvc.WorkContextMessages(userID, work, doc.ID, string(before[max(0, len(before)-generateQueryRunes):]))
+	if err != nil {
+		return nil, err
+	}
+	after := []rune(suffix)
+	userPrompt, err := s.promptSvc.Render(userID, work.ID, prompt.TemplateContinue, prompt.ContinueData{
+		Instruction:  strings.TrimSpace(req.Instruction),
+		Before:       string(before[max(0, len(before)-generateBeforeRunes):]),
+		After:        string(after[:min(len(after), generateAfterRunes)]),
+		TargetLength: targetLength,
+	})
+	if err != nil {
+		return nil, err
+	}
+	messages = append(messages, models.Message{Role: "user", Content: userPrompt})
+
+	// 先按读取时的版本锁定文档，生成期间其他修改返回409
+	token := utils.GenerateID()
+	if err := s.workDocumentRepo.Lock(doc.ID, userID, token, doc.Version, time.Now().Add(streamLockTTL)); err != nil {
+		return nil, err
+	}
+	defer s.workDocumentRepo.Unlock(doc.ID, userID, token)
+
+	// 持有锁后再把原内容（包括空文档）记录为修订，作为撤销点，保证记录的正是将被改写的内容
+	target := revision.Target{Type: models.RevisionTargetWorkDocument, ID: doc.ID, WorkID: doc.WorkID}
+	baseline, err := s.revisionSvc.EnsureBaseline(userID, target, doc.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &documentStream{
+		writer: writer,
+		repo:   s.workDocumentRepo,
+		docID:  doc.ID,
+		userID: userID,
+		token:  token,
+		prefix: prefix,
+		suffix: suffix,
+		atEnd:  start == len(runes),
+	}
+	// 生成不随请求取消，客户端断开后继续写入文档
+	streamErr := provider.ChatStream(context.Background(), messages, stream)
+	if stream.generated.Len() == 0 {
+		if streamErr != nil {
+			return nil, streamErr
+		}
+		return nil, ErrEmptyGeneration
+	}
+	if err := stream.flush(); err != nil {
+		return nil, err
+	}
+
+	content := prefix + stream.generated.String() + suffix
+	rev, err := s.revisionSvc.RecordChange(userID, target, models.RevisionAuthorModel, activeModel(provider, model), doc.Content, content)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.workDocumentRepo.GetByIDAndUserID(doc.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	s.extractionSvc.ScheduleWorkDocument(doc.ID, userID)
+	s.ragSvc.IndexWorkDocument(doc.ID, userID)
+
+	result := &models.GenerateResult{
+		DocumentID:   doc.ID,
+		Start:        start,
+		End:          start + utf8.RuneCountInString(stream.generated.String()),
+		Version:      current.Version,
+		UndoRevision: baseline.Number,
+	}
+	if rev != nil {
+		result.Revision = rev.Number
+	}
+	return result, streamErr
+}
+
+// ListPatches 获取文档待处理的修改建议
+func (s *WritingService) ListPatches(documentID, userID string) (*models.DocumentPatchListResponse, error) {
+	// 先验证文档属于该用户
+	if _, err := s.workDocumentRepo.GetByIDAndUserID(documentID, userID); err != nil {
+		return nil, err
+	}
+
+	patches, err := s.patchRepo.GetPendingByDocumentIDAndUserID(documentID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &models.DocumentPatchListResponse{
+		Patches: patches,
+		Total:   len(patches),
+	}, nil
+}
+
+// AcceptPatch 把修改建议写入文档并记录为模型修订，返回更新后的文档
+// 文档在生成建议之后被修改过时，原位置的文本仍与原文一致或原文在文档中只出现一次才能接受
+func (s *WritingService) AcceptPatch(id, userID string, version int64) (*models.WorkDocument, error) {
+	patch, err := s.patchRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if patch.Status != models.PatchStatusPending {
+		return nil, ErrPatchResolved
+	}
+	doc, err := s.workDocumentRepo.GetByIDAndUserID(patch.DocumentID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := repository.CheckVersion(doc, version); err != nil {
+		return nil, err
+	}
+	start, ok := locatePatch(doc, patch)
+	if !ok {
+		return nil, &repository.WriteConflictError{Reason: ErrPatchConflict, Document: doc}
+	}
+
+	runes := []rune(doc.Content)
+	end := start + utf8.RuneCountInString(patch.Original)
+	content := string(runes[:start]) + patch.Replacement + string(runes[end:])
+	target := revision.Target{Type: models.RevisionTargetWorkDocument, ID: doc.ID, WorkID: doc.WorkID}
+
+	// 标记建议、写入文档和记录修订在同一个事务中完成，任何一步失败都不会留下部分修改
+	err = s.workRepo.Transaction(func(tx *gorm.DB) error {
+		// 先把建议标记为已接受，避免并发的两次接受重复写入
+		if err := s.patchRepo.WithTx(tx).UpdateStatusByIDAndUserID(patch.ID, userID, models.PatchStatusPending, models.PatchStatusAccepted); err != nil {
+			if repository.IsNotFound(err) {
+				return ErrPatchResolved
+			}
+			return err
+		}
+		if err := s.workDocumentRepo.WithTx(tx).UpdateContentByIDAndUserID(doc.ID, userID, content, doc.Version); err != nil {
+			return err
+		}
+		_, err := s.revisionSvc.WithTx(tx).RecordChange(userID, target, models.RevisionAuthorModel, patch.Model, doc.Content, content)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.extractionSvc.ScheduleWorkDocument(doc.ID, userID)
+	s.ragSvc.IndexWorkDocument(doc.ID, userID)
+	return s.workDocumentRepo.GetByIDAndUserID(doc.ID, userID)
+}
+
+// RejectPatch 拒绝修改建议，文档不做任何修改
+func (s *WritingService) RejectPatch(id, userID string) error {
+	patch, err := s.patchRepo.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.patchRepo.UpdateStatusByIDAndUserID(patch.ID, userID, models.PatchStatusPending, models.PatchStatusRejected); err != nil {
+		if repository.IsNotFound(err) {
+			return ErrPatchResolved
+		}
+		return err
+	}
+	return nil
+}
+
+// meteredProvider 检查用户配额，并返回记录用量的服务提供者和使用的模型（未指定时为openai）
+func (s *WritingService) meteredProvider(userID, model, feature string) (services.ChatProvider, string, error) {
+	if model == "" {
+		model = "openai"
+	}
+	provider, err := s.credentialSvc.GetProvider(userID, model)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.usageSvc.CheckQuota(userID); err != nil {
+		return nil, "", err
+	}
+	return s.usageSvc.Meter(provider, userID, feature), model, nil
+}
+
+// activeModel 实际响应的后端（发生故障转移时与请求的模型不同）
+func activeModel(provider services.ChatProvider, model string) string {
+	if reporter, ok := provider.(services.BackendReporter); ok && reporter.ActiveBackend() != "" {
+		return reporter.ActiveBackend()
+	}
+	return model
+}
+
+// operationInstruction 生成编辑操作的修改要求
+func operationInstruction(operation, extra string) (string, error) {
+	format, ok := operations[operation]
+	if !ok {
+		return "", ErrInvalidOperation
+	}
+	extra = strings.TrimSpace(extra)
+	if !strings.Contains(format, "%s") {
+		if extra != "" {
+			format += "。补充要求：" + extra
+		}
+		return format, nil
+	}
+	if extra == "" {
+		return "", ErrInvalidOperation
+	}
+	return fmt.Sprintf(format, extra), nil
+}
+
+// locatePatch 确定修改建议在文档当前内容中的起始位置（字符）
+func locatePatch(doc *models.WorkDocument, patch *models.DocumentPatch) (int, bool) {
+	runes := []rune(doc.Content)
+	if patch.End <= len(runes) && string(runes[patch.Start:patch.End]) == patch.Original {
+		return patch.Start, true
+	}
+	if strings.Count(doc.Content, patch.Original) != 1 {
+		return 0, false
+	}
+	return utf8.RuneCountInString(doc.Content[:strings.Index(doc.Content, patch.Original)]), true
+}
+
+// replacementCollector 把替换文本流式写给客户端，同时收集完整内容；推理过程不会写入
+type replacementCollector struct {
+	writer  io.Writer
+	content strings.Builder
+}
+
+func (rc *replacementCollector) Write(p []byte) (int, error) {
+	rc.content.Write(p)
+	// 客户端断开后继续收集，保证修改建议完整保存
+	rc.writer.Write(p)
+	return len(p), nil
+}
+
+// documentStream 把生成的内容流式写给客户端，同时分批写入被锁定的文档：
+// 在结尾续写时追加内容，在中间写入时用前文、已生成的内容和后文替换全部内容
+type documentStream struct {
+	writer    io.Writer
+	repo      *repository.WorkDocumentRepository
+	docID     string
+	userID    string
+	token     string
+	prefix    string
+	suffix    string
+	atEnd     bool // 是否在文档结尾续写
+	generated strings.Builder
+	pending   strings.Builder // 还没有写入文档的内容
+}
+
+func (ds *documentStream) Write(p []byte) (int, error) {
+	ds.generated.Write(p)
+	ds.pending.Write(p)
+	// 客户端断开后继续生成，内容已经写入文档
+	ds.writer.Write(p)
+	if ds.pending.Len() >= streamFlushBytes {
+		// 写入文档失败（如锁已过期）时中止生成
+		if err := ds.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// flush 把还没有写入的内容写入文档并延长锁
+func (ds *documentStream) flush() error {
+	if ds.pending.Len() == 0 {
+		return nil
+	}
+	until := time.Now().Add(streamLockTTL)
+	var err error
+	if ds.atEnd {
+		err = ds.repo.AppendLockedContent(ds.docID, ds.userID, ds.token, ds.pending.String(), until)
+	} else {
+		err = ds.repo.UpdateLockedContent(ds.docID, ds.userID, ds.token, ds.prefix+ds.generated.String()+ds.suffix, until)
+	}
+	if err != nil {
+		return err
+	}
+	ds.pending.Reset()
+	return nil
+}
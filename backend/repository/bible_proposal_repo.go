@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"grandma/backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BibleProposalRepository 设定建议仓库
+type BibleProposalRepository struct {
+	db *gorm.DB
+}
+
+// NewBibleProposalRepository 创建设定建议仓库
+func NewBibleProposalRepository(db *gorm.DB) *BibleProposalRepository {
+	return &BibleProposalRepository{db: db}
+}
+
+// WithTx 返回绑定到事务tx的设定建议仓库
+func (r *BibleProposalRepository) WithTx(tx *gorm.DB) *BibleProposalRepository {
+	return &BibleProposalRepository{db: tx}
+}
+
+// Create 创建设定建议
+func (r *BibleProposalRepository) Create(proposal *models.BibleProposal) error {
+	proposal.CreatedAt = time.Now()
+	proposal.UpdatedAt = time.Now()
+	return r.db.Create(proposal).Error
+}
+
+// GetByIDAndUserID 根据ID和用户ID获取设定建议
+func (r *BibleProposalRepository) GetByIDAndUserID(id, userID string) (*models.BibleProposal, error) {
+	var proposal models.BibleProposal
+	err := r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).First(&proposal).Error
+	if err != nil {
+		return nil, err
+	}
+	return &proposal, nil
+}
+
+// GetByWorkIDAndUserID 获取创作的设定建议，status为空时不过滤（按created_at正序）
+func (r *BibleProposalRepository) GetByWorkIDAndUserID(workID, userID, status string) ([]models.BibleProposal, error) {
+	query := r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var proposals []models.BibleProposal
+	err := query.Order("created_at ASC").Find(&proposals).Error
+	return proposals, err
+}
+
+// UpdateStatusByIDAndUserID 把状态为from的设定建议改为to，状态不是from时返回gorm.ErrRecordNotFound
+func (r *BibleProposalRepository) UpdateStatusByIDAndUserID(id, userID, from, to string) error {
+	return requireAffected(r.db.Model(&models.BibleProposal{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ? AND status = ?", id, from).
+		Updates(map[string]interface{}{
+			"status":     to,
+			"updated_at": time.Now(),
+		}))
+}
+
+// DeleteByWorkIDAndUserID 删除创作下的所有设定建议
+func (r *BibleProposalRepository) DeleteByWorkIDAndUserID(workID, userID string) error {
+	return r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Delete(&models.BibleProposal{}).Error
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"grandma/backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ContinuityRepository 设定一致性检查仓库（检查任务和发现的冲突）
+type ContinuityRepository struct {
+	db *gorm.DB
+}
+
+// NewContinuityRepository 创建设定一致性检查仓库
+func NewContinuityRepository(db *gorm.DB) *ContinuityRepository {
+	return &ContinuityRepository{db: db}
+}
+
+// WithTx 返回绑定到事务tx的设定一致性检查仓库
+func (r *ContinuityRepository) WithTx(tx *gorm.DB) *ContinuityRepository {
+	return &ContinuityRepository{db: tx}
+}
+
+// CreateCheck 创建检查任务
+func (r *ContinuityRepository) CreateCheck(check *models.ContinuityCheck) error {
+	check.CreatedAt = time.Now()
+	check.UpdatedAt = time.Now()
+	return r.db.Create(check).Error
+}
+
+// GetCheckByIDAndUserID 根据ID和用户ID获取检查任务
+func (r *ContinuityRepository) GetCheckByIDAndUserID(id, userID string) (*models.ContinuityCheck, error) {
+	var check models.ContinuityCheck
+	err := r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).First(&check).Error
+	if err != nil {
+		return nil, err
+	}
+	return &check, nil
+}
+
+// GetChecksByDocumentIDAndUserID 获取文档的所有检查任务（按created_at倒序）
+func (r *ContinuityRepository) GetChecksByDocumentIDAndUserID(documentID, userID string) ([]models.ContinuityCheck, error) {
+	var checks []models.ContinuityCheck
+	err := r.db.Scopes(OwnedBy(userID)).Where("document_id = ?", documentID).Order("created_at DESC").Find(&checks).Error
+	return checks, err
+}
+
+// CompleteCheck 保存检查发现的冲突，并把任务标记为完成
+func (r *ContinuityRepository) CompleteCheck(check *models.ContinuityCheck, issues []models.ContinuityIssue) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		for i := range issues {
+			issues[i].CreatedAt = now
+			issues[i].UpdatedAt = now
+		}
+		if len(issues) > 0 {
+			if err := tx.Create(&issues).Error; err != nil {
+				return err
+			}
+		}
+		return finishCheck(tx, check, models.ContinuityCheckCompleted, "", len(issues))
+	})
+}
+
+// FailCheck 把任务标记为失败
+func (r *ContinuityRepository) FailCheck(check *models.ContinuityCheck, reason string) error {
+	return finishCheck(r.db, check, models.ContinuityCheckFailed, reason, 0)
+}
+
+// finishCheck 更新任务的最终状态
+func finishCheck(db *gorm.DB, check *models.ContinuityCheck, status, reason string, issueCount int) error {
+	now := time.Now()
+	check.Status = status
+	check.Error = reason
+	check.IssueCount = issueCount
+	check.CompletedAt = &now
+	check.UpdatedAt = now
+	return requireAffected(db.Model(&models.ContinuityCheck{}).
+		Scopes(OwnedBy(check.UserID)).
+		Where("id = ?", check.ID).
+		Updates(map[string]interface{}{
+			"status":       status,
+			"error":        reason,
+			"issue_count":  issueCount,
+			"completed_at": now,
+			"updated_at":   now,
+		}))
+}
+
+// GetIssueByIDAndUserID 根据ID和用户ID获取冲突
+func (r *ContinuityRepository) GetIssueByIDAndUserID(id, userID string) (*models.ContinuityIssue, error) {
+	var issue models.ContinuityIssue
+	err := r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).First(&issue).Error
+	if err != nil {
+		return nil, err
+	}
+	return &issue, nil
+}
+
+// GetIssuesByCheckIDAndUserID 获取检查任务发现的冲突（按章节中的位置排序）
+func (r *ContinuityRepository) GetIssuesByCheckIDAndUserID(checkID, userID string) ([]models.ContinuityIssue, error) {
+	var issues []models.ContinuityIssue
+	err := r.db.Scopes(OwnedBy(userID)).Where("check_id = ?", checkID).Order("quote_start ASC, created_at ASC").Find(&issues).Error
+	return issues, err
+}
+
+// GetIssuesByWorkIDAndUserID 获取创作的冲突，documentID和status为空时不过滤（按created_at倒序）
+func (r *ContinuityRepository) GetIssuesByWorkIDAndUserID(workID, userID, documentID, status string) ([]models.ContinuityIssue, error) {
+	query := r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID)
+	if documentID != "" {
+		query = query.Where("document_id = ?", documentID)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var issues []models.ContinuityIssue
+	err := query.Order("created_at DESC, quote_start ASC").Find(&issues).Error
+	return issues, err
+}
+
+// UpdateIssueStatusByIDAndUserID 更新冲突的处理状态
+func (r *ContinuityRepository) UpdateIssueStatusByIDAndUserID(id, userID, status string) error {
+	now := time.Now()
+	var resolvedAt *time.Time
+	if status == models.ContinuityIssueResolved {
+		resolvedAt = &now
+	}
+	return requireAffected(r.db.Model(&models.ContinuityIssue{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":      status,
+			"resolved_at": resolvedAt,
+			"updated_at":  now,
+		}))
+}
+
+// DeleteByDocumentIDAndUserID 删除文档的所有检查任务和冲突
+func (r *ContinuityRepository) DeleteByDocumentIDAndUserID(documentID, userID string) error {
+	if err := r.db.Scopes(OwnedBy(userID)).Where("document_id = ?", documentID).Delete(&models.ContinuityIssue{}).Error; err != nil {
+		return err
+	}
+	return r.db.Scopes(OwnedBy(userID)).Where("document_id = ?", documentID).Delete(&models.ContinuityCheck{}).Error
+}
+
+// DeleteByWorkIDAndUserID 删除创作下的所有检查任务和冲突
+func (r *ContinuityRepository) DeleteByWorkIDAndUserID(workID, userID string) error {
+	if err := r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Delete(&models.ContinuityIssue{}).Error; err != nil {
+		return err
+	}
+	return r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Delete(&models.ContinuityCheck{}).Error
+}
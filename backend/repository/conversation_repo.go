@@ -2,6 +2,7 @@ package repository
 
 import (
 	"grandma/backend/models"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -17,6 +18,16 @@ func NewConversationRepository(db *gorm.DB) *ConversationRepository {
 	return &ConversationRepository{db: db}
 }
 
+// WithTx 返回绑定到事务tx的对话仓库
+func (r *ConversationRepository) WithTx(tx *gorm.DB) *ConversationRepository {
+	return &ConversationRepository{db: tx}
+}
+
+// Transaction 在事务中执行fn，fn返回错误时回滚
+func (r *ConversationRepository) Transaction(fn func(tx *gorm.DB) error) error {
+	return r.db.Transaction(fn)
+}
+
 // Create 创建对话
 func (r *ConversationRepository) Create(conversation *models.Conversation) error {
 	conversation.CreatedAt = time.Now()
@@ -24,52 +35,19 @@ func (r *ConversationRepository) Create(conversation *models.Conversation) error
 	return r.db.Create(conversation).Error
 }
 
-// GetByID 根据ID获取对话
-func (r *ConversationRepository) GetByID(id string) (*models.Conversation, error) {
-	var conversation models.Conversation
-	err := r.db.Preload("Documents").Where("id = ?", id).First(&conversation).Error
-	if err != nil {
-		return nil, err
-	}
-	return &conversation, nil
-}
-
 // GetByIDAndUserID 根据ID和用户ID获取对话（确保数据隔离）
 func (r *ConversationRepository) GetByIDAndUserID(id, userID string) (*models.Conversation, error) {
 	var conversation models.Conversation
-	err := r.db.Preload("Documents").Where("id = ? AND user_id = ?", id, userID).First(&conversation).Error
+	err := r.db.Scopes(OwnedBy(userID)).
+		Preload("Documents", "user_id = ?", userID).
+		Where("id = ?", id).
+		First(&conversation).Error
 	if err != nil {
 		return nil, err
 	}
 	return &conversation, nil
 }
 
-// List 获取对话列表（已废弃，使用ListByUserID）
-func (r *ConversationRepository) List(page, pageSize int) ([]models.Conversation, int64, error) {
-	var conversations []models.Conversation
-	var total int64
-
-	offset := (page - 1) * pageSize
-	if offset < 0 {
-		offset = 0
-	}
-	if pageSize <= 0 {
-		pageSize = 20
-	}
-
-	err := r.db.Model(&models.Conversation{}).Count(&total).Error
-	if err != nil {
-		return nil, 0, err
-	}
-
-	err = r.db.Order("updated_at DESC").Offset(offset).Limit(pageSize).Find(&conversations).Error
-	if err != nil {
-		return nil, 0, err
-	}
-
-	return conversations, total, nil
-}
-
 // ListByUserID 根据用户ID获取对话列表
 func (r *ConversationRepository) ListByUserID(userID string, page, pageSize int) ([]models.Conversation, int64, error) {
 	var conversations []models.Conversation
@@ -83,12 +61,12 @@ func (r *ConversationRepository) ListByUserID(userID string, page, pageSize int)
 		pageSize = 20
 	}
 
-	err := r.db.Model(&models.Conversation{}).Where("user_id = ?", userID).Count(&total).Error
+	err := r.db.Model(&models.Conversation{}).Scopes(OwnedBy(userID)).Count(&total).Error
 	if err != nil {
 		return nil, 0, err
 	}
 
-	err = r.db.Where("user_id = ?", userID).Order("updated_at DESC").Offset(offset).Limit(pageSize).Find(&conversations).Error
+	err = r.db.Scopes(OwnedBy(userID)).Order("updated_at DESC").Offset(offset).Limit(pageSize).Find(&conversations).Error
 	if err != nil {
 		return nil, 0, err
 	}
@@ -96,35 +74,51 @@ func (r *ConversationRepository) ListByUserID(userID string, page, pageSize int)
 	return conversations, total, nil
 }
 
-// Update 更新对话
-func (r *ConversationRepository) Update(conversation *models.Conversation) error {
+// UpdateByIDAndUserID 更新对话的标题和文档ID列表（确保数据隔离）
+func (r *ConversationRepository) UpdateByIDAndUserID(conversation *models.Conversation) error {
 	conversation.UpdatedAt = time.Now()
-	return r.db.Save(conversation).Error
-}
-
-// Delete 删除对话
-func (r *ConversationRepository) Delete(id string) error {
-	return r.db.Delete(&models.Conversation{}, "id = ?", id).Error
+	return requireAffected(r.db.Model(&models.Conversation{}).
+		Scopes(OwnedBy(conversation.UserID)).
+		Where("id = ?", conversation.ID).
+		Updates(map[string]interface{}{
+			"title":        conversation.Title,
+			"document_ids": conversation.DocumentIDs,
+			"updated_at":   conversation.UpdatedAt,
+		}))
 }
 
 // DeleteByIDAndUserID 根据ID和用户ID删除对话（确保数据隔离）
 func (r *ConversationRepository) DeleteByIDAndUserID(id, userID string) error {
-	return r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Conversation{}).Error
-}
-
-// UpdateTitle 更新对话标题
-func (r *ConversationRepository) UpdateTitle(id, title string) error {
-	return r.db.Model(&models.Conversation{}).Where("id = ?", id).Update("title", title).Error
+	return requireAffected(r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).Delete(&models.Conversation{}))
 }
 
 // UpdateTitleByIDAndUserID 根据ID和用户ID更新对话标题（确保数据隔离）
 func (r *ConversationRepository) UpdateTitleByIDAndUserID(id, userID, title string) error {
-	return r.db.Model(&models.Conversation{}).Where("id = ? AND user_id = ?", id, userID).Update("title", title).Error
+	return requireAffected(r.db.Model(&models.Conversation{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Update("title", title))
+}
+
+// UpdateStyleProfileByIDAndUserID 设置对话的文风档案（确保数据隔离）
+func (r *ConversationRepository) UpdateStyleProfileByIDAndUserID(id, userID, styleProfileID string) error {
+	return requireAffected(r.db.Model(&models.Conversation{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Update("style_profile_id", styleProfileID))
+}
+
+// ClearStyleProfileByUserID 取消所有使用该文风档案的对话的关联
+func (r *ConversationRepository) ClearStyleProfileByUserID(userID, styleProfileID string) error {
+	return r.db.Model(&models.Conversation{}).
+		Scopes(OwnedBy(userID)).
+		Where("style_profile_id = ?", styleProfileID).
+		Update("style_profile_id", "").Error
 }
 
-// AppendDocumentID 添加文档ID到对话的文档ID列表
-func (r *ConversationRepository) AppendDocumentID(id, documentID string) error {
-	conversation, err := r.GetByID(id)
+// AppendDocumentID 添加文档ID到对话的文档ID列表（确保数据隔离）
+func (r *ConversationRepository) AppendDocumentID(id, userID, documentID string) error {
+	conversation, err := r.GetByIDAndUserID(id, userID)
 	if err != nil {
 		return err
 	}
@@ -136,9 +130,50 @@ func (r *ConversationRepository) AppendDocumentID(id, documentID string) error {
 		newDocumentIDs = conversation.DocumentIDs + "," + documentID
 	}
 
+	return r.db.Model(&models.Conversation{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"document_ids": newDocumentIDs,
+			"updated_at":   time.Now(),
+		}).Error
+}
+
+// RemoveDocumentID 从对话的文档ID列表中移除指定文档ID（确保数据隔离）
+func (r *ConversationRepository) RemoveDocumentID(id, userID, documentID string) error {
+	conversation, err := r.GetByIDAndUserID(id, userID)
+	if err != nil {
+		return err
+	}
+
+	if conversation.DocumentIDs == "" {
+		return nil
+	}
+
+	ids := strings.Split(conversation.DocumentIDs, ",")
+	kept := make([]string, 0, len(ids))
+	for _, docID := range ids {
+		if docID != documentID {
+			kept = append(kept, docID)
+		}
+	}
+
+	return r.db.Model(&models.Conversation{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Update("document_ids", strings.Join(kept, ",")).Error
+}
+
+// ListAll 获取所有用户的对话（仅用于一致性检查，不得用于面向用户的接口）
+func (r *ConversationRepository) ListAll() ([]models.Conversation, error) {
+	var conversations []models.Conversation
+	err := r.db.Order("created_at ASC").Find(&conversations).Error
+	return conversations, err
+}
+
+// UpdateDocumentIDs 覆盖对话的文档ID列表（仅用于一致性修复）
+func (r *ConversationRepository) UpdateDocumentIDs(id, documentIDs string) error {
 	return r.db.Model(&models.Conversation{}).
 		Where("id = ?", id).
-		Update("document_ids", newDocumentIDs).
-		Update("updated_at", time.Now()).
-		Error
+		Update("document_ids", documentIDs).Error
 }
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"grandma/backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DocumentPatchRepository 修改建议仓库
+type DocumentPatchRepository struct {
+	db *gorm.DB
+}
+
+// NewDocumentPatchRepository 创建修改建议仓库
+func NewDocumentPatchRepository(db *gorm.DB) *DocumentPatchRepository {
+	return &DocumentPatchRepository{db: db}
+}
+
+// WithTx 返回绑定到事务tx的修改建议仓库
+func (r *DocumentPatchRepository) WithTx(tx *gorm.DB) *DocumentPatchRepository {
+	return &DocumentPatchRepository{db: tx}
+}
+
+// Create 创建修改建议
+func (r *DocumentPatchRepository) Create(patch *models.DocumentPatch) error {
+	patch.CreatedAt = time.Now()
+	patch.UpdatedAt = time.Now()
+	return r.db.Create(patch).Error
+}
+
+// GetByIDAndUserID 根据ID和用户ID获取修改建议
+func (r *DocumentPatchRepository) GetByIDAndUserID(id, userID string) (*models.DocumentPatch, error) {
+	var patch models.DocumentPatch
+	err := r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).First(&patch).Error
+	if err != nil {
+		return nil, err
+	}
+	return &patch, nil
+}
+
+// GetPendingByDocumentIDAndUserID 获取文档待处理的修改建议（按created_at正序）
+func (r *DocumentPatchRepository) GetPendingByDocumentIDAndUserID(documentID, userID string) ([]models.DocumentPatch, error) {
+	var patches []models.DocumentPatch
+	err := r.db.Scopes(OwnedBy(userID)).
+		Where("document_id = ? AND status = ?", documentID, models.PatchStatusPending).
+		Order("created_at ASC").
+		Find(&patches).Error
+	return patches, err
+}
+
+// UpdateStatusByIDAndUserID 把状态为from的修改建议改为to，状态不是from时返回gorm.ErrRecordNotFound
+func (r *DocumentPatchRepository) UpdateStatusByIDAndUserID(id, userID, from, to string) error {
+	return requireAffected(r.db.Model(&models.DocumentPatch{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ? AND status = ?", id, from).
+		Updates(map[string]interface{}{
+			"status":     to,
+			"updated_at": time.Now(),
+		}))
+}
+
+// DeleteByDocumentIDAndUserID 删除文档的所有修改建议
+func (r *DocumentPatchRepository) DeleteByDocumentIDAndUserID(documentID, userID string) error {
+	return r.db.Scopes(OwnedBy(userID)).Where("document_id = ?", documentID).Delete(&models.DocumentPatch{}).Error
+}
+
+// DeleteByWorkIDAndUserID 删除创作下的所有修改建议
+func (r *DocumentPatchRepository) DeleteByWorkIDAndUserID(workID, userID string) error {
+	return r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Delete(&models.DocumentPatch{}).Error
+}
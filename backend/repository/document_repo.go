@@ -1,13 +1,25 @@
 package repository
 
 import (
-	"fmt"
 	"grandma/backend/models"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// DocumentConflictError 文档写入冲突，Document为服务器上的当前文档
+type DocumentConflictError struct {
+	Document *models.Document
+}
+
+func (e *DocumentConflictError) Error() string {
+	return ErrVersionConflict.Error()
+}
+
+func (e *DocumentConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
 // DocumentRepository 文档仓库
 type DocumentRepository struct {
 	db *gorm.DB
@@ -18,49 +30,38 @@ func NewDocumentRepository(db *gorm.DB) *DocumentRepository {
 	return &DocumentRepository{db: db}
 }
 
+// WithTx 返回绑定到事务tx的文档仓库
+func (r *DocumentRepository) WithTx(tx *gorm.DB) *DocumentRepository {
+	return &DocumentRepository{db: tx}
+}
+
+// Transaction 在事务中执行fn，fn返回错误时回滚
+func (r *DocumentRepository) Transaction(fn func(tx *gorm.DB) error) error {
+	return r.db.Transaction(fn)
+}
+
 // Create 创建文档
 func (r *DocumentRepository) Create(document *models.Document) error {
 	document.CreatedAt = time.Now()
 	document.UpdatedAt = time.Now()
+	document.Version = 1
 	return r.db.Create(document).Error
 }
 
-// GetByID 根据ID获取文档
-func (r *DocumentRepository) GetByID(id string) (*models.Document, error) {
-	var document models.Document
-	err := r.db.Where("id = ?", id).First(&document).Error
-	if err != nil {
-		return nil, err
-	}
-	return &document, nil
-}
-
 // GetByIDAndUserID 根据ID和用户ID获取文档（确保数据隔离）
 func (r *DocumentRepository) GetByIDAndUserID(id, userID string) (*models.Document, error) {
 	var document models.Document
-	err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&document).Error
+	err := r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).First(&document).Error
 	if err != nil {
 		return nil, err
 	}
 	return &document, nil
 }
 
-// GetByConversationID 根据对话ID获取文档列表
-func (r *DocumentRepository) GetByConversationID(conversationID string) ([]models.Document, error) {
-	var documents []models.Document
-	err := r.db.Where("conversation_id = ?", conversationID).Order("created_at ASC").Find(&documents).Error
-	if err != nil {
-		fmt.Printf("[document_repo GetByConversationID] Error: %+v\n", err)
-		return nil, err
-	}
-	fmt.Printf("[document_repo GetByConversationID] Documents: %+v\n", documents)
-	return documents, nil
-}
-
-// GetDocumentIDsByConversationID 根据对话ID获取文档ID列表（用于翻页）
+// GetDocumentIDsByConversationIDAndUserID 根据对话ID和用户ID获取文档ID列表（确保数据隔离）
 // beforeDocumentID: 如果提供，返回比该文档更早的文档ID
 // limit: 返回的最大数量
-func (r *DocumentRepository) GetDocumentIDsByConversationID(conversationID string, beforeDocumentID string, limit int) ([]string, error) {
+func (r *DocumentRepository) GetDocumentIDsByConversationIDAndUserID(conversationID, userID string, beforeDocumentID string, limit int) ([]string, error) {
 	var documentIDs []string
 
 	if limit <= 0 {
@@ -70,6 +71,7 @@ func (r *DocumentRepository) GetDocumentIDsByConversationID(conversationID strin
 	if beforeDocumentID == "" {
 		// 如果没有提供beforeDocumentID，返回最新的文档ID（按created_at倒序，取前limit个，然后反转顺序）
 		query := r.db.Model(&models.Document{}).
+			Scopes(OwnedBy(userID)).
 			Where("conversation_id = ?", conversationID).
 			Order("created_at DESC").
 			Limit(limit)
@@ -89,59 +91,12 @@ func (r *DocumentRepository) GetDocumentIDsByConversationID(conversationID strin
 
 	// 如果提供了beforeDocumentID，返回比该ID更早的文档ID（按created_at正序）
 	query := r.db.Model(&models.Document{}).
+		Scopes(OwnedBy(userID)).
 		Where("conversation_id = ?", conversationID).
 		Order("created_at ASC")
 
-	// 找到 beforeDocumentID 对应的文档的 created_at
-	var beforeDoc models.Document
-	if err := r.db.Where("id = ?", beforeDocumentID).First(&beforeDoc).Error; err == nil {
-		query = query.Where("created_at < ?", beforeDoc.CreatedAt)
-	}
-
-	err := query.Limit(limit).Pluck("id", &documentIDs).Error
-	if err != nil {
-		return nil, err
-	}
-
-	return documentIDs, nil
-}
-
-// GetDocumentIDsByConversationIDAndUserID 根据对话ID和用户ID获取文档ID列表（确保数据隔离）
-func (r *DocumentRepository) GetDocumentIDsByConversationIDAndUserID(conversationID, userID string, beforeDocumentID string, limit int) ([]string, error) {
-	var documentIDs []string
-
-	if limit <= 0 {
-		limit = 10
-	}
-
-	if beforeDocumentID == "" {
-		// 如果没有提供beforeDocumentID，返回最新的文档ID（按created_at倒序，取前limit个，然后反转顺序）
-		query := r.db.Model(&models.Document{}).
-			Where("conversation_id = ? AND user_id = ?", conversationID, userID).
-			Order("created_at DESC").
-			Limit(limit)
-
-		err := query.Pluck("id", &documentIDs).Error
-		if err != nil {
-			return nil, err
-		}
-
-		// 反转顺序，使其按时间正序排列（最早的在前）
-		for i, j := 0, len(documentIDs)-1; i < j; i, j = i+1, j-1 {
-			documentIDs[i], documentIDs[j] = documentIDs[j], documentIDs[i]
-		}
-
-		return documentIDs, nil
-	}
-
-	// 如果提供了beforeDocumentID，返回比该ID更早的文档ID（按created_at正序）
-	query := r.db.Model(&models.Document{}).
-		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
-		Order("created_at ASC")
-
 	// 找到 beforeDocumentID 对应的文档的 created_at（需要验证用户ID）
-	var beforeDoc models.Document
-	if err := r.db.Where("id = ? AND user_id = ?", beforeDocumentID, userID).First(&beforeDoc).Error; err == nil {
+	if beforeDoc, err := r.GetByIDAndUserID(beforeDocumentID, userID); err == nil {
 		query = query.Where("created_at < ?", beforeDoc.CreatedAt)
 	}
 
@@ -153,10 +108,10 @@ func (r *DocumentRepository) GetDocumentIDsByConversationIDAndUserID(conversatio
 	return documentIDs, nil
 }
 
-// GetLatestDocumentsByConversationID 获取对话的最新文档（按created_at倒序）
-func (r *DocumentRepository) GetLatestDocumentsByConversationID(conversationID string, limit int) ([]models.Document, error) {
+// GetLatestDocumentsByConversationIDAndUserID 获取对话的最新文档（按created_at倒序，确保数据隔离）
+func (r *DocumentRepository) GetLatestDocumentsByConversationIDAndUserID(conversationID, userID string, limit int) ([]models.Document, error) {
 	var documents []models.Document
-	query := r.db.Where("conversation_id = ?", conversationID).Order("created_at DESC")
+	query := r.db.Scopes(OwnedBy(userID)).Where("conversation_id = ?", conversationID).Order("created_at DESC")
 	if limit > 0 {
 		query = query.Limit(limit)
 	}
@@ -167,39 +122,107 @@ func (r *DocumentRepository) GetLatestDocumentsByConversationID(conversationID s
 	return documents, nil
 }
 
-// Update 更新文档
-func (r *DocumentRepository) Update(document *models.Document) error {
-	document.UpdatedAt = time.Now()
-	return r.db.Save(document).Error
+// GetIDsByConversationIDAndUserID 获取对话下所有文档的ID（确保数据隔离）
+func (r *DocumentRepository) GetIDsByConversationIDAndUserID(conversationID, userID string) ([]string, error) {
+	var ids []string
+	err := r.db.Model(&models.Document{}).
+		Scopes(OwnedBy(userID)).
+		Where("conversation_id = ?", conversationID).
+		Pluck("id", &ids).Error
+	return ids, err
 }
 
-// Delete 删除文档
-func (r *DocumentRepository) Delete(id string) error {
-	return r.db.Delete(&models.Document{}, "id = ?", id).Error
+// UpdateContentByIDAndUserID 更新文档内容（确保数据隔离），version大于0时要求当前版本号与之相同，成功后版本号加一
+func (r *DocumentRepository) UpdateContentByIDAndUserID(id, userID, content string, version int64) error {
+	query := r.db.Model(&models.Document{}).Scopes(OwnedBy(userID)).Where("id = ?", id)
+	if version > 0 {
+		query = query.Where("version = ?", version)
+	}
+	result := query.Updates(map[string]interface{}{
+		"content":    content,
+		"updated_at": time.Now(),
+		"version":    gorm.Expr("version + 1"),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		current, err := r.GetByIDAndUserID(id, userID)
+		if err != nil {
+			return err
+		}
+		return &DocumentConflictError{Document: current}
+	}
+	return nil
+}
+
+// UpdateModelByIDAndUserID 更新文档记录的模型（实际响应的后端）
+func (r *DocumentRepository) UpdateModelByIDAndUserID(id, userID, model string) error {
+	return requireAffected(r.db.Model(&models.Document{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Update("model", model))
 }
 
-// DeleteByConversationID 删除对话的所有文档
-func (r *DocumentRepository) DeleteByConversationID(conversationID string) error {
-	return r.db.Where("conversation_id = ?", conversationID).Delete(&models.Document{}).Error
+// DeleteByIDAndUserID 删除文档（确保数据隔离）
+func (r *DocumentRepository) DeleteByIDAndUserID(id, userID string) error {
+	return requireAffected(r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).Delete(&models.Document{}))
 }
 
-// AppendContent 追加内容到文档（用于流式更新）
-func (r *DocumentRepository) AppendContent(id string, content string) error {
-	var doc models.Document
-	err := r.db.Where("id = ?", id).First(&doc).Error
+// DeleteByConversationIDAndUserID 删除对话的所有文档（确保数据隔离）
+func (r *DocumentRepository) DeleteByConversationIDAndUserID(conversationID, userID string) error {
+	return r.db.Scopes(OwnedBy(userID)).Where("conversation_id = ?", conversationID).Delete(&models.Document{}).Error
+}
+
+// UpdateReasoningByIDAndUserID 保存文档的推理过程
+func (r *DocumentRepository) UpdateReasoningByIDAndUserID(id, userID, reasoning string) error {
+	return requireAffected(r.db.Model(&models.Document{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Update("reasoning", reasoning))
+}
+
+// AppendContentByIDAndUserID 追加内容到文档（用于流式更新，确保数据隔离）
+func (r *DocumentRepository) AppendContentByIDAndUserID(id, userID, content string) error {
+	return requireAffected(r.db.Model(&models.Document{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"content":    gorm.Expr("content || ?", content),
+			"updated_at": time.Now(),
+		}))
+}
+
+// GetExistingIDs 返回给定ID中实际存在的文档ID集合（仅用于一致性检查）
+func (r *DocumentRepository) GetExistingIDs(ids []string) (map[string]bool, error) {
+	existing := make(map[string]bool, len(ids))
+	if len(ids) == 0 {
+		return existing, nil
+	}
+
+	var found []string
+	err := r.db.Model(&models.Document{}).Where("id IN ?", ids).Pluck("id", &found).Error
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for _, id := range found {
+		existing[id] = true
 	}
-	doc.Content = doc.Content + content
-	doc.UpdatedAt = time.Now()
-	return r.db.Save(&doc).Error
+	return existing, nil
 }
 
-// UpdateContent 更新文档内容（用于流式更新的初始设置）
-func (r *DocumentRepository) UpdateContent(id string, content string) error {
-	return r.db.Model(&models.Document{}).
-		Where("id = ?", id).
-		Update("content", content).
-		Update("updated_at", time.Now()).
-		Error
+// GetOrphans 获取所属对话已不存在的文档（仅用于一致性检查）
+func (r *DocumentRepository) GetOrphans() ([]models.Document, error) {
+	var documents []models.Document
+	err := r.db.Where("conversation_id NOT IN (?)", r.db.Model(&models.Conversation{}).Select("id")).
+		Find(&documents).Error
+	return documents, err
+}
+
+// DeleteByIDs 批量删除文档（仅用于一致性修复）
+func (r *DocumentRepository) DeleteByIDs(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.Where("id IN ?", ids).Delete(&models.Document{}).Error
 }
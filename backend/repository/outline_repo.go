@@ -0,0 +1,203 @@
+package repository
+
+import (
+	"errors"
+	"grandma/backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidOrder 调整顺序时给出的节点与当前的同级节点不一致
+var ErrInvalidOrder = errors.New("invalid_order")
+
+// OutlineRepository 大纲仓库
+// 同一父节点下的节点 order_index 始终为 0..n-1 的连续编号，所有改变顺序的操作都在事务中完成
+type OutlineRepository struct {
+	db *gorm.DB
+}
+
+// NewOutlineRepository 创建大纲仓库
+func NewOutlineRepository(db *gorm.DB) *OutlineRepository {
+	return &OutlineRepository{db: db}
+}
+
+// WithTx 返回绑定到事务tx的大纲仓库
+func (r *OutlineRepository) WithTx(tx *gorm.DB) *OutlineRepository {
+	return &OutlineRepository{db: tx}
+}
+
+// siblings 同一父节点下的节点
+func siblings(tx *gorm.DB, workID, userID, parentID string) *gorm.DB {
+	return tx.Model(&models.OutlineNode{}).
+		Scopes(OwnedBy(userID)).
+		Where("work_id = ? AND parent_id = ?", workID, parentID)
+}
+
+// insertPosition 在父节点下腾出位置，position为nil或超出范围时追加到末尾，返回实际位置
+func insertPosition(tx *gorm.DB, workID, userID, parentID string, position *int) (int, error) {
+	var count int64
+	if err := siblings(tx, workID, userID, parentID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	if position == nil || *position >= int(count) {
+		return int(count), nil
+	}
+	index := *position
+	if index < 0 {
+		index = 0
+	}
+	err := siblings(tx, workID, userID, parentID).
+		Where("order_index >= ?", index).
+		Update("order_index", gorm.Expr("order_index + 1")).Error
+	return index, err
+}
+
+// closeGap 节点移出后，把后面的同级节点前移
+func closeGap(tx *gorm.DB, workID, userID, parentID string, orderIndex int) error {
+	return siblings(tx, workID, userID, parentID).
+		Where("order_index > ?", orderIndex).
+		Update("order_index", gorm.Expr("order_index - 1")).Error
+}
+
+// Create 在指定位置创建节点
+func (r *OutlineRepository) Create(node *models.OutlineNode, position *int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		index, err := insertPosition(tx, node.WorkID, node.UserID, node.ParentID, position)
+		if err != nil {
+			return err
+		}
+		node.OrderIndex = index
+		node.CreatedAt = time.Now()
+		node.UpdatedAt = time.Now()
+		return tx.Create(node).Error
+	})
+}
+
+// GetByIDAndUserID 根据ID和用户ID获取节点
+func (r *OutlineRepository) GetByIDAndUserID(id, userID string) (*models.OutlineNode, error) {
+	var node models.OutlineNode
+	err := r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).First(&node).Error
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// GetByWorkIDAndUserID 获取创作的所有节点（按父节点和顺序排列）
+func (r *OutlineRepository) GetByWorkIDAndUserID(workID, userID string) ([]models.OutlineNode, error) {
+	var nodes []models.OutlineNode
+	err := r.db.Scopes(OwnedBy(userID)).
+		Where("work_id = ?", workID).
+		Order("parent_id ASC, order_index ASC").
+		Find(&nodes).Error
+	return nodes, err
+}
+
+// UpdateByIDAndUserID 更新节点的内容字段（不改变位置）
+func (r *OutlineRepository) UpdateByIDAndUserID(node *models.OutlineNode) error {
+	node.UpdatedAt = time.Now()
+	return requireAffected(r.db.Model(&models.OutlineNode{}).
+		Scopes(OwnedBy(node.UserID)).
+		Where("id = ?", node.ID).
+		Select("title", "synopsis", "status", "target_length", "document_id", "updated_at").
+		Updates(node))
+}
+
+// Reorder 按给出的顺序重新编号同级节点，nodeIDs必须恰好是当前的同级节点
+func (r *OutlineRepository) Reorder(workID, userID, parentID string, nodeIDs []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var current []string
+		if err := siblings(tx, workID, userID, parentID).Pluck("id", &current).Error; err != nil {
+			return err
+		}
+		if len(current) != len(nodeIDs) {
+			return ErrInvalidOrder
+		}
+		expected := make(map[string]bool, len(current))
+		for _, id := range current {
+			expected[id] = true
+		}
+		for _, id := range nodeIDs {
+			if !expected[id] {
+				return ErrInvalidOrder
+			}
+			delete(expected, id)
+		}
+
+		for i, id := range nodeIDs {
+			err := siblings(tx, workID, userID, parentID).
+				Where("id = ?", id).
+				Updates(map[string]interface{}{"order_index": i, "updated_at": time.Now()}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Move 把节点移动到新父节点下的指定位置
+func (r *OutlineRepository) Move(node *models.OutlineNode, parentID string, position *int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// 在事务中重新读取节点，确保按当前位置计算
+		if err := tx.Scopes(OwnedBy(node.UserID)).Where("id = ?", node.ID).First(node).Error; err != nil {
+			return err
+		}
+
+		// 先把节点移出原父节点（暂时以自身为父节点），这样同一父节点内移动时位置按移出后的列表计算
+		err := tx.Model(&models.OutlineNode{}).
+			Scopes(OwnedBy(node.UserID)).
+			Where("id = ?", node.ID).
+			Update("parent_id", node.ID).Error
+		if err != nil {
+			return err
+		}
+		if err := closeGap(tx, node.WorkID, node.UserID, node.ParentID, node.OrderIndex); err != nil {
+			return err
+		}
+
+		index, err := insertPosition(tx, node.WorkID, node.UserID, parentID, position)
+		if err != nil {
+			return err
+		}
+		node.ParentID = parentID
+		node.OrderIndex = index
+		node.UpdatedAt = time.Now()
+		return requireAffected(tx.Model(&models.OutlineNode{}).
+			Scopes(OwnedBy(node.UserID)).
+			Where("id = ?", node.ID).
+			Updates(map[string]interface{}{
+				"parent_id":   node.ParentID,
+				"order_index": node.OrderIndex,
+				"updated_at":  node.UpdatedAt,
+			}))
+	})
+}
+
+// DeleteSubtree 删除节点及其所有子孙节点，并把后面的同级节点前移
+func (r *OutlineRepository) DeleteSubtree(node *models.OutlineNode, ids []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Scopes(OwnedBy(node.UserID)).Where("id = ?", node.ID).First(node).Error; err != nil {
+			return err
+		}
+		result := tx.Scopes(OwnedBy(node.UserID)).Where("id IN ?", ids).Delete(&models.OutlineNode{})
+		if err := requireAffected(result); err != nil {
+			return err
+		}
+		return closeGap(tx, node.WorkID, node.UserID, node.ParentID, node.OrderIndex)
+	})
+}
+
+// ClearDocumentByUserID 取消节点与已删除正文文档的关联
+func (r *OutlineRepository) ClearDocumentByUserID(userID, documentID string) error {
+	return r.db.Model(&models.OutlineNode{}).
+		Scopes(OwnedBy(userID)).
+		Where("document_id = ?", documentID).
+		Update("document_id", "").Error
+}
+
+// DeleteByWorkIDAndUserID 删除创作的所有节点
+func (r *OutlineRepository) DeleteByWorkIDAndUserID(workID, userID string) error {
+	return r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Delete(&models.OutlineNode{}).Error
+}
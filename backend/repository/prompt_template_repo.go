@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"grandma/backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PromptTemplateRepository 提示词模板仓库
+type PromptTemplateRepository struct {
+	db *gorm.DB
+}
+
+// NewPromptTemplateRepository 创建提示词模板仓库
+func NewPromptTemplateRepository(db *gorm.DB) *PromptTemplateRepository {
+	return &PromptTemplateRepository{db: db}
+}
+
+// WithTx 返回绑定到事务tx的提示词模板仓库
+func (r *PromptTemplateRepository) WithTx(tx *gorm.DB) *PromptTemplateRepository {
+	return &PromptTemplateRepository{db: tx}
+}
+
+// CreateVersion 保存模板的新版本，版本号在事务中分配
+func (r *PromptTemplateRepository) CreateVersion(template *models.PromptTemplate) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var latest int
+		err := tx.Model(&models.PromptTemplate{}).
+			Scopes(OwnedBy(template.UserID)).
+			Where("work_id = ? AND name = ?", template.WorkID, template.Name).
+			Select("COALESCE(MAX(version), 0)").
+			Scan(&latest).Error
+		if err != nil {
+			return err
+		}
+		template.Version = latest + 1
+		template.CreatedAt = time.Now()
+		return tx.Create(template).Error
+	})
+}
+
+// GetLatest 获取模板生效的（最新）版本
+func (r *PromptTemplateRepository) GetLatest(userID, workID, name string) (*models.PromptTemplate, error) {
+	var template models.PromptTemplate
+	err := r.db.Scopes(OwnedBy(userID)).
+		Where("work_id = ? AND name = ?", workID, name).
+		Order("version DESC").
+		First(&template).Error
+	if err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// GetVersion 获取模板的指定版本
+func (r *PromptTemplateRepository) GetVersion(userID, workID, name string, version int) (*models.PromptTemplate, error) {
+	var template models.PromptTemplate
+	err := r.db.Scopes(OwnedBy(userID)).
+		Where("work_id = ? AND name = ? AND version = ?", workID, name, version).
+		First(&template).Error
+	if err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// GetVersions 获取模板的所有版本（新版本在前）
+func (r *PromptTemplateRepository) GetVersions(userID, workID, name string) ([]models.PromptTemplate, error) {
+	var templates []models.PromptTemplate
+	err := r.db.Scopes(OwnedBy(userID)).
+		Where("work_id = ? AND name = ?", workID, name).
+		Order("version DESC").
+		Find(&templates).Error
+	return templates, err
+}
+
+// DeleteByNameAndUserID 删除模板的所有版本（恢复为上一级模板）
+func (r *PromptTemplateRepository) DeleteByNameAndUserID(userID, workID, name string) error {
+	return requireAffected(r.db.Scopes(OwnedBy(userID)).
+		Where("work_id = ? AND name = ?", workID, name).
+		Delete(&models.PromptTemplate{}))
+}
+
+// DeleteByWorkIDAndUserID 删除创作的所有模板
+func (r *PromptTemplateRepository) DeleteByWorkIDAndUserID(workID, userID string) error {
+	return r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Delete(&models.PromptTemplate{}).Error
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"grandma/backend/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ProviderCredentialRepository 服务商凭证仓库
+type ProviderCredentialRepository struct {
+	db *gorm.DB
+}
+
+// NewProviderCredentialRepository 创建服务商凭证仓库
+func NewProviderCredentialRepository(db *gorm.DB) *ProviderCredentialRepository {
+	return &ProviderCredentialRepository{db: db}
+}
+
+// Upsert 保存用户某个服务商的凭证，已存在时覆盖
+func (r *ProviderCredentialRepository) Upsert(credential *models.ProviderCredential) error {
+	now := time.Now()
+	credential.CreatedAt = now
+	credential.UpdatedAt = now
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "provider"}},
+		DoUpdates: clause.AssignmentColumns([]string{"encrypted_api_key", "key_hint", "base_url", "updated_at"}),
+	}).Create(credential).Error
+}
+
+// GetByUserIDAndProvider 获取用户某个服务商的凭证
+func (r *ProviderCredentialRepository) GetByUserIDAndProvider(userID, provider string) (*models.ProviderCredential, error) {
+	var credential models.ProviderCredential
+	err := r.db.Scopes(OwnedBy(userID)).Where("provider = ?", provider).First(&credential).Error
+	if err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// ListByUserID 获取用户的所有凭证
+func (r *ProviderCredentialRepository) ListByUserID(userID string) ([]models.ProviderCredential, error) {
+	var credentials []models.ProviderCredential
+	err := r.db.Scopes(OwnedBy(userID)).Order("provider ASC").Find(&credentials).Error
+	return credentials, err
+}
+
+// DeleteByUserIDAndProvider 删除用户某个服务商的凭证
+func (r *ProviderCredentialRepository) DeleteByUserIDAndProvider(userID, provider string) error {
+	return requireAffected(r.db.Scopes(OwnedBy(userID)).Where("provider = ?", provider).Delete(&models.ProviderCredential{}))
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"grandma/backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RevisionRepository 修订记录仓库
+type RevisionRepository struct {
+	db *gorm.DB
+}
+
+// NewRevisionRepository 创建修订记录仓库
+func NewRevisionRepository(db *gorm.DB) *RevisionRepository {
+	return &RevisionRepository{db: db}
+}
+
+// WithTx 返回绑定到事务tx的修订记录仓库
+func (r *RevisionRepository) WithTx(tx *gorm.DB) *RevisionRepository {
+	return &RevisionRepository{db: tx}
+}
+
+// Transaction 在事务中执行fn，fn返回错误时回滚
+func (r *RevisionRepository) Transaction(fn func(tx *gorm.DB) error) error {
+	return r.db.Transaction(fn)
+}
+
+// ofTarget 同一对象的修订记录
+func ofTarget(userID, targetType, targetID string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Scopes(OwnedBy(userID)).Where("target_type = ? AND target_id = ?", targetType, targetID)
+	}
+}
+
+// CreateNext 在事务中创建下一个修订：先确定修订号，再调用prepare填充内容
+// prepare收到事务内的仓库和当前最新的修订（没有时为nil），返回错误时不保存
+func (r *RevisionRepository) CreateNext(revision *models.Revision, prepare func(tx *RevisionRepository, latest *models.Revision) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		txRepo := &RevisionRepository{db: tx}
+		latest, err := txRepo.GetLatest(revision.UserID, revision.TargetType, revision.TargetID)
+		if err != nil && !IsNotFound(err) {
+			return err
+		}
+
+		revision.Number = 1
+		if latest != nil {
+			revision.Number = latest.Number + 1
+		}
+		if err := prepare(txRepo, latest); err != nil {
+			return err
+		}
+		revision.CreatedAt = time.Now()
+		return tx.Create(revision).Error
+	})
+}
+
+// GetLatest 获取对象最新的修订（不包含内容）
+func (r *RevisionRepository) GetLatest(userID, targetType, targetID string) (*models.Revision, error) {
+	var revision models.Revision
+	err := r.db.Scopes(ofTarget(userID, targetType, targetID)).
+		Omit("data").
+		Order("number DESC").
+		First(&revision).Error
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// GetByNumber 获取指定修订号的修订（不包含内容）
+func (r *RevisionRepository) GetByNumber(userID, targetType, targetID string, number int) (*models.Revision, error) {
+	var revision models.Revision
+	err := r.db.Scopes(ofTarget(userID, targetType, targetID)).
+		Omit("data").
+		Where("number = ?", number).
+		First(&revision).Error
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// GetList 获取对象的所有修订（按修订号倒序，不包含内容）
+func (r *RevisionRepository) GetList(userID, targetType, targetID string) ([]models.Revision, error) {
+	var revisions []models.Revision
+	err := r.db.Scopes(ofTarget(userID, targetType, targetID)).
+		Omit("data").
+		Order("number DESC").
+		Find(&revisions).Error
+	return revisions, err
+}
+
+// GetChain 获取还原指定修订所需的修订：从不晚于它的最近一个完整快照到它本身（按修订号正序）
+func (r *RevisionRepository) GetChain(userID, targetType, targetID string, number int) ([]models.Revision, error) {
+	var snapshot models.Revision
+	err := r.db.Scopes(ofTarget(userID, targetType, targetID)).
+		Omit("data").
+		Where("number <= ? AND encoding = ?", number, models.RevisionEncodingSnapshot).
+		Order("number DESC").
+		First(&snapshot).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []models.Revision
+	err = r.db.Scopes(ofTarget(userID, targetType, targetID)).
+		Where("number BETWEEN ? AND ?", snapshot.Number, number).
+		Order("number ASC").
+		Find(&revisions).Error
+	return revisions, err
+}
+
+// DeleteByTargetAndUserID 删除对象的所有修订
+func (r *RevisionRepository) DeleteByTargetAndUserID(userID, targetType, targetID string) error {
+	return r.db.Scopes(ofTarget(userID, targetType, targetID)).Delete(&models.Revision{}).Error
+}
+
+// DeleteByWorkIDAndUserID 删除创作下所有文档的修订
+func (r *RevisionRepository) DeleteByWorkIDAndUserID(workID, userID string) error {
+	return r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Delete(&models.Revision{}).Error
+}
@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// OwnedBy 数据隔离作用域：把 user_id 条件强制加入查询
+// 所有面向用户的查询、更新和删除都应通过 r.db.Scopes(OwnedBy(userID)) 构造
+func OwnedBy(userID string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ?", userID)
+	}
+}
+
+// IsNotFound 判断错误是否表示记录不存在（包括不属于当前用户的记录）
+func IsNotFound(err error) bool {
+	return errors.Is(err, gorm.ErrRecordNotFound)
+}
+
+// requireAffected 更新/删除没有命中任何记录时返回 gorm.ErrRecordNotFound，
+// 避免对不存在或不属于当前用户的记录静默返回成功
+func requireAffected(result *gorm.DB) error {
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"grandma/backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SessionRepository 会话仓库
+type SessionRepository struct {
+	db *gorm.DB
+}
+
+// NewSessionRepository 创建会话仓库
+func NewSessionRepository(db *gorm.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+// Create 创建会话
+func (r *SessionRepository) Create(session *models.Session) error {
+	session.CreatedAt = time.Now()
+	return r.db.Create(session).Error
+}
+
+// GetValidByTokenHash 根据令牌特征值获取未过期的会话
+func (r *SessionRepository) GetValidByTokenHash(tokenHash string) (*models.Session, error) {
+	var session models.Session
+	err := r.db.Where("token_hash = ? AND expires_at > ?", tokenHash, time.Now()).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// DeleteByTokenHash 删除会话（登出）
+func (r *SessionRepository) DeleteByTokenHash(tokenHash string) error {
+	return r.db.Where("token_hash = ?", tokenHash).Delete(&models.Session{}).Error
+}
+
+// DeleteExpired 删除所有已过期的会话
+func (r *SessionRepository) DeleteExpired() error {
+	return r.db.Where("expires_at <= ?", time.Now()).Delete(&models.Session{}).Error
+}
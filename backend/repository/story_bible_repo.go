@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"encoding/json"
+	"grandma/backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StoryBibleRepository 故事设定集仓库
+type StoryBibleRepository struct {
+	db *gorm.DB
+}
+
+// NewStoryBibleRepository 创建故事设定集仓库
+func NewStoryBibleRepository(db *gorm.DB) *StoryBibleRepository {
+	return &StoryBibleRepository{db: db}
+}
+
+// WithTx 返回绑定到事务tx的故事设定集仓库
+func (r *StoryBibleRepository) WithTx(tx *gorm.DB) *StoryBibleRepository {
+	return &StoryBibleRepository{db: tx}
+}
+
+// Create 创建设定条目
+func (r *StoryBibleRepository) Create(entry *models.StoryBibleEntry) error {
+	entry.CreatedAt = time.Now()
+	entry.UpdatedAt = time.Now()
+	return r.db.Create(entry).Error
+}
+
+// GetByWorkIDAndUserID 获取创作的所有设定条目
+func (r *StoryBibleRepository) GetByWorkIDAndUserID(workID, userID string) ([]models.StoryBibleEntry, error) {
+	var entries []models.StoryBibleEntry
+	err := r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Order("category ASC, created_at ASC").Find(&entries).Error
+	return entries, err
+}
+
+// GetByNameAndUserID 根据分类和名称获取设定条目
+func (r *StoryBibleRepository) GetByNameAndUserID(workID, userID, category, name string) (*models.StoryBibleEntry, error) {
+	var entry models.StoryBibleEntry
+	err := r.db.Scopes(OwnedBy(userID)).
+		Where("work_id = ? AND category = ? AND name = ?", workID, category, name).
+		First(&entry).Error
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// AppendContentByIDAndUserID 追加设定内容
+func (r *StoryBibleRepository) AppendContentByIDAndUserID(id, userID, content string) error {
+	return requireAffected(r.db.Model(&models.StoryBibleEntry{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"content":    gorm.Expr("content || ?", content),
+			"embedding":  "",
+			"updated_at": time.Now(),
+		}))
+}
+
+// GetByIDAndUserID 根据ID和用户ID获取设定条目
+func (r *StoryBibleRepository) GetByIDAndUserID(id, userID string) (*models.StoryBibleEntry, error) {
+	var entry models.StoryBibleEntry
+	err := r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).First(&entry).Error
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// MergeByIDAndUserID 替换设定条目的别名并追加内容（content为空时只更新别名）
+func (r *StoryBibleRepository) MergeByIDAndUserID(id, userID string, aliases []string, content string) error {
+	data, err := json.Marshal(aliases)
+	if err != nil {
+		return err
+	}
+	return requireAffected(r.db.Model(&models.StoryBibleEntry{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"aliases":    string(data),
+			"content":    gorm.Expr("content || ?", content),
+			"embedding":  "",
+			"updated_at": time.Now(),
+		}))
+}
+
+// UpdateEmbeddingByIDAndUserID 保存设定条目的向量嵌入，不改变updated_at
+func (r *StoryBibleRepository) UpdateEmbeddingByIDAndUserID(id, userID, embedding string) error {
+	return r.db.Model(&models.StoryBibleEntry{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		UpdateColumn("embedding", embedding).Error
+}
+
+// DeleteByWorkIDAndUserID 删除创作的所有设定条目
+func (r *StoryBibleRepository) DeleteByWorkIDAndUserID(workID, userID string) error {
+	return r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Delete(&models.StoryBibleEntry{}).Error
+}
@@ -8,6 +8,19 @@ import (
 	"gorm.io/gorm"
 )
 
+// StoryConflictError 故事写入冲突，Story为服务器上的当前故事
+type StoryConflictError struct {
+	Story *models.Story
+}
+
+func (e *StoryConflictError) Error() string {
+	return ErrVersionConflict.Error()
+}
+
+func (e *StoryConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
 // StoryRepository 故事仓库
 type StoryRepository struct {
 	db *gorm.DB
@@ -18,29 +31,32 @@ func NewStoryRepository(db *gorm.DB) *StoryRepository {
 	return &StoryRepository{db: db}
 }
 
+// WithTx 返回绑定到事务tx的故事仓库
+func (r *StoryRepository) WithTx(tx *gorm.DB) *StoryRepository {
+	return &StoryRepository{db: tx}
+}
+
+// Transaction 在事务中执行fn，fn返回错误时回滚
+func (r *StoryRepository) Transaction(fn func(tx *gorm.DB) error) error {
+	return r.db.Transaction(fn)
+}
+
 // Create 创建故事
 func (r *StoryRepository) Create(story *models.Story) error {
 	story.CreatedAt = time.Now()
 	story.UpdatedAt = time.Now()
+	story.Version = 1
 	return r.db.Create(story).Error
 }
 
-// GetByGuid 根据Guid获取故事
-func (r *StoryRepository) GetByGuid(Guid string) ([]models.Story, error) {
-	var stories []models.Story
-	err := r.db.Preload("Document").Where("guid = ?", Guid).Order("created_at DESC").Find(&stories).Error
-	if err != nil {
-		fmt.Printf("[Story_repo GetByGuid] Error: %+v\n", err)
-		return nil, err
-	}
-	fmt.Printf("[Story_repo GetByGuid] Documents: %+v\n", stories)
-	return stories, nil
-}
-
 // GetByGuidAndUserID 根据Guid和用户ID获取故事（确保数据隔离）
 func (r *StoryRepository) GetByGuidAndUserID(Guid, userID string) ([]models.Story, error) {
 	var stories []models.Story
-	err := r.db.Preload("Document").Where("guid = ? AND user_id = ?", Guid, userID).Order("created_at DESC").Find(&stories).Error
+	err := r.db.Scopes(OwnedBy(userID)).
+		Preload("Document", "user_id = ?", userID).
+		Where("guid = ?", Guid).
+		Order("created_at DESC").
+		Find(&stories).Error
 	if err != nil {
 		fmt.Printf("[Story_repo GetByGuidAndUserID] Error: %+v\n", err)
 		return nil, err
@@ -48,21 +64,13 @@ func (r *StoryRepository) GetByGuidAndUserID(Guid, userID string) ([]models.Stor
 	return stories, nil
 }
 
-// GetAll 获取所有故事（用于默认guid）
-func (r *StoryRepository) GetAll() ([]models.Story, error) {
-	var stories []models.Story
-	err := r.db.Preload("Document").Order("created_at DESC").Find(&stories).Error
-	if err != nil {
-		fmt.Printf("[Story_repo GetAll] Error: %+v\n", err)
-		return nil, err
-	}
-	return stories, nil
-}
-
 // GetAllByUserID 根据用户ID获取所有故事（确保数据隔离）
 func (r *StoryRepository) GetAllByUserID(userID string) ([]models.Story, error) {
 	var stories []models.Story
-	err := r.db.Preload("Document").Where("user_id = ?", userID).Order("created_at DESC").Find(&stories).Error
+	err := r.db.Scopes(OwnedBy(userID)).
+		Preload("Document", "user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&stories).Error
 	if err != nil {
 		fmt.Printf("[Story_repo GetAllByUserID] Error: %+v\n", err)
 		return nil, err
@@ -70,20 +78,15 @@ func (r *StoryRepository) GetAllByUserID(userID string) ([]models.Story, error)
 	return stories, nil
 }
 
-// Delete 删除文档
-func (r *StoryRepository) Delete(id string) error {
-	return r.db.Delete(&models.Story{}, "id = ?", id).Error
-}
-
 // DeleteByIDAndUserID 根据ID和用户ID删除故事（确保数据隔离）
 func (r *StoryRepository) DeleteByIDAndUserID(id, userID string) error {
-	return r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Story{}).Error
+	return requireAffected(r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).Delete(&models.Story{}))
 }
 
-// GetByContentHash 根据内容特征值查找故事
-func (r *StoryRepository) GetByContentHash(guid, contentHash string) (*models.Story, error) {
+// GetByContentHashAndUserID 根据内容特征值和用户ID查找故事（确保数据隔离）
+func (r *StoryRepository) GetByContentHashAndUserID(guid, userID, contentHash string) (*models.Story, error) {
 	var story models.Story
-	err := r.db.Where("guid = ? AND content_hash = ?", guid, contentHash).First(&story).Error
+	err := r.db.Scopes(OwnedBy(userID)).Where("guid = ? AND content_hash = ?", guid, contentHash).First(&story).Error
 	if err != nil {
 		// 如果是记录不存在的错误，返回nil而不是错误
 		if err == gorm.ErrRecordNotFound {
@@ -94,42 +97,72 @@ func (r *StoryRepository) GetByContentHash(guid, contentHash string) (*models.St
 	return &story, nil
 }
 
-// GetByContentHashAndUserID 根据内容特征值和用户ID查找故事（确保数据隔离）
-func (r *StoryRepository) GetByContentHashAndUserID(guid, userID, contentHash string) (*models.Story, error) {
+// GetByIDAndUserID 根据ID和用户ID获取故事（确保数据隔离）
+func (r *StoryRepository) GetByIDAndUserID(id, userID string) (*models.Story, error) {
 	var story models.Story
-	err := r.db.Where("guid = ? AND user_id = ? AND content_hash = ?", guid, userID, contentHash).First(&story).Error
+	err := r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).First(&story).Error
 	if err != nil {
-		// 如果是记录不存在的错误，返回nil而不是错误
-		if err == gorm.ErrRecordNotFound {
-			return nil, nil
-		}
 		return nil, err
 	}
 	return &story, nil
 }
 
-// GetByID 根据ID获取故事
-func (r *StoryRepository) GetByID(id string) (*models.Story, error) {
-	var story models.Story
-	err := r.db.Where("id = ?", id).First(&story).Error
-	if err != nil {
-		return nil, err
+// UpdateByIDAndUserID 更新故事的标题和内容（确保数据隔离），要求当前版本号与story.Version相同，成功后版本号加一
+func (r *StoryRepository) UpdateByIDAndUserID(story *models.Story) error {
+	story.UpdatedAt = time.Now()
+	result := r.db.Model(&models.Story{}).
+		Scopes(OwnedBy(story.UserID)).
+		Where("id = ? AND version = ?", story.ID, story.Version).
+		Updates(map[string]interface{}{
+			"title":        story.Title,
+			"content":      story.Content,
+			"content_hash": story.ContentHash,
+			"updated_at":   story.UpdatedAt,
+			"version":      gorm.Expr("version + 1"),
+		})
+	if result.Error != nil {
+		return result.Error
 	}
-	return &story, nil
+	if result.RowsAffected == 0 {
+		current, err := r.GetByIDAndUserID(story.ID, story.UserID)
+		if err != nil {
+			return err
+		}
+		return &StoryConflictError{Story: current}
+	}
+	story.Version++
+	return nil
 }
 
-// GetByIDAndUserID 根据ID和用户ID获取故事（确保数据隔离）
-func (r *StoryRepository) GetByIDAndUserID(id, userID string) (*models.Story, error) {
-	var story models.Story
-	err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&story).Error
-	if err != nil {
-		return nil, err
+// CheckStoryVersion 请求指定了版本号（If-Match）时检查与故事的当前版本一致，version为0表示不检查
+func CheckStoryVersion(story *models.Story, version int64) error {
+	if version > 0 && version != story.Version {
+		return &StoryConflictError{Story: story}
 	}
-	return &story, nil
+	return nil
 }
 
-// Update 更新故事
-func (r *StoryRepository) Update(story *models.Story) error {
-	story.UpdatedAt = time.Now()
-	return r.db.Save(story).Error
+// ClearDocumentIDsByUserID 批量清除引用指定文档的故事关联（用于级联删除，确保数据隔离）
+func (r *StoryRepository) ClearDocumentIDsByUserID(userID string, documentIDs []string) error {
+	if len(documentIDs) == 0 {
+		return nil
+	}
+	return r.db.Model(&models.Story{}).
+		Scopes(OwnedBy(userID)).
+		Where("document_id IN ?", documentIDs).
+		Update("document_id", "").Error
+}
+
+// GetWithMissingDocument 获取关联文档已不存在的故事（仅用于一致性检查）
+func (r *StoryRepository) GetWithMissingDocument() ([]models.Story, error) {
+	var stories []models.Story
+	err := r.db.Where("document_id != '' AND document_id IS NOT NULL").
+		Where("document_id NOT IN (?)", r.db.Model(&models.Document{}).Select("id")).
+		Find(&stories).Error
+	return stories, err
+}
+
+// ClearDocumentID 清除故事的关联文档ID（仅用于一致性修复，故事内容本身已保存在content中）
+func (r *StoryRepository) ClearDocumentID(id string) error {
+	return r.db.Model(&models.Story{}).Where("id = ?", id).Update("document_id", "").Error
 }
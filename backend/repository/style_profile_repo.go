@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"grandma/backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StyleProfileRepository 文风档案仓库
+type StyleProfileRepository struct {
+	db *gorm.DB
+}
+
+// NewStyleProfileRepository 创建文风档案仓库
+func NewStyleProfileRepository(db *gorm.DB) *StyleProfileRepository {
+	return &StyleProfileRepository{db: db}
+}
+
+// WithTx 返回绑定到事务tx的文风档案仓库
+func (r *StyleProfileRepository) WithTx(tx *gorm.DB) *StyleProfileRepository {
+	return &StyleProfileRepository{db: tx}
+}
+
+// Create 创建文风档案
+func (r *StyleProfileRepository) Create(profile *models.StyleProfile) error {
+	profile.CreatedAt = time.Now()
+	profile.UpdatedAt = time.Now()
+	return r.db.Create(profile).Error
+}
+
+// GetByIDAndUserID 根据ID和用户ID获取文风档案
+func (r *StyleProfileRepository) GetByIDAndUserID(id, userID string) (*models.StyleProfile, error) {
+	var profile models.StyleProfile
+	err := r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).First(&profile).Error
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// GetAllByUserID 获取用户的所有文风档案
+func (r *StyleProfileRepository) GetAllByUserID(userID string) ([]models.StyleProfile, error) {
+	var profiles []models.StyleProfile
+	err := r.db.Scopes(OwnedBy(userID)).Order("updated_at DESC").Find(&profiles).Error
+	return profiles, err
+}
+
+// UpdateByIDAndUserID 更新文风档案的内容
+func (r *StyleProfileRepository) UpdateByIDAndUserID(profile *models.StyleProfile) error {
+	profile.UpdatedAt = time.Now()
+	return requireAffected(r.db.Model(&models.StyleProfile{}).
+		Scopes(OwnedBy(profile.UserID)).
+		Where("id = ?", profile.ID).
+		Select("name", "voice", "tense", "point_of_view", "banned_words", "reading_level", "sample_passages", "updated_at").
+		Updates(profile))
+}
+
+// DeleteByIDAndUserID 删除文风档案
+func (r *StyleProfileRepository) DeleteByIDAndUserID(id, userID string) error {
+	return requireAffected(r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).Delete(&models.StyleProfile{}))
+}
@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"grandma/backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TimelineRepository 时间线仓库（历法和事件）
+type TimelineRepository struct {
+	db *gorm.DB
+}
+
+// NewTimelineRepository 创建时间线仓库
+func NewTimelineRepository(db *gorm.DB) *TimelineRepository {
+	return &TimelineRepository{db: db}
+}
+
+// WithTx 返回绑定到事务tx的时间线仓库
+func (r *TimelineRepository) WithTx(tx *gorm.DB) *TimelineRepository {
+	return &TimelineRepository{db: tx}
+}
+
+// GetCalendarByWorkIDAndUserID 获取创作的历法，未设置时返回gorm.ErrRecordNotFound
+func (r *TimelineRepository) GetCalendarByWorkIDAndUserID(workID, userID string) (*models.TimelineCalendar, error) {
+	var calendar models.TimelineCalendar
+	err := r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).First(&calendar).Error
+	if err != nil {
+		return nil, err
+	}
+	return &calendar, nil
+}
+
+// SaveCalendar 保存历法，并在同一事务中更新有日期事件的位置（ordinals为事件ID到新位置的映射）
+func (r *TimelineRepository) SaveCalendar(calendar *models.TimelineCalendar, ordinals map[string]float64) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		calendar.UpdatedAt = time.Now()
+		if calendar.CreatedAt.IsZero() {
+			calendar.CreatedAt = time.Now()
+			if err := tx.Create(calendar).Error; err != nil {
+				return err
+			}
+		} else {
+			err := tx.Model(&models.TimelineCalendar{}).
+				Scopes(OwnedBy(calendar.UserID)).
+				Where("id = ?", calendar.ID).
+				Select("era", "months", "updated_at").
+				Updates(calendar).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		for id, ordinal := range ordinals {
+			err := tx.Model(&models.TimelineEvent{}).
+				Scopes(OwnedBy(calendar.UserID)).
+				Where("id = ?", id).
+				Update("ordinal", ordinal).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CreateEvent 创建时间线事件
+func (r *TimelineRepository) CreateEvent(event *models.TimelineEvent) error {
+	event.CreatedAt = time.Now()
+	event.UpdatedAt = time.Now()
+	return r.db.Create(event).Error
+}
+
+// GetEventByIDAndUserID 根据ID和用户ID获取时间线事件
+func (r *TimelineRepository) GetEventByIDAndUserID(id, userID string) (*models.TimelineEvent, error) {
+	var event models.TimelineEvent
+	err := r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).First(&event).Error
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// GetEventsByWorkIDAndUserID 获取创作的所有时间线事件（按故事时间排序，同一时间按创建顺序）
+func (r *TimelineRepository) GetEventsByWorkIDAndUserID(workID, userID string) ([]models.TimelineEvent, error) {
+	var events []models.TimelineEvent
+	err := r.db.Scopes(OwnedBy(userID)).
+		Where("work_id = ?", workID).
+		Order("ordinal ASC, created_at ASC").
+		Find(&events).Error
+	return events, err
+}
+
+// UpdateEventByIDAndUserID 更新时间线事件的所有可编辑字段
+func (r *TimelineRepository) UpdateEventByIDAndUserID(event *models.TimelineEvent) error {
+	event.UpdatedAt = time.Now()
+	return requireAffected(r.db.Model(&models.TimelineEvent{}).
+		Scopes(OwnedBy(event.UserID)).
+		Where("id = ?", event.ID).
+		Select("title", "description", "year", "month", "day", "ordinal", "participants", "location",
+			"document_id", "source_quote", "source_start", "source_end", "updated_at").
+		Updates(event))
+}
+
+// DeleteEventByIDAndUserID 删除时间线事件
+func (r *TimelineRepository) DeleteEventByIDAndUserID(id, userID string) error {
+	return requireAffected(r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).Delete(&models.TimelineEvent{}))
+}
+
+// ClearDocumentByUserID 取消事件与已删除正文文档的关联（事件本身保留）
+func (r *TimelineRepository) ClearDocumentByUserID(userID, documentID string) error {
+	return r.db.Model(&models.TimelineEvent{}).
+		Scopes(OwnedBy(userID)).
+		Where("document_id = ?", documentID).
+		Updates(map[string]interface{}{
+			"document_id":  "",
+			"source_start": nil,
+			"source_end":   nil,
+		}).Error
+}
+
+// DeleteByWorkIDAndUserID 删除创作的历法和所有时间线事件
+func (r *TimelineRepository) DeleteByWorkIDAndUserID(workID, userID string) error {
+	if err := r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Delete(&models.TimelineEvent{}).Error; err != nil {
+		return err
+	}
+	return r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Delete(&models.TimelineCalendar{}).Error
+}
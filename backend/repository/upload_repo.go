@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"grandma/backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UploadRepository 上传文件仓库
+type UploadRepository struct {
+	db *gorm.DB
+}
+
+// NewUploadRepository 创建上传文件仓库
+func NewUploadRepository(db *gorm.DB) *UploadRepository {
+	return &UploadRepository{db: db}
+}
+
+// Create 创建上传记录
+func (r *UploadRepository) Create(upload *models.Upload) error {
+	upload.CreatedAt = time.Now()
+	return r.db.Create(upload).Error
+}
+
+// GetByIDAndUserID 根据ID获取上传记录（限定用户）
+func (r *UploadRepository) GetByIDAndUserID(id, userID string) (*models.Upload, error) {
+	var upload models.Upload
+	err := r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).First(&upload).Error
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// DeleteByIDAndUserID 删除上传记录（限定用户）
+func (r *UploadRepository) DeleteByIDAndUserID(id, userID string) error {
+	return requireAffected(r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).Delete(&models.Upload{}))
+}
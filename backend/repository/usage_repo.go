@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"grandma/backend/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UsageRepository 用量仓库
+type UsageRepository struct {
+	db *gorm.DB
+}
+
+// NewUsageRepository 创建用量仓库
+func NewUsageRepository(db *gorm.DB) *UsageRepository {
+	return &UsageRepository{db: db}
+}
+
+// CreateEvent 记录一次用量
+func (r *UsageRepository) CreateEvent(event *models.UsageEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	return r.db.Create(event).Error
+}
+
+// SumTokensSince 统计用户自某时间以来消耗的Token总数（输入+输出）
+func (r *UsageRepository) SumTokensSince(userID string, since time.Time) (int64, error) {
+	var total int64
+	err := r.db.Model(&models.UsageEvent{}).Scopes(OwnedBy(userID)).
+		Where("created_at >= ?", since).
+		Select("COALESCE(SUM(input_tokens + output_tokens), 0)").
+		Scan(&total).Error
+	return total, err
+}
+
+// SummarizeSince 按功能和模型分组统计用户自某时间以来的用量
+func (r *UsageRepository) SummarizeSince(userID string, since time.Time) ([]models.UsageBreakdown, error) {
+	var breakdown []models.UsageBreakdown
+	err := r.db.Model(&models.UsageEvent{}).Scopes(OwnedBy(userID)).
+		Where("created_at >= ?", since).
+		Select("feature, model, COUNT(*) AS calls, SUM(input_tokens) AS input_tokens, SUM(output_tokens) AS output_tokens, SUM(cost_usd) AS cost_usd").
+		Group("feature, model").
+		Order("feature ASC, model ASC").
+		Scan(&breakdown).Error
+	return breakdown, err
+}
+
+// ListEventsByUserID 分页获取用户的用量明细（按时间倒序）
+func (r *UsageRepository) ListEventsByUserID(userID string, page, pageSize int) ([]models.UsageEvent, int64, error) {
+	var events []models.UsageEvent
+	var total int64
+
+	offset := (page - 1) * pageSize
+	if offset < 0 {
+		offset = 0
+	}
+	if pageSize <= 0 {
+		pageSize = 50
+	}
+
+	err := r.db.Model(&models.UsageEvent{}).Scopes(OwnedBy(userID)).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = r.db.Scopes(OwnedBy(userID)).
+		Order("created_at DESC").
+		Offset(offset).
+		Limit(pageSize).
+		Find(&events).Error
+	return events, total, err
+}
+
+// GetQuota 获取用户的配额设置
+func (r *UsageRepository) GetQuota(userID string) (*models.UsageQuota, error) {
+	var quota models.UsageQuota
+	err := r.db.Scopes(OwnedBy(userID)).First(&quota).Error
+	if err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// UpsertQuota 保存用户的配额设置
+func (r *UsageRepository) UpsertQuota(quota *models.UsageQuota) error {
+	quota.UpdatedAt = time.Now()
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"daily_tokens", "monthly_tokens", "updated_at"}),
+	}).Create(quota).Error
+}
@@ -17,6 +17,11 @@ func NewVectorChunkRepository(db *gorm.DB) *VectorChunkRepository {
 	return &VectorChunkRepository{db: db}
 }
 
+// WithTx 返回绑定到事务tx的向量chunk仓库
+func (r *VectorChunkRepository) WithTx(tx *gorm.DB) *VectorChunkRepository {
+	return &VectorChunkRepository{db: tx}
+}
+
 // Create 创建向量chunk
 func (r *VectorChunkRepository) Create(chunk *models.VectorChunk) error {
 	chunk.CreatedAt = time.Now()
@@ -58,7 +63,7 @@ func (r *VectorChunkRepository) GetByWorkID(workID string) ([]models.VectorChunk
 // GetByUserID 根据用户ID获取所有chunks（用于数据隔离）
 func (r *VectorChunkRepository) GetByUserID(userID string) ([]models.VectorChunk, error) {
 	var chunks []models.VectorChunk
-	err := r.db.Where("user_id = ?", userID).Find(&chunks).Error
+	err := r.db.Scopes(OwnedBy(userID)).Find(&chunks).Error
 	return chunks, err
 }
 
@@ -68,7 +73,7 @@ func (r *VectorChunkRepository) GetByUserID(userID string) ([]models.VectorChunk
 // excludeConversationID 和 excludeWorkID 用于排除当前对话/创作，避免重复
 func (r *VectorChunkRepository) GetAllWithEmbeddings(userID string, excludeConversationID, excludeWorkID string) ([]models.VectorChunk, error) {
 	var chunks []models.VectorChunk
-	query := r.db.Where("user_id = ?", userID)
+	query := r.db.Scopes(OwnedBy(userID))
 
 	// 排除当前对话/创作中的文档，避免重复
 	if excludeConversationID != "" {
@@ -82,11 +87,28 @@ func (r *VectorChunkRepository) GetAllWithEmbeddings(userID string, excludeConve
 	return chunks, err
 }
 
+// GetByWorkIDWithEmbeddings 获取某个创作中带向量嵌入的chunks（用于在创作内搜索）
+func (r *VectorChunkRepository) GetByWorkIDWithEmbeddings(userID, workID string) ([]models.VectorChunk, error) {
+	var chunks []models.VectorChunk
+	err := r.db.Scopes(OwnedBy(userID)).
+		Where("work_id = ? AND embedding_json != '' AND embedding_json IS NOT NULL", workID).
+		Find(&chunks).Error
+	return chunks, err
+}
+
 // DeleteByDocumentID 删除文档的所有chunks
 func (r *VectorChunkRepository) DeleteByDocumentID(documentID string) error {
 	return r.db.Where("document_id = ?", documentID).Delete(&models.VectorChunk{}).Error
 }
 
+// DeleteByDocumentIDs 批量删除多个文档的chunks
+func (r *VectorChunkRepository) DeleteByDocumentIDs(documentIDs []string) error {
+	if len(documentIDs) == 0 {
+		return nil
+	}
+	return r.db.Where("document_id IN ?", documentIDs).Delete(&models.VectorChunk{}).Error
+}
+
 // DeleteByConversationID 删除对话的所有chunks
 func (r *VectorChunkRepository) DeleteByConversationID(conversationID string) error {
 	return r.db.Where("conversation_id = ?", conversationID).Delete(&models.VectorChunk{}).Error
@@ -103,3 +125,21 @@ func (r *VectorChunkRepository) Update(chunk *models.VectorChunk) error {
 	return r.db.Save(chunk).Error
 }
 
+// GetOrphans 获取来源文档已不存在的chunks（不在documents、work_documents和work_chat_messages中）
+func (r *VectorChunkRepository) GetOrphans() ([]models.VectorChunk, error) {
+	var chunks []models.VectorChunk
+	err := r.db.Select("id", "user_id", "conversation_id", "work_id", "document_id").
+		Where("document_id NOT IN (?)", r.db.Model(&models.Document{}).Select("id")).
+		Where("document_id NOT IN (?)", r.db.Model(&models.WorkDocument{}).Select("id")).
+		Where("document_id NOT IN (?)", r.db.Model(&models.WorkChatMessage{}).Select("id")).
+		Find(&chunks).Error
+	return chunks, err
+}
+
+// DeleteByIDs 批量删除chunks
+func (r *VectorChunkRepository) DeleteByIDs(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.Where("id IN ?", ids).Delete(&models.VectorChunk{}).Error
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"grandma/backend/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WorkChatMessageRepository 灵感模式对话消息仓库
+type WorkChatMessageRepository struct {
+	db *gorm.DB
+}
+
+// NewWorkChatMessageRepository 创建灵感模式对话消息仓库
+func NewWorkChatMessageRepository(db *gorm.DB) *WorkChatMessageRepository {
+	return &WorkChatMessageRepository{db: db}
+}
+
+// WithTx 返回绑定到事务tx的灵感模式对话消息仓库
+func (r *WorkChatMessageRepository) WithTx(tx *gorm.DB) *WorkChatMessageRepository {
+	return &WorkChatMessageRepository{db: tx}
+}
+
+// Create 创建消息
+func (r *WorkChatMessageRepository) Create(message *models.WorkChatMessage) error {
+	message.CreatedAt = time.Now()
+	message.UpdatedAt = time.Now()
+	return r.db.Create(message).Error
+}
+
+// GetByIDAndUserID 根据ID和用户ID获取消息
+func (r *WorkChatMessageRepository) GetByIDAndUserID(id, userID string) (*models.WorkChatMessage, error) {
+	var message models.WorkChatMessage
+	err := r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).First(&message).Error
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// GetByWorkIDAndUserID 获取创作的所有消息（按created_at正序）
+func (r *WorkChatMessageRepository) GetByWorkIDAndUserID(workID, userID string) ([]models.WorkChatMessage, error) {
+	var messages []models.WorkChatMessage
+	err := r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Order("created_at ASC").Find(&messages).Error
+	return messages, err
+}
+
+// GetLatestByWorkIDAndUserID 获取创作最新的对话消息（按created_at倒序，不包括工具调用记录）
+func (r *WorkChatMessageRepository) GetLatestByWorkIDAndUserID(workID, userID string, limit int) ([]models.WorkChatMessage, error) {
+	var messages []models.WorkChatMessage
+	query := r.db.Scopes(OwnedBy(userID)).
+		Where("work_id = ? AND role NOT IN ?", workID, []string{models.RoleToolCall, models.RoleToolResult}).
+		Order("created_at DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// AppendContentByIDAndUserID 追加内容到消息（用于流式更新）
+func (r *WorkChatMessageRepository) AppendContentByIDAndUserID(id, userID, content string) error {
+	return requireAffected(r.db.Model(&models.WorkChatMessage{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"content":    gorm.Expr("content || ?", content),
+			"updated_at": time.Now(),
+		}))
+}
+
+// UpdateReasoningByIDAndUserID 保存消息的推理过程
+func (r *WorkChatMessageRepository) UpdateReasoningByIDAndUserID(id, userID, reasoning string) error {
+	return requireAffected(r.db.Model(&models.WorkChatMessage{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Update("reasoning", reasoning))
+}
+
+// UpdateModelByIDAndUserID 更新消息记录的模型（实际响应的后端）
+func (r *WorkChatMessageRepository) UpdateModelByIDAndUserID(id, userID, model string) error {
+	return requireAffected(r.db.Model(&models.WorkChatMessage{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Update("model", model))
+}
+
+// UpdatePromotedDocumentByIDAndUserID 记录消息加入正文时写入的文档
+func (r *WorkChatMessageRepository) UpdatePromotedDocumentByIDAndUserID(id, userID, documentID string) error {
+	return requireAffected(r.db.Model(&models.WorkChatMessage{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Update("promoted_document_id", documentID))
+}
+
+// DeleteByIDAndUserID 删除消息
+func (r *WorkChatMessageRepository) DeleteByIDAndUserID(id, userID string) error {
+	return requireAffected(r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).Delete(&models.WorkChatMessage{}))
+}
+
+// DeleteByWorkIDAndUserID 删除创作下的所有消息
+func (r *WorkChatMessageRepository) DeleteByWorkIDAndUserID(workID, userID string) error {
+	return r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Delete(&models.WorkChatMessage{}).Error
+}
+
+// GetOrphans 获取所属创作已不存在的消息（仅用于一致性检查）
+func (r *WorkChatMessageRepository) GetOrphans() ([]models.WorkChatMessage, error) {
+	var messages []models.WorkChatMessage
+	err := r.db.Where("work_id NOT IN (?)", r.db.Model(&models.Work{}).Select("id")).
+		Find(&messages).Error
+	return messages, err
+}
+
+// DeleteByIDs 批量删除消息（仅用于一致性修复）
+func (r *WorkChatMessageRepository) DeleteByIDs(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.Where("id IN ?", ids).Delete(&models.WorkChatMessage{}).Error
+}
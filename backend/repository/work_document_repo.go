@@ -1,12 +1,34 @@
 package repository
 
 import (
+	"errors"
 	"grandma/backend/models"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+var (
+	// ErrVersionConflict 文档已被其他请求修改，版本号与预期不一致
+	ErrVersionConflict = errors.New("version_conflict")
+	// ErrDocumentLocked 文档正在被流式写入，暂时不能修改
+	ErrDocumentLocked = errors.New("document_locked")
+)
+
+// WriteConflictError 文档写入冲突，Document为服务器上的当前文档
+type WriteConflictError struct {
+	Reason   error
+	Document *models.WorkDocument
+}
+
+func (e *WriteConflictError) Error() string {
+	return e.Reason.Error()
+}
+
+func (e *WriteConflictError) Unwrap() error {
+	return e.Reason
+}
+
 // WorkDocumentRepository 创作文档仓库
 type WorkDocumentRepository struct {
 	db *gorm.DB
@@ -17,17 +39,23 @@ func NewWorkDocumentRepository(db *gorm.DB) *WorkDocumentRepository {
 	return &WorkDocumentRepository{db: db}
 }
 
+// WithTx 返回绑定到事务tx的创作文档仓库
+func (r *WorkDocumentRepository) WithTx(tx *gorm.DB) *WorkDocumentRepository {
+	return &WorkDocumentRepository{db: tx}
+}
+
 // Create 创建创作文档
 func (r *WorkDocumentRepository) Create(doc *models.WorkDocument) error {
 	doc.CreatedAt = time.Now()
 	doc.UpdatedAt = time.Now()
+	doc.Version = 1
 	return r.db.Create(doc).Error
 }
 
 // GetByIDAndUserID 根据ID和用户ID获取文档
 func (r *WorkDocumentRepository) GetByIDAndUserID(id, userID string) (*models.WorkDocument, error) {
 	var doc models.WorkDocument
-	err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&doc).Error
+	err := r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).First(&doc).Error
 	if err != nil {
 		return nil, err
 	}
@@ -37,60 +65,149 @@ func (r *WorkDocumentRepository) GetByIDAndUserID(id, userID string) (*models.Wo
 // GetByWorkIDAndUserID 根据创作ID和用户ID获取所有文档
 func (r *WorkDocumentRepository) GetByWorkIDAndUserID(workID, userID string) ([]models.WorkDocument, error) {
 	var docs []models.WorkDocument
-	err := r.db.Where("work_id = ? AND user_id = ?", workID, userID).Order("created_at ASC").Find(&docs).Error
+	err := r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Order("created_at ASC").Find(&docs).Error
 	return docs, err
 }
 
-// Update 更新文档
-func (r *WorkDocumentRepository) Update(doc *models.WorkDocument) error {
-	doc.UpdatedAt = time.Now()
-	return r.db.Save(doc).Error
+// UpdateTitleByIDAndUserID 更新文档标题，version大于0时要求当前版本号与之相同
+func (r *WorkDocumentRepository) UpdateTitleByIDAndUserID(id, userID, title string, version int64) error {
+	return r.update(id, userID, version, map[string]interface{}{"title": title})
 }
 
-// UpdateTitleByIDAndUserID 更新文档标题
-func (r *WorkDocumentRepository) UpdateTitleByIDAndUserID(id, userID, title string) error {
-	return r.db.Model(&models.WorkDocument{}).
-		Where("id = ? AND user_id = ?", id, userID).
-		Update("title", title).Error
+// UpdateContentByIDAndUserID 更新文档内容，version大于0时要求当前版本号与之相同
+func (r *WorkDocumentRepository) UpdateContentByIDAndUserID(id, userID, content string, version int64) error {
+	return r.update(id, userID, version, map[string]interface{}{"content": content})
+}
+
+// AppendContentByIDAndUserID 追加内容到文档末尾，version大于0时要求当前版本号与之相同
+func (r *WorkDocumentRepository) AppendContentByIDAndUserID(id, userID, content string, version int64) error {
+	return r.update(id, userID, version, map[string]interface{}{"content": gorm.Expr("content || ?", content)})
+}
+
+// DeleteByIDAndUserID 删除文档，version大于0时要求当前版本号与之相同
+func (r *WorkDocumentRepository) DeleteByIDAndUserID(id, userID string, version int64) error {
+	query := r.db.Scopes(OwnedBy(userID), unlocked(time.Now())).Where("id = ?", id)
+	if version > 0 {
+		query = query.Where("version = ?", version)
+	}
+	result := query.Delete(&models.WorkDocument{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return r.writeConflict(id, userID)
+	}
+	return nil
+}
+
+// Lock 为流式写入锁定文档，锁在until之后自动失效；文档已被锁定时返回写入冲突
+func (r *WorkDocumentRepository) Lock(id, userID, token string, version int64, until time.Time) error {
+	return r.update(id, userID, version, map[string]interface{}{
+		"lock_token":   token,
+		"locked_until": until,
+		"version":      gorm.Expr("version"),
+	})
+}
+
+// AppendLockedContent 持有未过期的流式写入锁时追加内容，并把锁延长到until
+func (r *WorkDocumentRepository) AppendLockedContent(id, userID, token, content string, until time.Time) error {
+	return r.writeLocked(id, userID, token, until, gorm.Expr("content || ?", content))
+}
+
+// UpdateLockedContent 持有未过期的流式写入锁时替换全部内容（用于在文档中间写入），并把锁延长到until
+func (r *WorkDocumentRepository) UpdateLockedContent(id, userID, token, content string, until time.Time) error {
+	return r.writeLocked(id, userID, token, until, content)
 }
 
-// UpdateContentByIDAndUserID 更新文档内容
-func (r *WorkDocumentRepository) UpdateContentByIDAndUserID(id, userID, content string) error {
+// writeLocked 持有锁时写入内容，锁已过期或被其他写入者持有时返回gorm.ErrRecordNotFound
+func (r *WorkDocumentRepository) writeLocked(id, userID, token string, until time.Time, content interface{}) error {
+	return requireAffected(r.db.Model(&models.WorkDocument{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ? AND lock_token = ? AND locked_until > ?", id, token, time.Now()).
+		Updates(map[string]interface{}{
+			"content":      content,
+			"version":      gorm.Expr("version + 1"),
+			"locked_until": until,
+			"updated_at":   time.Now(),
+		}))
+}
+
+// Unlock 释放流式写入锁，锁已被其他写入者持有时不做任何修改
+func (r *WorkDocumentRepository) Unlock(id, userID, token string) error {
 	return r.db.Model(&models.WorkDocument{}).
-		Where("id = ? AND user_id = ?", id, userID).
+		Scopes(OwnedBy(userID)).
+		Where("id = ? AND lock_token = ?", id, token).
 		Updates(map[string]interface{}{
-			"content":    content,
-			"updated_at": time.Now(),
+			"lock_token":   "",
+			"locked_until": nil,
 		}).Error
 }
 
-// DeleteByIDAndUserID 删除文档
-func (r *WorkDocumentRepository) DeleteByIDAndUserID(id, userID string) error {
-	return r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.WorkDocument{}).Error
+// update 在文档未被锁定且版本号符合预期时更新文档并把版本号加一
+func (r *WorkDocumentRepository) update(id, userID string, version int64, updates map[string]interface{}) error {
+	query := r.db.Model(&models.WorkDocument{}).Scopes(OwnedBy(userID), unlocked(time.Now())).Where("id = ?", id)
+	if version > 0 {
+		query = query.Where("version = ?", version)
+	}
+	if _, ok := updates["version"]; !ok {
+		updates["version"] = gorm.Expr("version + 1")
+	}
+	updates["updated_at"] = time.Now()
+
+	result := query.Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return r.writeConflict(id, userID)
+	}
+	return nil
 }
 
-// AppendContent 追加内容到文档（用于流式更新）
-func (r *WorkDocumentRepository) AppendContent(id string, content string) error {
-	var doc models.WorkDocument
-	err := r.db.Where("id = ?", id).First(&doc).Error
+// writeConflict 条件写入没有命中时判断原因：文档不存在、正在流式写入或版本号不一致
+func (r *WorkDocumentRepository) writeConflict(id, userID string) error {
+	doc, err := r.GetByIDAndUserID(id, userID)
 	if err != nil {
 		return err
 	}
-	doc.Content = doc.Content + content
-	doc.UpdatedAt = time.Now()
-	return r.db.Save(&doc).Error
+	if doc.LockedUntil != nil && doc.LockedUntil.After(time.Now()) {
+		return &WriteConflictError{Reason: ErrDocumentLocked, Document: doc}
+	}
+	return &WriteConflictError{Reason: ErrVersionConflict, Document: doc}
 }
 
-// GetLatestDocumentsByWorkID 获取创作的最新文档（按created_at倒序）
-func (r *WorkDocumentRepository) GetLatestDocumentsByWorkID(workID string, limit int) ([]models.WorkDocument, error) {
-	var documents []models.WorkDocument
-	query := r.db.Where("work_id = ?", workID).Order("created_at DESC")
-	if limit > 0 {
-		query = query.Limit(limit)
+// CheckVersion 请求指定了版本号（If-Match）时检查与文档的当前版本一致，version为0表示不检查
+func CheckVersion(doc *models.WorkDocument, version int64) error {
+	if version > 0 && version != doc.Version {
+		return &WriteConflictError{Reason: ErrVersionConflict, Document: doc}
 	}
-	err := query.Find(&documents).Error
-	if err != nil {
-		return nil, err
+	return nil
+}
+
+// unlocked 排除正在流式写入的文档（过期的锁视为已释放）
+func unlocked(now time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("(locked_until IS NULL OR locked_until <= ?)", now)
+	}
+}
+
+// DeleteByWorkIDAndUserID 删除创作下的所有文档
+func (r *WorkDocumentRepository) DeleteByWorkIDAndUserID(workID, userID string) error {
+	return r.db.Scopes(OwnedBy(userID)).Where("work_id = ?", workID).Delete(&models.WorkDocument{}).Error
+}
+
+// GetOrphans 获取所属创作已不存在的文档（仅用于一致性检查）
+func (r *WorkDocumentRepository) GetOrphans() ([]models.WorkDocument, error) {
+	var docs []models.WorkDocument
+	err := r.db.Where("work_id NOT IN (?)", r.db.Model(&models.Work{}).Select("id")).
+		Find(&docs).Error
+	return docs, err
+}
+
+// DeleteByIDs 批量删除创作文档（仅用于一致性修复）
+func (r *WorkDocumentRepository) DeleteByIDs(ids []string) error {
+	if len(ids) == 0 {
+		return nil
 	}
-	return documents, nil
+	return r.db.Where("id IN ?", ids).Delete(&models.WorkDocument{}).Error
 }
@@ -17,6 +17,16 @@ func NewWorkRepository(db *gorm.DB) *WorkRepository {
 	return &WorkRepository{db: db}
 }
 
+// WithTx 返回绑定到事务tx的创作仓库
+func (r *WorkRepository) WithTx(tx *gorm.DB) *WorkRepository {
+	return &WorkRepository{db: tx}
+}
+
+// Transaction 在事务中执行fn，fn返回错误时回滚
+func (r *WorkRepository) Transaction(fn func(tx *gorm.DB) error) error {
+	return r.db.Transaction(fn)
+}
+
 // Create 创建创作
 func (r *WorkRepository) Create(work *models.Work) error {
 	work.CreatedAt = time.Now()
@@ -27,7 +37,10 @@ func (r *WorkRepository) Create(work *models.Work) error {
 // GetByIDAndUserID 根据ID和用户ID获取创作
 func (r *WorkRepository) GetByIDAndUserID(id, userID string) (*models.Work, error) {
 	var work models.Work
-	err := r.db.Preload("Documents").Where("id = ? AND user_id = ?", id, userID).First(&work).Error
+	err := r.db.Scopes(OwnedBy(userID)).
+		Preload("Documents", "user_id = ?", userID).
+		Where("id = ?", id).
+		First(&work).Error
 	if err != nil {
 		return nil, err
 	}
@@ -37,24 +50,38 @@ func (r *WorkRepository) GetByIDAndUserID(id, userID string) (*models.Work, erro
 // GetAllByUserID 获取用户的所有创作
 func (r *WorkRepository) GetAllByUserID(userID string) ([]models.Work, error) {
 	var works []models.Work
-	err := r.db.Preload("Documents").Where("user_id = ?", userID).Order("updated_at DESC").Find(&works).Error
+	err := r.db.Scopes(OwnedBy(userID)).
+		Preload("Documents", "user_id = ?", userID).
+		Order("updated_at DESC").
+		Find(&works).Error
 	return works, err
 }
 
-// Update 更新创作
-func (r *WorkRepository) Update(work *models.Work) error {
-	work.UpdatedAt = time.Now()
-	return r.db.Save(work).Error
-}
-
 // UpdateTitleByIDAndUserID 更新创作标题
 func (r *WorkRepository) UpdateTitleByIDAndUserID(id, userID, title string) error {
+	return requireAffected(r.db.Model(&models.Work{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Update("title", title))
+}
+
+// UpdateStyleProfileByIDAndUserID 设置创作的文风档案
+func (r *WorkRepository) UpdateStyleProfileByIDAndUserID(id, userID, styleProfileID string) error {
+	return requireAffected(r.db.Model(&models.Work{}).
+		Scopes(OwnedBy(userID)).
+		Where("id = ?", id).
+		Update("style_profile_id", styleProfileID))
+}
+
+// ClearStyleProfileByUserID 取消所有使用该文风档案的创作的关联
+func (r *WorkRepository) ClearStyleProfileByUserID(userID, styleProfileID string) error {
 	return r.db.Model(&models.Work{}).
-		Where("id = ? AND user_id = ?", id, userID).
-		Update("title", title).Error
+		Scopes(OwnedBy(userID)).
+		Where("style_profile_id = ?", styleProfileID).
+		Update("style_profile_id", "").Error
 }
 
 // DeleteByIDAndUserID 删除创作
 func (r *WorkRepository) DeleteByIDAndUserID(id, userID string) error {
-	return r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Work{}).Error
+	return requireAffected(r.db.Scopes(OwnedBy(userID)).Where("id = ?", id).Delete(&models.Work{}))
 }
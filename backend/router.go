@@ -0,0 +1,404 @@
+package main
+
+import (
+	"grandma/backend/config"
+	"grandma/backend/models"
+	"grandma/backend/modules/auth"
+	chatHandler "grandma/backend/modules/chat"
+	chatService "grandma/backend/modules/chat"
+	"grandma/backend/modules/continuity"
+	conversationHandler "grandma/backend/modules/conversation"
+	conversationService "grandma/backend/modules/conversation"
+	conversationListHandler "grandma/backend/modules/conversation_list"
+	conversationListService "grandma/backend/modules/conversation_list"
+	"grandma/backend/modules/credential"
+	documentHandler "grandma/backend/modules/document"
+	documentService "grandma/backend/modules/document"
+	"grandma/backend/modules/extraction"
+	"grandma/backend/modules/maintenance"
+	"grandma/backend/modules/outline"
+	"grandma/backend/modules/prompt"
+	"grandma/backend/modules/rag"
+	"grandma/backend/modules/revision"
+	"grandma/backend/modules/story"
+	"grandma/backend/modules/style"
+	"grandma/backend/modules/timeline"
+	"grandma/backend/modules/upload"
+	"grandma/backend/modules/usage"
+	"grandma/backend/modules/work"
+	"grandma/backend/modules/writing"
+	"grandma/backend/repository"
+	"grandma/backend/services"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// setupRouter 创建所有Repository、Service和Handler并注册路由
+func setupRouter(cfg *config.Config, db *gorm.DB) *gin.Engine {
+	// 创建gin引擎
+	r := gin.Default()
+
+	// 配置CORS
+	r.Use(func(c *gin.Context) {
+		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, If-Match")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+
+		c.Next()
+	})
+
+	// 创建Repository
+	conversationRepo := repository.NewConversationRepository(db)
+	documentRepo := repository.NewDocumentRepository(db)
+	storyRepo := repository.NewStoryRepository(db)
+	workRepo := repository.NewWorkRepository(db)
+	workDocumentRepo := repository.NewWorkDocumentRepository(db)
+	storyBibleRepo := repository.NewStoryBibleRepository(db)
+	vectorChunkRepo := repository.NewVectorChunkRepository(db)
+	userRepo := repository.NewUserRepository(db)
+	sessionRepo := repository.NewSessionRepository(db)
+	credentialRepo := repository.NewProviderCredentialRepository(db)
+	usageRepo := repository.NewUsageRepository(db)
+	uploadRepo := repository.NewUploadRepository(db)
+	promptRepo := repository.NewPromptTemplateRepository(db)
+	styleRepo := repository.NewStyleProfileRepository(db)
+	outlineRepo := repository.NewOutlineRepository(db)
+	workMessageRepo := repository.NewWorkChatMessageRepository(db)
+	revisionRepo := repository.NewRevisionRepository(db)
+	patchRepo := repository.NewDocumentPatchRepository(db)
+	continuityRepo := repository.NewContinuityRepository(db)
+	proposalRepo := repository.NewBibleProposalRepository(db)
+	timelineRepo := repository.NewTimelineRepository(db)
+
+	// 创建提示词模板服务（RAG、聊天和标题生成都需要渲染模板）
+	promptSvc := prompt.NewPromptService(promptRepo, workRepo)
+
+	// 创建用量服务（RAG向量化也需要记录用量）
+	pricing, err := usage.ParsePricing(cfg.UsagePricing)
+	if err != nil {
+		log.Printf("Ignoring USAGE_PRICING: %v", err)
+		pricing = nil
+	}
+	usageSvc := usage.NewUsageService(usageRepo, userRepo, &usage.UsageConfig{
+		DailyTokenQuota:   cfg.DailyTokenQuota,
+		MonthlyTokenQuota: cfg.MonthlyTokenQuota,
+		Pricing:           pricing,
+	})
+
+	// 创建RAG服务
+	var ragSvc *rag.RAGService
+	if cfg.EnableRAG && cfg.EmbeddingAPIKey != "" {
+		embeddingSvc := services.NewEmbeddingService(cfg.EmbeddingAPIKey, cfg.EmbeddingBaseURL, cfg.EmbeddingModel)
+		ragSvc = rag.NewRAGService(&rag.RAGConfig{
+			Enabled:          true,
+			EmbeddingService: embeddingSvc,
+			VectorChunkRepo:  vectorChunkRepo,
+			DocumentRepo:     documentRepo,
+			WorkDocumentRepo: workDocumentRepo,
+			UsageService:     usageSvc,
+			PromptService:    promptSvc,
+		})
+		log.Println("RAG service initialized")
+	} else {
+		ragSvc = rag.NewRAGService(&rag.RAGConfig{Enabled: false})
+		log.Println("RAG service disabled")
+	}
+
+	// 本地模拟服务商（model为mock），未启用时请求mock会返回错误
+	if cfg.EnableMockProvider {
+		services.EnableMockProvider(&services.MockOptions{
+			Responses:        cfg.MockResponses,
+			FirstChunkDelay:  cfg.MockFirstChunkDelay,
+			ChunkDelay:       cfg.MockChunkDelay,
+			ChunkSize:        cfg.MockChunkSize,
+			ErrorStatus:      cfg.MockErrorStatus,
+			ErrorAfterChunks: cfg.MockErrorAfterChunks,
+			Reasoning:        cfg.MockReasoning,
+			ToolCalls:        cfg.MockToolCalls,
+		})
+		log.Println("Mock provider enabled")
+	} else {
+		services.EnableMockProvider(nil)
+	}
+
+	// 创建Services
+	authSvc := auth.NewAuthService(userRepo, sessionRepo, &auth.AuthConfig{
+		SessionTTL:     cfg.SessionTTL,
+		AdminUsernames: cfg.AdminUsernames,
+	})
+	credentialSvc := credential.NewCredentialService(credentialRepo, userRepo, &credential.CredentialConfig{
+		MasterKey:        cfg.CredentialMasterKey,
+		FallbackPolicy:   cfg.ProviderKeyFallback,
+		OpenAIAPIKey:     cfg.OpenAIAPIKey,
+		OpenAIBaseURL:    cfg.OpenAIBaseURL,
+		AnthropicAPIKey:  cfg.AnthropicAPIKey,
+		AnthropicBaseURL: cfg.AnthropicBaseURL,
+		GeminiAPIKey:     cfg.GeminiAPIKey,
+		GeminiBaseURL:    cfg.GeminiBaseURL,
+		GeminiModel:      cfg.GeminiModel,
+		OllamaAPIKey:     cfg.OllamaAPIKey,
+		OllamaBaseURL:    cfg.OllamaBaseURL,
+		OllamaModel:      cfg.OllamaModel,
+		ProviderChain:    cfg.ProviderChain,
+		Routing: services.RoutingOptions{
+			MaxAttempts: cfg.ProviderMaxAttempts,
+			Backoff:     cfg.ProviderRetryBackoff,
+		},
+		BreakerThreshold: cfg.CircuitBreakerThreshold,
+		BreakerCooldown:  cfg.CircuitBreakerCooldown,
+	})
+	uploadSvc := upload.NewUploadService(uploadRepo, &upload.UploadConfig{
+		Dir:      cfg.UploadDir,
+		MaxBytes: cfg.UploadMaxBytes,
+	})
+	styleSvc := style.NewStyleService(styleRepo, workRepo, conversationRepo, workDocumentRepo, credentialSvc, usageSvc, promptSvc)
+	extractionSvc := extraction.NewExtractionService(proposalRepo, storyBibleRepo, workRepo, workDocumentRepo, workMessageRepo, ragSvc, credentialSvc, usageSvc, &extraction.ExtractionConfig{
+		Enabled: cfg.EnableEntityExtraction,
+		Model:   cfg.ExtractionModel,
+		Delay:   cfg.ExtractionDelay,
+	})
+	outlineSvc := outline.NewOutlineService(outlineRepo, workRepo, workDocumentRepo)
+	timelineSvc := timeline.NewTimelineService(timelineRepo, workRepo, workDocumentRepo, storyBibleRepo, outlineSvc)
+	chatSvc := chatService.NewChatService(
+		conversationRepo,
+		workRepo,
+		documentRepo,
+		workDocumentRepo,
+		workMessageRepo,
+		storyBibleRepo,
+		ragSvc,
+		credentialSvc,
+		usageSvc,
+		uploadSvc,
+		promptSvc,
+		styleSvc,
+		extractionSvc,
+		timelineSvc,
+		&chatService.ToolConfig{
+			Enabled:   cfg.EnableTools,
+			MaxRounds: cfg.ToolMaxRounds,
+		},
+	)
+	conversationListSvc := conversationListService.NewConversationListService(
+		conversationRepo,
+		credentialSvc,
+		usageSvc,
+		promptSvc,
+		&conversationListService.TitleGenerationConfig{
+			DefaultModel: "openai", // 默认使用openai生成标题
+		},
+	)
+	documentSvc := documentService.NewDocumentService(documentRepo, conversationRepo, vectorChunkRepo, storyRepo)
+	conversationSvc := conversationService.NewConversationService(conversationRepo, documentRepo, vectorChunkRepo, storyRepo)
+	revisionSvc := revision.NewRevisionService(revisionRepo, workDocumentRepo, storyRepo, ragSvc)
+	storySvc := story.NewStoryService(storyRepo, documentRepo, revisionSvc)
+	workSvc := work.NewWorkService(workRepo, workDocumentRepo, workMessageRepo, vectorChunkRepo, storyBibleRepo, promptRepo, outlineRepo, patchRepo, continuityRepo, proposalRepo, timelineRepo, revisionSvc, extractionSvc, ragSvc)
+	writingSvc := writing.NewWritingService(workRepo, workDocumentRepo, patchRepo, chatSvc, credentialSvc, usageSvc, promptSvc, revisionSvc, extractionSvc, ragSvc)
+	continuitySvc := continuity.NewContinuityService(continuityRepo, workRepo, workDocumentRepo, workMessageRepo, storyBibleRepo, ragSvc, credentialSvc, usageSvc)
+	consistencySvc := maintenance.NewConsistencyService(conversationRepo, documentRepo, workDocumentRepo, workMessageRepo, vectorChunkRepo, storyRepo)
+
+	// 启动定时一致性检查
+	consistencySvc.StartPeriodicCheck(cfg.ConsistencyCheckInterval, cfg.ConsistencyAutoRepair)
+
+	// 创建Handlers
+	authHdlr := auth.NewAuthHandler(authSvc)
+	chatHdlr := chatHandler.NewChatHandler(chatSvc)
+	conversationListHdlr := conversationListHandler.NewConversationListHandler(conversationListSvc)
+	documentHdlr := documentHandler.NewDocumentHandler(documentSvc)
+	conversationHdlr := conversationHandler.NewConversationHandler(conversationSvc)
+	storiesHdlr := story.NewStoryHandler(storySvc)
+	workHdlr := work.NewWorkHandler(workSvc)
+	consistencyHdlr := maintenance.NewConsistencyHandler(consistencySvc)
+	credentialHdlr := credential.NewCredentialHandler(credentialSvc)
+	usageHdlr := usage.NewUsageHandler(usageSvc)
+	uploadHdlr := upload.NewUploadHandler(uploadSvc)
+	promptHdlr := prompt.NewPromptHandler(promptSvc)
+	styleHdlr := style.NewStyleHandler(styleSvc)
+	outlineHdlr := outline.NewOutlineHandler(outlineSvc)
+	revisionHdlr := revision.NewRevisionHandler(revisionSvc)
+	writingHdlr := writing.NewWritingHandler(writingSvc)
+	continuityHdlr := continuity.NewContinuityHandler(continuitySvc)
+	extractionHdlr := extraction.NewExtractionHandler(extractionSvc)
+	timelineHdlr := timeline.NewTimelineHandler(timelineSvc)
+
+	// 认证模块（无需登录）
+	authGroup := r.Group("/api/auth")
+	{
+		authGroup.POST("/register", authHdlr.Register)
+		authGroup.POST("/login", authHdlr.Login)
+	}
+
+	// 配置路由 - 以下接口均需要登录，用户身份从会话中获取
+	api := r.Group("/api")
+	api.Use(auth.RequireAuth(authSvc))
+	{
+		// 当前用户
+		api.GET("/auth/me", authHdlr.Me)
+		api.POST("/auth/logout", authHdlr.Logout)
+
+		// 服务商凭证（用户自带API Key）
+		api.GET("/credentials", credentialHdlr.GetCredentialList)
+		api.PUT("/credentials/:provider", credentialHdlr.SetCredential)
+		api.DELETE("/credentials/:provider", credentialHdlr.DeleteCredential)
+
+		// 用量统计
+		api.GET("/usage", usageHdlr.GetUsageSummary)
+		api.GET("/usage/events", usageHdlr.GetUsageEvents)
+
+		// 聊天接口
+		api.POST("/chat", chatHdlr.Chat)
+		api.POST("/chat/preview", chatHdlr.PreviewPrompt)
+
+		// 提示词模板（用户级或创作级，work_id 指定创作）
+		api.GET("/prompts", promptHdlr.GetPromptList)
+		api.GET("/prompts/:name/versions", promptHdlr.GetPromptVersions)
+		api.PUT("/prompts/:name", promptHdlr.SavePrompt)
+		api.POST("/prompts/:name/versions/:version/restore", promptHdlr.RestorePromptVersion)
+		api.DELETE("/prompts/:name", promptHdlr.DeletePrompt)
+
+		// 文风档案
+		api.GET("/style-profiles", styleHdlr.GetProfileList)
+		api.POST("/style-profiles", styleHdlr.CreateProfile)
+		api.POST("/style-profiles/derive", styleHdlr.DeriveProfile)
+		api.GET("/style-profiles/:id", styleHdlr.GetProfile)
+		api.PUT("/style-profiles/:id", styleHdlr.UpdateProfile)
+		api.DELETE("/style-profiles/:id", styleHdlr.DeleteProfile)
+		api.PUT("/works/:id/style-profile", styleHdlr.AttachToWork)
+		api.PUT("/conversations/:id/style-profile", styleHdlr.AttachToConversation)
+
+		// 上传图片（用于多模态消息）
+		api.POST("/uploads", uploadHdlr.CreateUpload)
+		api.GET("/uploads/:id", uploadHdlr.GetUpload)
+		api.DELETE("/uploads/:id", uploadHdlr.DeleteUpload)
+
+		// 对话列表模块
+		api.GET("/conversations", conversationListHdlr.GetConversationList)
+		api.POST("/conversations/new", conversationListHdlr.CreateNewConversation)
+		api.POST("/conversations/new-with-title", conversationListHdlr.CreateNewConversationWithTitle)
+		api.POST("/conversations/generate-title", conversationListHdlr.GenerateTitle)
+
+		// 对话管理模块
+		api.GET("/conversations/:id", conversationHdlr.GetConversationByID)
+		api.POST("/conversations", conversationHdlr.CreateConversation)
+		api.PUT("/conversations/:id", conversationHdlr.UpdateConversation)
+		api.PUT("/conversations/:id/title", conversationHdlr.UpdateConversationTitle)
+		api.DELETE("/conversations/:id", conversationHdlr.DeleteConversation)
+
+		// 文档管理模块
+		api.GET("/documents", documentHdlr.GetDocumentList)
+		api.GET("/documents/ids", documentHdlr.GetDocumentIDs)
+		api.GET("/documents/:id", documentHdlr.GetDocumentByID)
+		api.PUT("/documents/:id", documentHdlr.UpdateDocument)
+		api.DELETE("/documents/:id", documentHdlr.DeleteDocument)
+
+		api.GET("/stories", storiesHdlr.GetStoryList)
+		api.POST("/stories", storiesHdlr.CreateStory)
+		api.PUT("/stories/:id", storiesHdlr.UpdateStory)
+		api.DELETE("/stories/:id", storiesHdlr.DeleteStory)
+		api.GET("/stories/:id/revisions", revisionHdlr.ListRevisions(models.RevisionTargetStory))
+		api.GET("/stories/:id/revisions/diff", revisionHdlr.Diff(models.RevisionTargetStory))
+		api.GET("/stories/:id/revisions/:number", revisionHdlr.GetRevision(models.RevisionTargetStory))
+		api.POST("/stories/:id/revisions/:number/restore", revisionHdlr.Restore(models.RevisionTargetStory))
+
+		// 创作模块
+		api.GET("/works", workHdlr.GetWorkList)
+		api.POST("/works", workHdlr.CreateWork)
+		api.PUT("/works/:id/title", workHdlr.UpdateWorkTitle)
+		api.DELETE("/works/:id", workHdlr.DeleteWork)
+
+		// 创作文档模块
+		api.GET("/works/:work_id/documents", workHdlr.GetWorkDocuments)
+		api.POST("/works/:work_id/documents", workHdlr.CreateWorkDocument)
+		api.GET("/works/:work_id/bible", workHdlr.GetStoryBible)
+		api.GET("/works/:work_id/bible/proposals", extractionHdlr.ListProposals)
+		api.POST("/bible-proposals/:id/accept", extractionHdlr.AcceptProposal)
+		api.POST("/bible-proposals/:id/reject", extractionHdlr.RejectProposal)
+		api.GET("/work-documents/:id", workHdlr.GetWorkDocumentByID)
+		api.PUT("/work-documents/:id/title", workHdlr.UpdateWorkDocumentTitle)
+		api.PUT("/work-documents/:id/content", workHdlr.UpdateWorkDocumentContent)
+		api.DELETE("/work-documents/:id", workHdlr.DeleteWorkDocument)
+		api.GET("/work-documents/:id/revisions", revisionHdlr.ListRevisions(models.RevisionTargetWorkDocument))
+		api.GET("/work-documents/:id/revisions/diff", revisionHdlr.Diff(models.RevisionTargetWorkDocument))
+		api.GET("/work-documents/:id/revisions/:number", revisionHdlr.GetRevision(models.RevisionTargetWorkDocument))
+		api.POST("/work-documents/:id/revisions/:number/restore", revisionHdlr.Restore(models.RevisionTargetWorkDocument))
+
+		// 正文片段的AI编辑、续写补写和修改建议
+		api.POST("/work-documents/:id/transform", writingHdlr.Transform)
+		api.POST("/work-documents/:id/generate", writingHdlr.Generate)
+		api.GET("/work-documents/:id/patches", writingHdlr.ListPatches)
+		api.POST("/document-patches/:id/accept", writingHdlr.AcceptPatch)
+		api.POST("/document-patches/:id/reject", writingHdlr.RejectPatch)
+
+		// 设定一致性检查：章节与设定集、前文的矛盾
+		api.POST("/work-documents/:id/continuity-checks", continuityHdlr.StartCheck)
+		api.GET("/work-documents/:id/continuity-checks", continuityHdlr.ListChecks)
+		api.GET("/continuity-checks/:id", continuityHdlr.GetCheck)
+		api.GET("/works/:work_id/continuity-issues", continuityHdlr.ListIssues)
+		api.POST("/continuity-issues/:id/resolve", continuityHdlr.ResolveIssue)
+		api.POST("/continuity-issues/:id/reopen", continuityHdlr.ReopenIssue)
+
+		// 灵感模式对话消息
+		api.GET("/works/:work_id/messages", workHdlr.GetWorkMessages)
+		api.POST("/work-messages/:id/promote", workHdlr.PromoteWorkMessage)
+		api.DELETE("/work-messages/:id", workHdlr.DeleteWorkMessage)
+
+		// 创作大纲（卷、章、场景）
+		api.GET("/works/:work_id/outline", outlineHdlr.GetOutline)
+		api.POST("/works/:work_id/outline", outlineHdlr.CreateNode)
+		api.POST("/works/:work_id/outline/reorder", outlineHdlr.Reorder)
+		api.PUT("/outline-nodes/:id", outlineHdlr.UpdateNode)
+		api.DELETE("/outline-nodes/:id", outlineHdlr.DeleteNode)
+		api.POST("/outline-nodes/:id/move", outlineHdlr.MoveNode)
+		api.POST("/outline-nodes/:id/draft", outlineHdlr.CreateDraft)
+
+		// 故事时间线（故事内的历法和事件）
+		api.GET("/works/:work_id/timeline", timelineHdlr.GetTimeline)
+		api.POST("/works/:work_id/timeline", timelineHdlr.CreateEvent)
+		api.GET("/works/:work_id/timeline/calendar", timelineHdlr.GetCalendar)
+		api.PUT("/works/:id/timeline/calendar", timelineHdlr.SetCalendar)
+		api.PUT("/timeline-events/:id", timelineHdlr.UpdateEvent)
+		api.DELETE("/timeline-events/:id", timelineHdlr.DeleteEvent)
+
+		// 数据一致性检查（仅管理员）
+		admin := api.Group("/maintenance", auth.RequireAdmin())
+		admin.GET("/consistency", consistencyHdlr.CheckConsistency)
+		admin.POST("/consistency/repair", consistencyHdlr.RepairConsistency)
+
+		// 用户用量与配额（仅管理员）
+		adminUsers := api.Group("/admin/users", auth.RequireAdmin())
+		adminUsers.GET("/:user_id/usage", usageHdlr.GetUserUsage)
+		adminUsers.PUT("/:user_id/quota", usageHdlr.UpdateUserQuota)
+
+		// 获取可用模型列表
+		api.GET("/models", func(c *gin.Context) {
+			models := []map[string]string{
+				{"id": "openai", "name": "DeepSeek Chat", "provider": "OpenAI"},
+				{"id": "anthropic", "name": "Kimi", "provider": "Anthropic"},
+				{"id": "gemini", "name": "Gemini", "provider": "Google"},
+			}
+			if cfg.OllamaBaseURL != "" {
+				models = append(models, map[string]string{"id": "ollama", "name": cfg.OllamaModel, "provider": "Ollama"})
+			}
+			if services.MockProviderEnabled() {
+				models = append(models, map[string]string{"id": "mock", "name": "Mock", "provider": "Mock"})
+			}
+			c.JSON(200, gin.H{"models": models})
+		})
+	}
+
+	// 健康检查
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+
+	return r
+}
@@ -0,0 +1,2347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grandma/backend/config"
+	"grandma/backend/database"
+	"grandma/backend/models"
+	"grandma/backend/modules/usage"
+	"grandma/backend/repository"
+	"grandma/backend/services"
+	"grandma/backend/services/llmtest"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// testServer 基于临时SQLite数据库的完整路由
+type testServer struct {
+	t      *testing.T
+	router *gin.Engine
+}
+
+func newTestServer(t *testing.T) *testServer {
+	return newTestServerWithConfig(t, &config.Config{
+		EnableRAG:  false,
+		SessionTTL: time.Hour,
+	})
+}
+
+func newTestServerWithConfig(t *testing.T, cfg *config.Config) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	if err := database.InitDB(filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	return &testServer{t: t, router: setupRouter(cfg, database.DB)}
+}
+
+func (s *testServer) do(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	return s.doIfMatch(method, path, token, "", body)
+}
+
+// doIfMatch 发送请求，etag不为空时带上If-Match请求头
+func (s *testServer) doIfMatch(method, path, token, etag string, body interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatalf("marshal body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// upload 以multipart表单上传文件
+func (s *testServer) upload(token, fileName string, content []byte) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		s.t.Fatalf("create form file: %v", err)
+	}
+	part.Write(content)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/uploads", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func (s *testServer) decode(w *httptest.ResponseRecorder, out interface{}) {
+	s.t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+		s.t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+}
+
+// login 注册并登录用户，返回令牌和用户ID
+func (s *testServer) login(username string) (string, string) {
+	s.t.Helper()
+	creds := models.AuthRequest{Username: username, Password: "password-" + username}
+	if w := s.do(http.MethodPost, "/api/auth/register", "", creds); w.Code != http.StatusOK {
+		s.t.Fatalf("register %s: %d %s", username, w.Code, w.Body.String())
+	}
+	w := s.do(http.MethodPost, "/api/auth/login", "", creds)
+	if w.Code != http.StatusOK {
+		s.t.Fatalf("login %s: %d %s", username, w.Code, w.Body.String())
+	}
+	var resp models.LoginResponse
+	s.decode(w, &resp)
+	return resp.Token, resp.User.ID
+}
+
+func TestRequiresAuthentication(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, path := range []string{"/api/conversations", "/api/works", "/api/stories", "/api/auth/me"} {
+		if w := s.do(http.MethodGet, path, "", nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without token: got %d, want 401", path, w.Code)
+		}
+		if w := s.do(http.MethodGet, path, "not-a-token", nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s with bad token: got %d, want 401", path, w.Code)
+		}
+	}
+}
+
+func TestCrossUserAccessReturnsNotFound(t *testing.T) {
+	s := newTestServer(t)
+	aliceToken, aliceID := s.login("alice")
+	bobToken, _ := s.login("bob")
+
+	// alice 创建对话、文档、故事、创作和创作文档
+	var conv models.Conversation
+	w := s.do(http.MethodPost, "/api/conversations/new", aliceToken, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create conversation: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(w, &conv)
+
+	doc := &models.Document{ID: "doc_alice", UserID: aliceID, ConversationID: conv.ID, Role: "assistant", Content: "alice secret"}
+	if err := repository.NewDocumentRepository(database.DB).Create(doc); err != nil {
+		t.Fatalf("create document: %v", err)
+	}
+	if err := repository.NewConversationRepository(database.DB).AppendDocumentID(conv.ID, aliceID, doc.ID); err != nil {
+		t.Fatalf("append document id: %v", err)
+	}
+
+	var story models.Story
+	w = s.do(http.MethodPost, "/api/stories", aliceToken, models.StoryRequest{DocumentId: doc.ID, Title: "t", Content: "alice story"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create story: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(w, &story)
+
+	var work models.Work
+	w = s.do(http.MethodPost, "/api/works", aliceToken, models.WorkRequest{Title: "alice work"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create work: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(w, &work)
+
+	var workDoc models.WorkDocument
+	w = s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", aliceToken, models.WorkDocumentRequest{WorkID: work.ID, Title: "ch1", Content: "alice chapter"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create work document: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(w, &workDoc)
+
+	// bob 对 alice 的每个资源执行读、改、删，全部应返回404
+	cases := []struct {
+		method string
+		path   string
+		body   interface{}
+	}{
+		{http.MethodGet, "/api/conversations/" + conv.ID, nil},
+		{http.MethodPut, "/api/conversations/" + conv.ID, map[string]string{"title": "pwned"}},
+		{http.MethodPut, "/api/conversations/" + conv.ID + "/title", map[string]string{"title": "pwned"}},
+		{http.MethodGet, "/api/documents?conversation_id=" + conv.ID, nil},
+		{http.MethodGet, "/api/documents/ids?conversation_id=" + conv.ID, nil},
+		{http.MethodGet, "/api/documents/" + doc.ID, nil},
+		{http.MethodPut, "/api/documents/" + doc.ID, models.UpdateDocumentRequest{Content: "pwned"}},
+		{http.MethodPost, "/api/stories", models.StoryRequest{DocumentId: doc.ID, Content: "steal"}},
+		{http.MethodPut, "/api/stories/" + story.ID, models.StoryRequest{Title: "pwned", Content: "pwned"}},
+		{http.MethodPut, "/api/works/" + work.ID + "/title", models.WorkRequest{Title: "pwned"}},
+		{http.MethodGet, "/api/works/" + work.ID + "/documents", nil},
+		{http.MethodGet, "/api/works/" + work.ID + "/bible", nil},
+		{http.MethodPost, "/api/works/" + work.ID + "/documents", models.WorkDocumentRequest{WorkID: work.ID, Title: "x", Content: "x"}},
+		{http.MethodGet, "/api/work-documents/" + workDoc.ID, nil},
+		{http.MethodPut, "/api/work-documents/" + workDoc.ID + "/title", models.UpdateWorkDocumentTitleRequest{Title: "pwned"}},
+		{http.MethodPut, "/api/work-documents/" + workDoc.ID + "/content", models.UpdateWorkDocumentContentRequest{Content: "pwned"}},
+		{http.MethodPost, "/api/chat", models.ChatRequest{Model: "openai", ConversationID: conv.ID, Messages: []models.Message{{Role: "user", Content: "hi"}}}},
+		{http.MethodPost, "/api/chat", models.ChatRequest{Model: "openai", WorkID: work.ID, Messages: []models.Message{{Role: "user", Content: "hi"}}}},
+		{http.MethodDelete, "/api/documents/" + doc.ID, nil},
+		{http.MethodDelete, "/api/stories/" + story.ID, nil},
+		{http.MethodDelete, "/api/work-documents/" + workDoc.ID, nil},
+		{http.MethodDelete, "/api/works/" + work.ID, nil},
+		{http.MethodDelete, "/api/conversations/" + conv.ID, nil},
+	}
+	for _, tc := range cases {
+		w := s.do(tc.method, tc.path, bobToken, tc.body)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s %s as other user: got %d (%s), want 404", tc.method, tc.path, w.Code, w.Body.String())
+		}
+	}
+
+	// alice 的数据应保持不变
+	var gotConv models.Conversation
+	w = s.do(http.MethodGet, "/api/conversations/"+conv.ID, aliceToken, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("owner get conversation: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(w, &gotConv)
+	if gotConv.Title != conv.Title || gotConv.DocumentIDs != doc.ID {
+		t.Errorf("conversation modified by other user: %+v", gotConv)
+	}
+
+	var gotDoc models.Document
+	w = s.do(http.MethodGet, "/api/documents/"+doc.ID, aliceToken, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("owner get document: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(w, &gotDoc)
+	if gotDoc.Content != "alice secret" {
+		t.Errorf("document modified by other user: %q", gotDoc.Content)
+	}
+
+	var gotWorkDoc models.WorkDocument
+	w = s.do(http.MethodGet, "/api/work-documents/"+workDoc.ID, aliceToken, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("owner get work document: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(w, &gotWorkDoc)
+	if gotWorkDoc.Title != "ch1" || gotWorkDoc.Content != "alice chapter" {
+		t.Errorf("work document modified by other user: %+v", gotWorkDoc)
+	}
+
+	var stories models.StoryResponse
+	w = s.do(http.MethodGet, "/api/stories", aliceToken, nil)
+	s.decode(w, &stories)
+	if stories.Total != 1 || stories.Story[0].Title != "t" {
+		t.Errorf("story modified by other user: %+v", stories)
+	}
+
+	// bob 的列表中不应出现 alice 的数据
+	var bobWorks models.WorkResponse
+	s.decode(s.do(http.MethodGet, "/api/works", bobToken, nil), &bobWorks)
+	if bobWorks.Total != 0 {
+		t.Errorf("other user sees %d works", bobWorks.Total)
+	}
+	var bobConvs models.ConversationListResponse
+	s.decode(s.do(http.MethodGet, "/api/conversations", bobToken, nil), &bobConvs)
+	if bobConvs.Total != 0 {
+		t.Errorf("other user sees %d conversations", bobConvs.Total)
+	}
+}
+
+func TestProviderCredentials(t *testing.T) {
+	// 模拟OpenAI兼容接口，记录收到的API Key
+	var gotAuth []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = append(gotAuth, r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hello\"}}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer upstream.Close()
+
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:          time.Hour,
+		CredentialMasterKey: "test-master-key",
+		ProviderKeyFallback: "none",
+		OpenAIAPIKey:        "sk-server",
+		OpenAIBaseURL:       upstream.URL,
+	})
+	aliceToken, aliceID := s.login("alice")
+	bobToken, _ := s.login("bob")
+
+	chat := models.ChatRequest{Model: "openai", Messages: []models.Message{{Role: "user", Content: "hi"}}}
+
+	// 回退策略为none时，未配置Key的用户不能使用服务端Key
+	if w := s.do(http.MethodPost, "/api/chat", aliceToken, chat); w.Code != http.StatusBadRequest {
+		t.Fatalf("chat without key: got %d (%s), want 400", w.Code, w.Body.String())
+	}
+
+	w := s.do(http.MethodPut, "/api/credentials/openai", aliceToken, models.ProviderCredentialRequest{APIKey: "sk-alice-secret-1234"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("set credential: %d %s", w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "sk-alice-secret") {
+		t.Errorf("response leaks api key: %s", w.Body.String())
+	}
+	if w := s.do(http.MethodPut, "/api/credentials/unknown", aliceToken, models.ProviderCredentialRequest{APIKey: "x"}); w.Code != http.StatusBadRequest {
+		t.Errorf("unsupported provider: got %d, want 400", w.Code)
+	}
+
+	// 空Key和不合法的自定义地址返回400
+	invalid := []models.ProviderCredentialRequest{
+		{APIKey: "   "},
+		{APIKey: "sk-alice-secret-1234", BaseURL: "ftp://api.example.com"},
+		{APIKey: "sk-alice-secret-1234", BaseURL: "not a url"},
+		{APIKey: "sk-alice-secret-1234", BaseURL: "http://127.0.0.1:8080/v1"},
+		{APIKey: "sk-alice-secret-1234", BaseURL: "http://10.0.0.5/v1"},
+		{APIKey: "sk-alice-secret-1234", BaseURL: "http://[::1]/v1"},
+		{APIKey: "sk-alice-secret-1234", BaseURL: "http://169.254.169.254/latest"},
+	}
+	for _, req := range invalid {
+		if w := s.do(http.MethodPut, "/api/credentials/openai", bobToken, req); w.Code != http.StatusBadRequest {
+			t.Errorf("invalid credential %+v: got %d, want 400", req, w.Code)
+		}
+	}
+
+	// 数据库中只保存密文
+	stored, err := repository.NewProviderCredentialRepository(database.DB).GetByUserIDAndProvider(aliceID, "openai")
+	if err != nil {
+		t.Fatalf("load credential: %v", err)
+	}
+	if stored.EncryptedAPIKey == "" || strings.Contains(stored.EncryptedAPIKey, "sk-alice-secret") {
+		t.Errorf("api key not encrypted at rest: %q", stored.EncryptedAPIKey)
+	}
+	if stored.KeyHint != "****1234" {
+		t.Errorf("key hint: got %q", stored.KeyHint)
+	}
+
+	// 用户自己的Key优先，并沿用服务端地址
+	w = s.do(http.MethodPost, "/api/chat", aliceToken, chat)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "hello") {
+		t.Fatalf("chat with own key: %d %s", w.Code, w.Body.String())
+	}
+	if len(gotAuth) != 1 || gotAuth[0] != "Bearer sk-alice-secret-1234" {
+		t.Errorf("upstream auth: got %v, want alice's key", gotAuth)
+	}
+
+	// 其他用户看不到也删不掉
+	var bobList models.ProviderCredentialListResponse
+	s.decode(s.do(http.MethodGet, "/api/credentials", bobToken, nil), &bobList)
+	if len(bobList.Credentials) != 0 || bobList.ServerFallback {
+		t.Errorf("other user credential list: %+v", bobList)
+	}
+	if w := s.do(http.MethodDelete, "/api/credentials/openai", bobToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("delete other user's credential: got %d, want 404", w.Code)
+	}
+
+	var aliceList models.ProviderCredentialListResponse
+	s.decode(s.do(http.MethodGet, "/api/credentials", aliceToken, nil), &aliceList)
+	if len(aliceList.Credentials) != 1 || aliceList.Credentials[0].Provider != "openai" {
+		t.Errorf("owner credential list: %+v", aliceList)
+	}
+
+	if w := s.do(http.MethodDelete, "/api/credentials/openai", aliceToken, nil); w.Code != http.StatusOK {
+		t.Errorf("delete credential: got %d", w.Code)
+	}
+	if w := s.do(http.MethodPost, "/api/chat", aliceToken, chat); w.Code != http.StatusBadRequest {
+		t.Errorf("chat after deleting key: got %d, want 400", w.Code)
+	}
+}
+
+func TestProviderCredentialsRequireMasterKey(t *testing.T) {
+	s := newTestServer(t)
+	token, _ := s.login("alice")
+
+	w := s.do(http.MethodPut, "/api/credentials/openai", token, models.ProviderCredentialRequest{APIKey: "sk-alice"})
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("set credential without master key: got %d, want 503", w.Code)
+	}
+}
+
+func TestUsageMeteringAndQuota(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hello\"}}]}\n\n"))
+		w.Write([]byte("data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":3}}\n\ndata: [DONE]\n\n"))
+	}))
+	defer upstream.Close()
+
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:     time.Hour,
+		AdminUsernames: []string{"root"},
+		OpenAIAPIKey:   "sk-server",
+		OpenAIBaseURL:  upstream.URL,
+		UsagePricing:   "deepseek-chat=1/2",
+	})
+	adminToken, _ := s.login("root")
+	aliceToken, aliceID := s.login("alice")
+
+	chat := models.ChatRequest{Model: "openai", Messages: []models.Message{{Role: "user", Content: "hi"}}}
+	if w := s.do(http.MethodPost, "/api/chat", aliceToken, chat); w.Code != http.StatusOK {
+		t.Fatalf("chat: %d %s", w.Code, w.Body.String())
+	}
+
+	var summary models.UsageSummaryResponse
+	s.decode(s.do(http.MethodGet, "/api/usage", aliceToken, nil), &summary)
+	if summary.Daily.InputTokens != 12 || summary.Daily.OutputTokens != 3 || summary.Monthly.TotalTokens != 15 {
+		t.Fatalf("usage summary: %+v", summary)
+	}
+	if len(summary.Daily.Breakdown) != 1 || summary.Daily.Breakdown[0].Feature != "chat" || summary.Daily.Breakdown[0].Model != "deepseek-chat" {
+		t.Errorf("usage breakdown: %+v", summary.Daily.Breakdown)
+	}
+	if want := (12*1.0 + 3*2.0) / 1e6; summary.Daily.CostUSD != want {
+		t.Errorf("cost: got %v, want %v", summary.Daily.CostUSD, want)
+	}
+	if summary.Daily.Limit != 0 || summary.Daily.Remaining != -1 {
+		t.Errorf("unlimited quota: %+v", summary.Daily)
+	}
+
+	// 只有管理员可以设置配额
+	limit := int64(15)
+	quota := models.UpdateUsageQuotaRequest{DailyTokens: &limit}
+	if w := s.do(http.MethodPut, "/api/admin/users/"+aliceID+"/quota", aliceToken, quota); w.Code != http.StatusForbidden {
+		t.Errorf("non-admin set quota: got %d, want 403", w.Code)
+	}
+	if w := s.do(http.MethodPut, "/api/admin/users/"+aliceID+"/quota", adminToken, quota); w.Code != http.StatusOK {
+		t.Fatalf("set quota: %d %s", w.Code, w.Body.String())
+	}
+	if w := s.do(http.MethodPut, "/api/admin/users/nobody/quota", adminToken, quota); w.Code != http.StatusNotFound {
+		t.Errorf("set quota for unknown user: got %d, want 404", w.Code)
+	}
+
+	// 配额用尽后，在调用服务商和写入任何数据之前拒绝请求
+	var convs models.ConversationListResponse
+	s.decode(s.do(http.MethodGet, "/api/conversations", aliceToken, nil), &convs)
+	before := convs.Total
+
+	if w := s.do(http.MethodPost, "/api/chat", aliceToken, chat); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("chat over quota: got %d (%s), want 429", w.Code, w.Body.String())
+	}
+	s.decode(s.do(http.MethodGet, "/api/conversations", aliceToken, nil), &convs)
+	if convs.Total != before {
+		t.Errorf("conversation created despite quota: %d -> %d", before, convs.Total)
+	}
+
+	var adminView models.UsageSummaryResponse
+	s.decode(s.do(http.MethodGet, "/api/admin/users/"+aliceID+"/usage", adminToken, nil), &adminView)
+	if adminView.Daily.Limit != 15 || adminView.Daily.Remaining != 0 {
+		t.Errorf("admin usage view: %+v", adminView.Daily)
+	}
+
+	var events models.UsageEventListResponse
+	s.decode(s.do(http.MethodGet, "/api/usage/events", aliceToken, nil), &events)
+	if events.Total != 1 || events.Events[0].Estimated {
+		t.Errorf("usage events: %+v", events)
+	}
+}
+
+func TestChatFailsOverToNextProvider(t *testing.T) {
+	down := llmtest.NewServer()
+	down.Status = http.StatusServiceUnavailable
+	defer down.Close()
+	up := llmtest.NewServer("from ", "fallback")
+	defer up.Close()
+
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:          time.Hour,
+		OpenAIAPIKey:        "sk-openai",
+		OpenAIBaseURL:       down.URL,
+		AnthropicAPIKey:     "sk-anthropic",
+		AnthropicBaseURL:    up.URL,
+		ProviderChain:       []string{"openai", "anthropic"},
+		ProviderMaxAttempts: 2,
+	})
+	token, _ := s.login("alice")
+
+	var conv models.Conversation
+	s.decode(s.do(http.MethodPost, "/api/conversations/new", token, nil), &conv)
+
+	chat := models.ChatRequest{Model: "openai", ConversationID: conv.ID, Messages: []models.Message{{Role: "user", Content: "hi"}}}
+	w := s.do(http.MethodPost, "/api/chat", token, chat)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "from fallback") {
+		t.Fatalf("chat: %d %s", w.Code, w.Body.String())
+	}
+
+	var docs models.DocumentListResponse
+	s.decode(s.do(http.MethodGet, "/api/documents?conversation_id="+conv.ID, token, nil), &docs)
+	var assistant *models.Document
+	for i := range docs.Documents {
+		if docs.Documents[i].Role == "assistant" {
+			assistant = &docs.Documents[i]
+		}
+	}
+	if assistant == nil {
+		t.Fatalf("assistant document not saved: %+v", docs)
+	}
+	if assistant.Model != "anthropic" || assistant.Content != "from fallback" {
+		t.Errorf("assistant document: model=%q content=%q", assistant.Model, assistant.Content)
+	}
+}
+
+func TestChatWithMockProvider(t *testing.T) {
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:         time.Hour,
+		EnableMockProvider: true,
+		MockResponses:      []string{"模拟回复"},
+		MockChunkSize:      2,
+	})
+	token, _ := s.login("alice")
+
+	var conv models.Conversation
+	s.decode(s.do(http.MethodPost, "/api/conversations/new", token, nil), &conv)
+
+	chat := models.ChatRequest{Model: "mock", ConversationID: conv.ID, Messages: []models.Message{{Role: "user", Content: "hi"}}}
+	w := s.do(http.MethodPost, "/api/chat", token, chat)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "模拟") || !strings.Contains(w.Body.String(), "回复") {
+		t.Fatalf("chat: %d %s", w.Code, w.Body.String())
+	}
+
+	var list struct {
+		Models []map[string]interface{} `json:"models"`
+	}
+	s.decode(s.do(http.MethodGet, "/api/models", token, nil), &list)
+	found := false
+	for _, m := range list.Models {
+		if m["id"] == "mock" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("mock model not listed: %+v", list.Models)
+	}
+}
+
+func TestChatStreamsReasoningSeparately(t *testing.T) {
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:         time.Hour,
+		EnableMockProvider: true,
+		MockResponses:      []string{"正文回答"},
+		MockReasoning:      "思考过程",
+	})
+	token, _ := s.login("alice")
+
+	var conv models.Conversation
+	s.decode(s.do(http.MethodPost, "/api/conversations/new", token, nil), &conv)
+
+	chat := models.ChatRequest{Model: "mock", ConversationID: conv.ID, StreamReasoning: true, Messages: []models.Message{{Role: "user", Content: "hi"}}}
+	w := s.do(http.MethodPost, "/api/chat", token, chat)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<GRANDMA_REASONING>思考过程</GRANDMA_REASONING>正文回答") {
+		t.Fatalf("chat: %d %s", w.Code, w.Body.String())
+	}
+
+	// 未请求推理通道时不输出推理过程
+	chat.StreamReasoning = false
+	w = s.do(http.MethodPost, "/api/chat", token, chat)
+	if strings.Contains(w.Body.String(), "思考过程") {
+		t.Errorf("reasoning streamed without stream_reasoning: %s", w.Body.String())
+	}
+
+	var docs models.DocumentListResponse
+	s.decode(s.do(http.MethodGet, "/api/documents?conversation_id="+conv.ID, token, nil), &docs)
+	assistants := 0
+	for _, doc := range docs.Documents {
+		if doc.Role != "assistant" {
+			continue
+		}
+		assistants++
+		if doc.Content != "正文回答" || doc.Reasoning != "思考过程" {
+			t.Errorf("assistant document: content=%q reasoning=%q", doc.Content, doc.Reasoning)
+		}
+	}
+	if assistants != 2 {
+		t.Errorf("expected 2 assistant documents, got %d", assistants)
+	}
+}
+
+func TestWorkChatRunsToolCalls(t *testing.T) {
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:         time.Hour,
+		EnableMockProvider: true,
+		MockResponses:      []string{"一共一章"},
+		MockToolCalls: []models.ToolCall{
+			{ID: "call_1", Name: "list_chapters", Arguments: "{}"},
+			{ID: "call_2", Name: "append_story_bible", Arguments: `{"category":"character","name":"林舟","content":"主角，渔村少年"}`},
+		},
+		EnableTools: true,
+	})
+	token, _ := s.login("alice")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长篇"}), &work)
+	w := s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第一章 出海", Content: "林舟出海了"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create work document: %d %s", w.Code, w.Body.String())
+	}
+
+	chat := models.ChatRequest{Model: "mock", WorkID: work.ID, Messages: []models.Message{{Role: "user", Content: "现在有几章？"}}}
+	w = s.do(http.MethodPost, "/api/chat", token, chat)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "一共一章") {
+		t.Fatalf("chat: %d %s", w.Code, w.Body.String())
+	}
+
+	var messages models.WorkChatMessageResponse
+	s.decode(s.do(http.MethodGet, "/api/works/"+work.ID+"/messages", token, nil), &messages)
+	var results []models.ToolResult
+	calls := 0
+	for _, doc := range messages.Messages {
+		switch doc.Role {
+		case models.RoleToolCall:
+			calls++
+		case models.RoleToolResult:
+			var result models.ToolResult
+			if err := json.Unmarshal([]byte(doc.Content), &result); err != nil {
+				t.Fatalf("tool result content: %v", err)
+			}
+			results = append(results, result)
+		case "assistant":
+			if doc.Content != "一共一章" {
+				t.Errorf("assistant content: %q", doc.Content)
+			}
+		}
+	}
+	if calls != 2 || len(results) != 2 {
+		t.Fatalf("persisted %d tool calls and %d results: %+v", calls, len(results), messages.Messages)
+	}
+	if results[0].IsError || !strings.Contains(results[0].Content, "第一章 出海") {
+		t.Errorf("list_chapters result: %+v", results[0])
+	}
+
+	var bible models.StoryBibleResponse
+	s.decode(s.do(http.MethodGet, "/api/works/"+work.ID+"/bible", token, nil), &bible)
+	if bible.Total != 1 || bible.Entries[0].Name != "林舟" || bible.Entries[0].Source != "assistant" {
+		t.Errorf("story bible: %+v", bible)
+	}
+
+	// 删除创作时级联删除设定集
+	s.do(http.MethodDelete, "/api/works/"+work.ID, token, nil)
+	var count int64
+	database.DB.Model(&models.StoryBibleEntry{}).Count(&count)
+	if count != 0 {
+		t.Errorf("story bible entries left after deleting work: %d", count)
+	}
+}
+
+func TestChatWithImageUpload(t *testing.T) {
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:         time.Hour,
+		EnableMockProvider: true,
+		MockResponses:      []string{"图里是一片海"},
+		UploadDir:          t.TempDir(),
+		UploadMaxBytes:     1 << 20,
+	})
+	token, _ := s.login("alice")
+	otherToken, _ := s.login("bob")
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 32)...)
+	w := s.upload(token, "sea.png", png)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload: %d %s", w.Code, w.Body.String())
+	}
+	var uploaded models.UploadResponse
+	s.decode(w, &uploaded)
+	if uploaded.MimeType != "image/png" || uploaded.Size != int64(len(png)) || uploaded.URL != "/api/uploads/"+uploaded.ID {
+		t.Fatalf("upload response: %+v", uploaded)
+	}
+
+	if w := s.upload(token, "notes.png", []byte("just text")); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("non-image upload: %d %s", w.Code, w.Body.String())
+	}
+	if w := s.do(http.MethodGet, "/api/uploads/"+uploaded.ID, otherToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("cross-user upload access: %d", w.Code)
+	}
+	if w := s.do(http.MethodGet, "/api/uploads/"+uploaded.ID, token, nil); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("get upload: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var conv models.Conversation
+	s.decode(s.do(http.MethodPost, "/api/conversations/new", token, nil), &conv)
+	chat := models.ChatRequest{Model: "mock", ConversationID: conv.ID, Messages: []models.Message{{Role: "user", Parts: []models.ContentPart{
+		{Type: models.PartText, Text: "这张图里有什么？"},
+		{Type: models.PartImage, UploadID: uploaded.ID},
+	}}}}
+	w = s.do(http.MethodPost, "/api/chat", token, chat)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "一片海") {
+		t.Fatalf("chat: %d %s", w.Code, w.Body.String())
+	}
+
+	var docs models.DocumentListResponse
+	s.decode(s.do(http.MethodGet, "/api/documents?conversation_id="+conv.ID, token, nil), &docs)
+	var userDoc *models.Document
+	for i := range docs.Documents {
+		if docs.Documents[i].Role == "user" {
+			userDoc = &docs.Documents[i]
+		}
+	}
+	if userDoc == nil || userDoc.Content != "这张图里有什么？" || len(userDoc.Attachments) != 1 || userDoc.Attachments[0].UploadID != uploaded.ID {
+		t.Fatalf("user document: %+v", userDoc)
+	}
+
+	// 其他用户不能引用别人的上传，图片片段必须有来源
+	chat.Messages[0].Parts[1] = models.ContentPart{Type: models.PartImage, UploadID: uploaded.ID}
+	if w := s.do(http.MethodPost, "/api/chat", otherToken, models.ChatRequest{Model: "mock", Messages: chat.Messages}); w.Code != http.StatusNotFound {
+		t.Errorf("chat with another user's upload: %d %s", w.Code, w.Body.String())
+	}
+	chat.Messages[0].Parts[1] = models.ContentPart{Type: models.PartImage, URL: "file:///etc/passwd"}
+	if w := s.do(http.MethodPost, "/api/chat", token, chat); w.Code != http.StatusBadRequest {
+		t.Errorf("chat with invalid image part: %d %s", w.Code, w.Body.String())
+	}
+
+	if w := s.do(http.MethodDelete, "/api/uploads/"+uploaded.ID, token, nil); w.Code != http.StatusOK {
+		t.Errorf("delete upload: %d %s", w.Code, w.Body.String())
+	}
+	if w := s.do(http.MethodGet, "/api/uploads/"+uploaded.ID, token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("get deleted upload: %d", w.Code)
+	}
+}
+
+func TestPromptTemplateOverridesAndPreview(t *testing.T) {
+	s := newTestServer(t)
+	token, _ := s.login("alice")
+	otherToken, _ := s.login("bob")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "潮汐"}), &work)
+	chat := models.ChatRequest{Model: "mock", WorkID: work.ID, Messages: []models.Message{{Role: "user", Content: "写开头"}}}
+
+	var preview models.PromptPreviewResponse
+	s.decode(s.do(http.MethodPost, "/api/chat/preview", token, chat), &preview)
+	if len(preview.Messages) != 2 || !strings.HasPrefix(preview.Messages[0].Content, "你是一位专业的长篇故事创作助手") || preview.Messages[1].Content != "写开头" {
+		t.Fatalf("default preview: %+v", preview)
+	}
+
+	// 用户级模板对所有创作生效，创作级模板优先
+	w := s.do(http.MethodPut, "/api/prompts/work_system", token, models.PromptTemplateRequest{Content: "用户模板：{{.WorkTitle}}"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("save user template: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(s.do(http.MethodPost, "/api/chat/preview", token, chat), &preview)
+	if preview.Messages[0].Content != "用户模板：潮汐" {
+		t.Errorf("user override: %q", preview.Messages[0].Content)
+	}
+	s.do(http.MethodPut, "/api/prompts/work_system", token, models.PromptTemplateRequest{WorkID: work.ID, Content: "创作模板 v1"})
+	s.do(http.MethodPut, "/api/prompts/work_system", token, models.PromptTemplateRequest{WorkID: work.ID, Content: "创作模板 v2"})
+	s.decode(s.do(http.MethodPost, "/api/chat/preview", token, chat), &preview)
+	if preview.Messages[0].Content != "创作模板 v2" {
+		t.Errorf("work override: %q", preview.Messages[0].Content)
+	}
+
+	var versions models.PromptTemplateVersionsResponse
+	s.decode(s.do(http.MethodGet, "/api/prompts/work_system/versions?work_id="+work.ID, token, nil), &versions)
+	if versions.Total != 2 || versions.Versions[0].Version != 2 {
+		t.Fatalf("versions: %+v", versions)
+	}
+	var restored models.PromptTemplate
+	s.decode(s.do(http.MethodPost, "/api/prompts/work_system/versions/1/restore?work_id="+work.ID, token, nil), &restored)
+	if restored.Version != 3 || restored.Content != "创作模板 v1" {
+		t.Errorf("restored: %+v", restored)
+	}
+
+	var list models.PromptTemplateListResponse
+	s.decode(s.do(http.MethodGet, "/api/prompts?work_id="+work.ID, token, nil), &list)
+	if list.Total != 8 || list.Templates[0].Source != "work" || list.Templates[0].Version != 3 || list.Templates[3].Source != "default" {
+		t.Errorf("list: %+v", list.Templates)
+	}
+
+	// 模板错误和越权访问
+	if w := s.do(http.MethodPut, "/api/prompts/work_system", token, models.PromptTemplateRequest{Content: "{{.Missing}}"}); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid template: %d %s", w.Code, w.Body.String())
+	}
+	if w := s.do(http.MethodPut, "/api/prompts/unknown", token, models.PromptTemplateRequest{Content: "内容"}); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown template: %d", w.Code)
+	}
+	if w := s.do(http.MethodPut, "/api/prompts/work_system", otherToken, models.PromptTemplateRequest{WorkID: work.ID, Content: "偷改"}); w.Code != http.StatusNotFound {
+		t.Errorf("cross-user work template: %d", w.Code)
+	}
+	if w := s.do(http.MethodPost, "/api/chat/preview", otherToken, chat); w.Code != http.StatusNotFound {
+		t.Errorf("cross-user preview: %d", w.Code)
+	}
+
+	// 删除创作级模板后恢复使用用户级模板，删除创作时级联删除模板
+	if w := s.do(http.MethodDelete, "/api/prompts/work_system?work_id="+work.ID, token, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete work template: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(s.do(http.MethodPost, "/api/chat/preview", token, chat), &preview)
+	if preview.Messages[0].Content != "用户模板：潮汐" {
+		t.Errorf("after delete: %q", preview.Messages[0].Content)
+	}
+	s.do(http.MethodPut, "/api/prompts/work_system", token, models.PromptTemplateRequest{WorkID: work.ID, Content: "创作模板"})
+	s.do(http.MethodDelete, "/api/works/"+work.ID, token, nil)
+	var count int64
+	database.DB.Model(&models.PromptTemplate{}).Where("work_id = ?", work.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("work templates left after deleting work: %d", count)
+	}
+}
+
+func TestStyleProfiles(t *testing.T) {
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:         time.Hour,
+		EnableMockProvider: true,
+		MockResponses:      []string{`{"voice":"冷静克制","tense":"过去时","point_of_view":"第三人称有限视角","reading_level":"成人","banned_words":["突然","不禁","突然"]}`},
+	})
+	token, _ := s.login("alice")
+	otherToken, _ := s.login("bob")
+
+	var profile models.StyleProfile
+	w := s.do(http.MethodPost, "/api/style-profiles", token, models.StyleProfileRequest{
+		Name:           "海风",
+		Voice:          "简洁冷峻",
+		PointOfView:    "第一人称",
+		BannedWords:    []string{" 突然 ", "", "突然", "竟然"},
+		SamplePassages: []string{"浪打在礁石上，碎成白沫。"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create profile: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(w, &profile)
+	if profile.Source != "manual" || len(profile.BannedWords) != 2 || profile.BannedWords[0] != "突然" {
+		t.Errorf("created profile: %+v", profile)
+	}
+	if w := s.do(http.MethodPost, "/api/style-profiles", token, models.StyleProfileRequest{Name: "长", SamplePassages: []string{"1", "2", "3", "4", "5", "6"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("too many sample passages: %d", w.Code)
+	}
+
+	// 关联到创作后编译进系统提示
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "潮汐"}), &work)
+	if w := s.do(http.MethodPut, "/api/works/"+work.ID+"/style-profile", otherToken, models.AttachStyleProfileRequest{StyleProfileID: profile.ID}); w.Code != http.StatusNotFound {
+		t.Errorf("cross-user attach: %d", w.Code)
+	}
+	if w := s.do(http.MethodPut, "/api/works/"+work.ID+"/style-profile", token, models.AttachStyleProfileRequest{StyleProfileID: profile.ID}); w.Code != http.StatusOK {
+		t.Fatalf("attach to work: %d %s", w.Code, w.Body.String())
+	}
+	chat := models.ChatRequest{Model: "mock", WorkID: work.ID, Messages: []models.Message{{Role: "user", Content: "写开头"}}}
+	var preview models.PromptPreviewResponse
+	s.decode(s.do(http.MethodPost, "/api/chat/preview", token, chat), &preview)
+	system := preview.Messages[0].Content
+	for _, want := range []string{"## 文风要求（海风）", "叙述声音与语气：简洁冷峻", "叙事视角：第一人称", "禁止使用以下词语：突然、竟然", "浪打在礁石上"} {
+		if !strings.Contains(system, want) {
+			t.Errorf("system prompt missing %q:\n%s", want, system)
+		}
+	}
+
+	// 关联到对话时作为单独的系统提示
+	var conv models.Conversation
+	s.decode(s.do(http.MethodPost, "/api/conversations/new", token, nil), &conv)
+	s.do(http.MethodPut, "/api/conversations/"+conv.ID+"/style-profile", token, models.AttachStyleProfileRequest{StyleProfileID: profile.ID})
+	s.decode(s.do(http.MethodPost, "/api/chat/preview", token, models.ChatRequest{Model: "mock", ConversationID: conv.ID, Messages: chat.Messages}), &preview)
+	if len(preview.Messages) != 2 || preview.Messages[0].Role != "system" || !strings.Contains(preview.Messages[0].Content, "海风") {
+		t.Errorf("conversation preview: %+v", preview.Messages)
+	}
+
+	// 从正文分析文风
+	if w := s.do(http.MethodPost, "/api/style-profiles/derive", token, models.DeriveStyleProfileRequest{WorkID: work.ID, Model: "mock"}); w.Code != http.StatusBadRequest {
+		t.Errorf("derive from empty work: %d %s", w.Code, w.Body.String())
+	}
+	paragraph := strings.Repeat("海风吹过渔村的石板路，", 10)
+	s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第一章", Content: "短句。\n" + paragraph})
+	var derived models.StyleProfile
+	w = s.do(http.MethodPost, "/api/style-profiles/derive", token, models.DeriveStyleProfileRequest{WorkID: work.ID, Model: "mock"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("derive: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(w, &derived)
+	if derived.Source != "derived" || derived.Name != "潮汐 文风" || derived.Tense != "过去时" || len(derived.BannedWords) != 2 ||
+		len(derived.SamplePassages) != 1 || derived.SamplePassages[0] != paragraph || derived.SourceWorkID != work.ID {
+		t.Errorf("derived profile: %+v", derived)
+	}
+
+	// 删除档案失败时，创作和对话的关联随事务回滚
+	failDelete := func(db *gorm.DB) {
+		if db.Statement.Schema != nil && db.Statement.Schema.Table == "style_profiles" {
+			db.AddError(errors.New("style store unavailable"))
+		}
+	}
+	if err := database.DB.Callback().Delete().Before("gorm:delete").Register("test:fail_style_delete", failDelete); err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+	w = s.do(http.MethodDelete, "/api/style-profiles/"+profile.ID, token, nil)
+	database.DB.Callback().Delete().Remove("test:fail_style_delete")
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("failed delete: %d", w.Code)
+	}
+	var kept models.Work
+	database.DB.First(&kept, "id = ?", work.ID)
+	var keptConv models.Conversation
+	database.DB.First(&keptConv, "id = ?", conv.ID)
+	if kept.StyleProfileID != profile.ID || keptConv.StyleProfileID != profile.ID {
+		t.Errorf("references cleared by failed delete: work %q conversation %q", kept.StyleProfileID, keptConv.StyleProfileID)
+	}
+
+	// 删除档案后取消关联
+	if w := s.do(http.MethodDelete, "/api/style-profiles/"+profile.ID, token, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete profile: %d %s", w.Code, w.Body.String())
+	}
+	var updated models.Work
+	database.DB.First(&updated, "id = ?", work.ID)
+	if updated.StyleProfileID != "" {
+		t.Errorf("work still references deleted profile: %q", updated.StyleProfileID)
+	}
+	var list models.StyleProfileListResponse
+	s.decode(s.do(http.MethodGet, "/api/style-profiles", token, nil), &list)
+	if list.Total != 1 || list.Profiles[0].ID != derived.ID {
+		t.Errorf("profiles after delete: %+v", list)
+	}
+}
+
+func TestOutlineHierarchy(t *testing.T) {
+	s := newTestServer(t)
+	token, _ := s.login("alice")
+	otherToken, _ := s.login("bob")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长河"}), &work)
+	outlinePath := "/api/works/" + work.ID + "/outline"
+
+	create := func(req models.OutlineNodeRequest) models.OutlineNode {
+		t.Helper()
+		w := s.do(http.MethodPost, outlinePath, token, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("create %q: %d %s", req.Title, w.Code, w.Body.String())
+		}
+		var node models.OutlineNode
+		s.decode(w, &node)
+		return node
+	}
+	titles := func(nodes []models.OutlineTreeNode) []string {
+		out := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			out = append(out, fmt.Sprintf("%s@%d", node.Title, node.OrderIndex))
+		}
+		return out
+	}
+	getOutline := func() models.OutlineResponse {
+		t.Helper()
+		var outline models.OutlineResponse
+		s.decode(s.do(http.MethodGet, outlinePath, token, nil), &outline)
+		return outline
+	}
+
+	part1 := create(models.OutlineNodeRequest{Kind: "part", Title: "第一卷"})
+	part2 := create(models.OutlineNodeRequest{Kind: "part", Title: "第二卷"})
+	ch1 := create(models.OutlineNodeRequest{ParentID: part1.ID, Kind: "chapter", Title: "一"})
+	ch2 := create(models.OutlineNodeRequest{ParentID: part1.ID, Kind: "chapter", Title: "二"})
+	front := 0
+	ch0 := create(models.OutlineNodeRequest{ParentID: part1.ID, Kind: "chapter", Title: "楔子", Position: &front})
+	scene := create(models.OutlineNodeRequest{ParentID: ch1.ID, Kind: "scene", Title: "渡口", TargetLength: 3000})
+	if scene.Status != "planned" || ch0.OrderIndex != 0 {
+		t.Errorf("defaults: status=%q order=%d", scene.Status, ch0.OrderIndex)
+	}
+
+	// 不允许的层级
+	for _, req := range []models.OutlineNodeRequest{
+		{Kind: "scene", Title: "顶层场景"},
+		{ParentID: part1.ID, Kind: "part", Title: "卷中卷"},
+		{ParentID: scene.ID, Kind: "scene", Title: "场景中场景"},
+		{Kind: "chapter", Title: "坏状态", Status: "published"},
+	} {
+		if w := s.do(http.MethodPost, outlinePath, token, req); w.Code != http.StatusBadRequest {
+			t.Errorf("create %q: expected 400, got %d", req.Title, w.Code)
+		}
+	}
+
+	outline := getOutline()
+	if outline.Total != 6 || len(outline.Nodes) != 2 {
+		t.Fatalf("outline: %+v", outline)
+	}
+	if got := titles(outline.Nodes[0].Children); strings.Join(got, ",") != "楔子@0,一@1,二@2" {
+		t.Errorf("chapters after insert: %v", got)
+	}
+
+	// 调整顺序必须恰好包含所有同级节点
+	reorderPath := outlinePath + "/reorder"
+	if w := s.do(http.MethodPost, reorderPath, token, models.ReorderOutlineRequest{ParentID: part1.ID, NodeIDs: []string{ch2.ID, ch1.ID}}); w.Code != http.StatusBadRequest {
+		t.Errorf("partial reorder: %d", w.Code)
+	}
+	if w := s.do(http.MethodPost, reorderPath, token, models.ReorderOutlineRequest{ParentID: part1.ID, NodeIDs: []string{ch2.ID, ch0.ID, ch1.ID}}); w.Code != http.StatusOK {
+		t.Fatalf("reorder: %d %s", w.Code, w.Body.String())
+	}
+
+	// 跨父节点移动，子节点随之移动
+	if w := s.do(http.MethodPost, "/api/outline-nodes/"+ch1.ID+"/move", token, models.MoveOutlineNodeRequest{ParentID: scene.ID}); w.Code != http.StatusBadRequest {
+		t.Errorf("move chapter under scene: %d", w.Code)
+	}
+	if w := s.do(http.MethodPost, "/api/outline-nodes/"+ch1.ID+"/move", token, models.MoveOutlineNodeRequest{ParentID: part2.ID}); w.Code != http.StatusOK {
+		t.Fatalf("move: %d %s", w.Code, w.Body.String())
+	}
+	outline = getOutline()
+	if got := titles(outline.Nodes[0].Children); strings.Join(got, ",") != "二@0,楔子@1" {
+		t.Errorf("source chapters after move: %v", got)
+	}
+	if moved := outline.Nodes[1].Children; len(moved) != 1 || moved[0].ID != ch1.ID || len(moved[0].Children) != 1 {
+		t.Errorf("target after move: %+v", moved)
+	}
+	// 同一父节点内移动
+	if w := s.do(http.MethodPost, "/api/outline-nodes/"+ch0.ID+"/move", token, models.MoveOutlineNodeRequest{ParentID: part1.ID, Position: &front}); w.Code != http.StatusOK {
+		t.Fatalf("move within parent: %d", w.Code)
+	}
+	if got := titles(getOutline().Nodes[0].Children); strings.Join(got, ",") != "楔子@0,二@1" {
+		t.Errorf("chapters after move within parent: %v", got)
+	}
+
+	// 为场景创建正文并统计字数
+	var draft models.WorkDocument
+	w := s.do(http.MethodPost, "/api/outline-nodes/"+scene.ID+"/draft", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create draft: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(w, &draft)
+	s.do(http.MethodPut, "/api/work-documents/"+draft.ID+"/content", token, models.UpdateWorkDocumentContentRequest{Content: "夜色里的渡口"})
+	linked := getOutline().Nodes[1].Children[0].Children[0]
+	if linked.DocumentID != draft.ID || linked.DraftLength != 6 || linked.Status != "drafting" {
+		t.Errorf("linked scene: %+v", linked)
+	}
+	if w := s.do(http.MethodPost, "/api/outline-nodes/"+part1.ID+"/draft", token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("draft for part: %d", w.Code)
+	}
+
+	// 其他用户无法访问
+	if w := s.do(http.MethodGet, outlinePath, otherToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("cross-user outline: %d", w.Code)
+	}
+	if w := s.do(http.MethodPut, "/api/outline-nodes/"+ch2.ID, otherToken, models.OutlineNodeRequest{Title: "改"}); w.Code != http.StatusNotFound {
+		t.Errorf("cross-user update: %d", w.Code)
+	}
+	if w := s.do(http.MethodDelete, "/api/outline-nodes/"+ch2.ID, otherToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("cross-user delete: %d", w.Code)
+	}
+
+	// 删除节点会删除子孙节点并补齐顺序；删除正文会取消关联
+	if w := s.do(http.MethodDelete, "/api/outline-nodes/"+ch0.ID, token, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete: %d", w.Code)
+	}
+	s.do(http.MethodDelete, "/api/work-documents/"+draft.ID, token, nil)
+	outline = getOutline()
+	if got := titles(outline.Nodes[0].Children); strings.Join(got, ",") != "二@0" {
+		t.Errorf("chapters after delete: %v", got)
+	}
+	if outline.Nodes[1].Children[0].Children[0].DocumentID != "" {
+		t.Errorf("scene still linked to deleted draft")
+	}
+	if w := s.do(http.MethodDelete, "/api/outline-nodes/"+part2.ID, token, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete part: %d", w.Code)
+	}
+	if outline := getOutline(); outline.Total != 2 {
+		t.Errorf("nodes after deleting part: %+v", outline)
+	}
+}
+
+func TestWorkChatMessagesAndPromote(t *testing.T) {
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:         time.Hour,
+		EnableMockProvider: true,
+		MockResponses:      []string{"潮水退去\n林舟在沙滩上捡到一只漂流瓶。"},
+	})
+	token, _ := s.login("alice")
+	otherToken, _ := s.login("bob")
+
+	var work, otherWork models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "潮汐"}), &work)
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "别的创作"}), &otherWork)
+
+	chat := models.ChatRequest{Model: "mock", WorkID: work.ID, Messages: []models.Message{{Role: "user", Content: "写一段开头"}}}
+	if w := s.do(http.MethodPost, "/api/chat", token, chat); w.Code != http.StatusOK {
+		t.Fatalf("chat: %d %s", w.Code, w.Body.String())
+	}
+
+	// 对话消息不再出现在正文文档中
+	var docs models.WorkDocumentResponse
+	s.decode(s.do(http.MethodGet, "/api/works/"+work.ID+"/documents", token, nil), &docs)
+	if docs.Total != 0 {
+		t.Errorf("chat turns leaked into manuscript: %+v", docs.Documents)
+	}
+	var messages models.WorkChatMessageResponse
+	s.decode(s.do(http.MethodGet, "/api/works/"+work.ID+"/messages", token, nil), &messages)
+	if messages.Total != 2 || messages.Messages[0].Role != "user" || messages.Messages[1].Role != "assistant" {
+		t.Fatalf("messages: %+v", messages)
+	}
+	userMessage, reply := messages.Messages[0], messages.Messages[1]
+
+	if w := s.do(http.MethodGet, "/api/works/"+work.ID+"/messages", otherToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("cross-user messages: %d", w.Code)
+	}
+	if w := s.do(http.MethodPost, "/api/work-messages/"+reply.ID+"/promote", otherToken, models.PromoteWorkChatMessageRequest{}); w.Code != http.StatusNotFound {
+		t.Errorf("cross-user promote: %d", w.Code)
+	}
+	if w := s.do(http.MethodPost, "/api/work-messages/"+userMessage.ID+"/promote", token, models.PromoteWorkChatMessageRequest{}); w.Code != http.StatusBadRequest {
+		t.Errorf("promote user message: %d", w.Code)
+	}
+
+	// 新建正文，标题取回答的第一行
+	var chapter models.WorkDocument
+	w := s.do(http.MethodPost, "/api/work-messages/"+reply.ID+"/promote", token, models.PromoteWorkChatMessageRequest{})
+	if w.Code != http.StatusOK {
+		t.Fatalf("promote: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(w, &chapter)
+	if chapter.Title != "潮水退去" || chapter.Content != reply.Content || chapter.WorkID != work.ID {
+		t.Errorf("promoted chapter: %+v", chapter)
+	}
+
+	// 追加到已有正文
+	w = s.do(http.MethodPost, "/api/work-messages/"+reply.ID+"/promote", token, models.PromoteWorkChatMessageRequest{DocumentID: chapter.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("promote into chapter: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(w, &chapter)
+	if chapter.Content != reply.Content+"\n\n"+reply.Content {
+		t.Errorf("appended chapter: %q", chapter.Content)
+	}
+	var foreign models.WorkDocument
+	s.decode(s.do(http.MethodPost, "/api/works/"+otherWork.ID+"/documents", token, models.WorkDocumentRequest{WorkID: otherWork.ID, Title: "x"}), &foreign)
+	if w := s.do(http.MethodPost, "/api/work-messages/"+reply.ID+"/promote", token, models.PromoteWorkChatMessageRequest{DocumentID: foreign.ID}); w.Code != http.StatusBadRequest {
+		t.Errorf("promote into another work's document: %d", w.Code)
+	}
+
+	s.decode(s.do(http.MethodGet, "/api/works/"+work.ID+"/messages", token, nil), &messages)
+	if messages.Messages[1].PromotedDocumentID != chapter.ID {
+		t.Errorf("promoted document not recorded: %+v", messages.Messages[1])
+	}
+	s.decode(s.do(http.MethodGet, "/api/works/"+work.ID+"/documents", token, nil), &docs)
+	if docs.Total != 1 {
+		t.Errorf("manuscript after promote: %+v", docs.Documents)
+	}
+
+	// 删除消息和创作
+	if w := s.do(http.MethodDelete, "/api/work-messages/"+userMessage.ID, token, nil); w.Code != http.StatusOK {
+		t.Errorf("delete message: %d", w.Code)
+	}
+	s.do(http.MethodDelete, "/api/works/"+work.ID, token, nil)
+	var count int64
+	database.DB.Model(&models.WorkChatMessage{}).Count(&count)
+	if count != 0 {
+		t.Errorf("messages left after deleting work: %d", count)
+	}
+}
+
+// legacyWorkDocument 拆分对话消息之前的work_documents表结构
+type legacyWorkDocument struct {
+	ID        string `gorm:"primaryKey"`
+	WorkID    string `gorm:"index"`
+	UserID    string `gorm:"index"`
+	Title     string
+	Content   string `gorm:"type:text"`
+	Reasoning string `gorm:"type:text"`
+	Role      string
+	Model     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (legacyWorkDocument) TableName() string {
+	return "work_documents"
+}
+
+func TestMigrateWorkChatMessagesFromWorkDocuments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open legacy db: %v", err)
+	}
+	// 旧版本的work_documents同时保存正文和灵感模式对话
+	if err := legacy.AutoMigrate(&legacyWorkDocument{}); err != nil {
+		t.Fatalf("prepare legacy db: %v", err)
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	legacy.Create([]legacyWorkDocument{
+		{ID: "doc_1", WorkID: "work_1", UserID: "user_1", Title: "第一章", Content: "正文", CreatedAt: base},
+		{ID: "doc_2", WorkID: "work_1", UserID: "user_1", Content: "提问", Role: "user", Model: "openai", CreatedAt: base.Add(time.Second)},
+		{ID: "doc_3", WorkID: "work_1", UserID: "user_1", Content: "回答", Reasoning: "想法", Role: "assistant", Model: "openai", CreatedAt: base.Add(2 * time.Second)},
+	})
+	if db, err := legacy.DB(); err == nil {
+		db.Close()
+	}
+
+	if err := database.InitDB(path); err != nil {
+		t.Fatalf("init db: %v", err)
+	}
+	var docs []models.WorkDocument
+	database.DB.Order("id").Find(&docs)
+	if len(docs) != 1 || docs[0].ID != "doc_1" || docs[0].Title != "第一章" {
+		t.Errorf("manuscript after migration: %+v", docs)
+	}
+	var messages []models.WorkChatMessage
+	database.DB.Order("created_at").Find(&messages)
+	if len(messages) != 2 || messages[0].ID != "doc_2" || messages[0].Role != "user" ||
+		messages[1].Content != "回答" || messages[1].Reasoning != "想法" || messages[1].Model != "openai" {
+		t.Errorf("messages after migration: %+v", messages)
+	}
+	if database.DB.Migrator().HasColumn(&models.WorkDocument{}, "role") {
+		t.Errorf("role column still present on work_documents")
+	}
+
+	// 再次启动不会重复迁移
+	if err := database.InitDB(path); err != nil {
+		t.Fatalf("init db again: %v", err)
+	}
+	var count int64
+	database.DB.Model(&models.WorkChatMessage{}).Count(&count)
+	if count != 2 {
+		t.Errorf("messages after second start: %d", count)
+	}
+}
+
+func TestRevisionHistory(t *testing.T) {
+	s := newTestServer(t)
+	token, _ := s.login("alice")
+	otherToken, _ := s.login("bob")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长河"}), &work)
+	var doc models.WorkDocument
+	s.decode(s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第一章", Content: "第0版\n"}), &doc)
+	base := "/api/work-documents/" + doc.ID
+
+	// 连续保存，跨过完整快照的间隔；内容未变化的保存不会产生修订
+	content := "第0版\n"
+	versions := []string{content}
+	for i := 1; i <= 24; i++ {
+		content += fmt.Sprintf("第%d行\n", i)
+		versions = append(versions, content)
+		if w := s.do(http.MethodPut, base+"/content", token, models.UpdateWorkDocumentContentRequest{Content: content}); w.Code != http.StatusOK {
+			t.Fatalf("save %d: %d", i, w.Code)
+		}
+	}
+	s.do(http.MethodPut, base+"/content", token, models.UpdateWorkDocumentContentRequest{Content: content})
+
+	var list models.RevisionListResponse
+	s.decode(s.do(http.MethodGet, base+"/revisions", token, nil), &list)
+	if list.Total != 25 || list.Revisions[0].Number != 25 || list.Revisions[0].Author != "user" {
+		t.Fatalf("revisions: total=%d first=%+v", list.Total, list.Revisions[0])
+	}
+	var snapshots int
+	for _, revision := range list.Revisions {
+		if revision.Encoding == "snapshot" {
+			snapshots++
+		}
+	}
+	if snapshots != 2 {
+		t.Errorf("snapshots: %d", snapshots)
+	}
+	for _, number := range []int{1, 20, 21, 25} {
+		var revision models.RevisionContentResponse
+		s.decode(s.do(http.MethodGet, fmt.Sprintf("%s/revisions/%d", base, number), token, nil), &revision)
+		if revision.Content != versions[number-1] {
+			t.Errorf("revision %d content: %q", number, revision.Content)
+		}
+	}
+
+	// 差异
+	var diff models.RevisionDiffResponse
+	s.decode(s.do(http.MethodGet, base+"/revisions/diff?from=1&to=3", token, nil), &diff)
+	if diff.Mode != "line" || diff.Inserted != 2 || diff.Deleted != 0 || len(diff.Ops) != 2 || diff.Ops[1].Text != "第1行\n第2行\n" {
+		t.Errorf("line diff: %+v", diff)
+	}
+	s.decode(s.do(http.MethodGet, base+"/revisions/diff?from=2&to=1&mode=rune", token, nil), &diff)
+	if diff.Deleted != 4 || diff.Inserted != 0 {
+		t.Errorf("rune diff: %+v", diff)
+	}
+	if w := s.do(http.MethodGet, base+"/revisions/diff?from=1&to=2&mode=word", token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid mode: %d", w.Code)
+	}
+	if w := s.do(http.MethodGet, base+"/revisions/99", token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("missing revision: %d", w.Code)
+	}
+
+	// 恢复为新的修订
+	var restored models.RevisionContentResponse
+	w := s.do(http.MethodPost, base+"/revisions/2/restore", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("restore: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(w, &restored)
+	var current models.WorkDocument
+	s.decode(s.do(http.MethodGet, base, token, nil), &current)
+	if restored.Number != 26 || current.Content != versions[1] {
+		t.Errorf("restore: revision=%d content=%q", restored.Number, current.Content)
+	}
+
+	// 其他用户无法访问
+	for _, path := range []string{base + "/revisions", base + "/revisions/1", base + "/revisions/diff?from=1&to=2"} {
+		if w := s.do(http.MethodGet, path, otherToken, nil); w.Code != http.StatusNotFound {
+			t.Errorf("cross-user %s: %d", path, w.Code)
+		}
+	}
+	if w := s.do(http.MethodPost, base+"/revisions/1/restore", otherToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("cross-user restore: %d", w.Code)
+	}
+
+	// 功能上线前创建的内容：第一次保存时先记录原内容
+	legacy := &models.WorkDocument{ID: "legacy_doc", WorkID: work.ID, UserID: doc.UserID, Title: "旧章节", Content: "旧内容"}
+	database.DB.Create(legacy)
+	s.do(http.MethodPut, "/api/work-documents/legacy_doc/content", token, models.UpdateWorkDocumentContentRequest{Content: "新内容"})
+	s.decode(s.do(http.MethodGet, "/api/work-documents/legacy_doc/revisions/1", token, nil), &restored)
+	if restored.Content != "旧内容" {
+		t.Errorf("legacy baseline: %+v", restored)
+	}
+
+	// 故事同样记录修订
+	var story models.Story
+	s.decode(s.do(http.MethodPost, "/api/stories", token, models.StoryRequest{Title: "短篇", Content: "初稿"}), &story)
+	s.do(http.MethodPut, "/api/stories/"+story.ID, token, models.StoryRequest{Title: "短篇", Content: "二稿"})
+	s.decode(s.do(http.MethodPost, "/api/stories/"+story.ID+"/revisions/1/restore", token, nil), &restored)
+	var stories models.StoryResponse
+	s.decode(s.do(http.MethodGet, "/api/stories", token, nil), &stories)
+	if restored.Number != 3 || stories.Story[0].Content != "初稿" || stories.Story[0].ContentHash != restored.ContentHash {
+		t.Errorf("story restore: %+v %+v", restored.Revision, stories.Story[0])
+	}
+
+	// 删除文档和创作时删除修订
+	s.do(http.MethodDelete, "/api/stories/"+story.ID, token, nil)
+	s.do(http.MethodDelete, "/api/works/"+work.ID, token, nil)
+	var count int64
+	database.DB.Model(&models.Revision{}).Count(&count)
+	if count != 0 {
+		t.Errorf("revisions left: %d", count)
+	}
+}
+
+func TestRevisionFailureRollsBackContent(t *testing.T) {
+	s := newTestServer(t)
+	token, _ := s.login("alice")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长河"}), &work)
+	var doc models.WorkDocument
+	s.decode(s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第一章", Content: "初稿"}), &doc)
+	base := "/api/work-documents/" + doc.ID
+	s.do(http.MethodPut, base+"/content", token, models.UpdateWorkDocumentContentRequest{Content: "二稿"})
+	var story models.Story
+	s.decode(s.do(http.MethodPost, "/api/stories", token, models.StoryRequest{Title: "短篇", Content: "初稿"}), &story)
+
+	// 记录修订失败时，内容的修改随事务回滚
+	failRevisions := func(db *gorm.DB) {
+		if db.Statement.Schema != nil && db.Statement.Schema.Table == "revisions" {
+			db.AddError(errors.New("revision store unavailable"))
+		}
+	}
+	if err := database.DB.Callback().Create().Before("gorm:create").Register("test:fail_revisions", failRevisions); err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+	defer database.DB.Callback().Create().Remove("test:fail_revisions")
+
+	if w := s.do(http.MethodPut, base+"/content", token, models.UpdateWorkDocumentContentRequest{Content: "三稿"}); w.Code != http.StatusInternalServerError {
+		t.Errorf("save: %d", w.Code)
+	}
+	if w := s.do(http.MethodPost, base+"/revisions/1/restore", token, nil); w.Code != http.StatusInternalServerError {
+		t.Errorf("restore: %d", w.Code)
+	}
+	var current models.WorkDocument
+	s.decode(s.do(http.MethodGet, base, token, nil), &current)
+	if current.Content != "二稿" || current.Version != 2 {
+		t.Errorf("document after failed revision: content=%q version=%d", current.Content, current.Version)
+	}
+
+	if w := s.do(http.MethodPut, "/api/stories/"+story.ID, token, models.StoryRequest{Title: "短篇", Content: "二稿"}); w.Code != http.StatusInternalServerError {
+		t.Errorf("story save: %d", w.Code)
+	}
+	var stories models.StoryResponse
+	s.decode(s.do(http.MethodGet, "/api/stories", token, nil), &stories)
+	if len(stories.Story) != 1 || stories.Story[0].Content != "初稿" || stories.Story[0].Version != 1 {
+		t.Errorf("story after failed revision: %+v", stories.Story)
+	}
+}
+
+func TestWorkDocumentOptimisticConcurrency(t *testing.T) {
+	s := newTestServer(t)
+	token, userID := s.login("alice")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长河"}), &work)
+	w := s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第一章", Content: "初稿"})
+	var doc models.WorkDocument
+	s.decode(w, &doc)
+	base := "/api/work-documents/" + doc.ID
+	if doc.Version != 1 || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("created: version=%d etag=%q", doc.Version, w.Header().Get("ETag"))
+	}
+
+	// 两个标签页都基于版本1修改，后保存的一方收到409和服务器上的当前内容
+	w = s.doIfMatch(http.MethodPut, base+"/content", token, `"1"`, models.UpdateWorkDocumentContentRequest{Content: "标签页A"})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("first save: %d etag=%q", w.Code, w.Header().Get("ETag"))
+	}
+	w = s.doIfMatch(http.MethodPut, base+"/content", token, `"1"`, models.UpdateWorkDocumentContentRequest{Content: "标签页B"})
+	var conflict models.WorkDocumentConflictResponse
+	s.decode(w, &conflict)
+	if w.Code != http.StatusConflict || conflict.Error != "version_conflict" || conflict.Document.Content != "标签页A" || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("stale save: %d %+v", w.Code, conflict)
+	}
+	for _, req := range []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodPut, base + "/title", models.UpdateWorkDocumentTitleRequest{Title: "旧标题"}},
+		{http.MethodPost, base + "/revisions/1/restore", nil},
+		{http.MethodDelete, base, nil},
+	} {
+		if w := s.doIfMatch(req.method, req.path, token, `"1"`, req.body); w.Code != http.StatusConflict {
+			t.Errorf("stale %s %s: %d", req.method, req.path, w.Code)
+		}
+	}
+	if w := s.doIfMatch(http.MethodPut, base+"/content", token, "2", models.UpdateWorkDocumentContentRequest{Content: "x"}); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid If-Match: %d", w.Code)
+	}
+
+	// 带当前ETag或不带If-Match的修改都会使版本号加一
+	w = s.doIfMatch(http.MethodPut, base+"/title", token, `"2"`, models.UpdateWorkDocumentTitleRequest{Title: "新标题"})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"3"` {
+		t.Fatalf("title: %d etag=%q", w.Code, w.Header().Get("ETag"))
+	}
+	var restored models.RevisionContentResponse
+	w = s.do(http.MethodPost, base+"/revisions/1/restore", token, nil)
+	s.decode(w, &restored)
+	if restored.DocumentVersion != 4 || w.Header().Get("ETag") != `"4"` {
+		t.Fatalf("restore: %d %+v", w.Code, restored)
+	}
+	w = s.do(http.MethodGet, base, token, nil)
+	s.decode(w, &doc)
+	if doc.Version != 4 || doc.Content != "初稿" || doc.Title != "新标题" || w.Header().Get("ETag") != `"4"` {
+		t.Fatalf("current: %+v etag=%q", doc, w.Header().Get("ETag"))
+	}
+
+	// 流式写入期间其他修改返回409，写入完成释放锁后恢复正常
+	repo := repository.NewWorkDocumentRepository(database.DB)
+	if err := repo.Lock(doc.ID, userID, "stream_1", 0, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if err := repo.Lock(doc.ID, userID, "stream_2", 0, time.Now().Add(time.Minute)); !errors.Is(err, repository.ErrDocumentLocked) {
+		t.Errorf("second lock: %v", err)
+	}
+	if err := repo.AppendLockedContent(doc.ID, userID, "stream_1", "，续写", time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("stream append: %v", err)
+	}
+	for _, req := range []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodPut, base + "/content", models.UpdateWorkDocumentContentRequest{Content: "手动保存"}},
+		{http.MethodPut, base + "/title", models.UpdateWorkDocumentTitleRequest{Title: "改名"}},
+		{http.MethodDelete, base, nil},
+	} {
+		w := s.do(req.method, req.path, token, req.body)
+		s.decode(w, &conflict)
+		if w.Code != http.StatusConflict || conflict.Error != "document_locked" || conflict.Document.Content != "初稿，续写" {
+			t.Errorf("locked %s %s: %d %+v", req.method, req.path, w.Code, conflict)
+		}
+	}
+	if err := repo.Unlock(doc.ID, userID, "stream_1"); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	if w := s.doIfMatch(http.MethodPut, base+"/content", token, `"5"`, models.UpdateWorkDocumentContentRequest{Content: "定稿"}); w.Code != http.StatusOK {
+		t.Errorf("after unlock: %d %s", w.Code, w.Body.String())
+	}
+
+	// 过期的锁不再阻止修改
+	if err := repo.Lock(doc.ID, userID, "stream_3", 0, time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("expired lock: %v", err)
+	}
+	if w := s.do(http.MethodPut, base+"/content", token, models.UpdateWorkDocumentContentRequest{Content: "再改"}); w.Code != http.StatusOK {
+		t.Errorf("expired lock save: %d", w.Code)
+	}
+}
+
+func TestStoryAndDocumentOptimisticConcurrency(t *testing.T) {
+	s := newTestServer(t)
+	token, userID := s.login("alice")
+
+	// 跨域请求可以携带If-Match并读取ETag
+	w := s.do(http.MethodOptions, "/api/stories", "", nil)
+	if !strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), "If-Match") || w.Header().Get("Access-Control-Expose-Headers") != "ETag" {
+		t.Errorf("cors headers: %v", w.Header())
+	}
+
+	// 故事：基于旧版本的修改和恢复返回409和服务器上的当前故事
+	var story models.Story
+	w = s.do(http.MethodPost, "/api/stories", token, models.StoryRequest{Title: "短篇", Content: "初稿"})
+	s.decode(w, &story)
+	if story.Version != 1 || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("create story: version=%d etag=%q", story.Version, w.Header().Get("ETag"))
+	}
+	w = s.doIfMatch(http.MethodPut, "/api/stories/"+story.ID, token, `"1"`, models.StoryRequest{Title: "短篇", Content: "标签页A"})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("first story save: %d etag=%q", w.Code, w.Header().Get("ETag"))
+	}
+	var storyConflict models.StoryConflictResponse
+	w = s.doIfMatch(http.MethodPut, "/api/stories/"+story.ID, token, `"1"`, models.StoryRequest{Title: "短篇", Content: "标签页B"})
+	s.decode(w, &storyConflict)
+	if w.Code != http.StatusConflict || storyConflict.Story.Content != "标签页A" || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("stale story save: %d %+v", w.Code, storyConflict)
+	}
+	if w := s.doIfMatch(http.MethodPost, "/api/stories/"+story.ID+"/revisions/1/restore", token, `"1"`, nil); w.Code != http.StatusConflict {
+		t.Errorf("stale story restore: %d", w.Code)
+	}
+	var restored models.RevisionContentResponse
+	w = s.doIfMatch(http.MethodPost, "/api/stories/"+story.ID+"/revisions/1/restore", token, `"2"`, nil)
+	s.decode(w, &restored)
+	if w.Code != http.StatusOK || restored.DocumentVersion != 3 || w.Header().Get("ETag") != `"3"` {
+		t.Fatalf("story restore: %d %+v", w.Code, restored)
+	}
+
+	// 对话文档：同样按版本号检查
+	var conv models.Conversation
+	s.decode(s.do(http.MethodPost, "/api/conversations/new", token, nil), &conv)
+	doc := &models.Document{ID: "doc_alice", UserID: userID, ConversationID: conv.ID, Role: "assistant", Content: "回答"}
+	if err := repository.NewDocumentRepository(database.DB).Create(doc); err != nil {
+		t.Fatalf("create document: %v", err)
+	}
+	if w := s.do(http.MethodGet, "/api/documents/"+doc.ID, token, nil); w.Header().Get("ETag") != `"1"` {
+		t.Errorf("document etag: %q", w.Header().Get("ETag"))
+	}
+	w = s.doIfMatch(http.MethodPut, "/api/documents/"+doc.ID, token, `"1"`, models.UpdateDocumentRequest{Content: "修改A"})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("first document save: %d etag=%q", w.Code, w.Header().Get("ETag"))
+	}
+	var docConflict models.DocumentConflictResponse
+	w = s.doIfMatch(http.MethodPut, "/api/documents/"+doc.ID, token, `"1"`, models.UpdateDocumentRequest{Content: "修改B"})
+	s.decode(w, &docConflict)
+	if w.Code != http.StatusConflict || docConflict.Error != "version_conflict" || docConflict.Document.Content != "修改A" {
+		t.Fatalf("stale document save: %d %+v", w.Code, docConflict)
+	}
+	if w := s.do(http.MethodPut, "/api/documents/"+doc.ID, token, models.UpdateDocumentRequest{Content: "修改C"}); w.Code != http.StatusOK || w.Header().Get("ETag") != `"3"` {
+		t.Errorf("save without If-Match: %d etag=%q", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestTransformSelectionProducesPatch(t *testing.T) {
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:         time.Hour,
+		EnableMockProvider: true,
+		MockResponses:      []string{"潮水退去，礁石露出黑色的脊背。", "海面像一块铁。"},
+		MockChunkSize:      3,
+	})
+	token, _ := s.login("alice")
+	otherToken, _ := s.login("bob")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长河"}), &work)
+	var doc models.WorkDocument
+	s.decode(s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第一章", Content: "天亮了。\n海很平静。\n林舟出门。"}), &doc)
+	base := "/api/work-documents/" + doc.ID
+
+	// 选中“海很平静。”（按字符计的位置）
+	transform := models.TransformRequest{Start: 5, End: 10, Operation: "rewrite", Model: "mock"}
+	w := s.do(http.MethodPost, base+"/transform", token, transform)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.HasPrefix(body, "潮水退去，礁石露出黑色的脊背。") || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("transform: %d %q", w.Code, body)
+	}
+	start := strings.Index(body, "<GRANDMA_PATCH>")
+	end := strings.Index(body, "</GRANDMA_PATCH>")
+	if start < 0 || end < start {
+		t.Fatalf("patch marker missing: %q", body)
+	}
+	var patch models.DocumentPatch
+	if err := json.Unmarshal([]byte(body[start+len("<GRANDMA_PATCH>"):end]), &patch); err != nil {
+		t.Fatalf("decode patch: %v", err)
+	}
+	if patch.Original != "海很平静。" || patch.Replacement != "潮水退去，礁石露出黑色的脊背。" || patch.BaseVersion != 1 || patch.Status != models.PatchStatusPending {
+		t.Fatalf("patch: %+v", patch)
+	}
+
+	// 请求不合法时返回400，版本不一致时返回409，都不会调用模型
+	for _, bad := range []models.TransformRequest{
+		{Start: 5, End: 10, Operation: "summarize", Model: "mock"},
+		{Start: 5, End: 10, Operation: "tone", Model: "mock"},
+		{Start: 5, End: 5, Operation: "rewrite", Model: "mock"},
+		{Start: 5, End: 100, Operation: "rewrite", Model: "mock"},
+	} {
+		if w := s.do(http.MethodPost, base+"/transform", token, bad); w.Code != http.StatusBadRequest {
+			t.Errorf("invalid transform %+v: %d", bad, w.Code)
+		}
+	}
+	if w := s.doIfMatch(http.MethodPost, base+"/transform", token, `"7"`, transform); w.Code != http.StatusConflict {
+		t.Errorf("stale transform: %d", w.Code)
+	}
+	if w := s.do(http.MethodPost, base+"/transform", otherToken, transform); w.Code != http.StatusNotFound {
+		t.Errorf("cross-user transform: %d", w.Code)
+	}
+
+	var patches models.DocumentPatchListResponse
+	s.decode(s.do(http.MethodGet, base+"/patches", token, nil), &patches)
+	if patches.Total != 1 || patches.Patches[0].ID != patch.ID {
+		t.Fatalf("pending patches: %+v", patches)
+	}
+
+	// 生成建议后文档被修改，原文仍唯一存在时可以接受
+	s.do(http.MethodPut, base+"/content", token, models.UpdateWorkDocumentContentRequest{Content: "序。\n天亮了。\n海很平静。\n林舟出门。"})
+	if w := s.do(http.MethodPost, "/api/document-patches/"+patch.ID+"/accept", otherToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("cross-user accept: %d", w.Code)
+	}
+	w = s.do(http.MethodPost, "/api/document-patches/"+patch.ID+"/accept", token, nil)
+	s.decode(w, &doc)
+	if w.Code != http.StatusOK || doc.Content != "序。\n天亮了。\n潮水退去，礁石露出黑色的脊背。\n林舟出门。" || w.Header().Get("ETag") != `"3"` {
+		t.Fatalf("accept: %d %+v", w.Code, doc)
+	}
+	var revisions models.RevisionListResponse
+	s.decode(s.do(http.MethodGet, base+"/revisions", token, nil), &revisions)
+	if revisions.Revisions[0].Author != models.RevisionAuthorModel || revisions.Revisions[0].Model != "mock" {
+		t.Errorf("accept revision: %+v", revisions.Revisions[0])
+	}
+	if w := s.do(http.MethodPost, "/api/document-patches/"+patch.ID+"/accept", token, nil); w.Code != http.StatusConflict {
+		t.Errorf("accept twice: %d", w.Code)
+	}
+
+	// 原文已不存在时接受返回409和当前文档
+	w = s.do(http.MethodPost, base+"/transform", token, models.TransformRequest{Start: 0, End: 2, Operation: "custom", Instruction: "换成景物描写", Model: "mock"})
+	body = w.Body.String()
+	if err := json.Unmarshal([]byte(body[strings.Index(body, "<GRANDMA_PATCH>")+len("<GRANDMA_PATCH>"):strings.Index(body, "</GRANDMA_PATCH>")]), &patch); err != nil {
+		t.Fatalf("decode second patch: %v", err)
+	}
+	s.do(http.MethodPut, base+"/content", token, models.UpdateWorkDocumentContentRequest{Content: "全部重写"})
+	w = s.do(http.MethodPost, "/api/document-patches/"+patch.ID+"/accept", token, nil)
+	var conflict models.WorkDocumentConflictResponse
+	s.decode(w, &conflict)
+	if w.Code != http.StatusConflict || conflict.Error != "patch_conflict" || conflict.Document.Content != "全部重写" {
+		t.Fatalf("conflicting accept: %d %+v", w.Code, conflict)
+	}
+
+	// 拒绝不修改文档
+	if w := s.do(http.MethodPost, "/api/document-patches/"+patch.ID+"/reject", token, nil); w.Code != http.StatusOK {
+		t.Errorf("reject: %d", w.Code)
+	}
+	if w := s.do(http.MethodPost, "/api/document-patches/"+patch.ID+"/reject", token, nil); w.Code != http.StatusConflict {
+		t.Errorf("reject twice: %d", w.Code)
+	}
+	s.decode(s.do(http.MethodGet, base+"/patches", token, nil), &patches)
+	if patches.Total != 0 {
+		t.Errorf("pending after resolve: %+v", patches)
+	}
+
+	var summary models.UsageSummaryResponse
+	s.decode(s.do(http.MethodGet, "/api/usage", token, nil), &summary)
+	if len(summary.Daily.Breakdown) != 1 || summary.Daily.Breakdown[0].Feature != "transform" {
+		t.Errorf("usage breakdown: %+v", summary.Daily.Breakdown)
+	}
+}
+
+func TestTransformPromptIncludesSelectionAndContext(t *testing.T) {
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:         time.Hour,
+		EnableMockProvider: true,
+	})
+	token, _ := s.login("alice")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长河"}), &work)
+	var doc models.WorkDocument
+	s.decode(s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第一章", Content: "天亮了。海很平静。林舟出门。"}), &doc)
+
+	// 未配置脚本响应时模拟服务商回显最后一条用户消息，即渲染后的transform模板
+	w := s.do(http.MethodPost, "/api/work-documents/"+doc.ID+"/transform", token, models.TransformRequest{Start: 4, End: 9, Operation: "translate", Instruction: "英文", Model: "mock"})
+	prompt := w.Body.String()
+	for _, want := range []string{"把这段文字翻译成英文", "天亮了。", "海很平静。", "林舟出门。"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt missing %q: %s", want, prompt)
+		}
+	}
+}
+
+// generation 解析续写响应末尾的写入结果
+func generation(t *testing.T, body string) models.GenerateResult {
+	t.Helper()
+	start := strings.Index(body, "<GRANDMA_GENERATION>")
+	end := strings.Index(body, "</GRANDMA_GENERATION>")
+	if start < 0 || end < start {
+		t.Fatalf("generation marker missing: %q", body)
+	}
+	var result models.GenerateResult
+	if err := json.Unmarshal([]byte(body[start+len("<GRANDMA_GENERATION>"):end]), &result); err != nil {
+		t.Fatalf("decode generation: %v", err)
+	}
+	return result
+}
+
+func TestGenerateContinuesAndFillsDocument(t *testing.T) {
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:         time.Hour,
+		EnableMockProvider: true,
+		MockResponses:      []string{"海风吹来。", "她回头。", "夜色"},
+		MockChunkSize:      2,
+	})
+	token, _ := s.login("alice")
+	otherToken, _ := s.login("bob")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长河"}), &work)
+	var doc models.WorkDocument
+	s.decode(s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第一章", Content: "天亮了。"}), &doc)
+	base := "/api/work-documents/" + doc.ID
+	position := func(n int) *int { return &n }
+
+	// 从结尾续写
+	w := s.do(http.MethodPost, base+"/generate", token, models.GenerateRequest{Model: "mock"})
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "海风吹来。") {
+		t.Fatalf("continue: %d %q", w.Code, w.Body.String())
+	}
+	result := generation(t, w.Body.String())
+	s.decode(s.do(http.MethodGet, base, token, nil), &doc)
+	if doc.Content != "天亮了。海风吹来。" || result.Start != 4 || result.End != 9 || result.Version != doc.Version || result.Revision != 2 || result.UndoRevision != 1 {
+		t.Fatalf("continue result: %+v %q", result, doc.Content)
+	}
+	var revisions models.RevisionListResponse
+	s.decode(s.do(http.MethodGet, base+"/revisions", token, nil), &revisions)
+	if revisions.Revisions[0].Author != models.RevisionAuthorModel || revisions.Revisions[0].Model != "mock" {
+		t.Errorf("continue revision: %+v", revisions.Revisions[0])
+	}
+
+	// 在光标处插入
+	w = s.do(http.MethodPost, base+"/generate", token, models.GenerateRequest{Start: position(4), Model: "mock"})
+	result = generation(t, w.Body.String())
+	s.decode(s.do(http.MethodGet, base, token, nil), &doc)
+	if doc.Content != "天亮了。她回头。海风吹来。" || result.Start != 4 || result.End != 8 || result.UndoRevision != 2 {
+		t.Fatalf("insert result: %+v %q", result, doc.Content)
+	}
+
+	// 补写两个位置之间的内容（替换其间的文本）
+	w = s.do(http.MethodPost, base+"/generate", token, models.GenerateRequest{Start: position(0), End: position(3), Model: "mock"})
+	result = generation(t, w.Body.String())
+	s.decode(s.do(http.MethodGet, base, token, nil), &doc)
+	if doc.Content != "夜色。她回头。海风吹来。" || result.End != 2 || result.Revision != 4 {
+		t.Fatalf("fill result: %+v %q", result, doc.Content)
+	}
+
+	// 恢复撤销点即可撤销
+	s.do(http.MethodPost, fmt.Sprintf("%s/revisions/%d/restore", base, result.UndoRevision), token, nil)
+	s.decode(s.do(http.MethodGet, base, token, nil), &doc)
+	if doc.Content != "天亮了。她回头。海风吹来。" {
+		t.Errorf("undo: %q", doc.Content)
+	}
+
+	for _, bad := range []models.GenerateRequest{
+		{Start: position(100), Model: "mock"},
+		{Start: position(3), End: position(1), Model: "mock"},
+		{End: position(1), Model: "mock"},
+		{TargetLength: 100000, Model: "mock"},
+	} {
+		if w := s.do(http.MethodPost, base+"/generate", token, bad); w.Code != http.StatusBadRequest {
+			t.Errorf("invalid generate %+v: %d", bad, w.Code)
+		}
+	}
+	if w := s.doIfMatch(http.MethodPost, base+"/generate", token, `"1"`, models.GenerateRequest{Model: "mock"}); w.Code != http.StatusConflict {
+		t.Errorf("stale generate: %d", w.Code)
+	}
+
+	// 空文档生成后也可以撤销回空内容
+	var empty models.WorkDocument
+	s.decode(s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第二章"}), &empty)
+	emptyBase := "/api/work-documents/" + empty.ID
+	result = generation(t, s.do(http.MethodPost, emptyBase+"/generate", token, models.GenerateRequest{Model: "mock"}).Body.String())
+	s.decode(s.do(http.MethodGet, emptyBase, token, nil), &empty)
+	if empty.Content == "" || result.UndoRevision < 1 || result.Revision != result.UndoRevision+1 {
+		t.Fatalf("generate into empty document: %+v %q", result, empty.Content)
+	}
+	if w := s.do(http.MethodPost, fmt.Sprintf("%s/revisions/%d/restore", emptyBase, result.UndoRevision), token, nil); w.Code != http.StatusOK {
+		t.Fatalf("undo empty document: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(s.do(http.MethodGet, emptyBase, token, nil), &empty)
+	if empty.Content != "" {
+		t.Errorf("undo empty document: %q", empty.Content)
+	}
+	if w := s.do(http.MethodPost, base+"/generate", otherToken, models.GenerateRequest{Model: "mock"}); w.Code != http.StatusNotFound {
+		t.Errorf("cross-user generate: %d", w.Code)
+	}
+}
+
+func TestGenerateLocksDocumentWhileStreaming(t *testing.T) {
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:           time.Hour,
+		EnableMockProvider:   true,
+		MockResponses:        []string{"一二三四五六"},
+		MockFirstChunkDelay:  300 * time.Millisecond,
+		MockChunkSize:        2,
+		MockErrorStatus:      http.StatusInternalServerError,
+		MockErrorAfterChunks: 2,
+	})
+	token, _ := s.login("alice")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长河"}), &work)
+	var doc models.WorkDocument
+	s.decode(s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第一章", Content: "开头"}), &doc)
+	base := "/api/work-documents/" + doc.ID
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- s.do(http.MethodPost, base+"/generate", token, models.GenerateRequest{Model: "mock"})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	w := s.do(http.MethodPut, base+"/content", token, models.UpdateWorkDocumentContentRequest{Content: "手动保存"})
+	var conflict models.WorkDocumentConflictResponse
+	s.decode(w, &conflict)
+	if w.Code != http.StatusConflict || conflict.Error != "document_locked" {
+		t.Fatalf("save while streaming: %d %+v", w.Code, conflict)
+	}
+
+	// 生成中途出错：已写入的内容保留并可以撤销，错误以标记返回，锁被释放
+	w = <-done
+	result := generation(t, w.Body.String())
+	if !strings.Contains(w.Body.String(), "<GRANDMA_ERROR>") || result.UndoRevision != 1 {
+		t.Fatalf("partial generation: %q", w.Body.String())
+	}
+	s.decode(s.do(http.MethodGet, base, token, nil), &doc)
+	if doc.Content != "开头一二三四" || doc.LockedUntil != nil {
+		t.Fatalf("after partial generation: %+v", doc)
+	}
+	if w := s.do(http.MethodPut, base+"/content", token, models.UpdateWorkDocumentContentRequest{Content: "手动保存"}); w.Code != http.StatusOK {
+		t.Errorf("save after streaming: %d", w.Code)
+	}
+}
+
+func TestGenerateWhileLockedRecordsNoBaseline(t *testing.T) {
+	first := strings.Repeat("潮", 40)
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:         time.Hour,
+		EnableMockProvider: true,
+		MockResponses:      []string{first + "尾声"},
+		MockChunkSize:      40,
+		MockChunkDelay:     300 * time.Millisecond,
+	})
+	token, _ := s.login("alice")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长河"}), &work)
+	var doc models.WorkDocument
+	s.decode(s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第一章", Content: "开头"}), &doc)
+	base := "/api/work-documents/" + doc.ID
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- s.do(http.MethodPost, base+"/generate", token, models.GenerateRequest{Model: "mock"})
+	}()
+	time.Sleep(150 * time.Millisecond)
+
+	// 第一段已经写入文档；此时的生成请求被锁拒绝，不会把写到一半的内容记录为撤销点
+	var current models.WorkDocument
+	s.decode(s.do(http.MethodGet, base, token, nil), &current)
+	if current.Content != "开头"+first {
+		t.Fatalf("content while streaming: %q", current.Content)
+	}
+	if w := s.do(http.MethodPost, base+"/generate", token, models.GenerateRequest{Model: "mock"}); w.Code != http.StatusConflict {
+		t.Errorf("generate while locked: %d", w.Code)
+	}
+
+	result := generation(t, (<-done).Body.String())
+	var revisions models.RevisionListResponse
+	s.decode(s.do(http.MethodGet, base+"/revisions", token, nil), &revisions)
+	if result.UndoRevision != 1 || result.Revision != 2 || revisions.Total != 2 {
+		t.Errorf("revisions after locked generate: %+v %+v", result, revisions)
+	}
+}
+
+// waitForCheck 轮询设定一致性检查任务直到结束
+func (s *testServer) waitForCheck(token, id string) models.ContinuityCheckResponse {
+	s.t.Helper()
+	var response models.ContinuityCheckResponse
+	for i := 0; i < 200; i++ {
+		s.decode(s.do(http.MethodGet, "/api/continuity-checks/"+id, token, nil), &response)
+		if response.Check.Status != models.ContinuityCheckRunning {
+			return response
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.t.Fatalf("continuity check %s still running", id)
+	return response
+}
+
+func TestContinuityCheckReportsConflicts(t *testing.T) {
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:         time.Hour,
+		EnableMockProvider: true,
+		MockResponses: []string{`{"issues":[` +
+			`{"kind":"appearance","severity":"medium","description":"林舟的眼睛在设定中是蓝色","quote":"林舟眨了眨绿色的眼睛","source":"B1","source_quote":"蓝色眼睛"},` +
+			`{"kind":"deceased","severity":"high","description":"老周在第一章已经死去","quote":"老周笑着递给他一碗粥","source":"D1","source_quote":"老周倒在礁石上"}]}`},
+	})
+	token, userID := s.login("alice")
+	otherToken, _ := s.login("bob")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长河"}), &work)
+	bibleRepo := repository.NewStoryBibleRepository(database.DB)
+	for _, entry := range []models.StoryBibleEntry{
+		{ID: "bible_1", Name: "林舟", Content: "主角，渔村少年，蓝色眼睛"},
+		{ID: "bible_2", Name: "老周", Content: "林舟的师父"},
+	} {
+		entry.UserID, entry.WorkID, entry.Category, entry.Source = userID, work.ID, "character", "user"
+		if err := bibleRepo.Create(&entry); err != nil {
+			t.Fatalf("create bible entry: %v", err)
+		}
+	}
+	var first, second models.WorkDocument
+	s.decode(s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第一章", Content: "风暴过后，老周倒在礁石上，再也没有醒来。"}), &first)
+	s.decode(s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第二章", Content: "清晨，林舟眨了眨绿色的眼睛。老周笑着递给他一碗粥。"}), &second)
+
+	w := s.do(http.MethodPost, "/api/work-documents/"+second.ID+"/continuity-checks", token, models.ContinuityCheckRequest{Model: "mock"})
+	var check models.ContinuityCheck
+	s.decode(w, &check)
+	if w.Code != http.StatusAccepted || check.Status != models.ContinuityCheckRunning || check.DocumentVersion != second.Version {
+		t.Fatalf("start check: %d %+v", w.Code, check)
+	}
+	if w := s.do(http.MethodGet, "/api/continuity-checks/"+check.ID, otherToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("other user's check: %d", w.Code)
+	}
+
+	result := s.waitForCheck(token, check.ID)
+	if result.Check.Status != models.ContinuityCheckCompleted || result.Check.IssueCount != 2 || len(result.Issues) != 2 {
+		t.Fatalf("check result: %+v", result)
+	}
+	appearance, deceased := result.Issues[0], result.Issues[1]
+	if appearance.Kind != models.ContinuityKindAppearance || appearance.SourceType != models.ContinuitySourceStoryBible || appearance.SourceID != "bible_1" || appearance.SourceTitle != "林舟" {
+		t.Errorf("appearance issue: %+v", appearance)
+	}
+	if appearance.QuoteStart == nil || *appearance.QuoteStart != 3 || *appearance.QuoteEnd != 13 || appearance.SourceStart == nil || *appearance.SourceStart != 8 {
+		t.Errorf("appearance location: %+v", appearance)
+	}
+	if deceased.Kind != models.ContinuityKindDeceased || deceased.SourceType != models.ContinuitySourceWorkDocument || deceased.SourceID != first.ID || deceased.SourceTitle != "第一章" {
+		t.Errorf("deceased issue: %+v", deceased)
+	}
+	if deceased.SourceStart == nil || *deceased.SourceStart != 5 || deceased.Status != models.ContinuityIssueOpen {
+		t.Errorf("deceased location: %+v", deceased)
+	}
+
+	// 标记为已解决后不再出现在未解决列表中，可以重新打开
+	var resolved models.ContinuityIssue
+	s.decode(s.do(http.MethodPost, "/api/continuity-issues/"+deceased.ID+"/resolve", token, nil), &resolved)
+	if resolved.Status != models.ContinuityIssueResolved || resolved.ResolvedAt == nil {
+		t.Fatalf("resolve: %+v", resolved)
+	}
+	if w := s.do(http.MethodPost, "/api/continuity-issues/"+deceased.ID+"/resolve", otherToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("other user resolving issue: %d", w.Code)
+	}
+	var open models.ContinuityIssueListResponse
+	s.decode(s.do(http.MethodGet, "/api/works/"+work.ID+"/continuity-issues?status=open", token, nil), &open)
+	if open.Total != 1 || open.Issues[0].ID != appearance.ID {
+		t.Errorf("open issues: %+v", open)
+	}
+	if w := s.do(http.MethodGet, "/api/works/"+work.ID+"/continuity-issues?status=closed", token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid status filter: %d", w.Code)
+	}
+	var reopened models.ContinuityIssue
+	s.decode(s.do(http.MethodPost, "/api/continuity-issues/"+deceased.ID+"/reopen", token, nil), &reopened)
+	if reopened.Status != models.ContinuityIssueOpen || reopened.ResolvedAt != nil {
+		t.Errorf("reopen: %+v", reopened)
+	}
+
+	// 空章节不能检查；删除章节时一并删除检查记录
+	var empty models.WorkDocument
+	s.decode(s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第三章"}), &empty)
+	if w := s.do(http.MethodPost, "/api/work-documents/"+empty.ID+"/continuity-checks", token, models.ContinuityCheckRequest{Model: "mock"}); w.Code != http.StatusBadRequest {
+		t.Errorf("empty chapter: %d", w.Code)
+	}
+	if w := s.do(http.MethodDelete, "/api/work-documents/"+second.ID, token, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete document: %d %s", w.Code, w.Body.String())
+	}
+	var count int64
+	database.DB.Model(&models.ContinuityIssue{}).Count(&count)
+	if count != 0 {
+		t.Errorf("continuity issues left after deleting document: %d", count)
+	}
+}
+
+// waitForProposals 轮询创作的设定建议直到数量达到want
+func (s *testServer) waitForProposals(token, workID string, want int) models.BibleProposalListResponse {
+	s.t.Helper()
+	var response models.BibleProposalListResponse
+	for i := 0; i < 200; i++ {
+		s.decode(s.do(http.MethodGet, "/api/works/"+workID+"/bible/proposals", token, nil), &response)
+		if response.Total >= want {
+			return response
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.t.Fatalf("expected %d proposals, got %+v", want, response)
+	return response
+}
+
+func TestEntityExtractionProposesBibleEntries(t *testing.T) {
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:             time.Hour,
+		EnableMockProvider:     true,
+		EnableEntityExtraction: true,
+		ExtractionModel:        "mock",
+		MockResponses: []string{
+			`{"entities":[` +
+				`{"category":"character","name":"小舟","aliases":["阿舟"],"description":"会划船"},` +
+				`{"category":"character","name":"林舟","aliases":[],"description":"主角，渔村少年"},` +
+				`{"category":"character","name":"老周","aliases":[],"description":"林舟的师父"},` +
+				`{"category":"location","name":"灯塔岛","aliases":[],"description":"海上的小岛"}]}`,
+			"老周说灯塔岛上住着守塔人阿青。",
+			`{"entities":[` +
+				`{"category":"character","name":"周伯","aliases":["老周"],"description":"林舟的师父"},` +
+				`{"category":"character","name":"阿青","aliases":[],"description":"灯塔岛的守塔人"}]}`,
+		},
+	})
+	token, userID := s.login("alice")
+	otherToken, _ := s.login("bob")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长河"}), &work)
+	existing := models.StoryBibleEntry{ID: "bible_1", UserID: userID, WorkID: work.ID, Category: "character", Name: "林舟", Aliases: []string{"小舟"}, Content: "主角，渔村少年", Source: "user"}
+	if err := repository.NewStoryBibleRepository(database.DB).Create(&existing); err != nil {
+		t.Fatalf("create bible entry: %v", err)
+	}
+
+	// 保存正文后在后台提取：按别名匹配到已有条目的只建议补充新内容，没有新内容的不生成建议
+	var doc models.WorkDocument
+	s.decode(s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第一章", Content: "小舟和老周划船去了灯塔岛。"}), &doc)
+	proposals := s.waitForProposals(token, work.ID, 3)
+	if proposals.Total != 3 {
+		t.Fatalf("proposals: %+v", proposals)
+	}
+	update, master, island := proposals.Proposals[0], proposals.Proposals[1], proposals.Proposals[2]
+	if update.Action != models.ProposalActionUpdate || update.EntryID != existing.ID || update.Name != "林舟" || len(update.Aliases) != 1 || update.Aliases[0] != "阿舟" || update.Content != "会划船" || update.Similarity != 1 {
+		t.Errorf("update proposal: %+v", update)
+	}
+	if master.Action != models.ProposalActionAdd || master.Name != "老周" || master.SourceType != models.ProposalSourceWorkDocument || master.SourceID != doc.ID || master.Status != models.ProposalStatusPending {
+		t.Errorf("add proposal: %+v", master)
+	}
+	if island.Category != "location" || island.Name != "灯塔岛" {
+		t.Errorf("location proposal: %+v", island)
+	}
+
+	// 助手回答保存后同样提取，与待处理的建议重复的不再生成
+	chat := models.ChatRequest{Model: "mock", WorkID: work.ID, Messages: []models.Message{{Role: "user", Content: "灯塔岛上有谁？"}}}
+	if w := s.do(http.MethodPost, "/api/chat", token, chat); w.Code != http.StatusOK {
+		t.Fatalf("chat: %d %s", w.Code, w.Body.String())
+	}
+	proposals = s.waitForProposals(token, work.ID, 4)
+	if proposals.Total != 4 || proposals.Proposals[3].Name != "阿青" || proposals.Proposals[3].SourceType != models.ProposalSourceWorkMessage {
+		t.Fatalf("proposals after chat: %+v", proposals)
+	}
+
+	// 接受补充建议合并别名和内容，接受新建建议创建条目，已处理的建议返回409
+	if w := s.do(http.MethodPost, "/api/bible-proposals/"+update.ID+"/accept", otherToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("other user accepting proposal: %d", w.Code)
+	}
+	var merged models.StoryBibleEntry
+	s.decode(s.do(http.MethodPost, "/api/bible-proposals/"+update.ID+"/accept", token, nil), &merged)
+	if merged.ID != existing.ID || strings.Join(merged.Aliases, ",") != "小舟,阿舟" || merged.Content != "主角，渔村少年\n会划船" {
+		t.Errorf("merged entry: %+v", merged)
+	}
+	var created models.StoryBibleEntry
+	s.decode(s.do(http.MethodPost, "/api/bible-proposals/"+master.ID+"/accept", token, nil), &created)
+	if created.Name != "老周" || created.Source != "extraction" || created.Content != "林舟的师父" {
+		t.Errorf("created entry: %+v", created)
+	}
+	if w := s.do(http.MethodPost, "/api/bible-proposals/"+master.ID+"/accept", token, nil); w.Code != http.StatusConflict {
+		t.Errorf("accepting twice: %d", w.Code)
+	}
+	if w := s.do(http.MethodPost, "/api/bible-proposals/"+island.ID+"/reject", token, nil); w.Code != http.StatusOK {
+		t.Errorf("reject: %d", w.Code)
+	}
+	if w := s.do(http.MethodPost, "/api/bible-proposals/"+island.ID+"/reject", token, nil); w.Code != http.StatusConflict {
+		t.Errorf("rejecting twice: %d", w.Code)
+	}
+
+	var pending models.BibleProposalListResponse
+	s.decode(s.do(http.MethodGet, "/api/works/"+work.ID+"/bible/proposals?status=pending", token, nil), &pending)
+	if pending.Total != 1 || pending.Proposals[0].Name != "阿青" {
+		t.Errorf("pending proposals: %+v", pending)
+	}
+	var bible models.StoryBibleResponse
+	s.decode(s.do(http.MethodGet, "/api/works/"+work.ID+"/bible", token, nil), &bible)
+	if bible.Total != 2 {
+		t.Errorf("story bible: %+v", bible)
+	}
+}
+
+func TestConcurrentExtractionsDoNotDuplicateProposals(t *testing.T) {
+	entities := `{"entities":[{"category":"character","name":"老周","aliases":[],"description":"林舟的师父"}]}`
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:             time.Hour,
+		EnableMockProvider:     true,
+		MockFirstChunkDelay:    50 * time.Millisecond,
+		EnableEntityExtraction: true,
+		ExtractionModel:        "mock",
+		MockResponses:          []string{entities, entities},
+	})
+	token, _ := s.login("alice")
+
+	// 同一作品的两章同时提取，模型调用并发进行，保存建议时仍按作品去重
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长河"}), &work)
+	for _, title := range []string{"第一章", "第二章"} {
+		s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: title, Content: "老周划船去了灯塔岛。"})
+	}
+	for i := 0; ; i++ {
+		var calls int64
+		database.DB.Model(&models.UsageEvent{}).Where("feature = ?", usage.FeatureExtraction).Count(&calls)
+		if calls == 2 {
+			break
+		}
+		if i == 200 {
+			t.Fatalf("expected 2 extraction calls, got %d", calls)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	var proposals models.BibleProposalListResponse
+	s.decode(s.do(http.MethodGet, "/api/works/"+work.ID+"/bible/proposals", token, nil), &proposals)
+	if proposals.Total != 1 || proposals.Proposals[0].Name != "老周" {
+		t.Errorf("proposals: %+v", proposals)
+	}
+}
+
+func TestTimelineWithCustomCalendar(t *testing.T) {
+	s := newTestServer(t)
+	token, userID := s.login("alice")
+	otherToken, _ := s.login("bob")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长河"}), &work)
+	timelinePath := "/api/works/" + work.ID + "/timeline"
+	entry := models.StoryBibleEntry{ID: "bible_1", UserID: userID, WorkID: work.ID, Category: "character", Name: "林舟", Aliases: []string{"小舟"}, Content: "主角", Source: "user"}
+	if err := repository.NewStoryBibleRepository(database.DB).Create(&entry); err != nil {
+		t.Fatalf("create bible entry: %v", err)
+	}
+
+	// 阅读顺序按大纲排列：第一章、第二章、第三章（第三章先于第二章创建）
+	createDoc := func(title, content string) models.WorkDocument {
+		t.Helper()
+		var doc models.WorkDocument
+		s.decode(s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: title, Content: content}), &doc)
+		return doc
+	}
+	ch1 := createDoc("第一章", "小舟在渡口遇见了老周。")
+	ch3 := createDoc("第三章", "老周走了。")
+	ch2 := createDoc("第二章", "灯塔熄灭了。")
+	for _, doc := range []models.WorkDocument{ch1, ch2, ch3} {
+		s.do(http.MethodPost, "/api/works/"+work.ID+"/outline", token, models.OutlineNodeRequest{Kind: "chapter", Title: doc.Title, DocumentID: doc.ID})
+	}
+
+	var calendar models.TimelineCalendar
+	s.decode(s.do(http.MethodGet, timelinePath+"/calendar", token, nil), &calendar)
+	if len(calendar.Months) != 12 || calendar.Months[1].Days != 28 {
+		t.Fatalf("default calendar: %+v", calendar)
+	}
+	twoMonths := models.TimelineCalendarRequest{Era: "天启", Months: []models.CalendarMonth{{Name: "霜月", Days: 30}, {Name: "雪月", Days: 30}}}
+	if w := s.do(http.MethodPut, timelinePath+"/calendar", token, twoMonths); w.Code != http.StatusOK {
+		t.Fatalf("set calendar: %d %s", w.Code, w.Body.String())
+	}
+
+	intp := func(v int) *int { return &v }
+	create := func(req models.TimelineEventRequest) models.TimelineEvent {
+		t.Helper()
+		w := s.do(http.MethodPost, timelinePath, token, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("create %q: %d %s", req.Title, w.Code, w.Body.String())
+		}
+		var event models.TimelineEvent
+		s.decode(w, &event)
+		return event
+	}
+	undated := 200.0
+	born := create(models.TimelineEventRequest{Title: "出生", Year: intp(1), Month: intp(1), Day: intp(1), Participants: []string{"林舟"}})
+	meeting := create(models.TimelineEventRequest{Title: "渡口相遇", Year: intp(3), Month: intp(2), Day: intp(10), Participants: []string{"小舟", "老周", "小舟"}, DocumentID: ch1.ID, SourceQuote: "遇见了老周"})
+	death := create(models.TimelineEventRequest{Title: "老周离世", Year: intp(5), Month: intp(1), Participants: []string{"老周"}, DocumentID: ch3.ID})
+	darkness := create(models.TimelineEventRequest{Title: "灯塔熄灭", Ordinal: &undated, Participants: []string{"林舟"}, DocumentID: ch2.ID})
+	create(models.TimelineEventRequest{Title: "远航", Year: intp(4), Participants: []string{"林舟"}})
+	if born.Ordinal != 0 || born.Date != "天启1年霜月1日" || meeting.Ordinal != 159 || meeting.Date != "天启3年雪月10日" || death.Date != "天启5年霜月" || darkness.Date != "" {
+		t.Errorf("dates: %+v %+v %+v %+v", born, meeting, death, darkness)
+	}
+	if len(meeting.Participants) != 2 || meeting.SourceStart == nil || *meeting.SourceStart != 5 || *meeting.SourceEnd != 10 {
+		t.Errorf("meeting source: %+v", meeting)
+	}
+
+	// 日期必须在历法中存在，没有年份的事件必须给出位置
+	for _, req := range []models.TimelineEventRequest{
+		{Title: "第三个月", Year: intp(1), Month: intp(3)},
+		{Title: "第三十一天", Year: intp(1), Month: intp(1), Day: intp(31)},
+		{Title: "只有月份", Month: intp(1), Ordinal: &undated},
+		{Title: "没有时间"},
+	} {
+		if w := s.do(http.MethodPost, timelinePath, token, req); w.Code != http.StatusBadRequest {
+			t.Errorf("create %q: expected 400, got %d", req.Title, w.Code)
+		}
+	}
+	if w := s.do(http.MethodPost, timelinePath, otherToken, models.TimelineEventRequest{Title: "越权", Ordinal: &undated}); w.Code != http.StatusNotFound {
+		t.Errorf("other user creating event: %d", w.Code)
+	}
+
+	titles := func(events []models.TimelineEvent) string {
+		out := make([]string, 0, len(events))
+		for _, event := range events {
+			out = append(out, event.Title)
+		}
+		return strings.Join(out, ",")
+	}
+	var timeline models.TimelineResponse
+	s.decode(s.do(http.MethodGet, timelinePath, token, nil), &timeline)
+	if timeline.Total != 5 || titles(timeline.Events) != "出生,渡口相遇,远航,灯塔熄灭,老周离世" || timeline.Events[3].Chapter != 2 || timeline.Events[4].Chapter != 3 {
+		t.Fatalf("timeline: %+v", timeline)
+	}
+
+	// 到第二章为止林舟（按别名也匹配小舟）经历过的事件
+	s.decode(s.do(http.MethodGet, timelinePath+"?until_chapter=2&participant="+url.QueryEscape("林舟"), token, nil), &timeline)
+	if titles(timeline.Events) != "出生,渡口相遇,远航,灯塔熄灭" || timeline.Until == nil || *timeline.Until != 200 {
+		t.Errorf("until chapter 2: %+v", timeline)
+	}
+	s.decode(s.do(http.MethodGet, timelinePath+"?until_chapter=1&participant="+url.QueryEscape("老周"), token, nil), &timeline)
+	if titles(timeline.Events) != "渡口相遇" {
+		t.Errorf("until chapter 1: %+v", timeline)
+	}
+	if w := s.do(http.MethodGet, timelinePath+"?until_chapter=0", token, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid until_chapter: %d", w.Code)
+	}
+
+	// 灵感模式指定当前章节时注入故事时间早于该章的事件
+	preview := func(documentID string) string {
+		t.Helper()
+		chat := models.ChatRequest{Model: "mock", WorkID: work.ID, DocumentID: documentID, Messages: []models.Message{{Role: "user", Content: "继续写"}}}
+		var response models.PromptPreviewResponse
+		s.decode(s.do(http.MethodPost, "/api/chat/preview", token, chat), &response)
+		if len(response.Messages) != 3 {
+			t.Fatalf("preview for %s: %+v", documentID, response)
+		}
+		return response.Messages[1].Content
+	}
+	if content := preview(ch3.ID); !strings.Contains(content, "当前章节（第三章）") || !strings.Contains(content, "【天启3年雪月10日】渡口相遇") || !strings.Contains(content, "参与人物：小舟、老周") || !strings.Contains(content, "灯塔熄灭") || strings.Contains(content, "老周离世") {
+		t.Errorf("chapter 3 timeline: %s", content)
+	}
+	if content := preview(ch2.ID); !strings.Contains(content, "远航") || strings.Contains(content, "灯塔熄灭") {
+		t.Errorf("chapter 2 timeline: %s", content)
+	}
+	chat := models.ChatRequest{Model: "mock", WorkID: work.ID, DocumentID: "missing", Messages: []models.Message{{Role: "user", Content: "继续写"}}}
+	if w := s.do(http.MethodPost, "/api/chat/preview", token, chat); w.Code != http.StatusNotFound {
+		t.Errorf("preview with unknown document: %d", w.Code)
+	}
+
+	// 修改历法后重新计算日期的位置，无法表示已有日期的历法返回409
+	if w := s.do(http.MethodPut, timelinePath+"/calendar", token, models.TimelineCalendarRequest{Months: []models.CalendarMonth{{Name: "年", Days: 365}}}); w.Code != http.StatusConflict {
+		t.Errorf("conflicting calendar: %d %s", w.Code, w.Body.String())
+	}
+	longMonths := models.TimelineCalendarRequest{Era: "天启", Months: []models.CalendarMonth{{Name: "霜月", Days: 40}, {Name: "雪月", Days: 40}}}
+	if w := s.do(http.MethodPut, timelinePath+"/calendar", token, longMonths); w.Code != http.StatusOK {
+		t.Fatalf("update calendar: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(s.do(http.MethodGet, timelinePath, token, nil), &timeline)
+	if titles(timeline.Events) != "出生,灯塔熄灭,渡口相遇,远航,老周离世" || timeline.Events[2].Ordinal != 209 {
+		t.Errorf("timeline after calendar change: %+v", timeline)
+	}
+
+	// 更新和删除事件
+	w := s.do(http.MethodPut, "/api/timeline-events/"+darkness.ID, token, models.TimelineEventRequest{Title: "灯塔重燃", Year: intp(6), Location: "灯塔岛", DocumentID: ch2.ID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("update event: %d %s", w.Code, w.Body.String())
+	}
+	var updated models.TimelineEvent
+	s.decode(w, &updated)
+	if updated.Ordinal != 400 || updated.Date != "天启6年" || updated.Location != "灯塔岛" || len(updated.Participants) != 0 {
+		t.Errorf("updated event: %+v", updated)
+	}
+	if w := s.do(http.MethodDelete, "/api/timeline-events/"+born.ID, otherToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("other user deleting event: %d", w.Code)
+	}
+	if w := s.do(http.MethodDelete, "/api/timeline-events/"+born.ID, token, nil); w.Code != http.StatusOK {
+		t.Errorf("delete event: %d", w.Code)
+	}
+
+	// 删除章节只取消事件的出处，删除创作时删除时间线
+	if w := s.do(http.MethodDelete, "/api/work-documents/"+ch1.ID, token, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete document: %d %s", w.Code, w.Body.String())
+	}
+	s.decode(s.do(http.MethodGet, timelinePath, token, nil), &timeline)
+	if timeline.Total != 4 || timeline.Events[0].Title != "渡口相遇" || timeline.Events[0].DocumentID != "" || timeline.Events[0].SourceStart != nil {
+		t.Errorf("timeline after deleting document: %+v", timeline)
+	}
+	s.do(http.MethodDelete, "/api/works/"+work.ID, token, nil)
+	var count int64
+	database.DB.Model(&models.TimelineEvent{}).Where("work_id = ?", work.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("timeline events left after deleting work: %d", count)
+	}
+	database.DB.Model(&models.TimelineCalendar{}).Where("work_id = ?", work.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("calendars left after deleting work: %d", count)
+	}
+}
+
+// waitForChunks 轮询文档的向量chunks直到满足条件
+func waitForChunks(t *testing.T, documentID string, done func([]models.VectorChunk) bool) []models.VectorChunk {
+	t.Helper()
+	repo := repository.NewVectorChunkRepository(database.DB)
+	var chunks []models.VectorChunk
+	for i := 0; i < 200; i++ {
+		var err error
+		if chunks, err = repo.GetByDocumentID(documentID); err != nil {
+			t.Fatalf("load chunks: %v", err)
+		}
+		if done(chunks) {
+			return chunks
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("chunks of %s: %+v", documentID, chunks)
+	return chunks
+}
+
+func TestManuscriptDocumentsAreIndexed(t *testing.T) {
+	// 模拟Embedding接口，每段文本返回相同的向量
+	embeddings := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req services.EmbeddingRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		var resp services.EmbeddingResponse
+		for i := range req.Input {
+			resp.Data = append(resp.Data, struct {
+				Embedding []float32 `json:"embedding"`
+				Index     int       `json:"index"`
+			}{Embedding: []float32{1, 0}, Index: i})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer embeddings.Close()
+
+	s := newTestServerWithConfig(t, &config.Config{
+		SessionTTL:       time.Hour,
+		EnableRAG:        true,
+		EmbeddingAPIKey:  "sk-embedding",
+		EmbeddingBaseURL: embeddings.URL,
+	})
+	token, _ := s.login("alice")
+
+	var work models.Work
+	s.decode(s.do(http.MethodPost, "/api/works", token, models.WorkRequest{Title: "长河"}), &work)
+	var doc models.WorkDocument
+	first := strings.Repeat("潮水退去，礁石露出黑色的脊背。", 3)
+	s.decode(s.do(http.MethodPost, "/api/works/"+work.ID+"/documents", token, models.WorkDocumentRequest{WorkID: work.ID, Title: "第一章", Content: first}), &doc)
+
+	// 创建后索引正文
+	chunks := waitForChunks(t, doc.ID, func(chunks []models.VectorChunk) bool { return len(chunks) > 0 })
+	if chunks[0].WorkID != work.ID || !strings.Contains(chunks[0].Content, "礁石") {
+		t.Errorf("indexed chunk: %+v", chunks[0])
+	}
+
+	// 修改后按新内容重新索引
+	second := strings.Repeat("林舟推开木门，海风灌进屋里。", 3)
+	s.do(http.MethodPut, "/api/work-documents/"+doc.ID+"/content", token, models.UpdateWorkDocumentContentRequest{Content: second})
+	waitForChunks(t, doc.ID, func(chunks []models.VectorChunk) bool {
+		for _, chunk := range chunks {
+			if strings.Contains(chunk.Content, "礁石") {
+				return false
+			}
+		}
+		return len(chunks) > 0 && strings.Contains(chunks[0].Content, "木门")
+	})
+
+	// 删除文档时删除其chunks
+	s.do(http.MethodDelete, "/api/work-documents/"+doc.ID, token, nil)
+	waitForChunks(t, doc.ID, func(chunks []models.VectorChunk) bool { return len(chunks) == 0 })
+}
@@ -2,17 +2,63 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"grandma/backend/models"
 	"io"
 	"net/http"
+	"strings"
 )
 
+const anthropicModel = "claude-3-5-sonnet-20241022"
+
 // AnthropicProvider Anthropic服务提供者
 type AnthropicProvider struct {
-	APIKey  string
-	BaseURL string
+	APIKey    string
+	BaseURL   string
+	lastUsage Usage
+	client    *http.Client // 访问服务商的HTTP客户端
+}
+
+// anthropicUsage Anthropic接口返回的用量
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// totalInputTokens 输入用量，包含写入和命中提示缓存的部分
+func (u anthropicUsage) totalInputTokens() int {
+	return u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+}
+
+// anthropicBlock 内容块：text、image、tool_use或tool_result
+type anthropicBlock struct {
+	Type         string                `json:"type"`
+	Text         string                `json:"text,omitempty"`
+	Source       *anthropicImageSource `json:"source,omitempty"`
+	CacheControl map[string]string     `json:"cache_control,omitempty"`
+	ID           string                `json:"id,omitempty"`
+	Name         string                `json:"name,omitempty"`
+	Input        json.RawMessage       `json:"input,omitempty"`
+	ToolUseID    string                `json:"tool_use_id,omitempty"`
+	Content      string                `json:"content,omitempty"`
+}
+
+// anthropicImageSource 图片来源：base64内容或URL
+type anthropicImageSource struct {
+	Type      string `json:"type"` // base64 或 url
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// anthropicMessage Messages API的消息，只有文本时content为字符串，否则为内容块数组
+type anthropicMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
 }
 
 // NewAnthropicProvider 创建Anthropic服务提供者
@@ -20,141 +66,339 @@ func NewAnthropicProvider(apiKey, baseURL string) *AnthropicProvider {
 	return &AnthropicProvider{
 		APIKey:  apiKey,
 		BaseURL: baseURL,
+		client:  providerHTTPClient,
 	}
 }
 
-func (p *AnthropicProvider) ChatStream(messages []models.Message, writer io.Writer) error {
-	url := fmt.Sprintf("%s/v1/messages", p.BaseURL)
+// buildAnthropicPayload 把通用消息转换为Messages API请求
+// system消息提升到顶层system字段，首个system块（固定的系统提示）标记为提示缓存断点；
+// 用户消息中的图片转换为image块，助手的工具调用转换为tool_use块，tool角色的结果转换为用户消息中的tool_result块
+func buildAnthropicPayload(messages []models.Message, tools []ToolDefinition, stream bool) map[string]interface{} {
+	var system []anthropicBlock
+	var roles []string
+	var contents [][]anthropicBlock
 
-	// 将消息数组转换为API格式
-	apiMessages := make([]map[string]string, len(messages))
-	for i, msg := range messages {
-		apiMessages[i] = map[string]string{
-			"role":    msg.Role,
-			"content": msg.Content,
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			if strings.TrimSpace(msg.Content) != "" {
+				system = append(system, anthropicBlock{Type: "text", Text: msg.Content})
+			}
+			continue
+		}
+
+		role := "user"
+		var blocks []anthropicBlock
+		switch {
+		case msg.Role == "tool":
+			blocks = append(blocks, anthropicBlock{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content})
+		case msg.Role == "assistant":
+			role = "assistant"
+			if strings.TrimSpace(msg.Content) != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				input := json.RawMessage(call.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: input})
+			}
+		case hasImages(msg):
+			blocks = append(blocks, anthropicImageBlocks(msg)...)
+		case strings.TrimSpace(msg.Content) != "":
+			blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+		}
+		if len(blocks) == 0 {
+			continue
 		}
+
+		// 相邻的同角色消息合并为一条
+		if n := len(roles); n > 0 && roles[n-1] == role {
+			contents[n-1] = append(contents[n-1], blocks...)
+			continue
+		}
+		roles = append(roles, role)
+		contents = append(contents, blocks)
+	}
+
+	// Messages API要求首条为用户消息
+	if len(roles) == 0 || roles[0] != "user" {
+		roles = append([]string{"user"}, roles...)
+		contents = append([][]anthropicBlock{{{Type: "text", Text: leadingUserTurn}}}, contents...)
+	}
+
+	turns := make([]anthropicMessage, len(roles))
+	for i, blocks := range contents {
+		turns[i] = anthropicMessage{Role: roles[i], Content: anthropicContent(blocks)}
 	}
 
 	payload := map[string]interface{}{
-		"model":      "claude-3-5-sonnet-20241022",
+		"model":      anthropicModel,
 		"max_tokens": 4096,
-		"messages":   apiMessages,
-		"stream":     true,
+		"messages":   turns,
+		"stream":     stream,
 	}
+	if len(system) > 0 {
+		// 之后的RAG上下文每次都不同，只缓存到第一个system块为止的前缀
+		system[0].CacheControl = map[string]string{"type": "ephemeral"}
+		payload["system"] = system
+	}
+	if len(tools) > 0 {
+		apiTools := make([]map[string]interface{}, len(tools))
+		for i, tool := range tools {
+			apiTools[i] = map[string]interface{}{
+				"name":         tool.Name,
+				"description":  tool.Description,
+				"input_schema": tool.Parameters,
+			}
+		}
+		payload["tools"] = apiTools
+	}
+	return payload
+}
+
+// anthropicImageBlocks 将包含图片的消息转换为text和image内容块
+func anthropicImageBlocks(msg models.Message) []anthropicBlock {
+	var blocks []anthropicBlock
+	for _, part := range contentParts(msg) {
+		switch {
+		case part.Type == models.PartText && strings.TrimSpace(part.Text) != "":
+			blocks = append(blocks, anthropicBlock{Type: "text", Text: part.Text})
+		case part.Type == models.PartImage && part.Data != "":
+			blocks = append(blocks, anthropicBlock{Type: "image", Source: &anthropicImageSource{Type: "base64", MediaType: part.MimeType, Data: part.Data}})
+		case part.Type == models.PartImage && part.URL != "":
+			blocks = append(blocks, anthropicBlock{Type: "image", Source: &anthropicImageSource{Type: "url", URL: part.URL}})
+		}
+	}
+	return blocks
+}
+
+// anthropicContent 只有文本块时合并为字符串，否则保留内容块数组
+func anthropicContent(blocks []anthropicBlock) interface{} {
+	texts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		if block.Type != "text" {
+			return blocks
+		}
+		texts = append(texts, block.Text)
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// post 发送Messages API请求，非200响应返回APIError
+func (p *AnthropicProvider) post(ctx context.Context, payload map[string]interface{}) (*http.Response, error) {
+	url := fmt.Sprintf("%s/v1/messages", p.BaseURL)
 
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("x-api-key", p.APIKey)
 	req.Header.Set("anthropic-version", "2023-06-01")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("anthropic api error: %s", string(body))
+		return nil, &APIError{Provider: "anthropic", StatusCode: resp.StatusCode, Body: string(body)}
 	}
+	return resp, nil
+}
+
+func (p *AnthropicProvider) ChatStream(ctx context.Context, messages []models.Message, writer io.Writer) error {
+	_, err := p.stream(ctx, messages, nil, writer)
+	return err
+}
+
+// ChatStreamWithTools 携带工具声明的流式聊天，返回模型要求的工具调用
+func (p *AnthropicProvider) ChatStreamWithTools(ctx context.Context, messages []models.Message, tools []ToolDefinition, writer io.Writer) ([]models.ToolCall, error) {
+	return p.stream(ctx, messages, tools, writer)
+}
 
-	decoder := json.NewDecoder(resp.Body)
-	for {
+// stream 发送流式请求并解析SSE事件
+func (p *AnthropicProvider) stream(ctx context.Context, messages []models.Message, tools []ToolDefinition, writer io.Writer) ([]models.ToolCall, error) {
+	p.lastUsage = Usage{Model: anthropicModel}
+
+	resp, err := p.post(ctx, buildAnthropicPayload(messages, tools, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// tool_use块的参数通过input_json_delta分多次返回，按块的index拼接
+	var calls []models.ToolCall
+	toolBlocks := map[int]int{}
+
+	err = readSSE(resp.Body, func(sse *SSEEvent) error {
 		var event struct {
-			Type  string `json:"type"`
+			Type         string `json:"type"`
+			Index        int    `json:"index"`
+			ContentBlock struct {
+				Type string `json:"type"`
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"content_block"`
 			Delta struct {
-				Text string `json:"text"`
-			} `json:"delta,omitempty"`
+				Type        string `json:"type"`
+				Text        string `json:"text"`
+				Thinking    string `json:"thinking"`
+				PartialJSON string `json:"partial_json"`
+				StopReason  string `json:"stop_reason"`
+			} `json:"delta"`
+			Message struct {
+				Usage anthropicUsage `json:"usage"`
+			} `json:"message"`
+			Usage *anthropicUsage `json:"usage"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
 		}
-
-		if err := decoder.Decode(&event); err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
+		if err := json.Unmarshal([]byte(sse.Data), &event); err != nil {
+			return nil
 		}
-
-		if event.Type == "content_block_delta" && event.Delta.Text != "" {
-			_, _ = writer.Write([]byte(event.Delta.Text))
+		if event.Type == "" {
+			event.Type = sse.Event
 		}
 
-		if event.Type == "message_stop" {
-			break
+		switch event.Type {
+		case "message_start":
+			// message_start携带输入用量（含提示缓存）
+			p.lastUsage.InputTokens = event.Message.Usage.totalInputTokens()
+			p.lastUsage.OutputTokens = event.Message.Usage.OutputTokens
+		case "message_delta":
+			// message_delta携带累计输出用量和结束原因
+			if event.Usage != nil {
+				p.lastUsage.OutputTokens = event.Usage.OutputTokens
+				if input := event.Usage.totalInputTokens(); input > 0 {
+					p.lastUsage.InputTokens = input
+				}
+			}
+			if event.Delta.StopReason != "" {
+				p.lastUsage.FinishReason = event.Delta.StopReason
+			}
+		case "content_block_start":
+			if event.ContentBlock.Type == "tool_use" {
+				toolBlocks[event.Index] = len(calls)
+				calls = append(calls, models.ToolCall{ID: event.ContentBlock.ID, Name: event.ContentBlock.Name})
+			}
+		case "content_block_delta":
+			// 扩展思考的thinking_delta写入推理通道，忽略签名等其他类型的增量
+			switch event.Delta.Type {
+			case "thinking_delta":
+				return WriteReasoning(writer, event.Delta.Thinking)
+			case "input_json_delta":
+				if i, ok := toolBlocks[event.Index]; ok {
+					calls[i].Arguments += event.Delta.PartialJSON
+				}
+			case "", "text_delta":
+				if event.Delta.Text != "" {
+					if _, err := writer.Write([]byte(event.Delta.Text)); err != nil {
+						return err
+					}
+				}
+			}
+		case "error":
+			return &StreamError{Provider: "anthropic", Type: event.Error.Type, Message: event.Error.Message}
+		case "message_stop":
+			return errSSEStop
+		}
+		return nil
+	})
+	for i := range calls {
+		if calls[i].Arguments == "" {
+			calls[i].Arguments = "{}"
 		}
 	}
+	return calls, err
+}
 
-	return nil
+// LastUsage 最近一次调用的用量
+func (p *AnthropicProvider) LastUsage() Usage {
+	return p.lastUsage
+}
+
+// anthropicResponse 非流式Messages API的响应
+type anthropicResponse struct {
+	Content []struct {
+		Type  string          `json:"type"`
+		Text  string          `json:"text"`
+		Name  string          `json:"name"`
+		Input json.RawMessage `json:"input"`
+	} `json:"content"`
+	StopReason string         `json:"stop_reason"`
+	Usage      anthropicUsage `json:"usage"`
 }
 
 // Chat 非流式聊天，用于生成标题等场景
-func (p *AnthropicProvider) Chat(messages []models.Message) (string, error) {
-	url := fmt.Sprintf("%s/v1/messages", p.BaseURL)
+func (p *AnthropicProvider) Chat(ctx context.Context, messages []models.Message) (string, error) {
+	result, err := p.complete(ctx, buildAnthropicPayload(messages, nil, false))
+	if err != nil {
+		return "", err
+	}
 
-	// 将消息数组转换为API格式
-	apiMessages := make([]map[string]string, len(messages))
-	for i, msg := range messages {
-		apiMessages[i] = map[string]string{
-			"role":    msg.Role,
-			"content": msg.Content,
+	var text strings.Builder
+	for _, block := range result.Content {
+		if block.Type == "" || block.Type == "text" {
+			text.WriteString(block.Text)
 		}
 	}
-
-	payload := map[string]interface{}{
-		"model":      "claude-3-5-sonnet-20241022",
-		"max_tokens": 4096,
-		"messages":   apiMessages,
-		"stream":     false,
+	if text.Len() > 0 {
+		return text.String(), nil
 	}
 
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return "", err
-	}
+	return "", fmt.Errorf("no response from Anthropic")
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+// ChatJSONMode Messages API没有JSON模式，通过强制调用一个以Schema为参数的工具获得结构化输出
+func (p *AnthropicProvider) ChatJSONMode(ctx context.Context, messages []models.Message, schema JSONSchema) (string, error) {
+	payload := buildAnthropicPayload(messages, []ToolDefinition{{
+		Name:        schema.Name,
+		Description: schema.Description,
+		Parameters:  schema.Schema,
+	}}, false)
+	payload["tool_choice"] = map[string]string{"type": "tool", "name": schema.Name}
+
+	result, err := p.complete(ctx, payload)
 	if err != nil {
 		return "", err
 	}
+	for _, block := range result.Content {
+		if block.Type == "tool_use" && block.Name == schema.Name {
+			return string(block.Input), nil
+		}
+	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", p.APIKey)
-	req.Header.Set("anthropic-version", "2023-06-01")
+	return "", fmt.Errorf("no structured output from Anthropic")
+}
+
+// complete 发送非流式请求并记录用量
+func (p *AnthropicProvider) complete(ctx context.Context, payload map[string]interface{}) (*anthropicResponse, error) {
+	p.lastUsage = Usage{Model: anthropicModel}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := p.post(ctx, payload)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("anthropic api error: %s", string(body))
-	}
-
-	var result struct {
-		Content []struct {
-			Text string `json:"text"`
-		} `json:"content"`
-	}
-
+	var result anthropicResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
-	}
-
-	if len(result.Content) > 0 {
-		return result.Content[0].Text, nil
+		return nil, err
 	}
-
-	return "", fmt.Errorf("no response from Anthropic")
+	p.lastUsage.InputTokens = result.Usage.totalInputTokens()
+	p.lastUsage.OutputTokens = result.Usage.OutputTokens
+	p.lastUsage.FinishReason = result.StopReason
+	return &result, nil
 }
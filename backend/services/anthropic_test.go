@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/services/llmtest"
+	"strings"
+	"testing"
+)
+
+func TestAnthropicChatStream(t *testing.T) {
+	server := llmtest.NewServer("Once", " upon", " a time")
+	server.InputTokens, server.OutputTokens = 20, 6
+	defer server.Close()
+
+	provider := NewAnthropicProvider("sk-ant", server.URL)
+	var out strings.Builder
+	if err := provider.ChatStream(context.Background(), testMessages, &out); err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if out.String() != "Once upon a time" {
+		t.Errorf("output: got %q", out.String())
+	}
+	if usage := provider.LastUsage(); usage.InputTokens != 20 || usage.OutputTokens != 6 || usage.FinishReason != "end_turn" {
+		t.Errorf("usage: %+v", usage)
+	}
+
+	req := server.LastRequest()
+	if req.Path != "/v1/messages" || req.Header.Get("x-api-key") != "sk-ant" || req.Header.Get("anthropic-version") == "" {
+		t.Errorf("request: path=%s headers=%v", req.Path, req.Header)
+	}
+	if req.Body["stream"] != true || req.Body["max_tokens"] == nil {
+		t.Errorf("request body: %v", req.Body)
+	}
+}
+
+func TestAnthropicChat(t *testing.T) {
+	server := llmtest.NewServer("short", " title")
+	server.InputTokens, server.OutputTokens = 9, 3
+	defer server.Close()
+
+	provider := NewAnthropicProvider("sk-ant", server.URL)
+	reply, err := provider.Chat(context.Background(), testMessages)
+	if err != nil || reply != "short title" {
+		t.Fatalf("Chat: %q, %v", reply, err)
+	}
+	if usage := provider.LastUsage(); usage.InputTokens != 9 || usage.OutputTokens != 3 {
+		t.Errorf("usage: %+v", usage)
+	}
+}
+
+func TestAnthropicErrorStatus(t *testing.T) {
+	server := llmtest.NewServer()
+	server.Status, server.ErrorBody = 429, "rate limited"
+	defer server.Close()
+
+	err := NewAnthropicProvider("sk-ant", server.URL).ChatStream(context.Background(), testMessages, &strings.Builder{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 429 || !IsTransientError(err) {
+		t.Fatalf("expected transient APIError 429, got %v", err)
+	}
+}
+
+func TestAnthropicStreamDropped(t *testing.T) {
+	server := llmtest.NewServer("a", "b", "c")
+	server.Drop, server.DropAfter = true, 1
+	defer server.Close()
+
+	var out strings.Builder
+	err := NewAnthropicProvider("sk-ant", server.URL).ChatStream(context.Background(), testMessages, &out)
+	if err == nil {
+		t.Fatal("expected error when connection drops mid-stream")
+	}
+	if out.String() != "a" {
+		t.Errorf("output before drop: got %q", out.String())
+	}
+}
+
+func TestAnthropicRequestLiftsSystemAndMergesTurns(t *testing.T) {
+	server := llmtest.NewServer("ok")
+	server.InputTokens, server.CacheReadTokens = 10, 500
+	defer server.Close()
+
+	messages := []models.Message{
+		{Role: "system", Content: "灵感模式系统提示"},
+		{Role: "system", Content: "RAG背景"},
+		{Role: "assistant", Content: "上一段正文"},
+		{Role: "user", Content: "第一句"},
+		{Role: "user", Content: "第二句"},
+		{Role: "assistant", Content: ""},
+	}
+	provider := NewAnthropicProvider("sk-ant", server.URL)
+	if err := provider.ChatStream(context.Background(), messages, &strings.Builder{}); err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if usage := provider.LastUsage(); usage.InputTokens != 510 {
+		t.Errorf("input tokens should include cache reads: %+v", usage)
+	}
+
+	var body struct {
+		System []struct {
+			Text         string            `json:"text"`
+			CacheControl map[string]string `json:"cache_control"`
+		} `json:"system"`
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	raw, _ := json.Marshal(server.LastRequest().Body)
+	if err := json.Unmarshal(raw, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body.System) != 2 || body.System[0].Text != "灵感模式系统提示" || body.System[1].Text != "RAG背景" {
+		t.Fatalf("system: %+v", body.System)
+	}
+	if body.System[0].CacheControl["type"] != "ephemeral" || body.System[1].CacheControl != nil {
+		t.Errorf("cache_control should mark only the first system block: %+v", body.System)
+	}
+
+	want := []string{"user:" + leadingUserTurn, "assistant:上一段正文", "user:第一句\n\n第二句"}
+	if len(body.Messages) != len(want) {
+		t.Fatalf("messages: %+v", body.Messages)
+	}
+	for i, msg := range body.Messages {
+		if got := msg.Role + ":" + msg.Content; got != want[i] {
+			t.Errorf("message %d: got %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestAnthropicStreamErrorEvent(t *testing.T) {
+	server := llmtest.NewServer("a", "b")
+	server.StreamErrorType, server.StreamErrorAfter = "overloaded_error", 1
+	defer server.Close()
+
+	var out strings.Builder
+	err := NewAnthropicProvider("sk-ant", server.URL).ChatStream(context.Background(), testMessages, &out)
+
+	var streamErr *StreamError
+	if !errors.As(err, &streamErr) || streamErr.Type != "overloaded_error" || !IsTransientError(err) {
+		t.Fatalf("expected transient StreamError, got %v", err)
+	}
+	if out.String() != "a" {
+		t.Errorf("output before error: %q", out.String())
+	}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// 熔断器状态
+const (
+	BreakerClosed   = "closed"    // 正常放行
+	BreakerOpen     = "open"      // 熔断中，拒绝请求
+	BreakerHalfOpen = "half_open" // 冷却结束，放行一个探测请求
+)
+
+// CircuitBreaker 熔断器：连续出现临时错误达到阈值后熔断一段时间
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     string
+	openedAt  time.Time
+	probing   bool
+	now       func() time.Time
+}
+
+// NewCircuitBreaker 创建熔断器
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+		now:       time.Now,
+	}
+}
+
+// Allow 判断当前是否允许发起请求
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		// 冷却结束，进入半开状态并放行一个探测请求
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// RecordSuccess 记录一次成功（服务商正常响应）
+func (b *CircuitBreaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.state = BreakerClosed
+	b.probing = false
+}
+
+// RecordFailure 记录一次临时错误
+func (b *CircuitBreaker) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+// State 获取当前状态
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// BreakerRegistry 按名称管理熔断器，熔断器在请求之间共享
+type BreakerRegistry struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	breakers  map[string]*CircuitBreaker
+}
+
+// NewBreakerRegistry 创建熔断器注册表
+func NewBreakerRegistry(threshold int, cooldown time.Duration) *BreakerRegistry {
+	return &BreakerRegistry{
+		threshold: threshold,
+		cooldown:  cooldown,
+		breakers:  make(map[string]*CircuitBreaker),
+	}
+}
+
+// Get 获取指定名称的熔断器，不存在时创建
+func (r *BreakerRegistry) Get(name string) *CircuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	breaker, ok := r.breakers[name]
+	if !ok {
+		breaker = NewCircuitBreaker(r.threshold, r.cooldown)
+		r.breakers[name] = breaker
+	}
+	return breaker
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"grandma/backend/models"
+	"strings"
+)
+
+// diffMaxEdits 差异算法最多搜索的编辑距离，超过时退化为整段删除加整段插入（结果仍然正确，只是不够精细）
+const diffMaxEdits = 1000
+
+// ErrInvalidDelta 增量与基准文本不匹配
+var ErrInvalidDelta = errors.New("invalid_delta")
+
+// editOp 单个词元的编辑操作
+type editOp struct {
+	Type  string
+	Token string
+}
+
+// SplitLines 按行切分文本，每行保留行尾的换行符，拼接后与原文完全一致
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// splitRunes 按字符切分文本
+func splitRunes(text string) []string {
+	tokens := make([]string, 0, len(text))
+	for _, r := range text {
+		tokens = append(tokens, string(r))
+	}
+	return tokens
+}
+
+// DiffLines 按行比较两段文本
+func DiffLines(a, b string) []models.DiffOp {
+	return mergeOps(diffTokens(SplitLines(a), SplitLines(b)))
+}
+
+// DiffRunes 按字符比较两段文本
+func DiffRunes(a, b string) []models.DiffOp {
+	return mergeOps(diffTokens(splitRunes(a), splitRunes(b)))
+}
+
+// CountDiff 统计差异中插入和删除的行数（mode为line）或字符数
+func CountDiff(ops []models.DiffOp, mode string) (inserted, deleted int) {
+	for _, op := range ops {
+		n := len([]rune(op.Text))
+		if mode == "line" {
+			n = len(SplitLines(op.Text))
+		}
+		switch op.Type {
+		case models.DiffInsert:
+			inserted += n
+		case models.DiffDelete:
+			deleted += n
+		}
+	}
+	return inserted, deleted
+}
+
+// mergeOps 合并相邻的同类编辑操作
+func mergeOps(edits []editOp) []models.DiffOp {
+	ops := make([]models.DiffOp, 0)
+	for _, edit := range edits {
+		if n := len(ops); n > 0 && ops[n-1].Type == edit.Type {
+			ops[n-1].Text += edit.Token
+			continue
+		}
+		ops = append(ops, models.DiffOp{Type: edit.Type, Text: edit.Token})
+	}
+	return ops
+}
+
+// diffTokens 计算把a变为b的最短编辑序列（先去掉公共前后缀，再使用Myers算法）
+func diffTokens(a, b []string) []editOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]editOp, 0, len(a)+len(b))
+	for _, token := range a[:prefix] {
+		edits = append(edits, editOp{models.DiffEqual, token})
+	}
+	edits = append(edits, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, token := range a[len(a)-suffix:] {
+		edits = append(edits, editOp{models.DiffEqual, token})
+	}
+	return edits
+}
+
+// myers Myers差异算法，只保存每一轮搜索用到的范围，内存为编辑距离的平方
+func myers(a, b []string) []editOp {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+	found := false
+	for d := 0; d <= max && d <= diffMaxEdits && !found; d++ {
+		snapshot := make([]int, 2*d+1)
+		copy(snapshot, v[offset-d:offset+d+1])
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	if !found {
+		edits := make([]editOp, 0, n+m)
+		for _, token := range a {
+			edits = append(edits, editOp{models.DiffDelete, token})
+		}
+		for _, token := range b {
+			edits = append(edits, editOp{models.DiffInsert, token})
+		}
+		return edits
+	}
+
+	// 从终点回溯，得到倒序的编辑序列
+	reversed := make([]editOp, 0, n+m)
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev[k-1+d] < prev[k+1+d]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[prevK+d]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			reversed = append(reversed, editOp{models.DiffEqual, a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			reversed = append(reversed, editOp{models.DiffInsert, b[prevY]})
+		} else {
+			reversed = append(reversed, editOp{models.DiffDelete, a[prevX]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		reversed = append(reversed, editOp{models.DiffEqual, a[x-1]})
+		x--
+		y--
+	}
+
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	return reversed
+}
+
+// deltaOp 行级增量的一个操作：保留基准文本的K行、删除D行或插入文本I
+type deltaOp struct {
+	K int    `json:"k,omitempty"`
+	D int    `json:"d,omitempty"`
+	I string `json:"i,omitempty"`
+}
+
+// MakeDelta 计算把base变为target的行级增量（JSON格式）
+func MakeDelta(base, target string) ([]byte, error) {
+	var ops []deltaOp
+	for _, edit := range diffTokens(SplitLines(base), SplitLines(target)) {
+		last := len(ops) - 1
+		switch edit.Type {
+		case models.DiffEqual:
+			if last >= 0 && ops[last].K > 0 {
+				ops[last].K++
+				continue
+			}
+			ops = append(ops, deltaOp{K: 1})
+		case models.DiffDelete:
+			if last >= 0 && ops[last].D > 0 {
+				ops[last].D++
+				continue
+			}
+			ops = append(ops, deltaOp{D: 1})
+		case models.DiffInsert:
+			if last >= 0 && ops[last].I != "" {
+				ops[last].I += edit.Token
+				continue
+			}
+			ops = append(ops, deltaOp{I: edit.Token})
+		}
+	}
+	return json.Marshal(ops)
+}
+
+// ApplyDelta 把MakeDelta生成的增量应用到base上
+func ApplyDelta(base string, delta []byte) (string, error) {
+	var ops []deltaOp
+	if err := json.Unmarshal(delta, &ops); err != nil {
+		return "", ErrInvalidDelta
+	}
+
+	lines := SplitLines(base)
+	var out strings.Builder
+	index := 0
+	for _, op := range ops {
+		if op.K < 0 || op.D < 0 || index+op.K+op.D > len(lines) {
+			return "", ErrInvalidDelta
+		}
+		for _, line := range lines[index : index+op.K] {
+			out.WriteString(line)
+		}
+		index += op.K + op.D
+		out.WriteString(op.I)
+	}
+	if index != len(lines) {
+		return "", ErrInvalidDelta
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"grandma/backend/models"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// rebuild 从差异片段还原出比较前后的文本
+func rebuild(ops []models.DiffOp) (before, after string) {
+	var a, b strings.Builder
+	for _, op := range ops {
+		if op.Type != models.DiffInsert {
+			a.WriteString(op.Text)
+		}
+		if op.Type != models.DiffDelete {
+			b.WriteString(op.Text)
+		}
+	}
+	return a.String(), b.String()
+}
+
+func TestDiffRunes(t *testing.T) {
+	ops := DiffRunes("林舟出海了", "林舟今天没有出海")
+	want := []models.DiffOp{
+		{Type: "equal", Text: "林舟"},
+		{Type: "insert", Text: "今天没有"},
+		{Type: "equal", Text: "出海"},
+		{Type: "delete", Text: "了"},
+	}
+	if len(ops) != len(want) {
+		t.Fatalf("ops: %+v", ops)
+	}
+	for i := range want {
+		if ops[i] != want[i] {
+			t.Errorf("op %d: got %+v, want %+v", i, ops[i], want[i])
+		}
+	}
+	if inserted, deleted := CountDiff(ops, "rune"); inserted != 4 || deleted != 1 {
+		t.Errorf("counts: +%d -%d", inserted, deleted)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	a := "第一行\n第二行\n第三行"
+	b := "第一行\n新的一行\n第三行\n第四行\n"
+	ops := DiffLines(a, b)
+	if before, after := rebuild(ops); before != a || after != b {
+		t.Errorf("rebuild: %q %q", before, after)
+	}
+	if inserted, deleted := CountDiff(ops, "line"); inserted != 3 || deleted != 2 {
+		t.Errorf("counts: +%d -%d (%+v)", inserted, deleted, ops)
+	}
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"海风", "渡口", "林舟", "\n", "\n\n", "。", "潮水"}
+	randomText := func() string {
+		var b strings.Builder
+		for i := rng.Intn(60); i > 0; i-- {
+			b.WriteString(words[rng.Intn(len(words))])
+		}
+		return b.String()
+	}
+
+	for i := 0; i < 200; i++ {
+		base, target := randomText(), randomText()
+		delta, err := MakeDelta(base, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ApplyDelta(base, delta)
+		if err != nil || got != target {
+			t.Fatalf("round trip %d: got %q (%v), want %q", i, got, err, target)
+		}
+		if before, after := rebuild(DiffRunes(base, target)); before != base || after != target {
+			t.Fatalf("rune diff %d does not rebuild inputs", i)
+		}
+	}
+
+	if _, err := ApplyDelta("一行\n", []byte(`[{"k":2}]`)); err != ErrInvalidDelta {
+		t.Errorf("delta longer than base: %v", err)
+	}
+}
+
+func TestDiffFallsBackBeyondMaxEdits(t *testing.T) {
+	a := strings.Repeat("甲", diffMaxEdits+10)
+	b := strings.Repeat("乙", diffMaxEdits+10)
+	ops := DiffRunes(a, b)
+	if len(ops) != 2 || ops[0].Type != "delete" || ops[1].Type != "insert" {
+		t.Fatalf("fallback ops: %d", len(ops))
+	}
+	if before, after := rebuild(ops); before != a || after != b {
+		t.Errorf("fallback does not rebuild inputs")
+	}
+}
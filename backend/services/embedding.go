@@ -57,8 +57,16 @@ func (e *EmbeddingService) GetEmbedding(text string) ([]float32, error) {
 
 // GetEmbeddings 批量获取文本的向量嵌入
 func (e *EmbeddingService) GetEmbeddings(texts []string) ([][]float32, error) {
+	embeddings, _, err := e.GetEmbeddingsWithUsage(texts)
+	return embeddings, err
+}
+
+// GetEmbeddingsWithUsage 批量获取文本的向量嵌入，同时返回本次调用的用量
+// EmbeddingService 被多个请求共享，因此用量通过返回值而不是LastUsage传递
+func (e *EmbeddingService) GetEmbeddingsWithUsage(texts []string) ([][]float32, Usage, error) {
+	usage := Usage{Model: e.Model}
 	if len(texts) == 0 {
-		return nil, fmt.Errorf("texts cannot be empty")
+		return nil, usage, fmt.Errorf("texts cannot be empty")
 	}
 
 	url := fmt.Sprintf("%s/embeddings", e.BaseURL)
@@ -70,12 +78,12 @@ func (e *EmbeddingService) GetEmbeddings(texts []string) ([][]float32, error) {
 
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		return nil, usage, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, usage, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -84,20 +92,22 @@ func (e *EmbeddingService) GetEmbeddings(texts []string) ([][]float32, error) {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, usage, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("embedding api error: status %d, body: %s", resp.StatusCode, string(body))
+		return nil, usage, fmt.Errorf("embedding api error: status %d, body: %s", resp.StatusCode, string(body))
 	}
 
 	var result EmbeddingResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+		return nil, usage, fmt.Errorf("failed to decode response: %w", err)
 	}
 
+	usage.InputTokens = result.Usage.PromptTokens
+
 	// 按索引排序，确保顺序正确
 	embeddings := make([][]float32, len(result.Data))
 	for _, item := range result.Data {
@@ -106,6 +116,6 @@ func (e *EmbeddingService) GetEmbeddings(texts []string) ([][]float32, error) {
 		}
 	}
 
-	return embeddings, nil
+	return embeddings, usage, nil
 }
 
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// providerHTTPClient 调用模型服务商使用的HTTP客户端
+// 流式响应可能持续很久，因此不设置整体超时，只限制建立连接和等待响应头的时间
+var providerHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 60 * time.Second,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+// ErrPrivateAddress 用户自定义的API地址在连接时解析到了本机或内网地址
+var ErrPrivateAddress = errors.New("private_address")
+
+// restrictedHTTPClient 访问用户自定义API地址使用的HTTP客户端
+// 每次建立连接时检查实际连接的IP，防止域名在保存后被重新解析到内网（DNS重绑定）；
+// 不使用代理（否则检查的是代理的地址），也不跟随重定向
+var restrictedHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: rejectPrivateAddress}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 60 * time.Second,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// rejectPrivateAddress 拒绝连接本机、内网、链路本地和未指定地址
+func rejectPrivateAddress(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+// IsPublicIP 判断IP是否可以作为用户自定义API地址访问
+func IsPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast())
+}
+
+// APIError 服务商返回的非200响应
+type APIError struct {
+	Provider   string // 服务商名称
+	StatusCode int    // HTTP状态码
+	Body       string // 响应内容
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s api error (status %d): %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// StreamError 服务商在流式响应中途返回的错误事件
+type StreamError struct {
+	Provider string // 服务商名称
+	Type     string // 错误类型，如overloaded_error
+	Message  string // 错误信息
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("%s stream error (%s): %s", e.Provider, e.Type, e.Message)
+}
+
+// transientStreamErrorTypes 可重试的流式错误类型
+var transientStreamErrorTypes = map[string]bool{
+	"overloaded_error":    true,
+	"rate_limit_error":    true,
+	"api_error":           true,
+	"server_error":        true,
+	"rate_limit_exceeded": true,
+}
+
+// IsTransientError 判断错误是否为可重试的临时错误：超时、网络错误、429和5xx
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, ErrPrivateAddress) {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+	}
+	var streamErr *StreamError
+	if errors.As(err, &streamErr) {
+		return transientStreamErrorTypes[streamErr.Type]
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
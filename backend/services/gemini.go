@@ -0,0 +1,309 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"grandma/backend/models"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const geminiDefaultModel = "gemini-2.0-flash"
+
+// GeminiProvider Google Gemini服务提供者
+type GeminiProvider struct {
+	APIKey    string
+	BaseURL   string
+	Model     string
+	lastUsage Usage
+	client    *http.Client // 访问服务商的HTTP客户端
+}
+
+// geminiPart 内容片段：文本或内联图片
+type geminiPart struct {
+	Text       string            `json:"text,omitempty"`
+	Thought    bool              `json:"thought,omitempty"`     // 为true时是思考过程
+	InlineData *geminiInlineData `json:"inline_data,omitempty"` // 图片的base64内容
+}
+
+// geminiInlineData 内联的图片
+type geminiInlineData struct {
+	MimeType string `json:"mime_type"`
+	Data     string `json:"data"`
+}
+
+// geminiContent Gemini的消息
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+// geminiResponse generateContent和streamGenerateContent每个chunk的响应
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+	Error *geminiError `json:"error"`
+}
+
+// geminiError Gemini接口返回的错误
+type geminiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// NewGeminiProvider 创建Gemini服务提供者，model为空时使用默认模型
+func NewGeminiProvider(apiKey, baseURL, model string) *GeminiProvider {
+	if model == "" {
+		model = geminiDefaultModel
+	}
+	return &GeminiProvider{
+		APIKey:  apiKey,
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Model:   model,
+		client:  providerHTTPClient,
+	}
+}
+
+// buildGeminiPayload 把通用消息转换为generateContent请求
+// system消息合并为systemInstruction，assistant角色对应Gemini的model角色，相邻的同角色消息合并为一条
+// 上传的图片以inline_data发送，Gemini不能直接引用图片URL，遇到时返回ErrImageURLUnsupported
+func buildGeminiPayload(messages []models.Message) (map[string]interface{}, error) {
+	var systemPrompts []string
+	contents := make([]geminiContent, 0, len(messages)+1)
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			if strings.TrimSpace(msg.Content) != "" {
+				systemPrompts = append(systemPrompts, msg.Content)
+			}
+			continue
+		}
+		if strings.TrimSpace(msg.Content) == "" && !hasImages(msg) {
+			continue
+		}
+
+		role := "user"
+		if msg.Role == "assistant" {
+			role = "model"
+		}
+		parts, err := geminiParts(msg)
+		if err != nil {
+			return nil, err
+		}
+		// 相邻的同角色消息合并为一条
+		if n := len(contents); n > 0 && contents[n-1].Role == role {
+			contents[n-1].Parts = append(contents[n-1].Parts, parts...)
+			continue
+		}
+		contents = append(contents, geminiContent{Role: role, Parts: parts})
+	}
+
+	// Gemini要求对话以用户消息开头
+	if len(contents) == 0 || contents[0].Role != "user" {
+		contents = append([]geminiContent{{Role: "user", Parts: []geminiPart{{Text: leadingUserTurn}}}}, contents...)
+	}
+
+	payload := map[string]interface{}{
+		"contents":         contents,
+		"generationConfig": map[string]int{"maxOutputTokens": 4096},
+	}
+	if len(systemPrompts) > 0 {
+		payload["systemInstruction"] = geminiContent{Parts: []geminiPart{{Text: strings.Join(systemPrompts, "\n\n")}}}
+	}
+	return payload, nil
+}
+
+// geminiParts 把消息转换为内容片段，包含图片时按顺序转换文本和图片片段
+func geminiParts(msg models.Message) ([]geminiPart, error) {
+	if !hasImages(msg) {
+		return []geminiPart{{Text: msg.Content}}, nil
+	}
+	var parts []geminiPart
+	for _, part := range contentParts(msg) {
+		switch {
+		case part.Type == models.PartText && strings.TrimSpace(part.Text) != "":
+			parts = append(parts, geminiPart{Text: part.Text})
+		case part.Type == models.PartImage && part.Data != "":
+			parts = append(parts, geminiPart{InlineData: &geminiInlineData{MimeType: part.MimeType, Data: part.Data}})
+		case part.Type == models.PartImage:
+			return nil, fmt.Errorf("gemini: %w: %s", ErrImageURLUnsupported, part.URL)
+		}
+	}
+	return parts, nil
+}
+
+// post 发送请求，非200响应返回APIError
+func (p *GeminiProvider) post(ctx context.Context, method string, payload map[string]interface{}) (*http.Response, error) {
+	url := fmt.Sprintf("%s/models/%s:%s", p.BaseURL, p.Model, method)
+	if method == "streamGenerateContent" {
+		url += "?alt=sse"
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", p.APIKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &APIError{Provider: "gemini", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return resp, nil
+}
+
+// handle 处理一个响应（或流式chunk），返回其中的回答文本和思考文本
+func (p *GeminiProvider) handle(result *geminiResponse) (string, string, error) {
+	if result.Error != nil {
+		// 流中途的错误带有HTTP状态码，按状态码映射，使429和5xx可以重试
+		return "", "", &APIError{Provider: "gemini", StatusCode: result.Error.Code, Body: result.Error.Status + ": " + result.Error.Message}
+	}
+	if result.UsageMetadata != nil {
+		p.lastUsage.InputTokens = result.UsageMetadata.PromptTokenCount
+		p.lastUsage.OutputTokens = result.UsageMetadata.CandidatesTokenCount
+	}
+	if len(result.Candidates) == 0 {
+		if result.PromptFeedback != nil && result.PromptFeedback.BlockReason != "" {
+			return "", "", &StreamError{Provider: "gemini", Type: "blocked", Message: result.PromptFeedback.BlockReason}
+		}
+		return "", "", nil
+	}
+
+	candidate := result.Candidates[0]
+	if candidate.FinishReason != "" {
+		p.lastUsage.FinishReason = geminiFinishReason(candidate.FinishReason)
+	}
+	var text, thought strings.Builder
+	for _, part := range candidate.Content.Parts {
+		if part.Thought {
+			thought.WriteString(part.Text)
+		} else {
+			text.WriteString(part.Text)
+		}
+	}
+	return text.String(), thought.String(), nil
+}
+
+// geminiFinishReason 把Gemini的结束原因映射为OpenAI的写法
+func geminiFinishReason(reason string) string {
+	switch reason {
+	case "STOP":
+		return "stop"
+	case "MAX_TOKENS":
+		return "length"
+	default:
+		return strings.ToLower(reason)
+	}
+}
+
+func (p *GeminiProvider) ChatStream(ctx context.Context, messages []models.Message, writer io.Writer) error {
+	p.lastUsage = Usage{Model: p.Model}
+
+	payload, err := buildGeminiPayload(messages)
+	if err != nil {
+		return err
+	}
+	resp, err := p.post(ctx, "streamGenerateContent", payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return readSSE(resp.Body, func(event *SSEEvent) error {
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			return nil
+		}
+		text, thought, err := p.handle(&chunk)
+		if err != nil {
+			return err
+		}
+		if err := WriteReasoning(writer, thought); err != nil {
+			return err
+		}
+		if text != "" {
+			if _, err := writer.Write([]byte(text)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LastUsage 最近一次调用的用量
+func (p *GeminiProvider) LastUsage() Usage {
+	return p.lastUsage
+}
+
+// Chat 非流式聊天，用于生成标题等场景
+func (p *GeminiProvider) Chat(ctx context.Context, messages []models.Message) (string, error) {
+	payload, err := buildGeminiPayload(messages)
+	if err != nil {
+		return "", err
+	}
+	return p.generate(ctx, payload)
+}
+
+// ChatJSONMode 设置responseMimeType要求返回JSON
+// Gemini的responseSchema只支持OpenAPI子集，Schema由提示词描述
+func (p *GeminiProvider) ChatJSONMode(ctx context.Context, messages []models.Message, schema JSONSchema) (string, error) {
+	payload, err := buildGeminiPayload(messages)
+	if err != nil {
+		return "", err
+	}
+	payload["generationConfig"] = map[string]interface{}{
+		"maxOutputTokens":  4096,
+		"responseMimeType": "application/json",
+	}
+	return p.generate(ctx, payload)
+}
+
+// generate 发送非流式generateContent请求
+func (p *GeminiProvider) generate(ctx context.Context, payload map[string]interface{}) (string, error) {
+	p.lastUsage = Usage{Model: p.Model}
+
+	resp, err := p.post(ctx, "generateContent", payload)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	text, _, err := p.handle(&result)
+	if err != nil {
+		return "", err
+	}
+	if text != "" {
+		return text, nil
+	}
+
+	return "", fmt.Errorf("no response from Gemini")
+}
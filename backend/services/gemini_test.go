@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/services/llmtest"
+	"strings"
+	"testing"
+)
+
+func TestGeminiChatStream(t *testing.T) {
+	server := llmtest.NewServer("山重水复", "疑无路")
+	server.InputTokens, server.OutputTokens = 12, 5
+	server.WriteSize = 7
+	defer server.Close()
+
+	provider := NewGeminiProvider("g-key", server.URL, "")
+	var out strings.Builder
+	if err := provider.ChatStream(context.Background(), testMessages, &out); err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if out.String() != "山重水复疑无路" {
+		t.Errorf("output: got %q", out.String())
+	}
+	if usage := provider.LastUsage(); usage.InputTokens != 12 || usage.OutputTokens != 5 || usage.FinishReason != "stop" || usage.Model != geminiDefaultModel {
+		t.Errorf("usage: %+v", usage)
+	}
+
+	req := server.LastRequest()
+	if req.Path != "/models/"+geminiDefaultModel+":streamGenerateContent" || req.Query != "alt=sse" || req.Header.Get("x-goog-api-key") != "g-key" {
+		t.Errorf("request: path=%s query=%s", req.Path, req.Query)
+	}
+	system, _ := req.Body["systemInstruction"].(map[string]interface{})
+	if system == nil {
+		t.Fatalf("system message should become systemInstruction: %v", req.Body)
+	}
+	contents, _ := req.Body["contents"].([]interface{})
+	if len(contents) != 1 || contents[0].(map[string]interface{})["role"] != "user" {
+		t.Errorf("contents: %v", req.Body["contents"])
+	}
+}
+
+func TestGeminiChatMapsAssistantRole(t *testing.T) {
+	server := llmtest.NewServer("标题")
+	defer server.Close()
+
+	provider := NewGeminiProvider("g-key", server.URL, "gemini-custom")
+	reply, err := provider.Chat(context.Background(), []models.Message{
+		{Role: "assistant", Content: "前文"},
+		{Role: "user", Content: "起个标题"},
+	})
+	if err != nil || reply != "标题" {
+		t.Fatalf("Chat: %q, %v", reply, err)
+	}
+
+	req := server.LastRequest()
+	if req.Path != "/models/gemini-custom:generateContent" {
+		t.Errorf("path: %s", req.Path)
+	}
+	var roles []string
+	for _, content := range req.Body["contents"].([]interface{}) {
+		roles = append(roles, content.(map[string]interface{})["role"].(string))
+	}
+	if strings.Join(roles, ",") != "user,model,user" {
+		t.Errorf("roles: %v", roles)
+	}
+}
+
+func TestGeminiRequestMergesTurns(t *testing.T) {
+	payload, err := buildGeminiPayload([]models.Message{
+		{Role: "system", Content: "灵感模式系统提示"},
+		{Role: "assistant", Content: "上一段正文"},
+		{Role: "assistant", Content: "下一段正文"},
+		{Role: "user", Content: "第一句"},
+		{Role: "assistant", Content: ""},
+		{Role: "tool", Content: "工具结果"},
+		{Role: "user", Content: "第二句"},
+	})
+	if err != nil {
+		t.Fatalf("buildGeminiPayload: %v", err)
+	}
+
+	var got []string
+	for _, content := range payload["contents"].([]geminiContent) {
+		var texts []string
+		for _, part := range content.Parts {
+			texts = append(texts, part.Text)
+		}
+		got = append(got, content.Role+":"+strings.Join(texts, "|"))
+	}
+	want := []string{"user:" + leadingUserTurn, "model:上一段正文|下一段正文", "user:第一句|工具结果|第二句"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("contents:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestGeminiErrors(t *testing.T) {
+	server := llmtest.NewServer("a", "b")
+	server.StreamErrorType, server.StreamErrorAfter = "UNAVAILABLE", 1
+	defer server.Close()
+
+	var out strings.Builder
+	err := NewGeminiProvider("g-key", server.URL, "").ChatStream(context.Background(), testMessages, &out)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 503 || !IsTransientError(err) {
+		t.Fatalf("expected transient APIError from stream error, got %v", err)
+	}
+	if out.String() != "a" {
+		t.Errorf("output before error: %q", out.String())
+	}
+
+	server.Status, server.ErrorBody = 400, "INVALID_ARGUMENT"
+	if _, err := NewGeminiProvider("g-key", server.URL, "").Chat(context.Background(), testMessages); !errors.As(err, &apiErr) || IsTransientError(err) {
+		t.Errorf("expected permanent APIError, got %v", err)
+	}
+}
@@ -0,0 +1,361 @@
+// Package llmtest 提供基于httptest的OpenAI/Anthropic/Gemini/Ollama兼容假服务，用于离线测试服务提供者的请求和响应解析
+package llmtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// Request 假服务收到的请求
+type Request struct {
+	Path   string
+	Query  string
+	Header http.Header
+	Body   map[string]interface{}
+}
+
+// Server OpenAI/Anthropic/Gemini/Ollama兼容的假服务
+// 路径以 /chat/completions 结尾时按OpenAI格式响应，以 /messages 结尾时按Anthropic格式响应，
+// 以 :generateContent 或 :streamGenerateContent 结尾时按Gemini格式响应，以 /api/chat 结尾时按Ollama格式响应
+type Server struct {
+	*httptest.Server
+
+	// 以下字段在发起请求之前设置
+	Chunks       []string // 流式响应依次输出的内容片段（非流式响应为它们的拼接）
+	Reasoning    []string // 流式响应在回答之前输出的推理片段
+	InputTokens  int      // 上报的输入用量
+	OutputTokens int      // 上报的输出用量
+	Status       int      // 非0且不为200时直接返回该状态码和ErrorBody
+	ErrorBody    string
+	DropAfter    int  // Drop为true时，输出该数量的片段后直接断开连接
+	Drop         bool // 是否模拟流式响应中途断开
+	WriteSize    int  // 大于0时把响应按该字节数拆分写出，模拟网络分包
+
+	StreamErrorType  string // 非空时在输出StreamErrorAfter个片段后发送该类型的错误事件并结束
+	StreamErrorAfter int
+	CacheReadTokens  int // Anthropic响应中上报的提示缓存命中用量
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+// NewServer 启动假服务，使用完毕后调用Close
+func NewServer(chunks ...string) *Server {
+	s := &Server{Chunks: chunks}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Requests 获取已收到的请求
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// LastRequest 获取最近收到的请求
+func (s *Server) LastRequest() Request {
+	requests := s.Requests()
+	if len(requests) == 0 {
+		return Request{}
+	}
+	return requests[len(requests)-1]
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	raw, _ := io.ReadAll(r.Body)
+	var body map[string]interface{}
+	_ = json.Unmarshal(raw, &body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Path: r.URL.Path, Query: r.URL.RawQuery, Header: r.Header.Clone(), Body: body})
+	s.mu.Unlock()
+
+	if s.Status != 0 && s.Status != http.StatusOK {
+		http.Error(w, s.ErrorBody, s.Status)
+		return
+	}
+
+	stream, _ := body["stream"].(bool)
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/chat/completions"):
+		if stream {
+			s.streamOpenAI(w, body)
+		} else {
+			s.writeJSON(w, map[string]interface{}{
+				"choices": []interface{}{map[string]interface{}{
+					"message":       map[string]interface{}{"role": "assistant", "content": strings.Join(s.Chunks, "")},
+					"finish_reason": "stop",
+				}},
+				"usage": map[string]int{"prompt_tokens": s.InputTokens, "completion_tokens": s.OutputTokens},
+			})
+		}
+	case strings.HasSuffix(r.URL.Path, "/messages"):
+		if stream {
+			s.streamAnthropic(w)
+		} else {
+			s.writeJSON(w, map[string]interface{}{
+				"type":        "message",
+				"role":        "assistant",
+				"content":     []interface{}{map[string]string{"type": "text", "text": strings.Join(s.Chunks, "")}},
+				"stop_reason": "end_turn",
+				"usage":       map[string]int{"input_tokens": s.InputTokens, "output_tokens": s.OutputTokens, "cache_read_input_tokens": s.CacheReadTokens},
+			})
+		}
+	case strings.HasSuffix(r.URL.Path, ":streamGenerateContent"):
+		s.streamGemini(w)
+	case strings.HasSuffix(r.URL.Path, ":generateContent"):
+		s.writeJSON(w, s.geminiChunk(strings.Join(s.Chunks, ""), "STOP", true))
+	case strings.HasSuffix(r.URL.Path, "/api/chat"):
+		// Ollama未指定stream时默认为流式
+		if enabled, ok := body["stream"].(bool); !ok || enabled {
+			s.streamOllama(w)
+		} else {
+			s.writeJSON(w, s.ollamaChunk(strings.Join(s.Chunks, ""), true))
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// streamGemini 按Gemini streamGenerateContent（alt=sse）格式输出
+func (s *Server) streamGemini(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, reasoning := range s.Reasoning {
+		s.writeEvent(w, "", map[string]interface{}{
+			"candidates": []interface{}{map[string]interface{}{
+				"content": map[string]interface{}{"role": "model", "parts": []interface{}{map[string]interface{}{"text": reasoning, "thought": true}}},
+			}},
+		})
+	}
+	for i, chunk := range s.Chunks {
+		if s.Drop && i == s.DropAfter {
+			s.drop(w)
+			return
+		}
+		if s.StreamErrorType != "" && i == s.StreamErrorAfter {
+			s.writeEvent(w, "", map[string]interface{}{
+				"error": map[string]interface{}{"code": http.StatusServiceUnavailable, "status": s.StreamErrorType, "message": "injected stream error"},
+			})
+			return
+		}
+		last := i == len(s.Chunks)-1
+		finishReason := ""
+		if last {
+			finishReason = "STOP"
+		}
+		s.writeEvent(w, "", s.geminiChunk(chunk, finishReason, last))
+	}
+}
+
+// geminiChunk 构造Gemini响应，withUsage为true时附带用量
+func (s *Server) geminiChunk(text, finishReason string, withUsage bool) map[string]interface{} {
+	candidate := map[string]interface{}{
+		"content": map[string]interface{}{"role": "model", "parts": []interface{}{map[string]string{"text": text}}},
+	}
+	if finishReason != "" {
+		candidate["finishReason"] = finishReason
+	}
+	chunk := map[string]interface{}{"candidates": []interface{}{candidate}}
+	if withUsage {
+		chunk["usageMetadata"] = map[string]int{"promptTokenCount": s.InputTokens, "candidatesTokenCount": s.OutputTokens}
+	}
+	return chunk
+}
+
+// streamOllama 按Ollama /api/chat的NDJSON格式输出
+func (s *Server) streamOllama(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	for _, reasoning := range s.Reasoning {
+		s.writeLine(w, map[string]interface{}{
+			"model":   "test",
+			"message": map[string]string{"role": "assistant", "content": "", "thinking": reasoning},
+			"done":    false,
+		})
+	}
+	for i, chunk := range s.Chunks {
+		if s.Drop && i == s.DropAfter {
+			s.drop(w)
+			return
+		}
+		if s.StreamErrorType != "" && i == s.StreamErrorAfter {
+			s.writeLine(w, map[string]string{"error": s.StreamErrorType})
+			return
+		}
+		s.writeLine(w, s.ollamaChunk(chunk, false))
+	}
+	if s.Drop && s.DropAfter >= len(s.Chunks) {
+		s.drop(w)
+		return
+	}
+	s.writeLine(w, s.ollamaChunk("", true))
+}
+
+// ollamaChunk 构造Ollama响应，done为true时附带用量和结束原因
+func (s *Server) ollamaChunk(text string, done bool) map[string]interface{} {
+	chunk := map[string]interface{}{
+		"model":   "test",
+		"message": map[string]string{"role": "assistant", "content": text},
+		"done":    done,
+	}
+	if done {
+		chunk["done_reason"] = "stop"
+		chunk["prompt_eval_count"] = s.InputTokens
+		chunk["eval_count"] = s.OutputTokens
+	}
+	return chunk
+}
+
+func (s *Server) writeLine(w http.ResponseWriter, data interface{}) {
+	payload, _ := json.Marshal(data)
+	s.write(w, string(payload)+"\n")
+}
+
+// streamOpenAI 按OpenAI Chat Completions流式格式输出
+func (s *Server) streamOpenAI(w http.ResponseWriter, body map[string]interface{}) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, reasoning := range s.Reasoning {
+		s.writeEvent(w, "", map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{"content": nil, "reasoning_content": reasoning}}},
+		})
+	}
+	for i, chunk := range s.Chunks {
+		if s.Drop && i == s.DropAfter {
+			s.drop(w)
+			return
+		}
+		if s.StreamErrorType != "" && i == s.StreamErrorAfter {
+			s.writeEvent(w, "", map[string]interface{}{
+				"error": map[string]string{"type": s.StreamErrorType, "message": "injected stream error"},
+			})
+			return
+		}
+		s.writeEvent(w, "", map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]string{"content": chunk}}},
+		})
+	}
+	if s.Drop && s.DropAfter >= len(s.Chunks) {
+		s.drop(w)
+		return
+	}
+	s.writeEvent(w, "", map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]string{}, "finish_reason": "stop"}},
+	})
+	if options, ok := body["stream_options"].(map[string]interface{}); ok && options["include_usage"] == true {
+		s.writeEvent(w, "", map[string]interface{}{
+			"choices": []interface{}{},
+			"usage":   map[string]int{"prompt_tokens": s.InputTokens, "completion_tokens": s.OutputTokens},
+		})
+	}
+	s.write(w, "data: [DONE]\n\n")
+}
+
+// streamAnthropic 按Anthropic Messages流式格式输出
+func (s *Server) streamAnthropic(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	s.writeEvent(w, "message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"role":  "assistant",
+			"usage": map[string]int{"input_tokens": s.InputTokens, "output_tokens": 1, "cache_read_input_tokens": s.CacheReadTokens},
+		},
+	})
+	index := 0
+	if len(s.Reasoning) > 0 {
+		s.writeEvent(w, "content_block_start", map[string]interface{}{
+			"type": "content_block_start", "index": index,
+			"content_block": map[string]string{"type": "thinking", "thinking": ""},
+		})
+		for _, reasoning := range s.Reasoning {
+			s.writeEvent(w, "content_block_delta", map[string]interface{}{
+				"type": "content_block_delta", "index": index,
+				"delta": map[string]string{"type": "thinking_delta", "thinking": reasoning},
+			})
+		}
+		s.writeEvent(w, "content_block_delta", map[string]interface{}{
+			"type": "content_block_delta", "index": index,
+			"delta": map[string]string{"type": "signature_delta", "signature": "sig"},
+		})
+		s.writeEvent(w, "content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": index})
+		index++
+	}
+	s.writeEvent(w, "content_block_start", map[string]interface{}{
+		"type": "content_block_start", "index": index,
+		"content_block": map[string]string{"type": "text", "text": ""},
+	})
+	s.writeEvent(w, "ping", map[string]string{"type": "ping"})
+	for i, chunk := range s.Chunks {
+		if s.Drop && i == s.DropAfter {
+			s.drop(w)
+			return
+		}
+		if s.StreamErrorType != "" && i == s.StreamErrorAfter {
+			s.writeEvent(w, "error", map[string]interface{}{
+				"type":  "error",
+				"error": map[string]string{"type": s.StreamErrorType, "message": "injected stream error"},
+			})
+			return
+		}
+		s.writeEvent(w, "content_block_delta", map[string]interface{}{
+			"type": "content_block_delta", "index": index,
+			"delta": map[string]string{"type": "text_delta", "text": chunk},
+		})
+	}
+	if s.Drop && s.DropAfter >= len(s.Chunks) {
+		s.drop(w)
+		return
+	}
+	s.writeEvent(w, "content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": index})
+	s.writeEvent(w, "message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]string{"stop_reason": "end_turn"},
+		"usage": map[string]int{"output_tokens": s.OutputTokens},
+	})
+	s.writeEvent(w, "message_stop", map[string]string{"type": "message_stop"})
+}
+
+func (s *Server) writeEvent(w http.ResponseWriter, event string, data interface{}) {
+	payload, _ := json.Marshal(data)
+	if event != "" {
+		s.write(w, fmt.Sprintf("event: %s\ndata: %s\n\n", event, payload))
+		return
+	}
+	s.write(w, fmt.Sprintf("data: %s\n\n", payload))
+}
+
+// write 写出并刷新，WriteSize大于0时拆分成多次写出
+func (s *Server) write(w http.ResponseWriter, data string) {
+	size := s.WriteSize
+	if size <= 0 {
+		size = len(data)
+	}
+	for start := 0; start < len(data); start += size {
+		end := start + size
+		if end > len(data) {
+			end = len(data)
+		}
+		io.WriteString(w, data[start:end])
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// drop 不发送结束标记直接断开连接
+func (s *Server) drop(w http.ResponseWriter) {
+	if hijacker, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hijacker.Hijack(); err == nil {
+			conn.Close()
+		}
+	}
+}
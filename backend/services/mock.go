@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"grandma/backend/models"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// ProviderMock 本地模拟服务商名称
+const ProviderMock = "mock"
+
+const mockModel = "mock"
+
+// MockOptions 模拟服务提供者的行为配置
+type MockOptions struct {
+	Responses        []string          // 依次循环返回的脚本响应，为空时回显最后一条用户消息
+	Reasoning        string            // 非空时在回答之前通过推理通道输出，模拟推理模型
+	FirstChunkDelay  time.Duration     // 第一个chunk之前的延迟（模拟首字延迟）
+	ChunkDelay       time.Duration     // 每个chunk之间的延迟
+	ChunkSize        int               // 每个chunk包含的字符数（按rune计，0表示整段一次输出）
+	ErrorStatus      int               // 非0时返回该状态码的APIError
+	ErrorAfterChunks int               // 输出多少个chunk后返回错误（0表示在输出任何内容之前）
+	InputTokens      int               // 上报的输入用量（0表示按字符数估算）
+	OutputTokens     int               // 上报的输出用量（0表示按字符数估算）
+	ToolCalls        []models.ToolCall // 携带工具声明且本轮还没有工具结果时要求调用的工具
+
+	next uint64 // 下一条脚本响应的序号，多个请求共享
+}
+
+var (
+	mockMu      sync.RWMutex
+	mockOptions *MockOptions
+)
+
+// EnableMockProvider 启用模拟服务商，options为nil时禁用
+func EnableMockProvider(options *MockOptions) {
+	mockMu.Lock()
+	defer mockMu.Unlock()
+	mockOptions = options
+}
+
+// MockProviderEnabled 模拟服务商是否已启用
+func MockProviderEnabled() bool {
+	mockMu.RLock()
+	defer mockMu.RUnlock()
+	return mockOptions != nil
+}
+
+// newConfiguredMockProvider 使用已启用的配置创建模拟服务提供者
+func newConfiguredMockProvider() (ChatProvider, error) {
+	mockMu.RLock()
+	defer mockMu.RUnlock()
+	if mockOptions == nil {
+		return nil, fmt.Errorf("mock provider is not enabled")
+	}
+	return NewMockProvider(mockOptions), nil
+}
+
+// MockProvider 本地模拟服务提供者，用于开发和测试，不访问网络
+type MockProvider struct {
+	options   *MockOptions
+	lastUsage Usage
+}
+
+// NewMockProvider 创建模拟服务提供者
+func NewMockProvider(options *MockOptions) *MockProvider {
+	if options == nil {
+		options = &MockOptions{}
+	}
+	return &MockProvider{options: options}
+}
+
+func (p *MockProvider) ChatStream(ctx context.Context, messages []models.Message, writer io.Writer) error {
+	response := p.response(messages)
+	chunks := splitChunks(response, p.options.ChunkSize)
+	p.lastUsage = Usage{Model: mockModel, InputTokens: p.inputTokens(messages)}
+
+	if err := sleepContext(ctx, p.options.FirstChunkDelay); err != nil {
+		return err
+	}
+	if err := WriteReasoning(writer, p.options.Reasoning); err != nil {
+		return err
+	}
+
+	var output strings.Builder
+	for i, chunk := range chunks {
+		if p.options.ErrorStatus != 0 && i == p.options.ErrorAfterChunks {
+			p.lastUsage.OutputTokens = p.outputTokens(output.String())
+			return p.error()
+		}
+		if i > 0 {
+			if err := sleepContext(ctx, p.options.ChunkDelay); err != nil {
+				return err
+			}
+		}
+		if _, err := writer.Write([]byte(chunk)); err != nil {
+			return err
+		}
+		output.WriteString(chunk)
+	}
+
+	p.lastUsage.OutputTokens = p.outputTokens(output.String())
+	if p.options.ErrorStatus != 0 && p.options.ErrorAfterChunks >= len(chunks) {
+		return p.error()
+	}
+	p.lastUsage.FinishReason = "stop"
+	return nil
+}
+
+// ChatStreamWithTools 配置了ToolCalls时，先要求调用工具，拿到工具结果后再正常回答
+func (p *MockProvider) ChatStreamWithTools(ctx context.Context, messages []models.Message, tools []ToolDefinition, writer io.Writer) ([]models.ToolCall, error) {
+	if len(tools) == 0 || len(p.options.ToolCalls) == 0 || hasToolResultSinceUser(messages) {
+		return nil, p.ChatStream(ctx, messages, writer)
+	}
+
+	p.lastUsage = Usage{Model: mockModel, InputTokens: p.inputTokens(messages)}
+	if p.options.ErrorStatus != 0 && p.options.ErrorAfterChunks == 0 {
+		return nil, p.error()
+	}
+	calls := append([]models.ToolCall(nil), p.options.ToolCalls...)
+	var arguments strings.Builder
+	for i := range calls {
+		if calls[i].ID == "" {
+			calls[i].ID = fmt.Sprintf("mock_call_%d", i+1)
+		}
+		if calls[i].Arguments == "" {
+			calls[i].Arguments = "{}"
+		}
+		arguments.WriteString(calls[i].Arguments)
+	}
+	p.lastUsage.OutputTokens = p.outputTokens(arguments.String())
+	p.lastUsage.FinishReason = "tool_calls"
+	return calls, nil
+}
+
+// hasToolResultSinceUser 最后一条用户消息之后是否已有工具结果
+func hasToolResultSinceUser(messages []models.Message) bool {
+	for i := len(messages) - 1; i >= 0; i-- {
+		switch messages[i].Role {
+		case "tool":
+			return true
+		case "user":
+			return false
+		}
+	}
+	return false
+}
+
+// Chat 非流式聊天，出错配置时直接返回错误
+func (p *MockProvider) Chat(ctx context.Context, messages []models.Message) (string, error) {
+	response := p.response(messages)
+	p.lastUsage = Usage{Model: mockModel, InputTokens: p.inputTokens(messages)}
+
+	if err := sleepContext(ctx, p.options.FirstChunkDelay); err != nil {
+		return "", err
+	}
+	if p.options.ErrorStatus != 0 {
+		return "", p.error()
+	}
+
+	p.lastUsage.OutputTokens = p.outputTokens(response)
+	p.lastUsage.FinishReason = "stop"
+	return response, nil
+}
+
+// LastUsage 最近一次调用的用量
+func (p *MockProvider) LastUsage() Usage {
+	return p.lastUsage
+}
+
+// response 选择本次返回的内容：脚本响应依次循环，否则回显最后一条用户消息
+func (p *MockProvider) response(messages []models.Message) string {
+	if len(p.options.Responses) > 0 {
+		index := atomic.AddUint64(&p.options.next, 1) - 1
+		return p.options.Responses[index%uint64(len(p.options.Responses))]
+	}
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+func (p *MockProvider) error() error {
+	return &APIError{Provider: ProviderMock, StatusCode: p.options.ErrorStatus, Body: "mock error"}
+}
+
+func (p *MockProvider) inputTokens(messages []models.Message) int {
+	if p.options.InputTokens > 0 {
+		return p.options.InputTokens
+	}
+	total := 0
+	for _, msg := range messages {
+		total += utf8.RuneCountInString(msg.Content)
+	}
+	return total
+}
+
+func (p *MockProvider) outputTokens(output string) int {
+	if p.options.OutputTokens > 0 {
+		return p.options.OutputTokens
+	}
+	return utf8.RuneCountInString(output)
+}
+
+// sleepContext 等待delay，ctx先结束时返回ctx的错误
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// splitChunks 按rune数切分文本，size<=0时不切分
+func splitChunks(text string, size int) []string {
+	if text == "" {
+		return nil
+	}
+	if size <= 0 {
+		return []string{text}
+	}
+
+	var chunks []string
+	runes := []rune(text)
+	for start := 0; start < len(runes); start += size {
+		end := start + size
+		if end > len(runes) {
+			end = len(runes)
+		}
+		chunks = append(chunks, string(runes[start:end]))
+	}
+	return chunks
+}
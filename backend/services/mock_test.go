@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// chunkRecorder 记录每次写入的内容
+type chunkRecorder struct {
+	chunks []string
+}
+
+func (r *chunkRecorder) Write(p []byte) (int, error) {
+	r.chunks = append(r.chunks, string(p))
+	return len(p), nil
+}
+
+func TestMockProviderEcho(t *testing.T) {
+	provider := NewMockProvider(&MockOptions{ChunkSize: 2})
+
+	var out chunkRecorder
+	if err := provider.ChatStream(context.Background(), testMessages, &out); err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if strings.Join(out.chunks, "|") != "he|ll|o" {
+		t.Errorf("chunks: %q", out.chunks)
+	}
+	if usage := provider.LastUsage(); usage.Model != "mock" || usage.InputTokens != len("be brief")+len("hello") || usage.OutputTokens != 5 {
+		t.Errorf("usage: %+v", usage)
+	}
+}
+
+func TestMockProviderScriptedResponses(t *testing.T) {
+	options := &MockOptions{Responses: []string{"第一章", "第二章"}, InputTokens: 100, OutputTokens: 50}
+
+	// 脚本响应在多个provider实例之间依次循环
+	for _, want := range []string{"第一章", "第二章", "第一章"} {
+		provider := NewMockProvider(options)
+		reply, err := provider.Chat(context.Background(), testMessages)
+		if err != nil || reply != want {
+			t.Fatalf("Chat: got %q, %v, want %q", reply, err, want)
+		}
+		if usage := provider.LastUsage(); usage.InputTokens != 100 || usage.OutputTokens != 50 {
+			t.Errorf("usage: %+v", usage)
+		}
+	}
+}
+
+func TestMockProviderMidStreamError(t *testing.T) {
+	provider := NewMockProvider(&MockOptions{Responses: []string{"abcdef"}, ChunkSize: 2, ErrorStatus: 502, ErrorAfterChunks: 2})
+
+	var out chunkRecorder
+	err := provider.ChatStream(context.Background(), testMessages, &out)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 502 {
+		t.Fatalf("expected APIError 502, got %v", err)
+	}
+	if strings.Join(out.chunks, "") != "abcd" {
+		t.Errorf("output before error: %q", out.chunks)
+	}
+}
+
+func TestMockProviderRegistration(t *testing.T) {
+	defer EnableMockProvider(nil)
+
+	EnableMockProvider(nil)
+	if _, err := GetProvider("mock", nil); err == nil {
+		t.Fatal("mock provider should be unavailable until enabled")
+	}
+
+	EnableMockProvider(&MockOptions{Responses: []string{"ok"}})
+	provider, err := GetProvider("mock", nil)
+	if err != nil {
+		t.Fatalf("GetProvider: %v", err)
+	}
+	if reply, err := provider.Chat(context.Background(), testMessages); err != nil || reply != "ok" {
+		t.Errorf("Chat: %q, %v", reply, err)
+	}
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"grandma/backend/models"
+)
+
+// ErrImageURLUnsupported 服务商只接受上传的图片内容，不能通过URL引用图片
+var ErrImageURLUnsupported = errors.New("image_url_unsupported")
+
+// hasImages 消息是否包含图片片段
+func hasImages(msg models.Message) bool {
+	for _, part := range msg.Parts {
+		if part.Type == models.PartImage {
+			return true
+		}
+	}
+	return false
+}
+
+// contentParts 消息的内容片段，Parts中没有文本片段时把Content作为第一个文本片段
+func contentParts(msg models.Message) []models.ContentPart {
+	for _, part := range msg.Parts {
+		if part.Type == models.PartText {
+			return msg.Parts
+		}
+	}
+	if msg.Content == "" {
+		return msg.Parts
+	}
+	return append([]models.ContentPart{{Type: models.PartText, Text: msg.Content}}, msg.Parts...)
+}
+
+// imageURL 图片的URL，上传的图片使用data URL
+func imageURL(part models.ContentPart) string {
+	if part.Data != "" {
+		return fmt.Sprintf("data:%s;base64,%s", part.MimeType, part.Data)
+	}
+	return part.URL
+}
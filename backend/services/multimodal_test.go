@@ -0,0 +1,84 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"grandma/backend/models"
+	"strings"
+	"testing"
+)
+
+var imageMessages = []models.Message{{Role: "user", Content: "这张图里有什么？", Parts: []models.ContentPart{
+	{Type: models.PartImage, MimeType: "image/png", Data: "iVBORw0K"},
+	{Type: models.PartImage, URL: "https://example.com/sea.jpg"},
+}}}
+
+func TestOpenAIRequestEncodesImages(t *testing.T) {
+	data, _ := json.Marshal(openAIMessages(imageMessages))
+	want := `[{"content":[{"text":"这张图里有什么？","type":"text"},` +
+		`{"image_url":{"url":"data:image/png;base64,iVBORw0K"},"type":"image_url"},` +
+		`{"image_url":{"url":"https://example.com/sea.jpg"},"type":"image_url"}],"role":"user"}]`
+	if string(data) != want {
+		t.Errorf("got %s\nwant %s", data, want)
+	}
+
+	// 没有图片时content仍然是字符串
+	data, _ = json.Marshal(openAIMessages(testMessages))
+	if want := `"content":"` + testMessages[len(testMessages)-1].Content + `"`; !strings.Contains(string(data), want) {
+		t.Errorf("text message: %s", data)
+	}
+}
+
+func TestAnthropicRequestEncodesImages(t *testing.T) {
+	data, _ := json.Marshal(buildAnthropicPayload(imageMessages, nil, false)["messages"])
+	want := `[{"role":"user","content":[{"type":"text","text":"这张图里有什么？"},` +
+		`{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0K"}},` +
+		`{"type":"image","source":{"type":"url","url":"https://example.com/sea.jpg"}}]}]`
+	if string(data) != want {
+		t.Errorf("got %s\nwant %s", data, want)
+	}
+}
+
+func TestGeminiRequestEncodesImages(t *testing.T) {
+	uploaded := []models.Message{
+		{Role: "user", Content: "先看这张", Parts: []models.ContentPart{{Type: models.PartImage, MimeType: "image/png", Data: "iVBORw0K"}}},
+		{Role: "user", Content: "再说说颜色"},
+	}
+	payload, err := buildGeminiPayload(uploaded)
+	if err != nil {
+		t.Fatalf("buildGeminiPayload: %v", err)
+	}
+	data, _ := json.Marshal(payload["contents"])
+	want := `[{"role":"user","parts":[{"text":"先看这张"},{"inline_data":{"mime_type":"image/png","data":"iVBORw0K"}},{"text":"再说说颜色"}]}]`
+	if string(data) != want {
+		t.Errorf("got %s\nwant %s", data, want)
+	}
+
+	// 只有图片的消息不会被丢弃
+	payload, _ = buildGeminiPayload([]models.Message{{Role: "user", Parts: uploaded[0].Parts}})
+	if data, _ := json.Marshal(payload["contents"]); !strings.Contains(string(data), "inline_data") {
+		t.Errorf("image-only message: %s", data)
+	}
+
+	// 图片URL无法发送，返回错误而不是静默丢弃
+	if _, err := buildGeminiPayload(imageMessages); !errors.Is(err, ErrImageURLUnsupported) {
+		t.Errorf("image URL: %v", err)
+	}
+}
+
+func TestOllamaRequestEncodesImages(t *testing.T) {
+	uploaded := []models.Message{{Role: "user", Content: "这张图里有什么？", Parts: imageMessages[0].Parts[:1]}}
+	apiMessages, err := ollamaMessages(uploaded)
+	if err != nil {
+		t.Fatalf("ollamaMessages: %v", err)
+	}
+	data, _ := json.Marshal(apiMessages)
+	want := `[{"content":"这张图里有什么？","images":["iVBORw0K"],"role":"user"}]`
+	if string(data) != want {
+		t.Errorf("got %s\nwant %s", data, want)
+	}
+
+	if _, err := ollamaMessages(imageMessages); !errors.Is(err, ErrImageURLUnsupported) {
+		t.Errorf("image URL: %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"grandma/backend/models"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const ollamaDefaultModel = "qwen2.5"
+
+// OllamaProvider Ollama本地模型服务提供者
+type OllamaProvider struct {
+	APIKey    string // 可选，经过鉴权代理访问时作为Bearer Token
+	BaseURL   string
+	Model     string
+	lastUsage Usage
+	client    *http.Client // 访问服务商的HTTP客户端
+}
+
+// ollamaChatResponse /api/chat的响应，流式时每行一个
+type ollamaChatResponse struct {
+	Message struct {
+		Content  string `json:"content"`
+		Thinking string `json:"thinking"` // 开启思考的模型返回的思考过程
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+// NewOllamaProvider 创建Ollama服务提供者，model为空时使用默认模型
+func NewOllamaProvider(apiKey, baseURL, model string) *OllamaProvider {
+	if model == "" {
+		model = ollamaDefaultModel
+	}
+	return &OllamaProvider{
+		APIKey:  apiKey,
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Model:   model,
+		client:  providerHTTPClient,
+	}
+}
+
+// ollamaMessages 把通用消息转换为/api/chat的消息
+// Ollama原生支持system角色，只需要去掉空消息；上传的图片以base64放在images中，
+// Ollama不能直接引用图片URL，遇到时返回ErrImageURLUnsupported
+func ollamaMessages(messages []models.Message) ([]map[string]interface{}, error) {
+	apiMessages := make([]map[string]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		if strings.TrimSpace(msg.Content) == "" && !hasImages(msg) {
+			continue
+		}
+		apiMessage := map[string]interface{}{
+			"role":    msg.Role,
+			"content": msg.Content,
+		}
+		if hasImages(msg) {
+			var images []string
+			for _, part := range msg.Parts {
+				if part.Type != models.PartImage {
+					continue
+				}
+				if part.Data == "" {
+					return nil, fmt.Errorf("ollama: %w: %s", ErrImageURLUnsupported, part.URL)
+				}
+				images = append(images, part.Data)
+			}
+			apiMessage["images"] = images
+		}
+		apiMessages = append(apiMessages, apiMessage)
+	}
+	return apiMessages, nil
+}
+
+// post 发送/api/chat请求，format非空时作为结构化输出的格式，非200响应返回APIError
+func (p *OllamaProvider) post(ctx context.Context, messages []models.Message, stream bool, format interface{}) (*http.Response, error) {
+	url := fmt.Sprintf("%s/api/chat", p.BaseURL)
+
+	apiMessages, err := ollamaMessages(messages)
+	if err != nil {
+		return nil, err
+	}
+	payload := map[string]interface{}{
+		"model":    p.Model,
+		"messages": apiMessages,
+		"stream":   stream,
+	}
+	if format != nil {
+		payload["format"] = format
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.APIKey))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &APIError{Provider: "ollama", StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return resp, nil
+}
+
+// recordDone 记录最后一个响应中的用量和结束原因
+func (p *OllamaProvider) recordDone(result *ollamaChatResponse) {
+	p.lastUsage.InputTokens = result.PromptEvalCount
+	p.lastUsage.OutputTokens = result.EvalCount
+	p.lastUsage.FinishReason = result.DoneReason
+}
+
+func (p *OllamaProvider) ChatStream(ctx context.Context, messages []models.Message, writer io.Writer) error {
+	p.lastUsage = Usage{Model: p.Model}
+
+	resp, err := p.post(ctx, messages, true, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 响应为NDJSON：每行一个完整的JSON对象，最后一行done为true
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var chunk ollamaChatResponse
+			if err := json.Unmarshal(line, &chunk); err != nil {
+				return fmt.Errorf("decode ollama stream: %w", err)
+			}
+			if chunk.Error != "" {
+				return &StreamError{Provider: "ollama", Type: "ollama_error", Message: chunk.Error}
+			}
+			if err := WriteReasoning(writer, chunk.Message.Thinking); err != nil {
+				return err
+			}
+			if chunk.Message.Content != "" {
+				if _, err := writer.Write([]byte(chunk.Message.Content)); err != nil {
+					return err
+				}
+			}
+			if chunk.Done {
+				p.recordDone(&chunk)
+				return nil
+			}
+		}
+
+		if readErr == io.EOF {
+			// 没有收到done就结束，说明连接中途断开
+			return io.ErrUnexpectedEOF
+		}
+	}
+}
+
+// LastUsage 最近一次调用的用量
+func (p *OllamaProvider) LastUsage() Usage {
+	return p.lastUsage
+}
+
+// Chat 非流式聊天，用于生成标题等场景
+func (p *OllamaProvider) Chat(ctx context.Context, messages []models.Message) (string, error) {
+	return p.complete(ctx, messages, nil)
+}
+
+// ChatJSONMode Ollama的format参数直接接受JSON Schema
+func (p *OllamaProvider) ChatJSONMode(ctx context.Context, messages []models.Message, schema JSONSchema) (string, error) {
+	var format interface{} = "json"
+	if schema.Schema != nil {
+		format = schema.Schema
+	}
+	return p.complete(ctx, messages, format)
+}
+
+// complete 发送非流式请求
+func (p *OllamaProvider) complete(ctx context.Context, messages []models.Message, format interface{}) (string, error) {
+	p.lastUsage = Usage{Model: p.Model}
+
+	resp, err := p.post(ctx, messages, false, format)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result ollamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.Error != "" {
+		return "", &StreamError{Provider: "ollama", Type: "ollama_error", Message: result.Error}
+	}
+	p.recordDone(&result)
+
+	if result.Message.Content != "" {
+		return result.Message.Content, nil
+	}
+
+	return "", fmt.Errorf("no response from Ollama")
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"grandma/backend/services/llmtest"
+	"strings"
+	"testing"
+)
+
+func TestOllamaChatStream(t *testing.T) {
+	server := llmtest.NewServer("柳暗", "花明", "又一村")
+	server.InputTokens, server.OutputTokens = 30, 8
+	server.WriteSize = 4
+	defer server.Close()
+
+	provider := NewOllamaProvider("", server.URL+"/", "llama3")
+	var out strings.Builder
+	if err := provider.ChatStream(context.Background(), testMessages, &out); err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if out.String() != "柳暗花明又一村" {
+		t.Errorf("output: got %q", out.String())
+	}
+	if usage := provider.LastUsage(); usage.InputTokens != 30 || usage.OutputTokens != 8 || usage.FinishReason != "stop" || usage.Model != "llama3" {
+		t.Errorf("usage: %+v", usage)
+	}
+
+	req := server.LastRequest()
+	if req.Path != "/api/chat" || req.Header.Get("Authorization") != "" || req.Body["model"] != "llama3" || req.Body["stream"] != true {
+		t.Errorf("request: path=%s body=%v", req.Path, req.Body)
+	}
+}
+
+func TestOllamaChat(t *testing.T) {
+	server := llmtest.NewServer("本地", "标题")
+	server.InputTokens, server.OutputTokens = 4, 2
+	defer server.Close()
+
+	provider := NewOllamaProvider("proxy-token", server.URL, "")
+	reply, err := provider.Chat(context.Background(), testMessages)
+	if err != nil || reply != "本地标题" {
+		t.Fatalf("Chat: %q, %v", reply, err)
+	}
+	if usage := provider.LastUsage(); usage.InputTokens != 4 || usage.OutputTokens != 2 || usage.Model != ollamaDefaultModel {
+		t.Errorf("usage: %+v", usage)
+	}
+	if auth := server.LastRequest().Header.Get("Authorization"); auth != "Bearer proxy-token" {
+		t.Errorf("authorization: %q", auth)
+	}
+}
+
+func TestOllamaErrors(t *testing.T) {
+	server := llmtest.NewServer("a", "b")
+	server.StreamErrorType, server.StreamErrorAfter = "model crashed", 1
+	defer server.Close()
+
+	var out strings.Builder
+	err := NewOllamaProvider("", server.URL, "").ChatStream(context.Background(), testMessages, &out)
+	var streamErr *StreamError
+	if !errors.As(err, &streamErr) || streamErr.Message != "model crashed" {
+		t.Fatalf("expected StreamError, got %v", err)
+	}
+
+	server.StreamErrorType = ""
+	server.Drop, server.DropAfter = true, 2
+	if err := NewOllamaProvider("", server.URL, "").ChatStream(context.Background(), testMessages, &strings.Builder{}); err == nil {
+		t.Error("expected error when stream ends without done")
+	}
+
+	server.Drop = false
+	server.Status, server.ErrorBody = 404, `{"error":"model not found"}`
+	err = NewOllamaProvider("", server.URL, "").ChatStream(context.Background(), testMessages, &strings.Builder{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 404 || IsTransientError(err) {
+		t.Errorf("expected permanent APIError 404, got %v", err)
+	}
+}
+
+func TestGetProviderRegistersNewProviders(t *testing.T) {
+	settings := map[string]ProviderSettings{
+		ProviderGemini: {APIKey: "g-key", BaseURL: "http://gemini.invalid", Model: "gemini-x"},
+		ProviderOllama: {BaseURL: "http://ollama.invalid"},
+	}
+	if provider, err := GetProvider("gemini", settings); err != nil {
+		t.Errorf("gemini: %v", err)
+	} else if p, ok := provider.(*GeminiProvider); !ok || p.Model != "gemini-x" {
+		t.Errorf("gemini provider: %#v", provider)
+	}
+	if _, err := GetProvider("local", settings); err != nil {
+		t.Errorf("ollama: %v", err)
+	}
+	if _, err := GetProvider("ollama", nil); err == nil {
+		t.Error("ollama without base URL should fail")
+	}
+	if _, err := GetProvider("gemini", nil); err == nil {
+		t.Error("gemini without API key should fail")
+	}
+}
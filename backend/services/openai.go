@@ -2,6 +2,7 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"grandma/backend/models"
@@ -10,10 +11,20 @@ import (
 	"net/http"
 )
 
+const openAIModel = "deepseek-chat"
+
 // OpenAIProvider OpenAI服务提供者
 type OpenAIProvider struct {
-	APIKey  string
-	BaseURL string
+	APIKey    string
+	BaseURL   string
+	lastUsage Usage
+	client    *http.Client // 访问服务商的HTTP客户端
+}
+
+// openAIUsage OpenAI接口返回的用量
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
 }
 
 // NewOpenAIProvider 创建OpenAI服务提供者
@@ -21,140 +32,252 @@ func NewOpenAIProvider(apiKey, baseURL string) *OpenAIProvider {
 	return &OpenAIProvider{
 		APIKey:  apiKey,
 		BaseURL: baseURL,
+		client:  providerHTTPClient,
 	}
 }
 
-func (p *OpenAIProvider) ChatStream(messages []models.Message, writer io.Writer) error {
-	url := fmt.Sprintf("%s/chat/completions", p.BaseURL)
-
-	// 将消息数组转换为API格式
-	apiMessages := make([]map[string]string, len(messages))
+// openAIMessages 将消息数组转换为API格式，包括图片、助手的工具调用和tool角色的工具结果
+func openAIMessages(messages []models.Message) []map[string]interface{} {
+	apiMessages := make([]map[string]interface{}, len(messages))
 	for i, msg := range messages {
-		apiMessages[i] = map[string]string{
+		apiMessage := map[string]interface{}{
 			"role":    msg.Role,
 			"content": msg.Content,
 		}
+		if hasImages(msg) {
+			apiMessage["content"] = openAIContentParts(msg)
+		}
+		if len(msg.ToolCalls) > 0 {
+			calls := make([]map[string]interface{}, len(msg.ToolCalls))
+			for j, call := range msg.ToolCalls {
+				calls[j] = map[string]interface{}{
+					"id":       call.ID,
+					"type":     "function",
+					"function": map[string]string{"name": call.Name, "arguments": call.Arguments},
+				}
+			}
+			apiMessage["tool_calls"] = calls
+		}
+		if msg.ToolCallID != "" {
+			apiMessage["tool_call_id"] = msg.ToolCallID
+		}
+		apiMessages[i] = apiMessage
+	}
+	return apiMessages
+}
+
+// openAIContentParts 将包含图片的消息转换为text和image_url片段数组
+func openAIContentParts(msg models.Message) []map[string]interface{} {
+	parts := contentParts(msg)
+	apiParts := make([]map[string]interface{}, 0, len(parts))
+	for _, part := range parts {
+		switch part.Type {
+		case models.PartText:
+			apiParts = append(apiParts, map[string]interface{}{"type": "text", "text": part.Text})
+		case models.PartImage:
+			apiParts = append(apiParts, map[string]interface{}{
+				"type":      "image_url",
+				"image_url": map[string]string{"url": imageURL(part)},
+			})
+		}
+	}
+	return apiParts
+}
+
+// openAITools 将工具声明转换为API格式
+func openAITools(tools []ToolDefinition) []map[string]interface{} {
+	apiTools := make([]map[string]interface{}, len(tools))
+	for i, tool := range tools {
+		apiTools[i] = map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        tool.Name,
+				"description": tool.Description,
+				"parameters":  tool.Parameters,
+			},
+		}
 	}
+	return apiTools
+}
+
+func (p *OpenAIProvider) ChatStream(ctx context.Context, messages []models.Message, writer io.Writer) error {
+	_, err := p.stream(ctx, messages, nil, writer)
+	return err
+}
+
+// ChatStreamWithTools 携带工具声明的流式聊天，返回模型要求的工具调用
+func (p *OpenAIProvider) ChatStreamWithTools(ctx context.Context, messages []models.Message, tools []ToolDefinition, writer io.Writer) ([]models.ToolCall, error) {
+	return p.stream(ctx, messages, tools, writer)
+}
+
+// stream 发送流式请求并解析响应
+func (p *OpenAIProvider) stream(ctx context.Context, messages []models.Message, tools []ToolDefinition, writer io.Writer) ([]models.ToolCall, error) {
+	url := fmt.Sprintf("%s/chat/completions", p.BaseURL)
 
 	payload := map[string]interface{}{
-		"model":    "deepseek-chat",
-		"messages": apiMessages,
+		"model":    openAIModel,
+		"messages": openAIMessages(messages),
 		"stream":   true,
+		// 要求在最后一个chunk中返回用量
+		"stream_options": map[string]bool{"include_usage": true},
+	}
+	if len(tools) > 0 {
+		payload["tools"] = openAITools(tools)
 	}
+	p.lastUsage = Usage{Model: openAIModel}
 
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Printf("[OpenAiProvider ChatStream] payload: %s", string(jsonData))
+	// 只记录请求概要，不记录消息内容和图片数据
+	parts := 0
+	for _, message := range messages {
+		parts += len(message.Parts)
+	}
+	log.Printf("[OpenAiProvider ChatStream] model=%s messages=%d parts=%d payload_bytes=%d", openAIModel, len(messages), parts, len(jsonData))
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.APIKey))
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("openai api error: %s", string(body))
-	}
-
-	buffer := make([]byte, 4096)
-	for {
-		n, err := resp.Body.Read(buffer)
-		if n > 0 {
-			data := string(buffer[:n])
-			lines := parseSSE(data)
-			for _, line := range lines {
-				if line == "" || line == "[DONE]" {
-					continue
-				}
+		return nil, &APIError{Provider: "openai", StatusCode: resp.StatusCode, Body: string(body)}
+	}
 
-				var streamResp struct {
-					Choices []struct {
-						Delta struct {
-							Content string `json:"content"`
-						} `json:"delta"`
-					} `json:"choices"`
-				}
+	return readOpenAIStream("openai", resp.Body, writer, &p.lastUsage)
+}
 
-				if err := json.Unmarshal([]byte(line), &streamResp); err != nil {
-					continue
-				}
+// openAIStreamChunk OpenAI兼容接口的流式chunk
+type openAIStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"` // 推理模型（如deepseek-reasoner）的思考过程
+			ToolCalls        []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
 
-				if len(streamResp.Choices) > 0 && streamResp.Choices[0].Delta.Content != "" {
-					_, _ = writer.Write([]byte(streamResp.Choices[0].Delta.Content))
-				}
+// readOpenAIStream 解析OpenAI兼容接口的SSE流，写出回答和推理增量并记录用量和结束原因
+// 工具调用的参数分多个chunk按index增量返回，拼接完整后随结果返回
+// 所有OpenAI兼容的服务提供者共用
+func readOpenAIStream(provider string, body io.Reader, writer io.Writer, usage *Usage) ([]models.ToolCall, error) {
+	var calls []models.ToolCall
+	err := readSSE(body, func(event *SSEEvent) error {
+		if event.Data == "[DONE]" {
+			return errSSEStop
+		}
+
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			log.Printf("[%s] skip malformed stream chunk: %v", provider, err)
+			return nil
+		}
+		if chunk.Error != nil {
+			errType := chunk.Error.Type
+			if errType == "" {
+				errType = chunk.Error.Code
 			}
+			return &StreamError{Provider: provider, Type: errType, Message: chunk.Error.Message}
+		}
+
+		if chunk.Usage != nil {
+			usage.InputTokens = chunk.Usage.PromptTokens
+			usage.OutputTokens = chunk.Usage.CompletionTokens
+		}
+		if len(chunk.Choices) == 0 {
+			return nil
 		}
-		if err == io.EOF {
-			break
+		choice := chunk.Choices[0]
+		if reason := choice.FinishReason; reason != nil && *reason != "" {
+			usage.FinishReason = *reason
 		}
-		if err != nil {
+		for _, delta := range choice.Delta.ToolCalls {
+			for len(calls) <= delta.Index {
+				calls = append(calls, models.ToolCall{})
+			}
+			call := &calls[delta.Index]
+			if delta.ID != "" {
+				call.ID = delta.ID
+			}
+			call.Name += delta.Function.Name
+			call.Arguments += delta.Function.Arguments
+		}
+		if err := WriteReasoning(writer, choice.Delta.ReasoningContent); err != nil {
 			return err
 		}
-	}
-
-	return nil
-}
-
-func parseSSE(data string) []string {
-	var lines []string
-	current := ""
-
-	for _, char := range data {
-		if char == '\n' {
-			if current != "" {
-				if len(current) > 6 && current[:6] == "data: " {
-					lines = append(lines, current[6:])
-				}
-				current = ""
+		if content := choice.Delta.Content; content != "" {
+			if _, err := writer.Write([]byte(content)); err != nil {
+				return err
 			}
-		} else {
-			current += string(char)
 		}
-	}
-	if current != "" && len(current) > 6 && current[:6] == "data: " {
-		lines = append(lines, current[6:])
-	}
+		return nil
+	})
+	return calls, err
+}
 
-	return lines
+// LastUsage 最近一次调用的用量
+func (p *OpenAIProvider) LastUsage() Usage {
+	return p.lastUsage
 }
 
 // Chat 非流式聊天，用于生成标题等场景
-func (p *OpenAIProvider) Chat(messages []models.Message) (string, error) {
-	url := fmt.Sprintf("%s/chat/completions", p.BaseURL)
+func (p *OpenAIProvider) Chat(ctx context.Context, messages []models.Message) (string, error) {
+	return p.complete(ctx, messages, nil)
+}
 
-	// 将消息数组转换为API格式
-	apiMessages := make([]map[string]string, len(messages))
-	for i, msg := range messages {
-		apiMessages[i] = map[string]string{
-			"role":    msg.Role,
-			"content": msg.Content,
-		}
-	}
+// ChatJSONMode 使用response_format要求返回JSON对象
+// 只使用兼容性最好的json_object模式（DeepSeek等兼容接口不支持json_schema），Schema由提示词描述
+func (p *OpenAIProvider) ChatJSONMode(ctx context.Context, messages []models.Message, schema JSONSchema) (string, error) {
+	return p.complete(ctx, messages, map[string]string{"type": "json_object"})
+}
+
+// complete 发送非流式请求，responseFormat非空时作为response_format
+func (p *OpenAIProvider) complete(ctx context.Context, messages []models.Message, responseFormat map[string]string) (string, error) {
+	url := fmt.Sprintf("%s/chat/completions", p.BaseURL)
 
 	payload := map[string]interface{}{
-		"model":    "deepseek-chat",
-		"messages": apiMessages,
+		"model":    openAIModel,
+		"messages": openAIMessages(messages),
 		"stream":   false,
 	}
+	if responseFormat != nil {
+		payload["response_format"] = responseFormat
+	}
+	p.lastUsage = Usage{Model: openAIModel}
 
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return "", err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return "", err
 	}
@@ -162,8 +285,7 @@ func (p *OpenAIProvider) Chat(messages []models.Message) (string, error) {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.APIKey))
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -171,7 +293,7 @@ func (p *OpenAIProvider) Chat(messages []models.Message) (string, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("openai api error: %s", string(body))
+		return "", &APIError{Provider: "openai", StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	var result struct {
@@ -179,14 +301,19 @@ func (p *OpenAIProvider) Chat(messages []models.Message) (string, error) {
 			Message struct {
 				Content string `json:"content"`
 			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
 		} `json:"choices"`
+		Usage openAIUsage `json:"usage"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return "", err
 	}
+	p.lastUsage.InputTokens = result.Usage.PromptTokens
+	p.lastUsage.OutputTokens = result.Usage.CompletionTokens
 
 	if len(result.Choices) > 0 {
+		p.lastUsage.FinishReason = result.Choices[0].FinishReason
 		return result.Choices[0].Message.Content, nil
 	}
 
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"grandma/backend/models"
+	"grandma/backend/services/llmtest"
+	"strings"
+	"testing"
+)
+
+var testMessages = []models.Message{
+	{Role: "system", Content: "be brief"},
+	{Role: "user", Content: "hello"},
+}
+
+func TestOpenAIChatStream(t *testing.T) {
+	server := llmtest.NewServer("你好", "，", "world")
+	server.InputTokens, server.OutputTokens = 11, 4
+	defer server.Close()
+
+	provider := NewOpenAIProvider("sk-test", server.URL)
+	var out strings.Builder
+	if err := provider.ChatStream(context.Background(), testMessages, &out); err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if out.String() != "你好，world" {
+		t.Errorf("output: got %q", out.String())
+	}
+	if usage := provider.LastUsage(); usage.InputTokens != 11 || usage.OutputTokens != 4 || usage.Model != "deepseek-chat" {
+		t.Errorf("usage: %+v", usage)
+	}
+
+	req := server.LastRequest()
+	if req.Path != "/chat/completions" || req.Header.Get("Authorization") != "Bearer sk-test" {
+		t.Errorf("request: path=%s auth=%s", req.Path, req.Header.Get("Authorization"))
+	}
+	if req.Body["stream"] != true || req.Body["model"] != "deepseek-chat" {
+		t.Errorf("request body: %v", req.Body)
+	}
+	if messages, _ := req.Body["messages"].([]interface{}); len(messages) != 2 {
+		t.Errorf("request messages: %v", req.Body["messages"])
+	}
+}
+
+func TestOpenAIChat(t *testing.T) {
+	server := llmtest.NewServer("title", " here")
+	server.InputTokens, server.OutputTokens = 7, 2
+	defer server.Close()
+
+	provider := NewOpenAIProvider("sk-test", server.URL)
+	reply, err := provider.Chat(context.Background(), testMessages)
+	if err != nil || reply != "title here" {
+		t.Fatalf("Chat: %q, %v", reply, err)
+	}
+	if usage := provider.LastUsage(); usage.InputTokens != 7 || usage.OutputTokens != 2 {
+		t.Errorf("usage: %+v", usage)
+	}
+	if server.LastRequest().Body["stream"] != false {
+		t.Errorf("non-stream request body: %v", server.LastRequest().Body)
+	}
+}
+
+func TestOpenAIErrorStatus(t *testing.T) {
+	server := llmtest.NewServer()
+	server.Status, server.ErrorBody = 503, "overloaded"
+	defer server.Close()
+
+	provider := NewOpenAIProvider("sk-test", server.URL)
+	err := provider.ChatStream(context.Background(), testMessages, &strings.Builder{})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 503 || !strings.Contains(apiErr.Body, "overloaded") {
+		t.Fatalf("expected APIError 503, got %v", err)
+	}
+	if !IsTransientError(err) {
+		t.Error("503 should be transient")
+	}
+
+	server.Status = 401
+	if _, err := provider.Chat(context.Background(), testMessages); IsTransientError(err) || !errors.As(err, &apiErr) {
+		t.Errorf("401 should be a permanent APIError, got %v", err)
+	}
+}
+
+func TestOpenAIStreamDropped(t *testing.T) {
+	server := llmtest.NewServer("a", "b", "c")
+	server.Drop, server.DropAfter = true, 2
+	defer server.Close()
+
+	var out strings.Builder
+	err := NewOpenAIProvider("sk-test", server.URL).ChatStream(context.Background(), testMessages, &out)
+	if err == nil {
+		t.Fatal("expected error when connection drops mid-stream")
+	}
+	if out.String() != "ab" {
+		t.Errorf("output before drop: got %q", out.String())
+	}
+}
+
+func TestOpenAIChatStreamFragmented(t *testing.T) {
+	server := llmtest.NewServer("春眠", "不觉晓，", "处处闻啼鸟。")
+	server.InputTokens, server.OutputTokens = 3, 9
+	server.WriteSize = 5
+	defer server.Close()
+
+	provider := NewOpenAIProvider("sk-test", server.URL)
+	var out strings.Builder
+	if err := provider.ChatStream(context.Background(), testMessages, &out); err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if out.String() != "春眠不觉晓，处处闻啼鸟。" {
+		t.Errorf("output: got %q", out.String())
+	}
+	if usage := provider.LastUsage(); usage.InputTokens != 3 || usage.OutputTokens != 9 || usage.FinishReason != "stop" {
+		t.Errorf("usage: %+v", usage)
+	}
+}
+
+func TestReadOpenAIStreamErrorEvent(t *testing.T) {
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"partial\"}}]}\n\n" +
+		"data: {\"error\":{\"type\":\"server_error\",\"message\":\"upstream failed\"}}\n\n"
+
+	var out strings.Builder
+	var usage Usage
+	_, err := readOpenAIStream("openai", strings.NewReader(stream), &out, &usage)
+
+	var streamErr *StreamError
+	if !errors.As(err, &streamErr) || streamErr.Message != "upstream failed" || !IsTransientError(err) {
+		t.Fatalf("expected transient StreamError, got %v", err)
+	}
+	if out.String() != "partial" {
+		t.Errorf("output before error: %q", out.String())
+	}
+}
@@ -1,31 +1,114 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"grandma/backend/models"
 	"io"
+	"net/http"
 )
 
+// 支持的服务商名称
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderGemini    = "gemini"
+	ProviderOllama    = "ollama"
+)
+
+// ProviderSettings 创建服务商实例所需的配置
+type ProviderSettings struct {
+	APIKey  string
+	BaseURL string
+	Model   string // 为空时使用服务商的默认模型
+	// Restricted 为true时BaseURL由用户提供：只连接公网地址且不跟随重定向
+	Restricted bool
+}
+
+// httpClient 按配置选择访问服务商的HTTP客户端
+func (s ProviderSettings) httpClient() *http.Client {
+	if s.Restricted {
+		return restrictedHTTPClient
+	}
+	return providerHTTPClient
+}
+
 // ChatProvider 聊天服务提供者接口
 type ChatProvider interface {
-	ChatStream(messages []models.Message, writer io.Writer) error
-	Chat(messages []models.Message) (string, error) // 非流式，用于生成标题等场景
+	ChatStream(ctx context.Context, messages []models.Message, writer io.Writer) error
+	Chat(ctx context.Context, messages []models.Message) (string, error) // 非流式，用于生成标题等场景
 }
 
-// GetProvider 获取聊天服务提供者
-func GetProvider(providerName, openaiKey, openaiURL, anthropicKey, anthropicURL string) (ChatProvider, error) {
-	switch providerName {
+// ResolveProviderName 将请求中的模型名映射为服务商名称，不支持时返回空字符串
+func ResolveProviderName(model string) string {
+	switch model {
 	case "openai", "gpt-3.5-turbo", "gpt-4":
-		if openaiKey == "" {
+		return ProviderOpenAI
+	case "anthropic", "claude":
+		return ProviderAnthropic
+	case "gemini":
+		return ProviderGemini
+	case "ollama", "local":
+		return ProviderOllama
+	case "mock":
+		return ProviderMock
+	default:
+		return ""
+	}
+}
+
+// leadingUserTurn 对话以助手消息开头时补在最前面的用户消息
+const leadingUserTurn = "（接上文）"
+
+// RequiresAPIKey 服务商是否必须配置API Key（本地模型和模拟服务商不需要）
+func RequiresAPIKey(providerName string) bool {
+	return providerName != ProviderOllama && providerName != ProviderMock
+}
+
+// NewChatProvider 使用指定的配置创建服务商实例
+func NewChatProvider(providerName string, settings ProviderSettings) (ChatProvider, error) {
+	switch providerName {
+	case ProviderOpenAI:
+		if settings.APIKey == "" {
 			return nil, fmt.Errorf("OpenAI API key is not configured")
 		}
-		return NewOpenAIProvider(openaiKey, openaiURL), nil
-	case "anthropic", "claude":
-		if anthropicKey == "" {
+		provider := NewOpenAIProvider(settings.APIKey, settings.BaseURL)
+		provider.client = settings.httpClient()
+		return provider, nil
+	case ProviderAnthropic:
+		if settings.APIKey == "" {
 			return nil, fmt.Errorf("Anthropic API key is not configured")
 		}
-		return NewAnthropicProvider(anthropicKey, anthropicURL), nil
+		provider := NewAnthropicProvider(settings.APIKey, settings.BaseURL)
+		provider.client = settings.httpClient()
+		return provider, nil
+	case ProviderGemini:
+		if settings.APIKey == "" {
+			return nil, fmt.Errorf("Gemini API key is not configured")
+		}
+		provider := NewGeminiProvider(settings.APIKey, settings.BaseURL, settings.Model)
+		provider.client = settings.httpClient()
+		return provider, nil
+	case ProviderOllama:
+		if settings.BaseURL == "" {
+			return nil, fmt.Errorf("Ollama base URL is not configured")
+		}
+		provider := NewOllamaProvider(settings.APIKey, settings.BaseURL, settings.Model)
+		provider.client = settings.httpClient()
+		return provider, nil
+	case ProviderMock:
+		// 模拟服务商不需要API Key
+		return newConfiguredMockProvider()
 	default:
 		return nil, fmt.Errorf("unsupported provider: %s", providerName)
 	}
 }
+
+// GetProvider 获取聊天服务提供者，settings按服务商名称提供配置
+func GetProvider(providerName string, settings map[string]ProviderSettings) (ChatProvider, error) {
+	name := ResolveProviderName(providerName)
+	if name == "" {
+		return nil, fmt.Errorf("unsupported provider: %s", providerName)
+	}
+	return NewChatProvider(name, settings[name])
+}
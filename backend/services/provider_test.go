@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"grandma/backend/services/llmtest"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRestrictedProviderRejectsPrivateAddresses(t *testing.T) {
+	server := llmtest.NewServer("ok")
+	defer server.Close()
+
+	// 服务端配置的地址可以是本机
+	provider, err := NewChatProvider(ProviderOpenAI, ProviderSettings{APIKey: "sk-test", BaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewChatProvider: %v", err)
+	}
+	if reply, err := provider.Chat(context.Background(), testMessages); err != nil || reply != "ok" {
+		t.Fatalf("server base URL: %q %v", reply, err)
+	}
+
+	// 用户提供的地址在连接时检查实际IP：域名解析到本机（DNS重绑定）同样被拒绝，且不是可重试的错误
+	for _, baseURL := range []string{server.URL, strings.Replace(server.URL, "127.0.0.1", "localhost", 1)} {
+		provider, err := NewChatProvider(ProviderOpenAI, ProviderSettings{APIKey: "sk-test", BaseURL: baseURL, Restricted: true})
+		if err != nil {
+			t.Fatalf("NewChatProvider: %v", err)
+		}
+		_, err = provider.Chat(context.Background(), testMessages)
+		if !errors.Is(err, ErrPrivateAddress) || IsTransientError(err) {
+			t.Errorf("%s: expected private address error, got %v", baseURL, err)
+		}
+	}
+	if n := len(server.Requests()); n != 1 {
+		t.Errorf("expected only the unrestricted request to reach the server, got %d", n)
+	}
+
+	for address, public := range map[string]bool{
+		"8.8.8.8:443":           true,
+		"[2001:4860::8888]:443": true,
+		"127.0.0.1:80":          false,
+		"10.1.2.3:80":           false,
+		"192.168.1.1:80":        false,
+		"169.254.169.254:80":    false,
+		"0.0.0.0:80":            false,
+		"[::1]:80":              false,
+		"[fd00::1]:80":          false,
+	} {
+		if err := rejectPrivateAddress("tcp", address, nil); (err == nil) != public {
+			t.Errorf("%s: got %v", address, err)
+		}
+	}
+
+	// 不跟随重定向，3xx作为普通响应返回
+	req, _ := http.NewRequest(http.MethodPost, "https://api.example.com/v1/chat/completions", nil)
+	if err := restrictedHTTPClient.CheckRedirect(req, []*http.Request{req}); err != http.ErrUseLastResponse {
+		t.Errorf("redirect: got %v", err)
+	}
+}
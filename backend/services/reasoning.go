@@ -0,0 +1,21 @@
+package services
+
+import "io"
+
+// ReasoningWriter 能够单独接收推理过程（思考内容）的writer
+// 服务提供者把推理增量写入WriteReasoning，把回答增量写入Write，两者互不混合
+type ReasoningWriter interface {
+	WriteReasoning(p []byte) (int, error)
+}
+
+// WriteReasoning 把推理内容写入writer，writer不支持推理通道时直接丢弃
+func WriteReasoning(writer io.Writer, text string) error {
+	if text == "" {
+		return nil
+	}
+	if rw, ok := writer.(ReasoningWriter); ok {
+		_, err := rw.WriteReasoning([]byte(text))
+		return err
+	}
+	return nil
+}
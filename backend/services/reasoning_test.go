@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"grandma/backend/services/llmtest"
+	"strings"
+	"testing"
+)
+
+// reasoningRecorder 分别记录回答和推理内容
+type reasoningRecorder struct {
+	answer    strings.Builder
+	reasoning strings.Builder
+}
+
+func (r *reasoningRecorder) Write(p []byte) (int, error) {
+	return r.answer.Write(p)
+}
+
+func (r *reasoningRecorder) WriteReasoning(p []byte) (int, error) {
+	return r.reasoning.Write(p)
+}
+
+func TestProvidersSeparateReasoning(t *testing.T) {
+	server := llmtest.NewServer("答案", "是42")
+	server.Reasoning = []string{"先想想，", "再算一算。"}
+	defer server.Close()
+
+	providers := map[string]ChatProvider{
+		"openai":    NewOpenAIProvider("sk", server.URL),
+		"anthropic": NewAnthropicProvider("sk", server.URL),
+		"gemini":    NewGeminiProvider("sk", server.URL, ""),
+		"ollama":    NewOllamaProvider("", server.URL, ""),
+		"mock":      NewMockProvider(&MockOptions{Responses: []string{"答案是42"}, Reasoning: "先想想，再算一算。"}),
+	}
+	for name, provider := range providers {
+		var out reasoningRecorder
+		if err := provider.ChatStream(context.Background(), testMessages, &out); err != nil {
+			t.Fatalf("%s: ChatStream: %v", name, err)
+		}
+		if out.answer.String() != "答案是42" || out.reasoning.String() != "先想想，再算一算。" {
+			t.Errorf("%s: answer=%q reasoning=%q", name, out.answer.String(), out.reasoning.String())
+		}
+	}
+}
+
+func TestReasoningDroppedForPlainWriter(t *testing.T) {
+	server := llmtest.NewServer("answer")
+	server.Reasoning = []string{"thinking"}
+	defer server.Close()
+
+	var out strings.Builder
+	if err := NewOpenAIProvider("sk", server.URL).ChatStream(context.Background(), testMessages, &out); err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if out.String() != "answer" {
+		t.Errorf("reasoning should not leak into the answer: %q", out.String())
+	}
+}
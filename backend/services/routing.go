@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grandma/backend/models"
+	"io"
+	"log"
+	"time"
+)
+
+// ErrAllBackendsUnavailable 所有后端都处于熔断状态
+var ErrAllBackendsUnavailable = errors.New("all_backends_unavailable")
+
+// RouteBackend 路由中的一个后端
+type RouteBackend struct {
+	Name     string          // 后端名称，记录到文档的Model字段
+	Provider ChatProvider    // 实际的服务提供者
+	Breaker  *CircuitBreaker // 该后端的熔断器（为空时不熔断）
+}
+
+// RoutingOptions 路由重试配置
+type RoutingOptions struct {
+	MaxAttempts int           // 每个后端的最大尝试次数（包括首次）
+	Backoff     time.Duration // 重试前的等待时间，按尝试次数线性增加
+}
+
+// BackendReporter 能够报告实际响应后端的服务提供者
+type BackendReporter interface {
+	ActiveBackend() string
+}
+
+// RoutingProvider 按顺序在多个后端之间故障转移的服务提供者
+// 只有在还没有向客户端输出任何内容时才会重试或切换后端
+type RoutingProvider struct {
+	backends []RouteBackend
+	options  RoutingOptions
+	active   *RouteBackend
+}
+
+// NewRoutingProvider 创建路由服务提供者，backends按优先级排列
+func NewRoutingProvider(backends []RouteBackend, options RoutingOptions) *RoutingProvider {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 1
+	}
+	return &RoutingProvider{
+		backends: backends,
+		options:  options,
+	}
+}
+
+// ChatStream 流式聊天，临时错误且尚未输出内容时重试或切换到下一个后端
+func (p *RoutingProvider) ChatStream(ctx context.Context, messages []models.Message, writer io.Writer) error {
+	return p.route(ctx, func(backend ChatProvider) (bool, error) {
+		counter := &writeCounter{writer: writer}
+		err := backend.ChatStream(ctx, messages, counter)
+		return counter.written > 0, err
+	})
+}
+
+// ChatStreamWithTools 携带工具声明的流式聊天，故障转移规则与ChatStream相同
+func (p *RoutingProvider) ChatStreamWithTools(ctx context.Context, messages []models.Message, tools []ToolDefinition, writer io.Writer) ([]models.ToolCall, error) {
+	var calls []models.ToolCall
+	err := p.route(ctx, func(backend ChatProvider) (bool, error) {
+		counter := &writeCounter{writer: writer}
+		var err error
+		calls, err = ChatStreamWithTools(ctx, backend, messages, tools, counter)
+		return counter.written > 0, err
+	})
+	return calls, err
+}
+
+// Chat 非流式聊天，临时错误时重试或切换到下一个后端
+func (p *RoutingProvider) Chat(ctx context.Context, messages []models.Message) (string, error) {
+	var reply string
+	err := p.route(ctx, func(backend ChatProvider) (bool, error) {
+		var err error
+		reply, err = backend.Chat(ctx, messages)
+		return false, err
+	})
+	return reply, err
+}
+
+// ChatJSONMode 结构化输出，后端支持时使用原生JSON模式，否则使用普通聊天
+func (p *RoutingProvider) ChatJSONMode(ctx context.Context, messages []models.Message, schema JSONSchema) (string, error) {
+	var reply string
+	err := p.route(ctx, func(backend ChatProvider) (bool, error) {
+		var err error
+		if native, ok := backend.(JSONModeProvider); ok {
+			reply, err = native.ChatJSONMode(ctx, messages, schema)
+		} else {
+			reply, err = backend.Chat(ctx, messages)
+		}
+		return false, err
+	})
+	return reply, err
+}
+
+// ActiveBackend 最近一次实际响应的后端名称
+func (p *RoutingProvider) ActiveBackend() string {
+	if p.active == nil {
+		return ""
+	}
+	return p.active.Name
+}
+
+// LastUsage 最近一次实际响应的后端的用量
+func (p *RoutingProvider) LastUsage() Usage {
+	if p.active == nil {
+		return Usage{}
+	}
+	if reporter, ok := p.active.Provider.(UsageReporter); ok {
+		return reporter.LastUsage()
+	}
+	return Usage{}
+}
+
+// route 按顺序尝试各个后端
+// call 返回是否已经向客户端输出过内容；一旦输出过内容，无论成功与否都不再重试
+func (p *RoutingProvider) route(ctx context.Context, call func(ChatProvider) (bool, error)) error {
+	p.active = nil
+	var lastErr error
+
+	for i := range p.backends {
+		backend := &p.backends[i]
+		if backend.Breaker != nil && !backend.Breaker.Allow() {
+			log.Printf("[RoutingProvider] skip backend %s: circuit open", backend.Name)
+			continue
+		}
+
+		for attempt := 1; attempt <= p.options.MaxAttempts; attempt++ {
+			if attempt > 1 && p.options.Backoff > 0 {
+				time.Sleep(time.Duration(attempt-1) * p.options.Backoff)
+			}
+			if err := ctx.Err(); err != nil {
+				// 调用方已取消或超时，不再重试
+				p.active = nil
+				return err
+			}
+
+			p.active = backend
+			streamed, err := call(backend.Provider)
+			if err == nil || !IsTransientError(err) {
+				// 服务商正常响应（包括请求本身有误的4xx），后端是健康的
+				if backend.Breaker != nil {
+					backend.Breaker.RecordSuccess()
+				}
+				return err
+			}
+
+			if backend.Breaker != nil {
+				backend.Breaker.RecordFailure()
+			}
+			if streamed {
+				// 已经输出了部分内容，无法透明地重试
+				return err
+			}
+
+			log.Printf("[RoutingProvider] backend %s attempt %d failed: %v", backend.Name, attempt, err)
+			lastErr = err
+			if backend.Breaker != nil && backend.Breaker.State() == BreakerOpen {
+				break
+			}
+		}
+	}
+
+	p.active = nil
+	if lastErr == nil {
+		return ErrAllBackendsUnavailable
+	}
+	return fmt.Errorf("all backends failed: %w", lastErr)
+}
+
+// writeCounter 转发写入并记录已输出的字节数
+type writeCounter struct {
+	writer  io.Writer
+	written int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.written += n
+	return n, err
+}
+
+// WriteReasoning 转发推理内容，推理内容同样算作已输出
+func (w *writeCounter) WriteReasoning(p []byte) (int, error) {
+	w.written += len(p)
+	return len(p), WriteReasoning(w.writer, string(p))
+}
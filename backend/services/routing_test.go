@@ -0,0 +1,205 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"grandma/backend/models"
+	"io"
+	"testing"
+	"time"
+)
+
+// scriptedProvider 按顺序返回预设结果的服务提供者
+type scriptedProvider struct {
+	results []scriptedResult
+	calls   int
+}
+
+type scriptedResult struct {
+	output string
+	err    error
+}
+
+func (p *scriptedProvider) next() scriptedResult {
+	result := p.results[len(p.results)-1]
+	if p.calls < len(p.results) {
+		result = p.results[p.calls]
+	}
+	p.calls++
+	return result
+}
+
+func (p *scriptedProvider) ChatStream(ctx context.Context, messages []models.Message, writer io.Writer) error {
+	result := p.next()
+	if result.output != "" {
+		writer.Write([]byte(result.output))
+	}
+	return result.err
+}
+
+func (p *scriptedProvider) Chat(ctx context.Context, messages []models.Message) (string, error) {
+	result := p.next()
+	return result.output, result.err
+}
+
+var (
+	errUnavailable = &APIError{Provider: "test", StatusCode: 503, Body: "unavailable"}
+	errBadRequest  = &APIError{Provider: "test", StatusCode: 400, Body: "bad request"}
+)
+
+func TestIsTransientError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("boom"), false},
+		{&APIError{StatusCode: 429}, true},
+		{&APIError{StatusCode: 500}, true},
+		{&APIError{StatusCode: 502}, true},
+		{&APIError{StatusCode: 401}, false},
+		{errBadRequest, false},
+	}
+	for _, tc := range cases {
+		if got := IsTransientError(tc.err); got != tc.want {
+			t.Errorf("IsTransientError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRoutingRetriesThenFallsBack(t *testing.T) {
+	primary := &scriptedProvider{results: []scriptedResult{{err: errUnavailable}}}
+	secondary := &scriptedProvider{results: []scriptedResult{{output: "hello"}}}
+	router := NewRoutingProvider([]RouteBackend{
+		{Name: "primary", Provider: primary},
+		{Name: "secondary", Provider: secondary},
+	}, RoutingOptions{MaxAttempts: 2})
+
+	var out bytes.Buffer
+	if err := router.ChatStream(context.Background(), nil, &out); err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+	if out.String() != "hello" || router.ActiveBackend() != "secondary" {
+		t.Errorf("got %q from %q", out.String(), router.ActiveBackend())
+	}
+	if primary.calls != 2 || secondary.calls != 1 {
+		t.Errorf("calls: primary=%d secondary=%d", primary.calls, secondary.calls)
+	}
+}
+
+func TestRoutingRetriesSameBackend(t *testing.T) {
+	primary := &scriptedProvider{results: []scriptedResult{{err: errUnavailable}, {output: "ok"}}}
+	router := NewRoutingProvider([]RouteBackend{{Name: "primary", Provider: primary}}, RoutingOptions{MaxAttempts: 3})
+
+	reply, err := router.Chat(context.Background(), nil)
+	if err != nil || reply != "ok" || primary.calls != 2 {
+		t.Errorf("got %q, %v after %d calls", reply, err, primary.calls)
+	}
+}
+
+func TestRoutingDoesNotRetryAfterStreaming(t *testing.T) {
+	primary := &scriptedProvider{results: []scriptedResult{{output: "partial", err: errUnavailable}}}
+	secondary := &scriptedProvider{results: []scriptedResult{{output: "hello"}}}
+	router := NewRoutingProvider([]RouteBackend{
+		{Name: "primary", Provider: primary},
+		{Name: "secondary", Provider: secondary},
+	}, RoutingOptions{MaxAttempts: 2})
+
+	var out bytes.Buffer
+	if err := router.ChatStream(context.Background(), nil, &out); !errors.Is(err, errUnavailable) {
+		t.Fatalf("expected upstream error, got %v", err)
+	}
+	if out.String() != "partial" || primary.calls != 1 || secondary.calls != 0 {
+		t.Errorf("output %q, calls primary=%d secondary=%d", out.String(), primary.calls, secondary.calls)
+	}
+}
+
+func TestRoutingDoesNotRetryPermanentErrors(t *testing.T) {
+	primary := &scriptedProvider{results: []scriptedResult{{err: errBadRequest}}}
+	secondary := &scriptedProvider{results: []scriptedResult{{output: "hello"}}}
+	router := NewRoutingProvider([]RouteBackend{
+		{Name: "primary", Provider: primary},
+		{Name: "secondary", Provider: secondary},
+	}, RoutingOptions{MaxAttempts: 3})
+
+	if _, err := router.Chat(context.Background(), nil); !errors.Is(err, errBadRequest) {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+	if primary.calls != 1 || secondary.calls != 0 {
+		t.Errorf("calls: primary=%d secondary=%d", primary.calls, secondary.calls)
+	}
+}
+
+func TestRoutingSkipsOpenCircuit(t *testing.T) {
+	breaker := NewCircuitBreaker(2, time.Minute)
+	primary := &scriptedProvider{results: []scriptedResult{{err: errUnavailable}}}
+	secondary := &scriptedProvider{results: []scriptedResult{{output: "hello"}}}
+	router := NewRoutingProvider([]RouteBackend{
+		{Name: "primary", Provider: primary, Breaker: breaker},
+		{Name: "secondary", Provider: secondary},
+	}, RoutingOptions{MaxAttempts: 5})
+
+	// 连续两次失败后熔断，不再继续重试
+	if _, err := router.Chat(context.Background(), nil); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if primary.calls != 2 || breaker.State() != BreakerOpen {
+		t.Fatalf("primary calls=%d state=%s", primary.calls, breaker.State())
+	}
+
+	// 熔断期间直接跳过
+	if _, err := router.Chat(context.Background(), nil); err != nil {
+		t.Fatalf("Chat: %v", err)
+	}
+	if primary.calls != 2 || secondary.calls != 2 {
+		t.Errorf("calls: primary=%d secondary=%d", primary.calls, secondary.calls)
+	}
+}
+
+func TestRoutingAllBackendsDown(t *testing.T) {
+	breaker := NewCircuitBreaker(1, time.Minute)
+	breaker.RecordFailure()
+	router := NewRoutingProvider([]RouteBackend{
+		{Name: "primary", Provider: &scriptedProvider{results: []scriptedResult{{output: "x"}}}, Breaker: breaker},
+	}, RoutingOptions{})
+
+	if _, err := router.Chat(context.Background(), nil); !errors.Is(err, ErrAllBackendsUnavailable) {
+		t.Errorf("expected ErrAllBackendsUnavailable, got %v", err)
+	}
+	if router.ActiveBackend() != "" {
+		t.Errorf("active backend should be empty, got %q", router.ActiveBackend())
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(1, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	breaker.RecordFailure()
+	if breaker.Allow() {
+		t.Fatal("open breaker should reject")
+	}
+
+	now = now.Add(time.Minute)
+	if !breaker.Allow() {
+		t.Fatal("breaker should allow a probe after cooldown")
+	}
+	if breaker.Allow() {
+		t.Fatal("only one probe is allowed while half open")
+	}
+
+	// 探测失败重新熔断
+	breaker.RecordFailure()
+	if breaker.State() != BreakerOpen || breaker.Allow() {
+		t.Fatalf("failed probe should reopen, state=%s", breaker.State())
+	}
+
+	now = now.Add(time.Minute)
+	breaker.Allow()
+	breaker.RecordSuccess()
+	if breaker.State() != BreakerClosed || !breaker.Allow() {
+		t.Fatalf("successful probe should close, state=%s", breaker.State())
+	}
+}
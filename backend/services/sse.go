@@ -0,0 +1,106 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// SSEEvent 一个Server-Sent Events事件
+type SSEEvent struct {
+	Event string // event字段，未设置时为空
+	Data  string // 多行data按换行拼接
+	ID    string // 最近一次出现的id字段
+}
+
+// SSEDecoder 增量的SSE解码器
+// 按行读取响应体，跨多次网络读取的行和多字节字符都能完整拼接
+type SSEDecoder struct {
+	reader *bufio.Reader
+	lastID string
+}
+
+// NewSSEDecoder 创建SSE解码器
+func NewSSEDecoder(r io.Reader) *SSEDecoder {
+	return &SSEDecoder{reader: bufio.NewReader(r)}
+}
+
+// Next 读取下一个事件，流结束时返回io.EOF
+// 连接异常断开时返回底层读取错误（如io.ErrUnexpectedEOF）
+func (d *SSEDecoder) Next() (*SSEEvent, error) {
+	var event SSEEvent
+	var data []string
+
+	for {
+		line, err := d.reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if err == io.EOF && line == "" {
+			if len(data) > 0 {
+				// 流结束时没有空行结尾的事件，宽松处理为已完成
+				event.Data = strings.Join(data, "\n")
+				event.ID = d.lastID
+				return &event, nil
+			}
+			return nil, io.EOF
+		}
+
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		// 空行表示事件结束
+		if line == "" {
+			if len(data) == 0 {
+				event = SSEEvent{}
+				continue
+			}
+			event.Data = strings.Join(data, "\n")
+			event.ID = d.lastID
+			return &event, nil
+		}
+
+		// 冒号开头为注释，常用于保活
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "event":
+			event.Event = value
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				d.lastID = value
+			}
+		}
+	}
+}
+
+// errSSEStop 在回调中返回以提前结束读取
+var errSSEStop = errors.New("sse_stop")
+
+// readSSE 依次把事件交给handle处理，直到流结束或handle返回errSSEStop
+func readSSE(r io.Reader, handle func(*SSEEvent) error) error {
+	decoder := NewSSEDecoder(r)
+	for {
+		event, err := decoder.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := handle(event); err != nil {
+			if err == errSSEStop {
+				return nil
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSSEDecoder(t *testing.T) {
+	stream := ": keep-alive\r\n" +
+		"event: greeting\r\n" +
+		"id: 1\r\n" +
+		"data: 第一行\r\n" +
+		"data:第二行\r\n" +
+		"\r\n" +
+		"data: {\"n\":2}\n" +
+		"\n" +
+		"\n" +
+		"retry: 1000\n" +
+		"data: tail"
+
+	// 逐字节读取，模拟行和多字节字符被拆到多次网络读取中
+	decoder := NewSSEDecoder(iotest.OneByteReader(strings.NewReader(stream)))
+
+	want := []SSEEvent{
+		{Event: "greeting", ID: "1", Data: "第一行\n第二行"},
+		{ID: "1", Data: `{"n":2}`},
+		{ID: "1", Data: "tail"},
+	}
+	for i, expected := range want {
+		event, err := decoder.Next()
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if *event != expected {
+			t.Errorf("event %d: got %+v, want %+v", i, *event, expected)
+		}
+	}
+	if _, err := decoder.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestSSEDecoderReadError(t *testing.T) {
+	decoder := NewSSEDecoder(io.MultiReader(strings.NewReader("data: partial\n"), iotest.ErrReader(io.ErrUnexpectedEOF)))
+	if _, err := decoder.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
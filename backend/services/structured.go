@@ -0,0 +1,239 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grandma/backend/models"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonMaxAttempts 结构化输出校验失败时的最大尝试次数（包括首次）
+const jsonMaxAttempts = 3
+
+// ErrInvalidJSONOutput 多次尝试后模型仍未返回符合Schema的JSON
+var ErrInvalidJSONOutput = errors.New("invalid_json_output")
+
+// JSONSchema 结构化输出的Schema
+type JSONSchema struct {
+	Name        string                 // Schema名称，只能包含字母、数字和下划线
+	Description string                 // 输出内容的说明
+	Schema      map[string]interface{} // JSON Schema（支持type、properties、required、items、enum、maxLength）
+}
+
+// JSONModeProvider 原生支持JSON输出的服务提供者
+// 返回的文本应当是一个JSON值，调用方仍会校验并在必要时要求模型修正
+type JSONModeProvider interface {
+	ChatJSONMode(ctx context.Context, messages []models.Message, schema JSONSchema) (string, error)
+}
+
+// ChatJSON 要求模型按Schema输出JSON并解码到out
+// 服务提供者支持时使用原生JSON模式，否则使用普通聊天；输出无法解析或不符合Schema时，
+// 把错误告诉模型并重试，最多尝试jsonMaxAttempts次
+func ChatJSON(ctx context.Context, provider ChatProvider, messages []models.Message, schema JSONSchema, out interface{}) error {
+	conversation := append([]models.Message{{Role: "system", Content: jsonInstruction(schema)}}, messages...)
+
+	var lastErr error
+	for attempt := 1; attempt <= jsonMaxAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var reply string
+		var err error
+		if native, ok := provider.(JSONModeProvider); ok {
+			reply, err = native.ChatJSONMode(ctx, conversation, schema)
+		} else {
+			reply, err = provider.Chat(ctx, conversation)
+		}
+		if err != nil {
+			return err
+		}
+
+		lastErr = decodeJSONOutput(reply, schema, out)
+		if lastErr == nil {
+			return nil
+		}
+
+		conversation = append(conversation,
+			models.Message{Role: "assistant", Content: reply},
+			models.Message{Role: "user", Content: fmt.Sprintf("上面的输出不符合要求：%v。请只输出符合Schema的JSON，不要包含任何其他文字。", lastErr)},
+		)
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidJSONOutput, lastErr)
+}
+
+// jsonInstruction 描述输出格式的系统提示
+func jsonInstruction(schema JSONSchema) string {
+	schemaJSON, _ := json.Marshal(schema.Schema)
+	var instruction strings.Builder
+	instruction.WriteString("你必须只输出一个JSON值，不要使用Markdown代码块，也不要输出任何解释。")
+	if schema.Description != "" {
+		instruction.WriteString("输出内容：" + schema.Description + "。")
+	}
+	instruction.WriteString("JSON必须符合以下JSON Schema：\n")
+	instruction.Write(schemaJSON)
+	return instruction.String()
+}
+
+// decodeJSONOutput 从模型输出中提取JSON，按Schema校验后解码到out
+func decodeJSONOutput(reply string, schema JSONSchema, out interface{}) error {
+	data := extractJSON(reply)
+	if len(data) == 0 {
+		return errors.New("no JSON found in output")
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("malformed JSON: %v", err)
+	}
+	if err := validateJSON(value, schema.Schema, "$"); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("cannot decode JSON: %v", err)
+	}
+	return nil
+}
+
+// extractJSON 去掉Markdown代码块和前后的说明文字，返回第一个JSON对象或数组
+func extractJSON(reply string) []byte {
+	text := strings.TrimSpace(reply)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+		text = strings.TrimSpace(text)
+	}
+
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return nil
+	}
+	closing := byte('}')
+	if text[start] == '[' {
+		closing = ']'
+	}
+	end := strings.LastIndexByte(text, closing)
+	if end < start {
+		return nil
+	}
+	return []byte(text[start : end+1])
+}
+
+// validateJSON 按Schema校验解码后的JSON值，path用于错误信息
+func validateJSON(value interface{}, schema map[string]interface{}, path string) error {
+	if schema == nil {
+		return nil
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok && !jsonEnumContains(enum, value) {
+		return fmt.Errorf("%s must be one of %v", path, enum)
+	}
+	if enum, ok := schema["enum"].([]string); ok {
+		s, _ := value.(string)
+		found := false
+		for _, e := range enum {
+			found = found || e == s
+		}
+		if !found {
+			return fmt.Errorf("%s must be one of %v", path, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", path)
+		}
+		for _, name := range jsonRequired(schema["required"]) {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, field := range obj {
+			fieldSchema, _ := properties[name].(map[string]interface{})
+			if err := validateJSON(field, fieldSchema, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an array", path)
+		}
+		itemSchema, _ := schema["items"].(map[string]interface{})
+		for i, item := range items {
+			if err := validateJSON(item, itemSchema, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		if maxLength, ok := jsonInt(schema["maxLength"]); ok && utf8.RuneCountInString(s) > maxLength {
+			return fmt.Errorf("%s must be at most %d characters", path, maxLength)
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if _, err := n.Int64(); !ok || err != nil {
+			return fmt.Errorf("%s must be an integer", path)
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return fmt.Errorf("%s must be a number", path)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", path)
+		}
+	}
+	return nil
+}
+
+// jsonRequired 读取required字段，兼容[]string和[]interface{}
+func jsonRequired(value interface{}) []string {
+	switch required := value.(type) {
+	case []string:
+		return required
+	case []interface{}:
+		names := make([]string, 0, len(required))
+		for _, name := range required {
+			if s, ok := name.(string); ok {
+				names = append(names, s)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// jsonInt 读取Schema中的整数约束
+func jsonInt(value interface{}) (int, bool) {
+	switch n := value.(type) {
+	case int:
+		return n, true
+	case float64:
+		return int(n), true
+	}
+	return 0, false
+}
+
+// jsonEnumContains 检查值是否在枚举中
+func jsonEnumContains(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
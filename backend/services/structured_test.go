@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grandma/backend/models"
+	"grandma/backend/services/llmtest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSchema = JSONSchema{
+	Name: "character_card",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{"type": "string", "maxLength": 4},
+			"age":  map[string]interface{}{"type": "integer"},
+			"tags": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"role": map[string]interface{}{"type": "string", "enum": []string{"主角", "配角"}},
+		},
+		"required": []string{"name", "age"},
+	},
+}
+
+type testCard struct {
+	Name string   `json:"name"`
+	Age  int      `json:"age"`
+	Tags []string `json:"tags"`
+	Role string   `json:"role"`
+}
+
+func TestDecodeJSONOutput(t *testing.T) {
+	cases := []struct {
+		reply string
+		err   string
+	}{
+		{`{"name":"林舟","age":17,"tags":["渔村"],"role":"主角"}`, ""},
+		{"好的，结果如下：\n```json\n{\"name\":\"林舟\",\"age\":17}\n```", ""},
+		{`没有JSON`, "no JSON"},
+		{`{"name":"林舟","age":17`, "no JSON"},
+		{`{"name":"林舟"}`, "$.age is required"},
+		{`{"name":"林舟","age":"17"}`, "$.age must be an integer"},
+		{`{"name":"林舟","age":17.5}`, "$.age must be an integer"},
+		{`{"name":"欧阳林舟舟","age":17}`, "$.name must be at most 4 characters"},
+		{`{"name":"林舟","age":17,"tags":["a",1]}`, "$.tags[1] must be a string"},
+		{`{"name":"林舟","age":17,"role":"反派"}`, "$.role must be one of"},
+	}
+	for _, tc := range cases {
+		var card testCard
+		err := decodeJSONOutput(tc.reply, testSchema, &card)
+		if tc.err == "" {
+			if err != nil || card.Name != "林舟" || card.Age != 17 {
+				t.Errorf("%q: got %+v, %v", tc.reply, card, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: got error %v, want %q", tc.reply, err, tc.err)
+		}
+	}
+}
+
+func TestChatJSONRepairsInvalidOutput(t *testing.T) {
+	provider := &jsonReplyProvider{replies: []string{`{"name":"林舟"}`, `{"name":"林舟","age":17}`}}
+
+	var card testCard
+	err := ChatJSON(context.Background(), provider, []models.Message{{Role: "user", Content: "生成人物卡"}}, testSchema, &card)
+	if err != nil || card.Age != 17 {
+		t.Fatalf("ChatJSON: %+v, %v", card, err)
+	}
+	if len(provider.calls) != 2 {
+		t.Fatalf("calls: %d", len(provider.calls))
+	}
+
+	first, retry := provider.calls[0], provider.calls[1]
+	if first[0].Role != "system" || !strings.Contains(first[0].Content, `"required":["name","age"]`) {
+		t.Errorf("schema instruction: %+v", first[0])
+	}
+	last := retry[len(retry)-1]
+	if retry[len(retry)-2].Content != `{"name":"林舟"}` || last.Role != "user" || !strings.Contains(last.Content, "$.age is required") {
+		t.Errorf("repair messages: %+v", retry)
+	}
+}
+
+func TestChatJSONGivesUp(t *testing.T) {
+	provider := &jsonReplyProvider{replies: []string{"不是JSON"}}
+
+	var card testCard
+	err := ChatJSON(context.Background(), provider, []models.Message{{Role: "user", Content: "生成人物卡"}}, testSchema, &card)
+	if !errors.Is(err, ErrInvalidJSONOutput) || len(provider.calls) != jsonMaxAttempts {
+		t.Fatalf("got %v after %d calls", err, len(provider.calls))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ChatJSON(ctx, provider, nil, testSchema, &card); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled context: %v", err)
+	}
+}
+
+func TestChatJSONCancelsStalledRequests(t *testing.T) {
+	// 服务商接受连接后一直不返回响应头，只能靠ctx取消
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	providers := map[string]ChatProvider{
+		"openai":    NewOpenAIProvider("sk-test", server.URL),
+		"anthropic": NewAnthropicProvider("sk-ant", server.URL),
+		"gemini":    NewGeminiProvider("g-key", server.URL, ""),
+		"ollama":    NewOllamaProvider("", server.URL, ""),
+	}
+	for name, provider := range providers {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		var card struct{}
+		err := ChatJSON(ctx, provider, testMessages, testSchema, &card)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected deadline exceeded, got %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: call was not cancelled, took %v", name, elapsed)
+		}
+	}
+
+	// 流式请求同样随ctx取消
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := NewOpenAIProvider("sk-test", server.URL).ChatStream(ctx, testMessages, &strings.Builder{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("stream: expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestChatJSONUsesNativeModes(t *testing.T) {
+	server := llmtest.NewServer(`{"name":"林舟","age":17}`)
+	defer server.Close()
+
+	providers := map[string]ChatProvider{
+		"openai": NewOpenAIProvider("sk", server.URL),
+		"gemini": NewGeminiProvider("g", server.URL, ""),
+		"ollama": NewOllamaProvider("", server.URL, ""),
+	}
+	for name, provider := range providers {
+		var card testCard
+		if err := ChatJSON(context.Background(), provider, testMessages, testSchema, &card); err != nil || card.Name != "林舟" {
+			t.Fatalf("%s: %+v, %v", name, card, err)
+		}
+
+		body := server.LastRequest().Body
+		switch name {
+		case "openai":
+			if format, _ := body["response_format"].(map[string]interface{}); format["type"] != "json_object" {
+				t.Errorf("openai response_format: %v", body["response_format"])
+			}
+		case "gemini":
+			if config, _ := body["generationConfig"].(map[string]interface{}); config["responseMimeType"] != "application/json" {
+				t.Errorf("gemini generationConfig: %v", body["generationConfig"])
+			}
+		case "ollama":
+			if format, _ := body["format"].(map[string]interface{}); format["type"] != "object" {
+				t.Errorf("ollama format: %v", body["format"])
+			}
+		}
+	}
+}
+
+func TestAnthropicChatJSONForcesTool(t *testing.T) {
+	var toolChoice interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		toolChoice = body["tool_choice"]
+		fmt.Fprint(w, `{"content":[{"type":"tool_use","id":"toolu_1","name":"character_card","input":{"name":"林舟","age":17}}],"stop_reason":"tool_use","usage":{"input_tokens":40,"output_tokens":12}}`)
+	}))
+	defer server.Close()
+
+	provider := NewAnthropicProvider("sk-ant", server.URL)
+	var card testCard
+	if err := ChatJSON(context.Background(), provider, testMessages, testSchema, &card); err != nil || card.Age != 17 {
+		t.Fatalf("ChatJSON: %+v, %v", card, err)
+	}
+	if choice, _ := toolChoice.(map[string]interface{}); choice["type"] != "tool" || choice["name"] != "character_card" {
+		t.Errorf("tool_choice: %v", toolChoice)
+	}
+	if usage := provider.LastUsage(); usage.InputTokens != 40 || usage.OutputTokens != 12 {
+		t.Errorf("usage: %+v", usage)
+	}
+}
+
+// jsonReplyProvider 按顺序返回预设回复并记录每次收到的消息，回复用完后重复最后一条
+type jsonReplyProvider struct {
+	replies []string
+	calls   [][]models.Message
+}
+
+func (p *jsonReplyProvider) ChatStream(ctx context.Context, messages []models.Message, writer io.Writer) error {
+	reply, err := p.Chat(ctx, messages)
+	if err == nil {
+		_, err = writer.Write([]byte(reply))
+	}
+	return err
+}
+
+func (p *jsonReplyProvider) Chat(ctx context.Context, messages []models.Message) (string, error) {
+	p.calls = append(p.calls, append([]models.Message(nil), messages...))
+	index := len(p.calls) - 1
+	if index >= len(p.replies) {
+		index = len(p.replies) - 1
+	}
+	return p.replies[index], nil
+}
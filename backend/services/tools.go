@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"grandma/backend/models"
+	"io"
+	"strings"
+)
+
+// ToolDefinition 提供给模型的工具声明
+type ToolDefinition struct {
+	Name        string                 // 工具名称
+	Description string                 // 工具用途说明
+	Parameters  map[string]interface{} // 参数的JSON Schema
+}
+
+// ToolCaller 支持工具调用的服务提供者
+// 回答文本照常写入writer；模型要求调用工具时返回调用列表，返回空列表表示回答已完成
+type ToolCaller interface {
+	ChatStreamWithTools(ctx context.Context, messages []models.Message, tools []ToolDefinition, writer io.Writer) ([]models.ToolCall, error)
+}
+
+// ChatStreamWithTools 使用工具调用流式聊天，服务提供者不支持工具时退化为普通聊天
+func ChatStreamWithTools(ctx context.Context, provider ChatProvider, messages []models.Message, tools []ToolDefinition, writer io.Writer) ([]models.ToolCall, error) {
+	if caller, ok := provider.(ToolCaller); ok && len(tools) > 0 {
+		return caller.ChatStreamWithTools(ctx, messages, tools, writer)
+	}
+	return nil, provider.ChatStream(ctx, FlattenToolMessages(messages), writer)
+}
+
+// FlattenToolMessages 把工具调用和结果转换为普通文本消息，供不支持工具的服务提供者使用
+func FlattenToolMessages(messages []models.Message) []models.Message {
+	flattened := make([]models.Message, 0, len(messages))
+	for _, msg := range messages {
+		switch {
+		case msg.Role == "tool":
+			flattened = append(flattened, models.Message{
+				Role:    "user",
+				Content: fmt.Sprintf("[工具结果 %s]\n%s", msg.ToolCallID, msg.Content),
+			})
+		case len(msg.ToolCalls) > 0:
+			var content strings.Builder
+			content.WriteString(msg.Content)
+			for _, call := range msg.ToolCalls {
+				if content.Len() > 0 {
+					content.WriteString("\n")
+				}
+				fmt.Fprintf(&content, "[调用工具 %s %s] %s", call.Name, call.ID, call.Arguments)
+			}
+			flattened = append(flattened, models.Message{Role: msg.Role, Content: content.String()})
+		default:
+			flattened = append(flattened, msg)
+		}
+	}
+	return flattened
+}
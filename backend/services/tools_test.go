@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"grandma/backend/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testTools = []ToolDefinition{{
+	Name:        "list_chapters",
+	Description: "列出章节",
+	Parameters:  map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+}}
+
+func TestReadOpenAIStreamToolCalls(t *testing.T) {
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"让我查一下\"}}]}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"function\":{\"name\":\"search_work\",\"arguments\":\"{\\\"query\\\":\"}}]}}]}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"\\\"林\\\"}\"}}]}}]}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":1,\"id\":\"call_2\",\"function\":{\"name\":\"list_chapters\",\"arguments\":\"{}\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\n" +
+		"data: [DONE]\n\n"
+
+	var out strings.Builder
+	var usage Usage
+	calls, err := readOpenAIStream("openai", strings.NewReader(stream), &out, &usage)
+	if err != nil {
+		t.Fatalf("readOpenAIStream: %v", err)
+	}
+	if out.String() != "让我查一下" || usage.FinishReason != "tool_calls" {
+		t.Errorf("output %q, usage %+v", out.String(), usage)
+	}
+	want := []models.ToolCall{
+		{ID: "call_1", Name: "search_work", Arguments: `{"query":"林"}`},
+		{ID: "call_2", Name: "list_chapters", Arguments: "{}"},
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("calls: got %+v, want %+v", calls, want)
+	}
+}
+
+func TestOpenAIRequestIncludesToolsAndResults(t *testing.T) {
+	messages := []models.Message{
+		{Role: "user", Content: "有几章？"},
+		{Role: "assistant", ToolCalls: []models.ToolCall{{ID: "call_1", Name: "list_chapters", Arguments: "{}"}}},
+		{Role: "tool", ToolCallID: "call_1", Content: "1. 序章"},
+	}
+	apiMessages := openAIMessages(messages)
+	calls, ok := apiMessages[1]["tool_calls"].([]map[string]interface{})
+	if !ok || len(calls) != 1 || calls[0]["id"] != "call_1" || calls[0]["type"] != "function" {
+		t.Errorf("assistant message: %+v", apiMessages[1])
+	}
+	if apiMessages[2]["tool_call_id"] != "call_1" || apiMessages[2]["role"] != "tool" {
+		t.Errorf("tool message: %+v", apiMessages[2])
+	}
+
+	tools := openAITools(testTools)
+	function := tools[0]["function"].(map[string]interface{})
+	if tools[0]["type"] != "function" || function["name"] != "list_chapters" || function["parameters"] == nil {
+		t.Errorf("tools: %+v", tools)
+	}
+}
+
+func TestAnthropicRequestConvertsToolMessages(t *testing.T) {
+	messages := []models.Message{
+		{Role: "user", Content: "有几章？"},
+		{Role: "assistant", Content: "我看一下", ToolCalls: []models.ToolCall{{ID: "toolu_1", Name: "list_chapters", Arguments: "{}"}}},
+		{Role: "tool", ToolCallID: "toolu_1", Content: "1. 序章"},
+	}
+	data, err := json.Marshal(buildAnthropicPayload(messages, testTools, true))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var body struct {
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+		Tools []struct {
+			Name        string                 `json:"name"`
+			InputSchema map[string]interface{} `json:"input_schema"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(body.Tools) != 1 || body.Tools[0].Name != "list_chapters" || body.Tools[0].InputSchema["type"] != "object" {
+		t.Errorf("tools: %+v", body.Tools)
+	}
+	if len(body.Messages) != 3 || string(body.Messages[0].Content) != `"有几章？"` {
+		t.Fatalf("messages: %s", data)
+	}
+
+	var assistant, result []anthropicBlock
+	json.Unmarshal(body.Messages[1].Content, &assistant)
+	json.Unmarshal(body.Messages[2].Content, &result)
+	if len(assistant) != 2 || assistant[1].Type != "tool_use" || assistant[1].ID != "toolu_1" || string(assistant[1].Input) != "{}" {
+		t.Errorf("assistant blocks: %s", body.Messages[1].Content)
+	}
+	if body.Messages[2].Role != "user" || len(result) != 1 || result[0].Type != "tool_result" || result[0].ToolUseID != "toolu_1" {
+		t.Errorf("tool result blocks: %s", body.Messages[2].Content)
+	}
+}
+
+func TestAnthropicStreamToolUse(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{"usage":{"input_tokens":30,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"查一下"}}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"search_work","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"query\":"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"林\"}"}}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_2","name":"list_chapters","input":{}}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":12}}`,
+		`{"type":"message_stop"}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range events {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	}))
+	defer server.Close()
+
+	provider := NewAnthropicProvider("sk-ant", server.URL)
+	var out strings.Builder
+	calls, err := provider.ChatStreamWithTools(context.Background(), testMessages, testTools, &out)
+	if err != nil {
+		t.Fatalf("ChatStreamWithTools: %v", err)
+	}
+	if out.String() != "查一下" {
+		t.Errorf("output: %q", out.String())
+	}
+	want := []models.ToolCall{
+		{ID: "toolu_1", Name: "search_work", Arguments: `{"query":"林"}`},
+		{ID: "toolu_2", Name: "list_chapters", Arguments: "{}"},
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("calls: got %+v, want %+v", calls, want)
+	}
+	if usage := provider.LastUsage(); usage.FinishReason != "tool_use" || usage.InputTokens != 30 || usage.OutputTokens != 12 {
+		t.Errorf("usage: %+v", usage)
+	}
+}
+
+func TestChatStreamWithToolsFallsBackForPlainProviders(t *testing.T) {
+	provider := &plainProvider{}
+	messages := []models.Message{
+		{Role: "user", Content: "有几章？"},
+		{Role: "assistant", ToolCalls: []models.ToolCall{{ID: "call_1", Name: "list_chapters", Arguments: "{}"}}},
+		{Role: "tool", ToolCallID: "call_1", Content: "1. 序章"},
+	}
+	calls, err := ChatStreamWithTools(context.Background(), provider, messages, testTools, &strings.Builder{})
+	if err != nil || calls != nil {
+		t.Fatalf("fallback: %v, %v", calls, err)
+	}
+	for _, msg := range provider.received {
+		if msg.Role == "tool" || len(msg.ToolCalls) > 0 {
+			t.Errorf("tool message passed to plain provider: %+v", msg)
+		}
+	}
+	if !strings.Contains(provider.received[2].Content, "1. 序章") {
+		t.Errorf("tool result lost: %+v", provider.received)
+	}
+}
+
+func TestMockProviderToolCalls(t *testing.T) {
+	provider := NewMockProvider(&MockOptions{
+		Responses: []string{"共一章"},
+		ToolCalls: []models.ToolCall{{Name: "list_chapters"}},
+	})
+	messages := []models.Message{{Role: "user", Content: "有几章？"}}
+
+	calls, err := provider.ChatStreamWithTools(context.Background(), messages, testTools, &strings.Builder{})
+	if err != nil || len(calls) != 1 || calls[0].ID == "" || calls[0].Arguments != "{}" {
+		t.Fatalf("first round: %+v, %v", calls, err)
+	}
+
+	messages = append(messages,
+		models.Message{Role: "assistant", ToolCalls: calls},
+		models.Message{Role: "tool", ToolCallID: calls[0].ID, Content: "1. 序章"},
+	)
+	var out strings.Builder
+	calls, err = provider.ChatStreamWithTools(context.Background(), messages, testTools, &out)
+	if err != nil || len(calls) != 0 || out.String() != "共一章" {
+		t.Fatalf("second round: %+v, %q, %v", calls, out.String(), err)
+	}
+}
+
+// plainProvider 不支持工具调用的服务提供者，记录收到的消息
+type plainProvider struct {
+	received []models.Message
+}
+
+func (p *plainProvider) ChatStream(ctx context.Context, messages []models.Message, writer io.Writer) error {
+	p.received = messages
+	_, err := writer.Write([]byte("ok"))
+	return err
+}
+
+func (p *plainProvider) Chat(ctx context.Context, messages []models.Message) (string, error) {
+	p.received = messages
+	return "ok", nil
+}
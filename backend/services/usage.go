@@ -0,0 +1,15 @@
+package services
+
+// Usage 单次模型调用的Token用量
+type Usage struct {
+	Model        string // 实际调用的模型名称
+	InputTokens  int    // 输入Token数
+	OutputTokens int    // 输出Token数
+	FinishReason string // 结束原因，如stop、length，服务商未返回时为空
+}
+
+// UsageReporter 能够报告最近一次调用用量的服务提供者
+// 服务提供者按请求创建，不会被多个请求共享，因此只需记录最近一次调用
+type UsageReporter interface {
+	LastUsage() Usage
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEncryptDecryptString(t *testing.T) {
+	key := DeriveKey("master")
+
+	ciphertext, err := EncryptString(key, "sk-secret")
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if ciphertext == "sk-secret" {
+		t.Fatal("ciphertext equals plaintext")
+	}
+
+	plaintext, err := DecryptString(key, ciphertext)
+	if err != nil || plaintext != "sk-secret" {
+		t.Fatalf("decrypt: got %q, %v", plaintext, err)
+	}
+
+	// 相同明文每次加密结果不同（随机nonce）
+	again, _ := EncryptString(key, "sk-secret")
+	if again == ciphertext {
+		t.Error("nonce reused")
+	}
+
+	if _, err := DecryptString(DeriveKey("other"), ciphertext); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("decrypt with wrong key: got %v", err)
+	}
+	if _, err := DecryptString(key, "not-base64!"); !errors.Is(err, ErrInvalidCiphertext) {
+		t.Errorf("decrypt garbage: got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	cases := []struct {
+		header string
+		want   int64
+		err    error
+	}{
+		{"", 0, nil},
+		{"*", 0, nil},
+		{FormatETag(7), 7, nil},
+		{` W/"12" `, 12, nil},
+		{"7", 0, ErrInvalidIfMatch},
+		{`"abc"`, 0, ErrInvalidIfMatch},
+		{`"0"`, 0, ErrInvalidIfMatch},
+		{`"1", "2"`, 0, ErrInvalidIfMatch},
+	}
+	for _, tc := range cases {
+		got, err := ParseIfMatch(tc.header)
+		if got != tc.want || !errors.Is(err, tc.err) {
+			t.Errorf("ParseIfMatch(%q): got %d, %v; want %d, %v", tc.header, got, err, tc.want, tc.err)
+		}
+	}
+}
@@ -22,6 +22,66 @@ func GenerateStoryId() string {
 	return generateID("story")
 }
 
+// GenerateStoryBibleEntryID 生成设定集条目ID
+func GenerateStoryBibleEntryID() string {
+	return generateID("bible")
+}
+
+// GenerateUploadID 生成上传文件ID
+func GenerateUploadID() string {
+	return generateID("upload")
+}
+
+// GeneratePromptTemplateID 生成提示词模板ID
+func GeneratePromptTemplateID() string {
+	return generateID("prompt")
+}
+
+// GenerateStyleProfileID 生成文风档案ID
+func GenerateStyleProfileID() string {
+	return generateID("style")
+}
+
+// GenerateOutlineNodeID 生成大纲节点ID
+func GenerateOutlineNodeID() string {
+	return generateID("outline")
+}
+
+// GenerateRevisionID 生成修订记录ID
+func GenerateRevisionID() string {
+	return generateID("rev")
+}
+
+// GenerateDocumentPatchID 生成修改建议ID
+func GenerateDocumentPatchID() string {
+	return generateID("patch")
+}
+
+// GenerateContinuityCheckID 生成设定一致性检查任务ID
+func GenerateContinuityCheckID() string {
+	return generateID("ccheck")
+}
+
+// GenerateContinuityIssueID 生成设定冲突ID
+func GenerateContinuityIssueID() string {
+	return generateID("cissue")
+}
+
+// GenerateBibleProposalID 生成设定建议ID
+func GenerateBibleProposalID() string {
+	return generateID("proposal")
+}
+
+// GenerateTimelineCalendarID 生成历法ID
+func GenerateTimelineCalendarID() string {
+	return generateID("calendar")
+}
+
+// GenerateTimelineEventID 生成时间线事件ID
+func GenerateTimelineEventID() string {
+	return generateID("tevent")
+}
+
 // GenerateID 生成通用唯一ID（不带前缀）
 func GenerateID() string {
 	timestamp := time.Now().UnixNano()
@@ -39,3 +99,17 @@ func generateID(prefix string) string {
 	randomHex := hex.EncodeToString(randomBytes)
 	return fmt.Sprintf("%s_%d_%s", prefix, timestamp, randomHex)
 }
+
+// GenerateUserID 生成用户ID
+func GenerateUserID() string {
+	return generateID("user")
+}
+
+// GenerateSessionToken 生成会话令牌（32字节随机数的十六进制表示）
+func GenerateSessionToken() (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(randomBytes), nil
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// TruncateRunes 按字符（而不是字节）截断文本，超出limit时截断并追加suffix
+func TruncateRunes(text string, limit int, suffix string) string {
+	if limit < 0 || utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	return string([]rune(text)[:limit]) + suffix
+}
+
+// LocateRunes 查找quote在文本中第一次出现的位置（按字符计，左闭右开），找不到或quote为空时返回nil
+func LocateRunes(text, quote string) (*int, *int) {
+	if quote == "" {
+		return nil, nil
+	}
+	index := strings.Index(text, quote)
+	if index < 0 {
+		return nil, nil
+	}
+	start := utf8.RuneCountInString(text[:index])
+	end := start + utf8.RuneCountInString(quote)
+	return &start, &end
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateRunes(t *testing.T) {
+	cases := []struct {
+		text   string
+		limit  int
+		suffix string
+		want   string
+	}{
+		{"短标题", 50, "...", "短标题"},
+		{"一二三四五六", 4, "...", "一二三四..."},
+		{"abcdef", 3, "", "abc"},
+		{"", 3, "...", ""},
+	}
+	for _, tc := range cases {
+		got := TruncateRunes(tc.text, tc.limit, tc.suffix)
+		if got != tc.want || !utf8.ValidString(got) {
+			t.Errorf("TruncateRunes(%q, %d): got %q, want %q", tc.text, tc.limit, got, tc.want)
+		}
+	}
+}
+
+func TestLocateRunes(t *testing.T) {
+	cases := []struct {
+		text, quote string
+		start, end  int // -1表示找不到
+	}{
+		{"小舟和老周划船去了灯塔岛。", "老周", 3, 5},
+		{"abc老周", "老周", 3, 5},
+		{"老周说老周", "老周", 0, 2},
+		{"灯塔岛", "渡口", -1, -1},
+		{"灯塔岛", "", -1, -1},
+	}
+	for _, tc := range cases {
+		start, end := LocateRunes(tc.text, tc.quote)
+		if tc.start < 0 {
+			if start != nil || end != nil {
+				t.Errorf("LocateRunes(%q, %q): got %d-%d, want nil", tc.text, tc.quote, *start, *end)
+			}
+			continue
+		}
+		if start == nil || end == nil || *start != tc.start || *end != tc.end {
+			t.Errorf("LocateRunes(%q, %q): got %v-%v, want %d-%d", tc.text, tc.quote, start, end, tc.start, tc.end)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import WorkDocumentList from './components/WorkDocumentList'
 import StoryEditDialog from './components/StoryEditDialog'
 import StoryViewDialog from './components/StoryViewDialog'
 import InspirationDocumentView from './components/InspirationDocumentView'
+import AuthForm from './components/AuthForm'
 import './App.css'
 
 function App() {
@@ -44,17 +45,9 @@ function App() {
     return saved ? parseInt(saved, 10) : 260
   })
   
-  // 用户ID：从localStorage获取或生成新的，确保多次打开网页时ID一致
-  const [userId, setUserId] = useState(() => {
-    const saved = localStorage.getItem('grandma_user_id')
-    if (saved) {
-      return saved
-    }
-    // 生成新的用户ID（使用时间戳和随机数）
-    const newUserId = 'user_' + Date.now() + '_' + Math.random().toString(36).substring(2, 15)
-    localStorage.setItem('grandma_user_id', newUserId)
-    return newUserId
-  })
+  // 会话令牌：登录后保存在localStorage，所有/api请求通过Authorization头携带
+  const [authToken, setAuthToken] = useState(() => localStorage.getItem('grandma_token'))
+  const authTokenRef = useRef(authToken)
   
   // 用于跟踪当前的流式响应读取器，以便在切换对话时取消
   const readerRef = useRef(null)
@@ -83,11 +76,14 @@ function App() {
   }, [currentWorkId])
 
   useEffect(() => {
+    if (!authToken) {
+      return
+    }
     fetchModels()
     fetchConversations()
     fetchStories()
     fetchWorks()
-  }, [])
+  }, [authToken])
 
   // 当灵感模式开启时，获取works；当选择work时，获取documents
   useEffect(() => {
@@ -134,43 +130,76 @@ function App() {
     }
   }, [currentWorkId])
 
-  // 辅助函数：为URL添加用户ID参数
-  const addUserIdToUrl = (url) => {
-    const separator = url.includes('?') ? '&' : '?'
-    return `${url}${separator}user_id=${encodeURIComponent(userId)}`
+  // 登录成功后保存令牌
+  const handleLogin = (token) => {
+    localStorage.setItem('grandma_token', token)
+    authTokenRef.current = token
+    setAuthToken(token)
+  }
+
+  // 清除令牌并回到登录界面
+  const clearAuth = () => {
+    localStorage.removeItem('grandma_token')
+    authTokenRef.current = null
+    setAuthToken(null)
+    setMessages([])
+    setConversations([])
+    setStories([])
+    setWorks([])
+    setWorkDocuments([])
+    setCurrentConversationId(null)
+    setCurrentWorkId(null)
   }
 
-  // v1.3: 加载创作的历史消息（从WorkDocument）
+  const handleLogout = async () => {
+    try {
+      await apiFetch('/api/auth/logout', { method: 'POST' })
+    } catch (error) {
+      console.error('Failed to logout:', error)
+    }
+    clearAuth()
+  }
+
+  // 辅助函数：携带会话令牌请求接口，令牌失效（401）时回到登录界面
+  const apiFetch = async (url, options = {}) => {
+    const response = await fetch(url, {
+      ...options,
+      headers: {
+        ...(options.headers || {}),
+        Authorization: `Bearer ${authTokenRef.current}`,
+      },
+    })
+    if (response.status === 401) {
+      clearAuth()
+    }
+    return response
+  }
+
+  // v1.3: 加载创作的历史消息（灵感模式的对话消息与正文文档分开保存）
   // 必须在所有useEffect之前定义，因为useEffect会引用它
-  // 注意：这个函数不包含handleAddToStory，因为它还没定义
+  // 注意：这个函数不包含handleAddToWork，因为它还没定义
   const loadWorkMessages = useCallback(async (workId) => {
     try {
-      // 获取该创作的所有文档（按时间正序）
-      const response = await fetch(addUserIdToUrl(`/api/works/${workId}/documents`))
+      // 获取该创作的所有对话消息（后端已按时间正序返回）
+      const response = await apiFetch(`/api/works/${workId}/messages`)
       if (!response.ok) {
-        throw new Error('Failed to get work documents')
+        throw new Error('Failed to get work messages')
       }
 
       const data = await response.json()
-      const documents = data.documents || []
 
-      // 过滤出有role的文档（对话消息），并按时间正序排列
-      const conversationDocs = documents
-        .filter(doc => doc.role === 'user' || doc.role === 'assistant')
-        .sort((a, b) => {
-          return new Date(a.created_at) - new Date(b.created_at)
-        })
-
-      // 转换为消息格式
-      // 注意：这里不能直接使用handleAddToStory，因为它还没定义
+      // 只显示用户提问和助手回答，不显示工具调用记录
+      // 注意：这里不能直接使用handleAddToWork，因为它还没定义
       // 我们会在后面通过loadWorkMessagesWithCallback添加onAddToStory
-      const workMessages = conversationDocs.map((doc) => ({
-        id: doc.id,
-        documentId: doc.id,
-        role: doc.role,
-        content: doc.content,
-        // onAddToStory会在后面通过loadWorkMessagesWithCallback添加
-      }))
+      const workMessages = (data.messages || [])
+        .filter(msg => msg.role === 'user' || msg.role === 'assistant')
+        .map((msg) => ({
+          id: msg.id,
+          documentId: msg.id,
+          role: msg.role,
+          content: msg.content,
+          // onAddToStory会在后面通过loadWorkMessagesWithCallback添加
+        }))
 
       // 设置消息
       setMessages(workMessages)
@@ -200,7 +229,7 @@ function App() {
       console.error('Failed to load work messages:', error)
       setMessages([])
     }
-  }, [userId])
+  }, [])
 
   // 将loadWorkMessages保存到ref，以便在useEffect中使用
   useEffect(() => {
@@ -209,8 +238,7 @@ function App() {
 
   const fetchModels = async () => {
     try {
-      // models接口不需要用户ID，因为模型列表是全局的
-      const response = await fetch('/api/models')
+      const response = await apiFetch('/api/models')
       const data = await response.json()
       setModels(data.models)
       if (data.models.length > 0) {
@@ -223,7 +251,7 @@ function App() {
 
   const fetchConversations = async () => {
     try {
-      const response = await fetch(addUserIdToUrl('/api/conversations?page=1&page_size=50'))
+      const response = await apiFetch('/api/conversations?page=1&page_size=50')
       const data = await response.json()
       setConversations(data.conversations || [])
     } catch (error) {
@@ -233,7 +261,7 @@ function App() {
 
   const fetchStories = async () => {
     try {
-      const response = await fetch(addUserIdToUrl('/api/stories?guid=default'))
+      const response = await apiFetch('/api/stories?guid=default')
       const data = await response.json()
       setStories(data.stories || [])
     } catch (error) {
@@ -243,7 +271,7 @@ function App() {
 
   const fetchWorks = async () => {
     try {
-      const response = await fetch(addUserIdToUrl('/api/works'))
+      const response = await apiFetch('/api/works')
       const data = await response.json()
       setWorks(data.works || [])
     } catch (error) {
@@ -253,7 +281,7 @@ function App() {
 
   const fetchWorkDocuments = async (workId) => {
     try {
-      const response = await fetch(addUserIdToUrl(`/api/works/${workId}/documents`))
+      const response = await apiFetch(`/api/works/${workId}/documents`)
       const data = await response.json()
       setWorkDocuments(data.documents || [])
     } catch (error) {
@@ -275,14 +303,13 @@ function App() {
           if (userInputs.length > 0) {
             try {
               // 调用智能命名接口生成标题
-              const titleResponse = await fetch('/api/conversations/generate-title', {
+              const titleResponse = await apiFetch('/api/conversations/generate-title', {
                 method: 'POST',
                 headers: {
                   'Content-Type': 'application/json',
                 },
                 body: JSON.stringify({
                   user_inputs: userInputs,
-                  user_id: userId,
                 }),
               })
 
@@ -299,13 +326,12 @@ function App() {
       }
 
       // 创建新对话
-      const response = await fetch('/api/conversations/new', {
+      const response = await apiFetch('/api/conversations/new', {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
         },
         body: JSON.stringify({
-          user_id: userId,
         }),
       })
 
@@ -323,14 +349,13 @@ function App() {
 
   const handleRenameConversation = async (conversationId, newTitle) => {
     try {
-      const response = await fetch(addUserIdToUrl(`/api/conversations/${conversationId}/title`), {
+      const response = await apiFetch(`/api/conversations/${conversationId}/title`, {
         method: 'PUT',
         headers: {
           'Content-Type': 'application/json',
         },
         body: JSON.stringify({
           title: newTitle,
-          user_id: userId,
         }),
       })
       if (response.ok) {
@@ -343,7 +368,7 @@ function App() {
 
   const handleDeleteConversation = async (conversationId) => {
     try {
-      const response = await fetch(addUserIdToUrl(`/api/conversations/${conversationId}`), {
+      const response = await apiFetch(`/api/conversations/${conversationId}`, {
         method: 'DELETE',
       })
       if (response.ok) {
@@ -373,7 +398,7 @@ function App() {
           ? `/api/documents/ids?conversation_id=${convId}&before_id=${beforeID}&limit=100`
           : `/api/documents/ids?conversation_id=${convId}&limit=100`
         
-        const idsResponse = await fetch(addUserIdToUrl(baseUrl))
+        const idsResponse = await apiFetch(baseUrl)
         if (!idsResponse.ok) {
           break
         }
@@ -388,7 +413,7 @@ function App() {
 
         // 并发请求所有文档的详细信息
         const documentPromises = documentIDs.map(id =>
-          fetch(addUserIdToUrl(`/api/documents/${id}`))
+          apiFetch(`/api/documents/${id}`)
             .then(response => {
               if (!response.ok) {
                 throw new Error(`Failed to get document ${id}`)
@@ -477,14 +502,13 @@ function App() {
           const userInputs = await getAllUserInputsForConversation(oldConversationId)
           if (userInputs.length > 0) {
             // 调用智能命名接口生成标题
-            const titleResponse = await fetch('/api/conversations/generate-title', {
+            const titleResponse = await apiFetch('/api/conversations/generate-title', {
               method: 'POST',
               headers: {
                 'Content-Type': 'application/json',
               },
               body: JSON.stringify({
                 user_inputs: userInputs,
-                user_id: userId,
               }),
             })
 
@@ -514,7 +538,7 @@ function App() {
     try {
       // 第一步：请求文档ID列表接口，获取这个对话的最新10个文档的ID
       // 后端返回的ID列表已经按时间正序排列（最早的在前，最新的在后）
-      const idsResponse = await fetch(addUserIdToUrl(`/api/documents/ids?conversation_id=${conversationId}&limit=10`))
+      const idsResponse = await apiFetch(`/api/documents/ids?conversation_id=${conversationId}&limit=10`)
       if (!idsResponse.ok) {
         throw new Error('Failed to get document IDs')
       }
@@ -532,7 +556,7 @@ function App() {
       // 第二步：并发请求文档管理模块，同时发送多个请求，每个请求获取一个文档的详细信息
       // 注意：这里只获取最新10条文档，不会获取全部对话
       const documentPromises = documentIDs.map(id =>
-        fetch(addUserIdToUrl(`/api/documents/${id}`))
+        apiFetch(`/api/documents/${id}`)
           .then(response => {
             if (!response.ok) {
               throw new Error(`Failed to get document ${id}`)
@@ -595,7 +619,7 @@ function App() {
           if (currentConversationIdRef.current === conversationId) {
             try {
               // 重新获取文档ID列表
-              const refreshIdsResponse = await fetch(addUserIdToUrl(`/api/documents/ids?conversation_id=${conversationId}&limit=10`))
+              const refreshIdsResponse = await apiFetch(`/api/documents/ids?conversation_id=${conversationId}&limit=10`)
               if (refreshIdsResponse.ok) {
                 const refreshIdsData = await refreshIdsResponse.json()
                 const refreshDocumentIDs = refreshIdsData.document_ids || []
@@ -606,7 +630,7 @@ function App() {
                      refreshDocumentIDs[refreshDocumentIDs.length - 1] !== documentIDs[documentIDs.length - 1])) {
                   // 重新加载文档
                   const refreshDocumentPromises = refreshDocumentIDs.map(id =>
-                    fetch(addUserIdToUrl(`/api/documents/${id}`))
+                    apiFetch(`/api/documents/${id}`)
                       .then(response => {
                         if (!response.ok) {
                           throw new Error(`Failed to get document ${id}`)
@@ -678,8 +702,8 @@ function App() {
     setIsLoadingMore(true)
     try {
       // 第一步：获取比earliestDocId更早的文档ID列表
-      const idsResponse = await fetch(
-        addUserIdToUrl(`/api/documents/ids?conversation_id=${currentConversationId}&before_id=${earliestDocId}&limit=10`)
+      const idsResponse = await apiFetch(
+        `/api/documents/ids?conversation_id=${currentConversationId}&before_id=${earliestDocId}&limit=10`
       )
 
       if (!idsResponse.ok) {
@@ -694,7 +718,7 @@ function App() {
 
       // 第二步：并发请求文档管理模块，同时发送多个请求，每个请求获取一个文档的详细信息
       const documentPromises = idsData.document_ids.map(id =>
-        fetch(addUserIdToUrl(`/api/documents/${id}`))
+        apiFetch(`/api/documents/${id}`)
           .then(response => {
             if (!response.ok) {
               throw new Error(`Failed to get document ${id}`)
@@ -749,13 +773,12 @@ function App() {
     // 如果没有对话ID，创建新对话
     // 注意：这里不刷新列表，因为新对话还没有标题，等到第一次发送消息后再刷新
     try {
-      const response = await fetch('/api/conversations/new', {
+      const response = await apiFetch('/api/conversations/new', {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
         },
         body: JSON.stringify({
-          user_id: userId,
         }),
       })
       if (response.ok) {
@@ -797,7 +820,7 @@ function App() {
   const handleAddToStory = useCallback(async (documentId) => {
     try {
       // 获取文档内容
-      const docResponse = await fetch(addUserIdToUrl(`/api/documents/${documentId}`))
+      const docResponse = await apiFetch(`/api/documents/${documentId}`)
       if (!docResponse.ok) {
         throw new Error('Failed to get document')
       }
@@ -821,19 +844,43 @@ function App() {
     } catch (error) {
       console.error('Failed to add to story:', error)
     }
-  }, [userId])
+  }, [])
+
+  // 灵感模式：把助手回答加入正文（新建一篇正文文档，标题取回答的第一行）
+  const handleAddToWork = useCallback(async (messageId) => {
+    try {
+      const response = await apiFetch(`/api/work-messages/${messageId}/promote`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({}),
+      })
+      if (!response.ok) {
+        const data = await response.json()
+        throw new Error(data.error || 'Failed to promote message')
+      }
+      // 刷新正文文档列表
+      const workId = currentWorkIdRef.current
+      if (workId) {
+        await fetchWorkDocuments(workId)
+      }
+    } catch (error) {
+      console.error('Failed to add to work:', error)
+    }
+  }, [])
 
-  // v1.3: 更新loadWorkMessages，添加handleAddToStory依赖，并在加载消息后添加onAddToStory回调
+  // v1.3: 更新loadWorkMessages，添加handleAddToWork依赖，并在加载消息后添加onAddToStory回调
   const loadWorkMessagesWithCallback = useCallback(async (workId) => {
     await loadWorkMessages(workId)
     // 加载完成后，更新消息以添加onAddToStory回调
     setMessages((prev) => {
       return prev.map(msg => ({
         ...msg,
-        onAddToStory: msg.role === 'assistant' ? handleAddToStory : undefined,
+        onAddToStory: msg.role === 'assistant' ? handleAddToWork : undefined,
       }))
     })
-  }, [loadWorkMessages, handleAddToStory])
+  }, [loadWorkMessages, handleAddToWork])
 
   // 将loadWorkMessagesWithCallback保存到ref，以便在useEffect中使用
   useEffect(() => {
@@ -854,7 +901,6 @@ function App() {
         title: title,
         content: content,
         content_hash: contentHash,
-        user_id: userId,
       }
 
       // 只有在创建时才需要document_id
@@ -863,7 +909,7 @@ function App() {
         requestBody.guid = 'default'
       }
 
-      const response = await fetch(addUserIdToUrl(url), {
+      const response = await apiFetch(url, {
         method: method,
         headers: {
           'Content-Type': 'application/json',
@@ -908,7 +954,7 @@ function App() {
 
   const handleDeleteStory = async (story) => {
     try {
-      const response = await fetch(addUserIdToUrl(`/api/stories/${story.id}`), {
+      const response = await apiFetch(`/api/stories/${story.id}`, {
         method: 'DELETE',
       })
 
@@ -942,13 +988,12 @@ function App() {
   // 创作相关处理函数
   const handleNewWork = async () => {
     try {
-      const response = await fetch('/api/works', {
+      const response = await apiFetch('/api/works', {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
         },
         body: JSON.stringify({
-          user_id: userId,
           title: '新创作',
         }),
       })
@@ -985,13 +1030,12 @@ function App() {
 
   const handleRenameWork = async (workId, newTitle) => {
     try {
-      const response = await fetch(`/api/works/${workId}/title`, {
+      const response = await apiFetch(`/api/works/${workId}/title`, {
         method: 'PUT',
         headers: {
           'Content-Type': 'application/json',
         },
         body: JSON.stringify({
-          user_id: userId,
           title: newTitle,
         }),
       })
@@ -1005,7 +1049,7 @@ function App() {
 
   const handleDeleteWork = async (workId) => {
     try {
-      const response = await fetch(addUserIdToUrl(`/api/works/${workId}`), {
+      const response = await apiFetch(`/api/works/${workId}`, {
         method: 'DELETE',
       })
       if (response.ok) {
@@ -1031,13 +1075,12 @@ function App() {
   const handleNewDocument = async () => {
     if (!currentWorkId) return
     try {
-      const response = await fetch(`/api/works/${currentWorkId}/documents`, {
+      const response = await apiFetch(`/api/works/${currentWorkId}/documents`, {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
         },
         body: JSON.stringify({
-          user_id: userId,
           work_id: currentWorkId,
           title: '新文档',
           content: '',
@@ -1053,13 +1096,12 @@ function App() {
 
   const handleRenameDocument = async (docId, newTitle) => {
     try {
-      const response = await fetch(`/api/work-documents/${docId}/title`, {
+      const response = await apiFetch(`/api/work-documents/${docId}/title`, {
         method: 'PUT',
         headers: {
           'Content-Type': 'application/json',
         },
         body: JSON.stringify({
-          user_id: userId,
           title: newTitle,
         }),
       })
@@ -1073,7 +1115,7 @@ function App() {
 
   const handleDeleteDocument = async (docId) => {
     try {
-      const response = await fetch(addUserIdToUrl(`/api/work-documents/${docId}`), {
+      const response = await apiFetch(`/api/work-documents/${docId}`, {
         method: 'DELETE',
       })
       if (response.ok) {
@@ -1098,13 +1140,12 @@ function App() {
   // v2.1: 保存文档内容
   const handleSaveWorkDocument = async (documentId, content) => {
     try {
-      const response = await fetch(`/api/work-documents/${documentId}/content`, {
+      const response = await apiFetch(`/api/work-documents/${documentId}/content`, {
         method: 'PUT',
         headers: {
           'Content-Type': 'application/json',
         },
         body: JSON.stringify({
-          user_id: userId,
           content: content,
         }),
       })
@@ -1166,13 +1207,12 @@ function App() {
       if (!currentWorkId) {
         // 如果没有创作，创建一个新创作
         try {
-          const workResponse = await fetch('/api/works', {
+          const workResponse = await apiFetch('/api/works', {
             method: 'POST',
             headers: {
               'Content-Type': 'application/json',
             },
             body: JSON.stringify({
-              user_id: userId,
               title: '新创作',
             }),
           })
@@ -1209,6 +1249,9 @@ function App() {
       }
     }
 
+    // 灵感模式下加入正文，普通模式下加入故事
+    const addToStoryHandler = isInspirationMode ? handleAddToWork : handleAddToStory
+
     const userMessage = {
       id: Date.now(),
       role: 'user',
@@ -1224,7 +1267,7 @@ function App() {
       id: tempMessageId,
       role: 'assistant',
       content: '',
-      onAddToStory: handleAddToStory,
+      onAddToStory: addToStoryHandler,
     }
     setMessages((prev) => [...prev, assistantMessage])
 
@@ -1237,7 +1280,6 @@ function App() {
       // v1.3: 在灵感模式下传递work_id，否则传递conversation_id
       const requestBody = {
           model: selectedModel,
-          user_id: userId,
           messages: [
             {
               role: 'user',
@@ -1252,7 +1294,7 @@ function App() {
         requestBody.conversation_id = targetId
       }
       
-      const response = await fetch('/api/chat', {
+      const response = await apiFetch('/api/chat', {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
@@ -1343,7 +1385,7 @@ function App() {
               lastMessage.content = content
               // 确保onAddToStory回调存在
               if (!lastMessage.onAddToStory) {
-                lastMessage.onAddToStory = handleAddToStory
+                lastMessage.onAddToStory = addToStoryHandler
               }
               // 注意：不在循环内更新id，避免key变化导致组件重新挂载
             }
@@ -1432,7 +1474,7 @@ function App() {
           
           // 更新回调（如果需要）
           if (needsUpdateCallback) {
-            updatedLastMessage.onAddToStory = handleAddToStory
+            updatedLastMessage.onAddToStory = addToStoryHandler
           }
           
           newMessages[newMessages.length - 1] = updatedLastMessage
@@ -1509,6 +1551,11 @@ function App() {
     }
   }
 
+  // 未登录时显示登录/注册界面
+  if (!authToken) {
+    return <AuthForm onLogin={handleLogin} />
+  }
+
   return (
     <div className="app">
       {isInspirationMode ? (
@@ -1573,6 +1620,9 @@ function App() {
                 <span className="toggle-text">灵感模式</span>
               </label>
             </div>
+            <button onClick={handleLogout} className="logout-button">
+              退出登录
+            </button>
           </div>
           <ChatContainer
           messages={messages}
@@ -0,0 +1,95 @@
+import { useState } from 'react'
+import './AuthForm.css'
+
+function AuthForm({ onLogin }) {
+  const [mode, setMode] = useState('login') // login 或 register
+  const [username, setUsername] = useState('')
+  const [password, setPassword] = useState('')
+  const [error, setError] = useState('')
+  const [isSubmitting, setIsSubmitting] = useState(false)
+
+  const login = async () => {
+    const response = await fetch('/api/auth/login', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ username, password }),
+    })
+    const data = await response.json()
+    if (!response.ok) {
+      throw new Error(data.error === 'invalid_credentials' ? '用户名或密码错误' : data.error)
+    }
+    onLogin(data.token)
+  }
+
+  const handleSubmit = async (e) => {
+    e.preventDefault()
+    if (!username.trim() || !password) {
+      setError('请输入用户名和密码')
+      return
+    }
+
+    setIsSubmitting(true)
+    setError('')
+    try {
+      if (mode === 'register') {
+        const response = await fetch('/api/auth/register', {
+          method: 'POST',
+          headers: {
+            'Content-Type': 'application/json',
+          },
+          body: JSON.stringify({ username, password }),
+        })
+        if (!response.ok) {
+          const data = await response.json()
+          throw new Error(data.error)
+        }
+      }
+      // 注册成功后直接登录
+      await login()
+    } catch (err) {
+      setError(err.message || '请求失败，请稍后重试')
+    } finally {
+      setIsSubmitting(false)
+    }
+  }
+
+  const toggleMode = () => {
+    setMode(mode === 'login' ? 'register' : 'login')
+    setError('')
+  }
+
+  return (
+    <div className="auth-overlay">
+      <form className="auth-content" onSubmit={handleSubmit}>
+        <h3 className="auth-title">{mode === 'login' ? '登录' : '注册'}</h3>
+        <input
+          className="auth-input"
+          type="text"
+          placeholder="用户名"
+          autoComplete="username"
+          value={username}
+          onChange={(e) => setUsername(e.target.value)}
+        />
+        <input
+          className="auth-input"
+          type="password"
+          placeholder="密码"
+          autoComplete={mode === 'login' ? 'current-password' : 'new-password'}
+          value={password}
+          onChange={(e) => setPassword(e.target.value)}
+        />
+        {error && <p className="auth-error">{error}</p>}
+        <button className="auth-submit-btn" type="submit" disabled={isSubmitting}>
+          {mode === 'login' ? '登录' : '注册并登录'}
+        </button>
+        <button className="auth-switch-btn" type="button" onClick={toggleMode}>
+          {mode === 'login' ? '没有账号？注册' : '已有账号？登录'}
+        </button>
+      </form>
+    </div>
+  )
+}
+
+export default AuthForm